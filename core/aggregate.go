@@ -0,0 +1,352 @@
+package core
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// Aggregate errors
+var (
+	ErrAggregateNotFound = fiber.NewError(fiber.StatusNotFound, "Aggregate not found")
+)
+
+// Aggregate is an event-sourced entity. Implementations embed AggregateRoot
+// and apply events to their state in Apply.
+type Aggregate interface {
+	// Apply mutates the state for an event, both for new and replayed events
+	Apply(event Event)
+	AggregateID() string
+	Version() int64
+	root() *AggregateRoot
+}
+
+// AggregateRoot holds the identity, version and uncommitted events of an aggregate
+type AggregateRoot struct {
+	id              string
+	version         int64
+	snapshotVersion int64
+	changes         []Event
+}
+
+// AggregateID returns the aggregate ID, which is also its stream ID
+func (a *AggregateRoot) AggregateID() string {
+	return a.id
+}
+
+// SetAggregateID sets the aggregate ID
+func (a *AggregateRoot) SetAggregateID(id string) {
+	a.id = id
+}
+
+// Version returns the version of the aggregate including uncommitted events
+func (a *AggregateRoot) Version() int64 {
+	return a.version
+}
+
+// Changes returns the events raised since the aggregate was loaded or saved
+func (a *AggregateRoot) Changes() []Event {
+	return a.changes
+}
+
+func (a *AggregateRoot) root() *AggregateRoot {
+	return a
+}
+
+// RaiseEvent applies a new event to an aggregate and records it for saving
+func RaiseEvent(aggregate Aggregate, event Event) {
+	aggregate.Apply(event)
+
+	root := aggregate.root()
+	root.version++
+	root.changes = append(root.changes, event)
+}
+
+// Snapshotter is implemented by aggregates that serialize their own snapshots.
+// Other aggregates are snapshotted as JSON.
+type Snapshotter interface {
+	Snapshot() ([]byte, error)
+	RestoreSnapshot(data []byte) error
+}
+
+// Snapshot is the serialized state of an aggregate at a version
+type Snapshot struct {
+	StreamID  string    `bson:"_id"`
+	Version   int64     `bson:"version"`
+	Data      []byte    `bson:"data"`
+	Timestamp time.Time `bson:"timestamp"`
+}
+
+// SnapshotStore stores the latest snapshot of every stream
+type SnapshotStore interface {
+	SaveSnapshot(ctx context.Context, snapshot Snapshot) error
+	// LoadSnapshot returns nil when the stream has no snapshot
+	LoadSnapshot(ctx context.Context, streamID string) (*Snapshot, error)
+}
+
+// MemorySnapshotStore is an in-memory SnapshotStore
+type MemorySnapshotStore struct {
+	snapshots map[string]Snapshot
+	mu        sync.RWMutex
+}
+
+// NewMemorySnapshotStore creates a new in-memory snapshot store
+func NewMemorySnapshotStore() *MemorySnapshotStore {
+	return &MemorySnapshotStore{
+		snapshots: make(map[string]Snapshot),
+	}
+}
+
+// SaveSnapshot implements SnapshotStore
+func (s *MemorySnapshotStore) SaveSnapshot(ctx context.Context, snapshot Snapshot) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.snapshots[snapshot.StreamID] = snapshot
+	return nil
+}
+
+// LoadSnapshot implements SnapshotStore
+func (s *MemorySnapshotStore) LoadSnapshot(ctx context.Context, streamID string) (*Snapshot, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	snapshot, exists := s.snapshots[streamID]
+	if !exists {
+		return nil, nil
+	}
+	return &snapshot, nil
+}
+
+// SQLSnapshotStore is a SnapshotStore stored in a SQL table
+type SQLSnapshotStore struct {
+	db      *sql.DB
+	dialect SQLDialect
+	table   string
+}
+
+// NewSQLSnapshotStore creates a new SQL snapshot store
+func NewSQLSnapshotStore(db *sql.DB, dialect SQLDialect, table string) *SQLSnapshotStore {
+	if table == "" {
+		table = "snapshots"
+	}
+	return &SQLSnapshotStore{db: db, dialect: dialect, table: table}
+}
+
+// CreateTable creates the snapshots table if it does not exist
+func (s *SQLSnapshotStore) CreateTable() error {
+	_, err := s.db.Exec(fmt.Sprintf(`CREATE TABLE IF NOT EXISTS %s (
+		stream_id VARCHAR(255) PRIMARY KEY,
+		version BIGINT NOT NULL,
+		data %s NOT NULL,
+		created_at TIMESTAMP NOT NULL
+	)`, s.table, s.dialect.BlobType()))
+	return err
+}
+
+// SaveSnapshot implements SnapshotStore
+func (s *SQLSnapshotStore) SaveSnapshot(ctx context.Context, snapshot Snapshot) error {
+	return RunInTransaction(ctx, s.db, func(tx *sql.Tx) error {
+		if _, err := tx.ExecContext(ctx, s.dialect.Rebind(fmt.Sprintf(
+			"DELETE FROM %s WHERE stream_id = ?", s.table)), snapshot.StreamID); err != nil {
+			return err
+		}
+		_, err := tx.ExecContext(ctx, s.dialect.Rebind(fmt.Sprintf(
+			"INSERT INTO %s (stream_id, version, data, created_at) VALUES (?, ?, ?, ?)", s.table)),
+			snapshot.StreamID, snapshot.Version, snapshot.Data, snapshot.Timestamp)
+		return err
+	})
+}
+
+// LoadSnapshot implements SnapshotStore
+func (s *SQLSnapshotStore) LoadSnapshot(ctx context.Context, streamID string) (*Snapshot, error) {
+	var snapshot Snapshot
+	err := s.db.QueryRowContext(ctx, s.dialect.Rebind(fmt.Sprintf(
+		"SELECT stream_id, version, data, created_at FROM %s WHERE stream_id = ?", s.table)), streamID).
+		Scan(&snapshot.StreamID, &snapshot.Version, &snapshot.Data, &snapshot.Timestamp)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &snapshot, nil
+}
+
+// MongoSnapshotStore is a SnapshotStore stored in a MongoDB collection
+type MongoSnapshotStore struct {
+	collection *mongo.Collection
+}
+
+// NewMongoSnapshotStore creates a new MongoDB snapshot store
+func NewMongoSnapshotStore(collection *mongo.Collection) *MongoSnapshotStore {
+	return &MongoSnapshotStore{collection: collection}
+}
+
+// SaveSnapshot implements SnapshotStore
+func (s *MongoSnapshotStore) SaveSnapshot(ctx context.Context, snapshot Snapshot) error {
+	_, err := s.collection.ReplaceOne(ctx,
+		bson.D{{Key: "_id", Value: snapshot.StreamID}},
+		snapshot,
+		options.Replace().SetUpsert(true))
+	return err
+}
+
+// LoadSnapshot implements SnapshotStore
+func (s *MongoSnapshotStore) LoadSnapshot(ctx context.Context, streamID string) (*Snapshot, error) {
+	var snapshot Snapshot
+	err := s.collection.FindOne(ctx, bson.D{{Key: "_id", Value: streamID}}).Decode(&snapshot)
+	if err == mongo.ErrNoDocuments {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &snapshot, nil
+}
+
+// AggregateRepositoryOptions defines aggregate repository configuration
+type AggregateRepositoryOptions struct {
+	Snapshots SnapshotStore
+	// SnapshotEvery takes a snapshot once this many events were saved since
+	// the last snapshot, 0 disables snapshots
+	SnapshotEvery int64
+	// Bus receives saved events after they were appended
+	Bus *EventBus
+	// OnPublishError is called for saved events the Bus failed to publish.
+	// The events stay stored, so Save still succeeds. Use an Outbox for
+	// guaranteed delivery.
+	OnPublishError func(event Event, err error)
+}
+
+// AggregateRepository loads and saves event-sourced aggregates
+type AggregateRepository[T Aggregate] struct {
+	store   EventStore
+	factory func() T
+	options AggregateRepositoryOptions
+}
+
+// NewAggregateRepository creates a new aggregate repository. The factory
+// returns an empty aggregate that events are replayed onto.
+func NewAggregateRepository[T Aggregate](store EventStore, factory func() T, options ...AggregateRepositoryOptions) *AggregateRepository[T] {
+	var opts AggregateRepositoryOptions
+	if len(options) > 0 {
+		opts = options[0]
+	}
+
+	return &AggregateRepository[T]{
+		store:   store,
+		factory: factory,
+		options: opts,
+	}
+}
+
+// Load rebuilds an aggregate from its latest snapshot and the events after it
+func (r *AggregateRepository[T]) Load(ctx context.Context, id string) (T, error) {
+	aggregate := r.factory()
+	root := aggregate.root()
+	root.id = id
+
+	if r.options.Snapshots != nil {
+		snapshot, err := r.options.Snapshots.LoadSnapshot(ctx, id)
+		if err != nil {
+			return aggregate, err
+		}
+		if snapshot != nil {
+			if err := restoreSnapshot(aggregate, snapshot.Data); err != nil {
+				return aggregate, fmt.Errorf("failed to restore snapshot of %s: %v", id, err)
+			}
+			root.version = snapshot.Version
+			root.snapshotVersion = snapshot.Version
+		}
+	}
+
+	events, err := r.store.Load(ctx, id, root.version)
+	if err != nil {
+		return aggregate, err
+	}
+	if root.version == 0 && len(events) == 0 {
+		return aggregate, ErrAggregateNotFound
+	}
+
+	for _, event := range events {
+		aggregate.Apply(event.Event)
+		root.version = event.Version
+	}
+	return aggregate, nil
+}
+
+// Save appends the uncommitted events of an aggregate. It fails with
+// ErrConcurrencyConflict when the stream changed since the aggregate was loaded.
+// Publish failures are reported to OnPublishError and do not fail the save.
+func (r *AggregateRepository[T]) Save(ctx context.Context, aggregate T) error {
+	root := aggregate.root()
+	if len(root.changes) == 0 {
+		return nil
+	}
+	if root.id == "" {
+		return fmt.Errorf("aggregate has no ID")
+	}
+
+	// Stored events keep the ID of the request that caused them
+	for _, event := range root.changes {
+		stampRequestID(ctx, event)
+	}
+
+	expected := root.version - int64(len(root.changes))
+	version, err := r.store.Append(ctx, root.id, expected, root.changes...)
+	if err != nil {
+		return err
+	}
+
+	changes := root.changes
+	root.version = version
+	root.changes = nil
+
+	if r.options.Bus != nil {
+		for _, event := range changes {
+			if err := r.options.Bus.PublishContext(ctx, event); err != nil && r.options.OnPublishError != nil {
+				r.options.OnPublishError(event, err)
+			}
+		}
+	}
+
+	if r.options.Snapshots != nil && r.options.SnapshotEvery > 0 && version-root.snapshotVersion >= r.options.SnapshotEvery {
+		// The events are already saved, a failed snapshot is retried on the next save
+		data, err := takeSnapshot(aggregate)
+		if err != nil {
+			return fmt.Errorf("failed to snapshot %s: %v", root.id, err)
+		}
+		if err := r.options.Snapshots.SaveSnapshot(ctx, Snapshot{
+			StreamID:  root.id,
+			Version:   version,
+			Data:      data,
+			Timestamp: time.Now().UTC(),
+		}); err != nil {
+			return fmt.Errorf("failed to snapshot %s: %v", root.id, err)
+		}
+		root.snapshotVersion = version
+	}
+	return nil
+}
+
+func takeSnapshot(aggregate Aggregate) ([]byte, error) {
+	if snapshotter, ok := aggregate.(Snapshotter); ok {
+		return snapshotter.Snapshot()
+	}
+	return json.Marshal(aggregate)
+}
+
+func restoreSnapshot(aggregate Aggregate, data []byte) error {
+	if snapshotter, ok := aggregate.(Snapshotter); ok {
+		return snapshotter.RestoreSnapshot(data)
+	}
+	return json.Unmarshal(data, aggregate)
+}
@@ -0,0 +1,202 @@
+package core
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+type testDeposited struct {
+	Amount int `json:"amount"`
+}
+
+func (e *testDeposited) GetName() string         { return "account.deposited" }
+func (e *testDeposited) GetTimestamp() time.Time { return time.Time{} }
+
+// testUnencodable cannot be marshaled to JSON
+type testUnencodable struct {
+	C chan int
+}
+
+func (e *testUnencodable) GetName() string         { return "account.unencodable" }
+func (e *testUnencodable) GetTimestamp() time.Time { return time.Time{} }
+
+type testAccount struct {
+	AggregateRoot
+	Balance int `json:"balance"`
+	applied int
+}
+
+func (a *testAccount) Apply(event Event) {
+	a.applied++
+	if e, ok := event.(*testDeposited); ok {
+		a.Balance += e.Amount
+	}
+}
+
+func newTestAccount() *testAccount {
+	return &testAccount{}
+}
+
+func depositTestAccount(t *testing.T, repo *AggregateRepository[*testAccount], id string, amounts ...int) {
+	t.Helper()
+	account, err := repo.Load(context.Background(), id)
+	if err != nil && !errors.Is(err, ErrAggregateNotFound) {
+		t.Fatal(err)
+	}
+	for _, amount := range amounts {
+		RaiseEvent(account, &testDeposited{Amount: amount})
+	}
+	if err := repo.Save(context.Background(), account); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestAggregateRepositoryLoadNotFound(t *testing.T) {
+	repo := NewAggregateRepository(NewMemoryEventStore(), newTestAccount)
+
+	account, err := repo.Load(context.Background(), "acc-1")
+	if !errors.Is(err, ErrAggregateNotFound) {
+		t.Fatalf("expected ErrAggregateNotFound, got %v", err)
+	}
+	if account.AggregateID() != "acc-1" || account.Version() != 0 {
+		t.Fatalf("expected an empty aggregate with the ID, got %q at %d", account.AggregateID(), account.Version())
+	}
+}
+
+func TestAggregateRepositorySaveAndLoad(t *testing.T) {
+	repo := NewAggregateRepository(NewMemoryEventStore(), newTestAccount)
+	depositTestAccount(t, repo, "acc-1", 10, 5)
+	depositTestAccount(t, repo, "acc-1", 20)
+
+	account, err := repo.Load(context.Background(), "acc-1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if account.Balance != 35 || account.Version() != 3 || len(account.Changes()) != 0 {
+		t.Fatalf("expected balance 35 at version 3, got %d at %d", account.Balance, account.Version())
+	}
+}
+
+func TestAggregateRepositoryConcurrencyConflict(t *testing.T) {
+	ctx := context.Background()
+	repo := NewAggregateRepository(NewMemoryEventStore(), newTestAccount)
+	depositTestAccount(t, repo, "acc-1", 10)
+
+	first, err := repo.Load(ctx, "acc-1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	second, err := repo.Load(ctx, "acc-1")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	RaiseEvent(first, &testDeposited{Amount: 1})
+	if err := repo.Save(ctx, first); err != nil {
+		t.Fatal(err)
+	}
+	RaiseEvent(second, &testDeposited{Amount: 2})
+	if err := repo.Save(ctx, second); !errors.Is(err, ErrConcurrencyConflict) {
+		t.Fatalf("expected ErrConcurrencyConflict, got %v", err)
+	}
+
+	account, err := repo.Load(ctx, "acc-1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if account.Balance != 11 || account.Version() != 2 {
+		t.Fatalf("expected only the first save, got balance %d at %d", account.Balance, account.Version())
+	}
+}
+
+func TestAggregateRepositorySnapshotRestore(t *testing.T) {
+	ctx := context.Background()
+	snapshots := NewMemorySnapshotStore()
+	repo := NewAggregateRepository(NewMemoryEventStore(), newTestAccount, AggregateRepositoryOptions{
+		Snapshots:     snapshots,
+		SnapshotEvery: 2,
+	})
+
+	depositTestAccount(t, repo, "acc-1", 10)
+	if snapshot, _ := snapshots.LoadSnapshot(ctx, "acc-1"); snapshot != nil {
+		t.Fatalf("expected no snapshot after one event, got version %d", snapshot.Version)
+	}
+	depositTestAccount(t, repo, "acc-1", 20)
+	depositTestAccount(t, repo, "acc-1", 5)
+
+	snapshot, err := snapshots.LoadSnapshot(ctx, "acc-1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if snapshot == nil || snapshot.Version != 2 {
+		t.Fatalf("expected a snapshot at version 2, got %+v", snapshot)
+	}
+
+	account, err := repo.Load(ctx, "acc-1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if account.Balance != 35 || account.Version() != 3 {
+		t.Fatalf("expected balance 35 at version 3, got %d at %d", account.Balance, account.Version())
+	}
+	if account.applied != 1 {
+		t.Fatalf("expected only the event after the snapshot to be replayed, got %d", account.applied)
+	}
+}
+
+func TestAggregateRepositoryPublishFailureKeepsSave(t *testing.T) {
+	ctx := context.Background()
+	bus := NewEventBus()
+	bus.Close()
+
+	var failed []Event
+	snapshots := NewMemorySnapshotStore()
+	repo := NewAggregateRepository(NewMemoryEventStore(), newTestAccount, AggregateRepositoryOptions{
+		Snapshots:     snapshots,
+		SnapshotEvery: 1,
+		Bus:           bus,
+		OnPublishError: func(event Event, err error) {
+			if !errors.Is(err, ErrEventBusClosed) {
+				t.Errorf("expected ErrEventBusClosed, got %v", err)
+			}
+			failed = append(failed, event)
+		},
+	})
+
+	account := newTestAccount()
+	account.SetAggregateID("acc-1")
+	RaiseEvent(account, &testDeposited{Amount: 10})
+	RaiseEvent(account, &testDeposited{Amount: 5})
+	if err := repo.Save(ctx, account); err != nil {
+		t.Fatalf("expected the save to succeed, got %v", err)
+	}
+	if len(failed) != 2 {
+		t.Fatalf("expected both events to be reported, got %d", len(failed))
+	}
+	if snapshot, _ := snapshots.LoadSnapshot(ctx, "acc-1"); snapshot == nil || snapshot.Version != 2 {
+		t.Fatalf("expected the snapshot to be taken, got %+v", snapshot)
+	}
+}
+
+func TestMemoryEventStoreAppendIsAtomic(t *testing.T) {
+	ctx := context.Background()
+	store := NewMemoryEventStore()
+
+	_, err := store.Append(ctx, "acc-1", NoStream, &testDeposited{Amount: 1}, &testUnencodable{})
+	if err == nil {
+		t.Fatal("expected the encode error")
+	}
+
+	events, err := store.Load(ctx, "acc-1", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(events) != 0 {
+		t.Fatalf("expected nothing to be appended, got %d events", len(events))
+	}
+	if _, err := store.Append(ctx, "acc-1", NoStream, &testDeposited{Amount: 1}); err != nil {
+		t.Fatalf("expected the stream to still be empty, got %v", err)
+	}
+}
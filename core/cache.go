@@ -1,6 +1,11 @@
 package core
 
 import (
+	"container/heap"
+	"encoding/json"
+	"net/url"
+	"sort"
+	"strings"
 	"sync"
 	"time"
 
@@ -13,82 +18,627 @@ type CacheItem struct {
 	Expiration time.Time
 }
 
+// Expired reports whether the item has expired
+func (i CacheItem) Expired(now time.Time) bool {
+	return !i.Expiration.IsZero() && now.After(i.Expiration)
+}
+
+// EvictionPolicy decides which entry is removed when the cache is full
+type EvictionPolicy int
+
+const (
+	// EvictLRU removes the least recently used entry
+	EvictLRU EvictionPolicy = iota
+	// EvictLFU removes the least frequently used entry
+	EvictLFU
+)
+
+// EvictionReason describes why an entry left the cache
+type EvictionReason int
+
+const (
+	EvictionReasonCapacity EvictionReason = iota
+	EvictionReasonExpired
+)
+
+// CacheSizer can be implemented by values to report their size in bytes
+type CacheSizer interface {
+	CacheSize() int64
+}
+
+// CacheOptions defines cache configuration
+type CacheOptions struct {
+	// MaxEntries limits the number of entries, 0 means unlimited
+	MaxEntries int
+	// MaxBytes limits the estimated size of all values, 0 means unlimited
+	MaxBytes int64
+	// Eviction selects the entry removed when a limit is reached
+	Eviction EvictionPolicy
+	// CleanupInterval enables a background janitor that purges expired entries
+	CleanupInterval time.Duration
+	// OnEvict is called after an entry was evicted or expired
+	OnEvict func(key string, value interface{}, reason EvictionReason)
+	// SizeFunc estimates the size of a value, defaults to EstimateCacheSize
+	SizeFunc func(value interface{}) int64
+}
+
+// CacheStats holds cache statistics
+type CacheStats struct {
+	Hits        uint64 `json:"hits"`
+	Misses      uint64 `json:"misses"`
+	Evictions   uint64 `json:"evictions"`
+	Expirations uint64 `json:"expirations"`
+	Entries     int    `json:"entries"`
+	Bytes       int64  `json:"bytes"`
+}
+
 // Cache is a thread-safe in-memory cache
 type Cache struct {
-	items map[string]CacheItem
-	mu    sync.RWMutex
+	items   map[string]*cacheEntry
+	order   cacheHeap
+	options CacheOptions
+	stats   CacheStats
+	seq     uint64
+	stop    chan struct{}
+	once    sync.Once
+	mu      sync.Mutex
+}
+
+type cacheEntry struct {
+	key   string
+	item  CacheItem
+	size  int64
+	freq  uint64
+	seq   uint64
+	index int
 }
 
-// NewCache creates a new cache
+type evictedEntry struct {
+	key    string
+	value  interface{}
+	reason EvictionReason
+}
+
+// NewCache creates a new unbounded cache
 func NewCache() *Cache {
-	return &Cache{
-		items: make(map[string]CacheItem),
+	return NewCacheWithOptions(CacheOptions{})
+}
+
+// NewCacheWithOptions creates a new cache with limits and an optional janitor
+func NewCacheWithOptions(options CacheOptions) *Cache {
+	if options.SizeFunc == nil {
+		options.SizeFunc = EstimateCacheSize
+	}
+
+	c := &Cache{
+		items:   make(map[string]*cacheEntry),
+		options: options,
+		stop:    make(chan struct{}),
+	}
+	c.order.policy = options.Eviction
+
+	if options.CleanupInterval > 0 {
+		go c.janitor(options.CleanupInterval)
 	}
+
+	return c
 }
 
-// Set adds an item to the cache with an expiration time
+// Set adds an item to the cache with an expiration time.
+// An expiration of zero or less keeps the item until it is evicted; before
+// the cache had limits such items expired immediately. A value larger than
+// MaxBytes is not stored and replaces any previous value of the key.
 func (c *Cache) Set(key string, value interface{}, expiration time.Duration) {
+	item := CacheItem{Value: value}
+	if expiration > 0 {
+		item.Expiration = time.Now().Add(expiration)
+	}
+
+	var size int64
+	if c.options.MaxBytes > 0 {
+		size = c.options.SizeFunc(value)
+	}
+
 	c.mu.Lock()
-	defer c.mu.Unlock()
+	if c.options.MaxBytes > 0 && size > c.options.MaxBytes {
+		if entry, exists := c.items[key]; exists {
+			c.removeEntry(entry)
+		}
+		c.stats.Evictions++
+		c.mu.Unlock()
+		c.notify([]evictedEntry{{key: key, value: value, reason: EvictionReasonCapacity}})
+		return
+	}
 
-	c.items[key] = CacheItem{
-		Value:      value,
-		Expiration: time.Now().Add(expiration),
+	c.seq++
+	if entry, exists := c.items[key]; exists {
+		c.stats.Bytes += size - entry.size
+		entry.item = item
+		entry.size = size
+		entry.freq++
+		entry.seq = c.seq
+		heap.Fix(&c.order, entry.index)
+	} else {
+		entry := &cacheEntry{key: key, item: item, size: size, freq: 1, seq: c.seq}
+		c.items[key] = entry
+		heap.Push(&c.order, entry)
+		c.stats.Bytes += size
 	}
+	evicted := c.enforceLimits(key)
+	c.mu.Unlock()
+
+	c.notify(evicted)
 }
 
 // Get retrieves an item from the cache
 func (c *Cache) Get(key string) (interface{}, bool) {
-	c.mu.RLock()
-	item, exists := c.items[key]
-	c.mu.RUnlock()
+	c.mu.Lock()
 
+	entry, exists := c.items[key]
 	if !exists {
+		c.stats.Misses++
+		c.mu.Unlock()
 		return nil, false
 	}
 
-	if time.Now().After(item.Expiration) {
-		c.Delete(key)
+	if entry.item.Expired(time.Now()) {
+		c.removeEntry(entry)
+		c.stats.Misses++
+		c.stats.Expirations++
+		c.mu.Unlock()
+		c.notify([]evictedEntry{{key: key, value: entry.item.Value, reason: EvictionReasonExpired}})
 		return nil, false
 	}
 
-	return item.Value, true
+	c.seq++
+	entry.freq++
+	entry.seq = c.seq
+	heap.Fix(&c.order, entry.index)
+	c.stats.Hits++
+	value := entry.item.Value
+	c.mu.Unlock()
+
+	return value, true
+}
+
+// item returns an unexpired item without updating its usage
+func (c *Cache) item(key string) (CacheItem, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	entry, exists := c.items[key]
+	if !exists || entry.item.Expired(time.Now()) {
+		return CacheItem{}, false
+	}
+	return entry.item, true
 }
 
 // Delete removes an item from the cache
 func (c *Cache) Delete(key string) {
 	c.mu.Lock()
 	defer c.mu.Unlock()
-	delete(c.items, key)
+
+	if entry, exists := c.items[key]; exists {
+		c.removeEntry(entry)
+	}
 }
 
 // Clear removes all items from the cache
 func (c *Cache) Clear() {
 	c.mu.Lock()
 	defer c.mu.Unlock()
-	c.items = make(map[string]CacheItem)
+
+	c.items = make(map[string]*cacheEntry)
+	c.order.entries = nil
+	c.stats.Bytes = 0
+}
+
+// Len returns the number of entries, including expired ones not yet purged
+func (c *Cache) Len() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return len(c.items)
+}
+
+// Stats returns a snapshot of the cache statistics
+func (c *Cache) Stats() CacheStats {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	stats := c.stats
+	stats.Entries = len(c.items)
+	return stats
+}
+
+// DeleteExpired purges all expired entries
+func (c *Cache) DeleteExpired() {
+	now := time.Now()
+	var evicted []evictedEntry
+
+	c.mu.Lock()
+	for key, entry := range c.items {
+		if entry.item.Expired(now) {
+			c.removeEntry(entry)
+			c.stats.Expirations++
+			evicted = append(evicted, evictedEntry{key: key, value: entry.item.Value, reason: EvictionReasonExpired})
+		}
+	}
+	c.mu.Unlock()
+
+	c.notify(evicted)
+}
+
+// Close stops the background janitor
+func (c *Cache) Close() error {
+	c.once.Do(func() {
+		close(c.stop)
+	})
+	return nil
+}
+
+func (c *Cache) janitor(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			c.DeleteExpired()
+		case <-c.stop:
+			return
+		}
+	}
+}
+
+// enforceLimits evicts entries until the cache fits its limits. The entry
+// that was just written is never evicted for its own sake, Set rejects
+// entries that cannot fit on their own.
+func (c *Cache) enforceLimits(keep string) []evictedEntry {
+	var evicted []evictedEntry
+
+	for c.overLimit() && c.order.Len() > 1 {
+		entry := c.order.entries[0]
+		if entry.key == keep {
+			// Temporarily take the newest entry out so the next victim surfaces
+			heap.Pop(&c.order)
+			victim := c.order.entries[0]
+			heap.Push(&c.order, entry)
+			entry = victim
+		}
+
+		c.removeEntry(entry)
+		c.stats.Evictions++
+		evicted = append(evicted, evictedEntry{key: entry.key, value: entry.item.Value, reason: EvictionReasonCapacity})
+	}
+
+	return evicted
+}
+
+func (c *Cache) overLimit() bool {
+	if c.options.MaxEntries > 0 && len(c.items) > c.options.MaxEntries {
+		return true
+	}
+	return c.options.MaxBytes > 0 && c.stats.Bytes > c.options.MaxBytes
+}
+
+func (c *Cache) removeEntry(entry *cacheEntry) {
+	heap.Remove(&c.order, entry.index)
+	delete(c.items, entry.key)
+	c.stats.Bytes -= entry.size
+}
+
+func (c *Cache) notify(evicted []evictedEntry) {
+	if c.options.OnEvict == nil {
+		return
+	}
+	for _, e := range evicted {
+		c.options.OnEvict(e.key, e.value, e.reason)
+	}
 }
 
-// CacheMiddleware creates a middleware that caches responses
+// EstimateCacheSize estimates the size of a value in bytes
+func EstimateCacheSize(value interface{}) int64 {
+	switch v := value.(type) {
+	case CacheSizer:
+		return v.CacheSize()
+	case []byte:
+		return int64(len(v))
+	case string:
+		return int64(len(v))
+	default:
+		data, err := json.Marshal(v)
+		if err != nil {
+			return 0
+		}
+		return int64(len(data))
+	}
+}
+
+// cacheHeap orders entries so the next eviction candidate is on top
+type cacheHeap struct {
+	entries []*cacheEntry
+	policy  EvictionPolicy
+}
+
+func (h cacheHeap) Len() int { return len(h.entries) }
+
+func (h cacheHeap) Less(i, j int) bool {
+	a, b := h.entries[i], h.entries[j]
+	if h.policy == EvictLFU && a.freq != b.freq {
+		return a.freq < b.freq
+	}
+	return a.seq < b.seq
+}
+
+func (h cacheHeap) Swap(i, j int) {
+	h.entries[i], h.entries[j] = h.entries[j], h.entries[i]
+	h.entries[i].index = i
+	h.entries[j].index = j
+}
+
+func (h *cacheHeap) Push(x interface{}) {
+	entry := x.(*cacheEntry)
+	entry.index = len(h.entries)
+	h.entries = append(h.entries, entry)
+}
+
+func (h *cacheHeap) Pop() interface{} {
+	old := h.entries
+	n := len(old)
+	entry := old[n-1]
+	old[n-1] = nil
+	h.entries = old[:n-1]
+	return entry
+}
+
+// CachedResponse is a copy of an HTTP response stored by CacheMiddleware
+type CachedResponse struct {
+	Status  int
+	Headers map[string]string
+	Body    []byte
+	// Vary lists the request headers of the response's Vary header. The
+	// entry under the request's key then only records them, and each
+	// variant is stored under a key including their values.
+	Vary []string `json:",omitempty"`
+	// Shared responses are marked public or have s-maxage, so they may be
+	// replayed to requests with credentials
+	Shared bool `json:",omitempty"`
+}
+
+// CacheMiddlewareOptions defines response cache configuration
+type CacheMiddlewareOptions struct {
+	Expiration time.Duration
+	// Methods that may be cached, defaults to GET and HEAD
+	Methods []string
+	// Statuses that may be cached, defaults to 200, 203, 204, 300, 301, 404, 405, 410 and 414
+	Statuses []int
+	// VaryHeaders are request headers included in the cache key
+	VaryHeaders []string
+	// Headers are response headers stored and replayed with the body
+	Headers []string
+	// KeyPrefix is prepended to every cache key
+	KeyPrefix string
+}
+
+// DefaultCacheMiddlewareOptions returns the default response cache options
+func DefaultCacheMiddlewareOptions() CacheMiddlewareOptions {
+	return CacheMiddlewareOptions{
+		Expiration: time.Minute,
+		Methods:    []string{fiber.MethodGet, fiber.MethodHead},
+		Statuses:   []int{200, 203, 204, 300, 301, 404, 405, 410, 414},
+		Headers: []string{
+			fiber.HeaderContentType,
+			fiber.HeaderContentEncoding,
+			fiber.HeaderContentLanguage,
+			fiber.HeaderCacheControl,
+			fiber.HeaderETag,
+			fiber.HeaderLastModified,
+			fiber.HeaderLocation,
+			fiber.HeaderVary,
+		},
+		KeyPrefix: "http:",
+	}
+}
+
+// CacheMiddleware creates a middleware that caches responses in memory
 func CacheMiddleware(cache *Cache, expiration time.Duration) fiber.Handler {
+	options := DefaultCacheMiddlewareOptions()
+	options.Expiration = expiration
+	return CacheMiddlewareWithOptions(NewMemoryCacheStore(cache, nil), options)
+}
+
+// CacheMiddlewareWithOptions creates a response cache middleware backed by any
+// CacheStore. Requests with an Authorization or Cookie header only share
+// responses marked Cache-Control: public or with s-maxage, and responses are
+// keyed by the request values of the headers in their Vary header.
+func CacheMiddlewareWithOptions(store CacheStore, options CacheMiddlewareOptions) fiber.Handler {
+	defaults := DefaultCacheMiddlewareOptions()
+	if options.Expiration == 0 {
+		options.Expiration = defaults.Expiration
+	}
+	if len(options.Methods) == 0 {
+		options.Methods = defaults.Methods
+	}
+	if len(options.Statuses) == 0 {
+		options.Statuses = defaults.Statuses
+	}
+	if len(options.Headers) == 0 {
+		options.Headers = defaults.Headers
+	}
+
+	methods := make(map[string]bool, len(options.Methods))
+	for _, method := range options.Methods {
+		methods[strings.ToUpper(method)] = true
+	}
+
+	statuses := make(map[int]bool, len(options.Statuses))
+	for _, status := range options.Statuses {
+		statuses[status] = true
+	}
+
 	return func(ctx *fiber.Ctx) error {
-		// Generate cache key from request
-		cacheKey := ctx.Path() + ":" + ctx.Method()
+		if !methods[ctx.Method()] || hasCacheDirective(ctx.Get(fiber.HeaderCacheControl), "no-store") {
+			return ctx.Next()
+		}
 
-		// Try to get from cache
-		if cached, exists := cache.Get(cacheKey); exists {
-			return ctx.JSON(cached)
+		cacheKey := options.KeyPrefix + responseCacheKey(ctx, options.VaryHeaders)
+		credentials := len(ctx.Request().Header.Peek(fiber.HeaderAuthorization)) > 0 ||
+			len(ctx.Request().Header.Peek(fiber.HeaderCookie)) > 0
+
+		// Replay the cached response, backend errors are treated as a miss
+		if hit := lookupCachedResponse(ctx, store, cacheKey); hit != nil && (hit.Shared || !credentials) {
+			ctx.Status(hit.Status)
+			for name, value := range hit.Headers {
+				ctx.Set(name, value)
+			}
+			ctx.Set("X-Cache", "HIT")
+			return ctx.Send(hit.Body)
 		}
 
 		// Continue to handler
-		err := ctx.Next()
-		if err != nil {
+		if err := ctx.Next(); err != nil {
 			return err
 		}
 
-		// Cache the response
-		cache.Set(cacheKey, ctx.Response().Body(), expiration)
+		ctx.Set("X-Cache", "MISS")
+
+		response := ctx.Response()
+		if !statuses[response.StatusCode()] || !isCacheableResponse(ctx) {
+			return nil
+		}
+
+		cacheControl := string(response.Header.Peek(fiber.HeaderCacheControl))
+		shared := hasCacheDirective(cacheControl, "public") || hasCacheDirective(cacheControl, "s-maxage")
+		if credentials && !shared {
+			return nil
+		}
+
+		vary, ok := responseVary(string(response.Header.Peek(fiber.HeaderVary)))
+		if !ok {
+			return nil
+		}
+		if len(vary) > 0 {
+			if err := store.Set(cacheKey, &CachedResponse{Vary: vary}, options.Expiration); err != nil {
+				return nil
+			}
+			cacheKey += varyCacheKey(ctx, vary)
+		}
+
+		// fasthttp reuses the response buffers, so everything must be copied
+		cached := &CachedResponse{
+			Status:  response.StatusCode(),
+			Headers: make(map[string]string),
+			Body:    append([]byte(nil), response.Body()...),
+			Shared:  shared,
+		}
+		for _, name := range options.Headers {
+			if value := response.Header.Peek(name); len(value) > 0 {
+				cached.Headers[name] = string(value)
+			}
+		}
+
+		store.Set(cacheKey, cached, options.Expiration)
 
 		return nil
 	}
-} 
\ No newline at end of file
+}
+
+// responseCacheKey builds a key from the method, host, path, sorted query
+// string and the values of the vary headers
+func responseCacheKey(ctx *fiber.Ctx, varyHeaders []string) string {
+	var key strings.Builder
+	key.WriteString(ctx.Method())
+	key.WriteString(" ")
+	key.WriteString(ctx.Hostname())
+	key.WriteString(ctx.Path())
+
+	query := url.Values{}
+	ctx.Request().URI().QueryArgs().VisitAll(func(k, v []byte) {
+		query.Add(string(k), string(v))
+	})
+	if len(query) > 0 {
+		for _, values := range query {
+			sort.Strings(values)
+		}
+		key.WriteString("?")
+		key.WriteString(query.Encode())
+	}
+
+	for _, header := range varyHeaders {
+		key.WriteString("|")
+		key.WriteString(strings.ToLower(header))
+		key.WriteString("=")
+		key.WriteString(ctx.Get(header))
+	}
+
+	return key.String()
+}
+
+// lookupCachedResponse returns the stored response for a request, following
+// the Vary entry to the variant of the request
+func lookupCachedResponse(ctx *fiber.Ctx, store CacheStore, cacheKey string) *CachedResponse {
+	var hit CachedResponse
+	if found, err := store.Get(cacheKey, &hit); err != nil || !found {
+		return nil
+	}
+	if len(hit.Vary) == 0 {
+		return &hit
+	}
+
+	var variant CachedResponse
+	if found, err := store.Get(cacheKey+varyCacheKey(ctx, hit.Vary), &variant); err != nil || !found {
+		return nil
+	}
+	return &variant
+}
+
+// responseVary returns the lowercased names of a Vary header, and false for
+// "Vary: *", which matches no later request
+func responseVary(header string) ([]string, bool) {
+	var names []string
+	for _, name := range strings.Split(header, ",") {
+		name = strings.ToLower(strings.TrimSpace(name))
+		if name == "*" {
+			return nil, false
+		}
+		if name != "" {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	return names, true
+}
+
+func varyCacheKey(ctx *fiber.Ctx, names []string) string {
+	var key strings.Builder
+	key.WriteString("#vary")
+	for _, name := range names {
+		key.WriteString("|")
+		key.WriteString(name)
+		key.WriteString("=")
+		key.WriteString(ctx.Get(name))
+	}
+	return key.String()
+}
+
+func isCacheableResponse(ctx *fiber.Ctx) bool {
+	header := &ctx.Response().Header
+	if len(header.Peek(fiber.HeaderSetCookie)) > 0 {
+		return false
+	}
+
+	cacheControl := string(header.Peek(fiber.HeaderCacheControl))
+	return !hasCacheDirective(cacheControl, "no-store") &&
+		!hasCacheDirective(cacheControl, "no-cache") &&
+		!hasCacheDirective(cacheControl, "private")
+}
+
+func hasCacheDirective(cacheControl, directive string) bool {
+	for _, part := range strings.Split(cacheControl, ",") {
+		name, _, _ := strings.Cut(strings.TrimSpace(part), "=")
+		if strings.EqualFold(name, directive) {
+			return true
+		}
+	}
+	return false
+}
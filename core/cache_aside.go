@@ -0,0 +1,279 @@
+package core
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"golang.org/x/sync/singleflight"
+)
+
+// CacheLoader loads a value when it is missing from the cache. Background
+// refreshes outlive the request, so loaders must use the context they are
+// given and not capture a *fiber.Ctx, which is recycled after the handler.
+type CacheLoader func(ctx context.Context) (interface{}, error)
+
+// CacheAsideOptions defines cache-aside configuration
+type CacheAsideOptions struct {
+	// StaleTTL is how long an expired value may still be served while it is
+	// refreshed in the background, 0 disables stale-while-revalidate
+	StaleTTL time.Duration
+	// NegativeTTL is how long a NegativeError result is cached, 0 disables negative caching
+	NegativeTTL time.Duration
+	// NegativeError is the loader error that is cached, defaults to ErrCacheNotFound
+	NegativeError error
+	// Codec serializes values inside cache entries, defaults to JSON
+	Codec CacheCodec
+	// KeyPrefix is prepended to all keys. Entries and tag versions are kept
+	// in separate namespaces below it.
+	KeyPrefix string
+	// OnRefreshError is called when a background refresh fails
+	OnRefreshError func(key string, err error)
+}
+
+// CacheAside implements the cache-aside pattern on top of a CacheStore.
+// Concurrent loads of the same key are deduplicated and entries can be
+// tagged so related keys are invalidated together.
+type CacheAside struct {
+	store   CacheStore
+	options CacheAsideOptions
+	group   singleflight.Group
+	// refreshing holds the keys with a background refresh in flight
+	refreshing sync.Map
+	now        func() time.Time
+}
+
+// cacheAsideEntry is the envelope stored for every key
+type cacheAsideEntry struct {
+	Data       []byte           `json:"data,omitempty"`
+	Negative   bool             `json:"negative,omitempty"`
+	FreshUntil time.Time        `json:"freshUntil"`
+	Tags       map[string]int64 `json:"tags,omitempty"`
+}
+
+func (e *cacheAsideEntry) stale(now time.Time) bool {
+	return !e.FreshUntil.IsZero() && now.After(e.FreshUntil)
+}
+
+// NewCacheAside creates a new cache-aside helper
+func NewCacheAside(store CacheStore, options CacheAsideOptions) *CacheAside {
+	if options.NegativeError == nil {
+		options.NegativeError = ErrCacheNotFound
+	}
+	if options.Codec == nil {
+		options.Codec = JSONCacheCodec{}
+	}
+
+	return &CacheAside{
+		store:   store,
+		options: options,
+		now:     time.Now,
+	}
+}
+
+// GetOrLoad decodes the cached value into dest, or calls loader and caches
+// its result for ttl. Tags attach the entry to groups cleared by InvalidateTag.
+// Stale values are refreshed in the background with a context detached from
+// ctx's cancellation, at most one refresh per key at a time.
+func (c *CacheAside) GetOrLoad(ctx context.Context, key string, ttl time.Duration, dest interface{}, loader CacheLoader, tags ...string) error {
+	entry, found, err := c.lookup(key)
+	if err != nil {
+		return err
+	}
+
+	if found {
+		if entry.stale(c.now()) {
+			c.refreshInBackground(context.WithoutCancel(ctx), key, ttl, loader, tags)
+		}
+		return c.decode(entry, dest)
+	}
+
+	data, err := c.load(ctx, key, ttl, loader, tags)
+	if err != nil {
+		return err
+	}
+
+	return c.options.Codec.Unmarshal(data, dest)
+}
+
+// Set stores a value directly and attaches it to tags
+func (c *CacheAside) Set(key string, value interface{}, ttl time.Duration, tags ...string) error {
+	data, err := c.options.Codec.Marshal(value)
+	if err != nil {
+		return err
+	}
+	return c.write(key, &cacheAsideEntry{Data: data}, ttl, tags)
+}
+
+// Delete removes a key
+func (c *CacheAside) Delete(key string) error {
+	return c.store.Delete(c.entryKey(key))
+}
+
+// InvalidateTag invalidates every entry attached to one of the tags
+func (c *CacheAside) InvalidateTag(tags ...string) error {
+	version := c.now().UnixNano()
+	for _, tag := range tags {
+		if err := c.store.Set(c.tagKey(tag), version, 0); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// lookup returns the entry for key unless it is missing, past its stale
+// window or attached to an invalidated tag
+func (c *CacheAside) lookup(key string) (*cacheAsideEntry, bool, error) {
+	var entry cacheAsideEntry
+	found, err := c.store.Get(c.entryKey(key), &entry)
+	if err != nil || !found {
+		return nil, false, err
+	}
+
+	for tag, version := range entry.Tags {
+		current, err := c.tagVersion(tag)
+		if err != nil {
+			return nil, false, err
+		}
+		if current != version {
+			c.Delete(key)
+			return nil, false, nil
+		}
+	}
+
+	if entry.Negative && entry.stale(c.now()) {
+		return nil, false, nil
+	}
+
+	return &entry, true, nil
+}
+
+// load calls the loader once per key no matter how many callers wait for it
+func (c *CacheAside) load(ctx context.Context, key string, ttl time.Duration, loader CacheLoader, tags []string) ([]byte, error) {
+	result, err, _ := c.group.Do(key, func() (interface{}, error) {
+		// Tag versions are read before loading, so an invalidation that
+		// happens while loading makes the new entry stale immediately
+		versions, err := c.tagVersions(tags)
+		if err != nil {
+			return nil, err
+		}
+
+		value, err := loader(ctx)
+		if err != nil {
+			if c.options.NegativeTTL > 0 && errors.Is(err, c.options.NegativeError) {
+				c.writeVersioned(key, &cacheAsideEntry{Negative: true}, c.options.NegativeTTL, versions)
+			}
+			return nil, err
+		}
+
+		data, err := c.options.Codec.Marshal(value)
+		if err != nil {
+			return nil, err
+		}
+
+		if err := c.writeVersioned(key, &cacheAsideEntry{Data: data}, ttl, versions); err != nil {
+			return nil, err
+		}
+
+		return data, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return result.([]byte), nil
+}
+
+// refreshInBackground starts a refresh unless one is already running for key
+func (c *CacheAside) refreshInBackground(ctx context.Context, key string, ttl time.Duration, loader CacheLoader, tags []string) {
+	if _, running := c.refreshing.LoadOrStore(key, struct{}{}); running {
+		return
+	}
+
+	go func() {
+		defer c.refreshing.Delete(key)
+		if _, err := c.load(ctx, key, ttl, loader, tags); err != nil && c.options.OnRefreshError != nil {
+			c.options.OnRefreshError(key, err)
+		}
+	}()
+}
+
+func (c *CacheAside) decode(entry *cacheAsideEntry, dest interface{}) error {
+	if entry.Negative {
+		return c.options.NegativeError
+	}
+	return c.options.Codec.Unmarshal(entry.Data, dest)
+}
+
+func (c *CacheAside) write(key string, entry *cacheAsideEntry, ttl time.Duration, tags []string) error {
+	versions, err := c.tagVersions(tags)
+	if err != nil {
+		return err
+	}
+	return c.writeVersioned(key, entry, ttl, versions)
+}
+
+func (c *CacheAside) writeVersioned(key string, entry *cacheAsideEntry, ttl time.Duration, versions map[string]int64) error {
+	entry.Tags = versions
+
+	// Entries without a ttl keep a zero FreshUntil and never go stale
+	storeTTL := ttl
+	if ttl > 0 {
+		entry.FreshUntil = c.now().Add(ttl)
+		if !entry.Negative {
+			storeTTL += c.options.StaleTTL
+		}
+	}
+
+	return c.store.Set(c.entryKey(key), entry, storeTTL)
+}
+
+func (c *CacheAside) tagVersions(tags []string) (map[string]int64, error) {
+	if len(tags) == 0 {
+		return nil, nil
+	}
+
+	versions := make(map[string]int64, len(tags))
+	for _, tag := range tags {
+		version, err := c.tagVersion(tag)
+		if err != nil {
+			return nil, err
+		}
+		versions[tag] = version
+	}
+	return versions, nil
+}
+
+func (c *CacheAside) tagVersion(tag string) (int64, error) {
+	var version int64
+	if _, err := c.store.Get(c.tagKey(tag), &version); err != nil {
+		return 0, err
+	}
+	return version, nil
+}
+
+// entryKey and tagKey use separate namespaces, so no user key can
+// overwrite a tag version
+func (c *CacheAside) entryKey(key string) string {
+	return c.options.KeyPrefix + "entry:" + key
+}
+
+func (c *CacheAside) tagKey(tag string) string {
+	return c.options.KeyPrefix + "tag:" + tag
+}
+
+// CacheGetOrLoad is a typed wrapper around CacheAside.GetOrLoad
+func CacheGetOrLoad[T any](ctx context.Context, c *CacheAside, key string, ttl time.Duration, loader func(ctx context.Context) (T, error), tags ...string) (T, error) {
+	var value T
+	err := c.GetOrLoad(ctx, key, ttl, &value, func(ctx context.Context) (interface{}, error) {
+		return loader(ctx)
+	}, tags...)
+	return value, err
+}
+
+// Cache-aside errors
+var (
+	ErrCacheNotFound = fiber.NewError(fiber.StatusNotFound, "not found")
+)
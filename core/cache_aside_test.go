@@ -0,0 +1,96 @@
+package core
+
+import (
+	"context"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestCacheAsideKeysDoNotCollideWithTags(t *testing.T) {
+	cache := NewCacheAside(NewMemoryCacheStore(nil, nil), CacheAsideOptions{})
+	ctx := context.Background()
+
+	if err := cache.Set("a", "value", time.Minute, "x"); err != nil {
+		t.Fatal(err)
+	}
+	// A user key that looks like a tag key must not bump the tag version
+	if err := cache.Set("tag:x", 42, time.Minute); err != nil {
+		t.Fatal(err)
+	}
+
+	var value string
+	loads := 0
+	err := cache.GetOrLoad(ctx, "a", time.Minute, &value, func(context.Context) (interface{}, error) {
+		loads++
+		return "reloaded", nil
+	}, "x")
+	if err != nil || value != "value" || loads != 0 {
+		t.Fatalf("GetOrLoad = %q, %v after %d loads, want the cached value", value, err, loads)
+	}
+
+	cache.InvalidateTag("x")
+	cache.GetOrLoad(ctx, "a", time.Minute, &value, func(context.Context) (interface{}, error) {
+		loads++
+		return "reloaded", nil
+	}, "x")
+	if value != "reloaded" || loads != 1 {
+		t.Fatalf("GetOrLoad after InvalidateTag = %q after %d loads", value, loads)
+	}
+}
+
+type refreshKey struct{}
+
+func TestCacheAsideRefreshesStaleValuesOnce(t *testing.T) {
+	cache := NewCacheAside(NewMemoryCacheStore(nil, nil), CacheAsideOptions{StaleTTL: time.Minute})
+	now := time.Now()
+	cache.now = func() time.Time { return now }
+
+	cache.Set("k", "old", time.Second)
+	now = now.Add(2 * time.Second)
+
+	var loads int32
+	release := make(chan struct{})
+	loaded := make(chan context.Context, 1)
+	loader := func(ctx context.Context) (interface{}, error) {
+		atomic.AddInt32(&loads, 1)
+		<-release
+		loaded <- ctx
+		return "new", nil
+	}
+
+	// The request context is cancelled before the refresh finishes
+	ctx, cancel := context.WithCancel(context.WithValue(context.Background(), refreshKey{}, "request"))
+	for i := 0; i < 10; i++ {
+		var value string
+		if err := cache.GetOrLoad(ctx, "k", time.Second, &value, loader); err != nil || value != "old" {
+			t.Fatalf("stale GetOrLoad = %q, %v", value, err)
+		}
+	}
+	cancel()
+	close(release)
+
+	refreshCtx := <-loaded
+	if refreshCtx.Err() != nil {
+		t.Fatal("refresh context was cancelled with the request")
+	}
+	if refreshCtx.Value(refreshKey{}) != "request" {
+		t.Fatal("refresh context lost the request values")
+	}
+
+	deadline := time.Now().Add(time.Second)
+	for {
+		var value string
+		if entry, found, _ := cache.lookup("k"); found && cache.decode(entry, &value) == nil && value == "new" {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("refreshed value was not stored")
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	if n := atomic.LoadInt32(&loads); n != 1 {
+		t.Fatalf("loader ran %d times, want 1", n)
+	}
+}
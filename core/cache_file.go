@@ -0,0 +1,137 @@
+package core
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+const (
+	fileCacheExt = ".cache"
+	fileCacheTmp = "tmp-"
+)
+
+// FileCacheStore is a CacheStore that keeps each entry in its own file.
+// Every file starts with the expiration as unix nanoseconds, 0 meaning none.
+type FileCacheStore struct {
+	dir   string
+	codec CacheCodec
+}
+
+// NewFileCacheStore creates a new on-disk cache store, codec defaults to JSON
+func NewFileCacheStore(dir string, codec CacheCodec) (*FileCacheStore, error) {
+	if err := os.MkdirAll(dir, os.ModePerm); err != nil {
+		return nil, err
+	}
+	if codec == nil {
+		codec = JSONCacheCodec{}
+	}
+	return &FileCacheStore{dir: dir, codec: codec}, nil
+}
+
+// Get implements CacheStore
+func (s *FileCacheStore) Get(key string, dest interface{}) (bool, error) {
+	path := s.path(key)
+
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	if len(data) < 8 {
+		os.Remove(path)
+		return false, nil
+	}
+
+	if expiration := int64(binary.BigEndian.Uint64(data[:8])); expiration != 0 && time.Now().UnixNano() > expiration {
+		os.Remove(path)
+		return false, nil
+	}
+
+	if err := s.codec.Unmarshal(data[8:], dest); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// Set implements CacheStore
+func (s *FileCacheStore) Set(key string, value interface{}, ttl time.Duration) error {
+	data, err := s.codec.Marshal(value)
+	if err != nil {
+		return err
+	}
+
+	var expiration int64
+	if ttl > 0 {
+		expiration = time.Now().Add(ttl).UnixNano()
+	}
+
+	content := make([]byte, 8, 8+len(data))
+	binary.BigEndian.PutUint64(content, uint64(expiration))
+	content = append(content, data...)
+
+	// Write to a temporary file first so readers never see partial entries
+	tmp, err := os.CreateTemp(s.dir, fileCacheTmp+"*")
+	if err != nil {
+		return err
+	}
+	if _, err := tmp.Write(content); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+
+	if err := os.Rename(tmp.Name(), s.path(key)); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	return nil
+}
+
+// Delete implements CacheStore
+func (s *FileCacheStore) Delete(key string) error {
+	err := os.Remove(s.path(key))
+	if os.IsNotExist(err) {
+		return nil
+	}
+	return err
+}
+
+// Clear implements CacheStore. Temporary files left by interrupted writes
+// are removed as well.
+func (s *FileCacheStore) Clear() error {
+	entries, err := os.ReadDir(s.dir)
+	if err != nil {
+		return err
+	}
+
+	for _, entry := range entries {
+		if name := entry.Name(); strings.HasSuffix(name, fileCacheExt) || strings.HasPrefix(name, fileCacheTmp) {
+			if err := os.Remove(filepath.Join(s.dir, name)); err != nil && !os.IsNotExist(err) {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// Close implements CacheStore
+func (s *FileCacheStore) Close() error {
+	return nil
+}
+
+func (s *FileCacheStore) path(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return filepath.Join(s.dir, hex.EncodeToString(sum[:])+fileCacheExt)
+}
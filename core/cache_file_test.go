@@ -0,0 +1,60 @@
+package core
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestFileCacheStore(t *testing.T) {
+	store, err := NewFileCacheStore(t.TempDir(), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := store.Set("a", "value", time.Millisecond); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(5 * time.Millisecond)
+
+	var value string
+	if found, _ := store.Get("a", &value); found {
+		t.Fatal("expired entry was found")
+	}
+
+	store.Set("b", "value", 0)
+	if found, err := store.Get("b", &value); err != nil || !found || value != "value" {
+		t.Fatalf("Get = %v, %v, %q", found, err, value)
+	}
+}
+
+func TestFileCacheStoreClearRemovesTempFiles(t *testing.T) {
+	dir := t.TempDir()
+	store, err := NewFileCacheStore(dir, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	store.Set("a", 1, 0)
+	// Left behind by a write that was interrupted before the rename
+	if err := os.WriteFile(filepath.Join(dir, "tmp-123"), []byte("partial"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "unrelated.txt"), nil, 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := store.Clear(); err != nil {
+		t.Fatal(err)
+	}
+
+	entries, _ := os.ReadDir(dir)
+	if len(entries) != 1 || entries[0].Name() != "unrelated.txt" {
+		var names []string
+		for _, entry := range entries {
+			names = append(names, entry.Name())
+		}
+		t.Fatalf("files after Clear = %v, want [unrelated.txt]", names)
+	}
+}
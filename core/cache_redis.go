@@ -0,0 +1,386 @@
+package core
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// RedisCacheOptions defines Redis cache store configuration
+type RedisCacheOptions struct {
+	Addr     string
+	Password string
+	DB       int
+	// Prefix namespaces all keys, Clear only removes keys with this prefix
+	// and refuses to run without one
+	Prefix string
+	Codec  CacheCodec
+	// PoolSize limits the open connections, defaults to 10
+	PoolSize int
+	// PoolTimeout limits the wait for a free connection, defaults to 5s
+	PoolTimeout time.Duration
+	DialTimeout time.Duration
+	IOTimeout   time.Duration
+}
+
+// RedisCacheStore is a CacheStore speaking the Redis protocol (RESP).
+// It works with Redis, Valkey, KeyDB and in-process stand-ins such as miniredis.
+type RedisCacheStore struct {
+	options RedisCacheOptions
+	idle    chan *redisConn
+	// slots holds a token for every open connection
+	slots chan struct{}
+}
+
+type redisConn struct {
+	conn   net.Conn
+	reader *bufio.Reader
+}
+
+// NewRedisCacheStore creates a new Redis cache store
+func NewRedisCacheStore(options RedisCacheOptions) *RedisCacheStore {
+	if options.Addr == "" {
+		options.Addr = "localhost:6379"
+	}
+	if options.Codec == nil {
+		options.Codec = JSONCacheCodec{}
+	}
+	if options.PoolSize == 0 {
+		options.PoolSize = 10
+	}
+	if options.PoolTimeout == 0 {
+		options.PoolTimeout = 5 * time.Second
+	}
+	if options.DialTimeout == 0 {
+		options.DialTimeout = 5 * time.Second
+	}
+	if options.IOTimeout == 0 {
+		options.IOTimeout = 3 * time.Second
+	}
+
+	return &RedisCacheStore{
+		options: options,
+		idle:    make(chan *redisConn, options.PoolSize),
+		slots:   make(chan struct{}, options.PoolSize),
+	}
+}
+
+// Ping checks the connection to the server
+func (s *RedisCacheStore) Ping() error {
+	_, err := s.do("PING")
+	return err
+}
+
+// Get implements CacheStore
+func (s *RedisCacheStore) Get(key string, dest interface{}) (bool, error) {
+	reply, err := s.do("GET", s.options.Prefix+key)
+	if err != nil {
+		return false, err
+	}
+	if reply == nil {
+		return false, nil
+	}
+
+	data, ok := reply.([]byte)
+	if !ok {
+		return false, fmt.Errorf("redis: unexpected reply %T for GET", reply)
+	}
+
+	if err := s.options.Codec.Unmarshal(data, dest); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// Set implements CacheStore
+func (s *RedisCacheStore) Set(key string, value interface{}, ttl time.Duration) error {
+	data, err := s.options.Codec.Marshal(value)
+	if err != nil {
+		return err
+	}
+
+	args := []interface{}{"SET", s.options.Prefix + key, data}
+	if ttl > 0 {
+		ms := ttl.Milliseconds()
+		if ms == 0 {
+			ms = 1
+		}
+		args = append(args, "PX", ms)
+	}
+
+	_, err = s.do(args...)
+	return err
+}
+
+// Increment implements CacheCounter with INCR. The counter is created with
+// its ttl first, so it never outlives the window.
+func (s *RedisCacheStore) Increment(key string, ttl time.Duration) (int64, error) {
+	key = s.options.Prefix + key
+	if ttl > 0 {
+		ms := ttl.Milliseconds()
+		if ms == 0 {
+			ms = 1
+		}
+		if _, err := s.do("SET", key, "0", "PX", ms, "NX"); err != nil {
+			return 0, err
+		}
+	}
+
+	reply, err := s.do("INCR", key)
+	if err != nil {
+		return 0, err
+	}
+	value, ok := reply.(int64)
+	if !ok {
+		return 0, fmt.Errorf("redis: unexpected reply %T for INCR", reply)
+	}
+	return value, nil
+}
+
+// Delete implements CacheStore
+func (s *RedisCacheStore) Delete(key string) error {
+	_, err := s.do("DEL", s.options.Prefix+key)
+	return err
+}
+
+// Clear implements CacheStore by deleting the keys with the store's prefix.
+// Without a prefix the store cannot tell its keys apart and returns an error.
+func (s *RedisCacheStore) Clear() error {
+	if s.options.Prefix == "" {
+		return ErrRedisPrefixRequired
+	}
+
+	cursor := "0"
+	for {
+		reply, err := s.do("SCAN", cursor, "MATCH", s.options.Prefix+"*", "COUNT", 100)
+		if err != nil {
+			return err
+		}
+
+		parts, ok := reply.([]interface{})
+		if !ok || len(parts) != 2 {
+			return fmt.Errorf("redis: unexpected reply %T for SCAN", reply)
+		}
+
+		next, _ := parts[0].([]byte)
+		keys, _ := parts[1].([]interface{})
+		if len(keys) > 0 {
+			if _, err := s.do(append([]interface{}{"DEL"}, keys...)...); err != nil {
+				return err
+			}
+		}
+
+		cursor = string(next)
+		if cursor == "0" || cursor == "" {
+			return nil
+		}
+	}
+}
+
+// Close implements CacheStore
+func (s *RedisCacheStore) Close() error {
+	for {
+		select {
+		case c := <-s.idle:
+			s.discard(c)
+		default:
+			return nil
+		}
+	}
+}
+
+// do sends a command and reads its reply using a pooled connection
+func (s *RedisCacheStore) do(args ...interface{}) (interface{}, error) {
+	c, err := s.acquire()
+	if err != nil {
+		return nil, err
+	}
+
+	reply, err := c.do(s.options.IOTimeout, args...)
+	if err != nil {
+		if _, isRedisErr := err.(RedisError); !isRedisErr {
+			// The connection state is unknown after an I/O error
+			s.discard(c)
+			return nil, err
+		}
+	}
+
+	s.release(c)
+	return reply, err
+}
+
+// acquire returns an idle connection or dials a new one, waiting while
+// PoolSize connections are in use
+func (s *RedisCacheStore) acquire() (*redisConn, error) {
+	select {
+	case c := <-s.idle:
+		return c, nil
+	default:
+	}
+
+	timer := time.NewTimer(s.options.PoolTimeout)
+	defer timer.Stop()
+
+	select {
+	case c := <-s.idle:
+		return c, nil
+	case s.slots <- struct{}{}:
+	case <-timer.C:
+		return nil, ErrRedisPoolTimeout
+	}
+
+	c, err := s.dial()
+	if err != nil {
+		<-s.slots
+		return nil, err
+	}
+	return c, nil
+}
+
+func (s *RedisCacheStore) dial() (*redisConn, error) {
+	conn, err := net.DialTimeout("tcp", s.options.Addr, s.options.DialTimeout)
+	if err != nil {
+		return nil, err
+	}
+
+	c := &redisConn{conn: conn, reader: bufio.NewReader(conn)}
+
+	if s.options.Password != "" {
+		if _, err := c.do(s.options.IOTimeout, "AUTH", s.options.Password); err != nil {
+			conn.Close()
+			return nil, err
+		}
+	}
+	if s.options.DB != 0 {
+		if _, err := c.do(s.options.IOTimeout, "SELECT", s.options.DB); err != nil {
+			conn.Close()
+			return nil, err
+		}
+	}
+
+	return c, nil
+}
+
+// release returns a connection to the idle pool, which has room for every
+// open connection
+func (s *RedisCacheStore) release(c *redisConn) {
+	s.idle <- c
+}
+
+// discard closes a connection and frees its slot
+func (s *RedisCacheStore) discard(c *redisConn) {
+	c.conn.Close()
+	<-s.slots
+}
+
+// Redis cache errors
+var (
+	ErrRedisPrefixRequired = fiber.NewError(fiber.StatusInternalServerError, "redis cache clear requires a key prefix")
+	ErrRedisPoolTimeout    = fiber.NewError(fiber.StatusServiceUnavailable, "timed out waiting for a redis connection")
+)
+
+// RedisError is an error reply returned by the server
+type RedisError string
+
+func (e RedisError) Error() string {
+	return "redis: " + string(e)
+}
+
+func (c *redisConn) do(timeout time.Duration, args ...interface{}) (interface{}, error) {
+	if timeout > 0 {
+		c.conn.SetDeadline(time.Now().Add(timeout))
+	}
+
+	if err := writeRESPCommand(c.conn, args); err != nil {
+		return nil, err
+	}
+
+	return readRESPReply(c.reader)
+}
+
+func writeRESPCommand(w io.Writer, args []interface{}) error {
+	buf := make([]byte, 0, 64)
+	buf = append(buf, '*')
+	buf = strconv.AppendInt(buf, int64(len(args)), 10)
+	buf = append(buf, '\r', '\n')
+
+	for _, arg := range args {
+		var data []byte
+		switch v := arg.(type) {
+		case string:
+			data = []byte(v)
+		case []byte:
+			data = v
+		case int:
+			data = strconv.AppendInt(nil, int64(v), 10)
+		case int64:
+			data = strconv.AppendInt(nil, v, 10)
+		default:
+			data = []byte(fmt.Sprint(v))
+		}
+
+		buf = append(buf, '$')
+		buf = strconv.AppendInt(buf, int64(len(data)), 10)
+		buf = append(buf, '\r', '\n')
+		buf = append(buf, data...)
+		buf = append(buf, '\r', '\n')
+	}
+
+	_, err := w.Write(buf)
+	return err
+}
+
+func readRESPReply(r *bufio.Reader) (interface{}, error) {
+	line, err := r.ReadString('\n')
+	if err != nil {
+		return nil, err
+	}
+	if len(line) < 3 || line[len(line)-2] != '\r' {
+		return nil, fmt.Errorf("redis: malformed reply %q", line)
+	}
+	payload := line[1 : len(line)-2]
+
+	switch line[0] {
+	case '+':
+		return payload, nil
+	case '-':
+		return nil, RedisError(payload)
+	case ':':
+		return strconv.ParseInt(payload, 10, 64)
+	case '$':
+		n, err := strconv.Atoi(payload)
+		if err != nil {
+			return nil, err
+		}
+		if n < 0 {
+			return nil, nil
+		}
+		data := make([]byte, n+2)
+		if _, err := io.ReadFull(r, data); err != nil {
+			return nil, err
+		}
+		return data[:n], nil
+	case '*':
+		n, err := strconv.Atoi(payload)
+		if err != nil {
+			return nil, err
+		}
+		if n < 0 {
+			return nil, nil
+		}
+		items := make([]interface{}, n)
+		for i := range items {
+			if items[i], err = readRESPReply(r); err != nil {
+				return nil, err
+			}
+		}
+		return items, nil
+	default:
+		return nil, fmt.Errorf("redis: unknown reply type %q", line[0])
+	}
+}
@@ -0,0 +1,171 @@
+package core
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+)
+
+func newTestRedisStore(t *testing.T, options RedisCacheOptions) (*RedisCacheStore, *miniredis.Miniredis) {
+	t.Helper()
+
+	server := miniredis.RunT(t)
+	options.Addr = server.Addr()
+	store := NewRedisCacheStore(options)
+	t.Cleanup(func() { store.Close() })
+	return store, server
+}
+
+func TestRedisCacheStore(t *testing.T) {
+	store, server := newTestRedisStore(t, RedisCacheOptions{Prefix: "app:"})
+
+	if err := store.Ping(); err != nil {
+		t.Fatal(err)
+	}
+
+	type user struct{ Name string }
+	if err := store.Set("user:1", user{Name: "jane"}, time.Minute); err != nil {
+		t.Fatal(err)
+	}
+	if !server.Exists("app:user:1") {
+		t.Fatal("key was not prefixed")
+	}
+
+	var got user
+	if found, err := store.Get("user:1", &got); err != nil || !found || got.Name != "jane" {
+		t.Fatalf("Get = %v, %v, %+v", found, err, got)
+	}
+
+	server.FastForward(2 * time.Minute)
+	if found, _ := store.Get("user:1", &got); found {
+		t.Fatal("expired key was found")
+	}
+
+	store.Set("user:2", user{Name: "joe"}, 0)
+	if err := store.Delete("user:2"); err != nil {
+		t.Fatal(err)
+	}
+	if found, _ := store.Get("user:2", &got); found {
+		t.Fatal("deleted key was found")
+	}
+}
+
+func TestRedisCacheStoreClear(t *testing.T) {
+	store, server := newTestRedisStore(t, RedisCacheOptions{Prefix: "app:"})
+
+	server.Set("other", "kept")
+	for _, key := range []string{"a", "b", "c"} {
+		store.Set(key, key, 0)
+	}
+
+	if err := store.Clear(); err != nil {
+		t.Fatal(err)
+	}
+	if keys := server.Keys(); len(keys) != 1 || keys[0] != "other" {
+		t.Fatalf("keys after Clear = %v, want [other]", keys)
+	}
+
+	unprefixed := NewRedisCacheStore(RedisCacheOptions{Addr: server.Addr()})
+	defer unprefixed.Close()
+	if err := unprefixed.Clear(); err != ErrRedisPrefixRequired {
+		t.Fatalf("Clear without prefix = %v, want ErrRedisPrefixRequired", err)
+	}
+	if !server.Exists("other") {
+		t.Fatal("Clear without prefix removed keys")
+	}
+}
+
+func TestRedisCacheStoreAuthAndDB(t *testing.T) {
+	server := miniredis.RunT(t)
+	server.RequireAuth("secret")
+
+	store := NewRedisCacheStore(RedisCacheOptions{Addr: server.Addr(), Password: "secret", DB: 2})
+	defer store.Close()
+
+	if err := store.Set("k", "v", 0); err != nil {
+		t.Fatal(err)
+	}
+	if !server.DB(2).Exists("k") {
+		t.Fatal("key was not written to the selected database")
+	}
+
+	wrong := NewRedisCacheStore(RedisCacheOptions{Addr: server.Addr(), Password: "wrong"})
+	defer wrong.Close()
+	if err := wrong.Ping(); err == nil {
+		t.Fatal("Ping succeeded with a wrong password")
+	}
+}
+
+func TestRedisCacheStoreIncrement(t *testing.T) {
+	store, server := newTestRedisStore(t, RedisCacheOptions{Prefix: "app:"})
+
+	var wg sync.WaitGroup
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if _, err := store.Increment("n", time.Minute); err != nil {
+				t.Error(err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	if value, _ := server.Get("app:n"); value != "20" {
+		t.Fatalf("counter = %s, want 20", value)
+	}
+	if ttl := server.TTL("app:n"); ttl <= 0 || ttl > time.Minute {
+		t.Fatalf("counter ttl = %v, want the window", ttl)
+	}
+}
+
+func TestRedisCacheStorePoolIsBounded(t *testing.T) {
+	store, server := newTestRedisStore(t, RedisCacheOptions{Prefix: "app:", PoolSize: 2})
+
+	var wg sync.WaitGroup
+	for i := 0; i < 50; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if err := store.Set("k", "v", 0); err != nil {
+				t.Error(err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	if total := server.TotalConnectionCount(); total > 2 {
+		t.Fatalf("opened %d connections, want at most 2", total)
+	}
+}
+
+func TestRESPReply(t *testing.T) {
+	store, server := newTestRedisStore(t, RedisCacheOptions{})
+
+	server.Lpush("list", "b")
+	server.Lpush("list", "a")
+
+	reply, err := store.do("LRANGE", "list", 0, -1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	items, ok := reply.([]interface{})
+	if !ok || len(items) != 2 || string(items[0].([]byte)) != "a" || string(items[1].([]byte)) != "b" {
+		t.Fatalf("LRANGE = %#v", reply)
+	}
+
+	if reply, err := store.do("GET", "missing"); err != nil || reply != nil {
+		t.Fatalf("GET missing = %#v, %v", reply, err)
+	}
+	if _, err := store.do("INCR", "list"); err == nil {
+		t.Fatal("error reply was not returned")
+	} else if _, ok := err.(RedisError); !ok {
+		t.Fatalf("error reply = %T, want RedisError", err)
+	}
+	// The connection stays usable after an error reply
+	if err := store.Ping(); err != nil {
+		t.Fatal(err)
+	}
+}
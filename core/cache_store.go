@@ -0,0 +1,178 @@
+package core
+
+import (
+	"bytes"
+	"encoding/gob"
+	"encoding/json"
+	"sync"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// CacheStore defines the interface for cache backends.
+// A ttl of zero or less keeps the value until it is deleted or evicted.
+type CacheStore interface {
+	// Get decodes the value stored under key into dest and reports whether it was found
+	Get(key string, dest interface{}) (bool, error)
+	// Set stores a value under key
+	Set(key string, value interface{}, ttl time.Duration) error
+	// Delete removes a key
+	Delete(key string) error
+	// Clear removes all keys owned by the store
+	Clear() error
+	// Close releases the resources held by the store
+	Close() error
+}
+
+// CacheCounter is implemented by cache stores that increment counters
+// atomically. The ttl is set when the counter is created and not extended by
+// later increments. Counters are stored as plain integers.
+type CacheCounter interface {
+	Increment(key string, ttl time.Duration) (int64, error)
+}
+
+// CacheCodec serializes cache values
+type CacheCodec interface {
+	Marshal(value interface{}) ([]byte, error)
+	Unmarshal(data []byte, dest interface{}) error
+}
+
+// JSONCacheCodec serializes cache values as JSON
+type JSONCacheCodec struct{}
+
+// Marshal implements CacheCodec
+func (JSONCacheCodec) Marshal(value interface{}) ([]byte, error) {
+	return json.Marshal(value)
+}
+
+// Unmarshal implements CacheCodec
+func (JSONCacheCodec) Unmarshal(data []byte, dest interface{}) error {
+	return json.Unmarshal(data, dest)
+}
+
+// GobCacheCodec serializes cache values with encoding/gob
+type GobCacheCodec struct{}
+
+// Marshal implements CacheCodec
+func (GobCacheCodec) Marshal(value interface{}) ([]byte, error) {
+	var buf bytes.Buffer
+	if err := gob.NewEncoder(&buf).Encode(value); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// Unmarshal implements CacheCodec
+func (GobCacheCodec) Unmarshal(data []byte, dest interface{}) error {
+	return gob.NewDecoder(bytes.NewReader(data)).Decode(dest)
+}
+
+// MemoryCacheStore is a CacheStore backed by the in-memory Cache.
+// Values are serialized like in the other backends, so callers never share
+// mutable state with the cache.
+type MemoryCacheStore struct {
+	cache *Cache
+	codec CacheCodec
+	// mu serializes Increment
+	mu sync.Mutex
+}
+
+// NewMemoryCacheStore creates a new in-memory cache store, codec defaults to JSON
+func NewMemoryCacheStore(cache *Cache, codec CacheCodec) *MemoryCacheStore {
+	if cache == nil {
+		cache = NewCache()
+	}
+	if codec == nil {
+		codec = JSONCacheCodec{}
+	}
+	return &MemoryCacheStore{cache: cache, codec: codec}
+}
+
+// Cache returns the underlying cache
+func (s *MemoryCacheStore) Cache() *Cache {
+	return s.cache
+}
+
+// Get implements CacheStore
+func (s *MemoryCacheStore) Get(key string, dest interface{}) (bool, error) {
+	value, exists := s.cache.Get(key)
+	if !exists {
+		return false, nil
+	}
+
+	data, ok := value.([]byte)
+	if !ok {
+		return false, ErrCacheValueType
+	}
+
+	if err := s.codec.Unmarshal(data, dest); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// Set implements CacheStore
+func (s *MemoryCacheStore) Set(key string, value interface{}, ttl time.Duration) error {
+	data, err := s.codec.Marshal(value)
+	if err != nil {
+		return err
+	}
+
+	s.cache.Set(key, data, ttl)
+	return nil
+}
+
+// Increment implements CacheCounter
+func (s *MemoryCacheStore) Increment(key string, ttl time.Duration) (int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var value int64
+	item, exists := s.cache.item(key)
+	if exists {
+		data, ok := item.Value.([]byte)
+		if !ok {
+			return 0, ErrCacheValueType
+		}
+		if err := s.codec.Unmarshal(data, &value); err != nil {
+			return 0, err
+		}
+		// Keep the expiration of the first increment
+		ttl = 0
+		if !item.Expiration.IsZero() {
+			ttl = time.Until(item.Expiration)
+			if ttl <= 0 {
+				ttl = time.Nanosecond
+			}
+		}
+	}
+
+	value++
+	if err := s.Set(key, value, ttl); err != nil {
+		return 0, err
+	}
+	return value, nil
+}
+
+// Delete implements CacheStore
+func (s *MemoryCacheStore) Delete(key string) error {
+	s.cache.Delete(key)
+	return nil
+}
+
+// Clear implements CacheStore
+func (s *MemoryCacheStore) Clear() error {
+	s.cache.Clear()
+	return nil
+}
+
+// Close implements CacheStore
+func (s *MemoryCacheStore) Close() error {
+	return s.cache.Close()
+}
+
+// Cache errors
+var (
+	ErrCacheValueType = fiber.NewError(fiber.StatusInternalServerError, "cache value was not written by a cache store")
+)
@@ -0,0 +1,177 @@
+package core
+
+import (
+	"io"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+func TestCacheRejectsOversizeEntries(t *testing.T) {
+	var evicted []string
+	cache := NewCacheWithOptions(CacheOptions{
+		MaxBytes: 10,
+		OnEvict: func(key string, value interface{}, reason EvictionReason) {
+			evicted = append(evicted, key)
+		},
+	})
+
+	cache.Set("big", strings.Repeat("x", 11), 0)
+	if _, ok := cache.Get("big"); ok {
+		t.Fatal("oversize entry was stored")
+	}
+
+	cache.Set("a", "12345", 0)
+	cache.Set("a", strings.Repeat("x", 20), 0)
+	if _, ok := cache.Get("a"); ok {
+		t.Fatal("oversize value kept the previous entry")
+	}
+	if stats := cache.Stats(); stats.Bytes != 0 || stats.Entries != 0 {
+		t.Fatalf("stats = %+v, want an empty cache", stats)
+	}
+	if len(evicted) != 2 {
+		t.Fatalf("evicted %v, want both oversize sets reported", evicted)
+	}
+}
+
+func TestCacheEvictsToLimits(t *testing.T) {
+	cache := NewCacheWithOptions(CacheOptions{MaxEntries: 2})
+
+	cache.Set("a", 1, 0)
+	cache.Set("b", 2, 0)
+	cache.Get("a")
+	cache.Set("c", 3, 0)
+
+	if _, ok := cache.Get("b"); ok {
+		t.Fatal("least recently used entry was not evicted")
+	}
+	for _, key := range []string{"a", "c"} {
+		if _, ok := cache.Get(key); !ok {
+			t.Fatalf("%s was evicted", key)
+		}
+	}
+}
+
+// cacheTestResponse is the part of a response the cache middleware tests compare
+type cacheTestResponse struct {
+	status      int
+	contentType string
+	cache       string
+	body        string
+}
+
+func cacheTestRequest(t *testing.T, app *fiber.App, target string, headers map[string]string) cacheTestResponse {
+	t.Helper()
+	req := httptest.NewRequest("GET", target, nil)
+	for name, value := range headers {
+		req.Header.Set(name, value)
+	}
+	resp, err := app.Test(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, _ := io.ReadAll(resp.Body)
+	return cacheTestResponse{
+		status:      resp.StatusCode,
+		contentType: resp.Header.Get(fiber.HeaderContentType),
+		cache:       resp.Header.Get("X-Cache"),
+		body:        string(body),
+	}
+}
+
+func TestCacheMiddlewareReplaysResponses(t *testing.T) {
+	calls := 0
+	app := fiber.New()
+	app.Use(CacheMiddleware(NewCache(), time.Minute))
+	app.Get("/missing", func(c *fiber.Ctx) error {
+		calls++
+		c.Set(fiber.HeaderContentType, "application/problem+json")
+		return c.Status(fiber.StatusNotFound).SendString(`{"title":"not found"}`)
+	})
+
+	first := cacheTestRequest(t, app, "/missing?b=2&a=1", nil)
+	// The query order does not change the key
+	second := cacheTestRequest(t, app, "/missing?a=1&b=2", nil)
+
+	if first.cache != "MISS" || second.cache != "HIT" || calls != 1 {
+		t.Fatalf("X-Cache = %s then %s with %d calls, want MISS then HIT with 1", first.cache, second.cache, calls)
+	}
+	want := cacheTestResponse{status: 404, contentType: "application/problem+json", cache: "HIT", body: `{"title":"not found"}`}
+	if second != want {
+		t.Fatalf("replayed %+v, want %+v", second, want)
+	}
+}
+
+func TestCacheMiddlewareDoesNotShareAuthenticatedResponses(t *testing.T) {
+	app := fiber.New()
+	app.Use(CacheMiddleware(NewCache(), time.Minute))
+	app.Get("/me", func(c *fiber.Ctx) error {
+		return c.SendString("user:" + c.Get(fiber.HeaderAuthorization) + c.Cookies("session"))
+	})
+	app.Get("/public", func(c *fiber.Ctx) error {
+		c.Set(fiber.HeaderCacheControl, "public, max-age=60")
+		return c.SendString("public:" + c.Get(fiber.HeaderAuthorization))
+	})
+
+	cacheTestRequest(t, app, "/me", map[string]string{fiber.HeaderAuthorization: "Bearer alice"})
+	if got := cacheTestRequest(t, app, "/me", map[string]string{fiber.HeaderAuthorization: "Bearer bob"}); got.body != "user:Bearer bob" {
+		t.Fatalf("bob received %q", got.body)
+	}
+	cacheTestRequest(t, app, "/me", map[string]string{fiber.HeaderCookie: "session=alice"})
+	if got := cacheTestRequest(t, app, "/me", nil); got.body != "user:" || got.cache != "MISS" {
+		t.Fatalf("anonymous request received %+v", got)
+	}
+	// The anonymous response is cached, but not served to a signed-in user
+	if got := cacheTestRequest(t, app, "/me", map[string]string{fiber.HeaderCookie: "session=bob"}); got.body != "user:bob" {
+		t.Fatalf("bob received %q", got.body)
+	}
+
+	// Responses marked public are shared
+	cacheTestRequest(t, app, "/public", map[string]string{fiber.HeaderAuthorization: "Bearer alice"})
+	if got := cacheTestRequest(t, app, "/public", map[string]string{fiber.HeaderAuthorization: "Bearer bob"}); got.cache != "HIT" || got.body != "public:Bearer alice" {
+		t.Fatalf("public response = %+v, want a HIT", got)
+	}
+}
+
+func TestCacheMiddlewareVariesOnResponseVary(t *testing.T) {
+	calls := 0
+	app := fiber.New()
+	app.Use(CacheMiddleware(NewCache(), time.Minute))
+	app.Get("/greeting", func(c *fiber.Ctx) error {
+		calls++
+		c.Vary(fiber.HeaderAcceptLanguage)
+		if strings.HasPrefix(c.Get(fiber.HeaderAcceptLanguage), "th") {
+			return c.SendString("sawasdee")
+		}
+		return c.SendString("hello")
+	})
+	app.Get("/any", func(c *fiber.Ctx) error {
+		calls++
+		c.Set(fiber.HeaderVary, "*")
+		return c.SendString("any")
+	})
+
+	en := map[string]string{fiber.HeaderAcceptLanguage: "en"}
+	th := map[string]string{fiber.HeaderAcceptLanguage: "th"}
+	cacheTestRequest(t, app, "/greeting", en)
+	if got := cacheTestRequest(t, app, "/greeting", th); got.body != "sawasdee" || got.cache != "MISS" {
+		t.Fatalf("th request = %+v", got)
+	}
+	if got := cacheTestRequest(t, app, "/greeting", en); got.body != "hello" || got.cache != "HIT" {
+		t.Fatalf("en request = %+v", got)
+	}
+	if got := cacheTestRequest(t, app, "/greeting", th); got.body != "sawasdee" || got.cache != "HIT" {
+		t.Fatalf("th request = %+v", got)
+	}
+	if calls != 2 {
+		t.Fatalf("handler called %d times, want 2", calls)
+	}
+
+	cacheTestRequest(t, app, "/any", nil)
+	if got := cacheTestRequest(t, app, "/any", nil); got.cache != "MISS" {
+		t.Fatalf("Vary: * was cached")
+	}
+}
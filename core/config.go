@@ -3,11 +3,13 @@ package core
 import (
 	"encoding/json"
 	"fmt"
-	"log"
 	"os"
 	"path/filepath"
+	"reflect"
+	"strings"
 
-	"github.com/joho/godotenv"
+	"github.com/BurntSushi/toml"
+	"gopkg.in/yaml.v3"
 )
 
 // Config represents the application configuration
@@ -20,57 +22,78 @@ type Config struct {
 
 // AppConfig represents the application configuration
 type AppConfig struct {
-	Port     int    `json:"port"`
-	Env      string `json:"env"`
-	LogLevel string `json:"logLevel"`
+	Port      int    `json:"port" env:"PORT" default:"3000" validate:"min=1,max=65535"`
+	Env       string `json:"env" env:"APP_ENV" default:"development"`
+	LogLevel  string `json:"logLevel" env:"LOG_LEVEL" default:"info" validate:"loglevel"`
+	LogFormat string `json:"logFormat" env:"LOG_FORMAT" default:"text" validate:"logformat"`
 }
 
 // DatabaseConfig represents the database configuration
 type DatabaseConfig struct {
-	Driver   string `json:"driver"`
-	Host     string `json:"host"`
-	Port     int    `json:"port"`
-	User     string `json:"user"`
-	Password string `json:"password"`
-	Database string `json:"database"`
+	Driver   string `json:"driver" env:"DB_DRIVER"`
+	Host     string `json:"host" env:"DB_HOST" default:"localhost"`
+	Port     int    `json:"port" env:"DB_PORT" validate:"omitempty,min=1,max=65535"`
+	User     string `json:"user" env:"DB_USER"`
+	Password Secret `json:"password" env:"DB_PASSWORD"`
+	Database string `json:"database" env:"DB_NAME"`
 }
 
 // AuthConfig represents the authentication configuration
 type AuthConfig struct {
-	Secret string `json:"secret"`
+	Secret Secret `json:"secret" env:"AUTH_SECRET"`
 }
 
 // CacheConfig represents the cache configuration
 type CacheConfig struct {
-	Enabled bool `json:"enabled"`
-	TTL     int  `json:"ttl"`
+	Enabled bool `json:"enabled" env:"CACHE_ENABLED"`
+	TTL     int  `json:"ttl" env:"CACHE_TTL" validate:"min=0"`
 }
 
-func LoadEnv() {
-	err := godotenv.Load()
-	if err != nil {
-		log.Fatal("Error loading .env file")
-	}
-}
-
-// LoadConfig loads the configuration from a file
+// LoadConfig loads the configuration from a JSON, YAML or TOML file
 func LoadConfig(path string) (*Config, error) {
-	file, err := os.Open(path)
-	if err != nil {
-		return nil, err
-	}
-	defer file.Close()
+	return LoadConfigFiles(path)
+}
 
+// LoadConfigFiles loads and merges configuration files in order, so later
+// files such as environment overlays override earlier ones. Missing values
+// take their defaults and the result is validated, see ConfigErrors.
+func LoadConfigFiles(paths ...string) (*Config, error) {
 	var config Config
-	if err := json.NewDecoder(file).Decode(&config); err != nil {
+	loader := NewConfigLoader(ConfigLoaderOptions{
+		Files:      paths,
+		DisableEnv: true,
+	})
+	if err := loader.Load(&config); err != nil {
 		return nil, err
 	}
 
 	return &config, nil
 }
 
-// SaveConfig saves the configuration to a file
+// ValidateConfig implements ConfigValidator. A secret is required outside
+// development.
+func (c *Config) ValidateConfig() []ConfigFieldError {
+	var problems []ConfigFieldError
+	if c.Auth.Secret.IsZero() && c.App.Env != "development" {
+		problems = append(problems, ConfigFieldError{
+			Key:     "auth.secret",
+			Message: fmt.Sprintf("is required when app.env is %q", c.App.Env),
+		})
+	}
+	return problems
+}
+
+// SaveConfig saves the configuration to a file in the format of its extension.
+// Secrets must be references such as env:DB_PASSWORD or enc:..., which are
+// written as they are. Resolved secrets are refused rather than written
+// redacted or in plain text.
 func SaveConfig(config *Config, path string) error {
+	var resolved []string
+	values := configFileValues(reflect.ValueOf(config).Elem(), "", &resolved)
+	if len(resolved) > 0 {
+		return fmt.Errorf("cannot save resolved secrets %s, set references such as env:VAR instead", strings.Join(resolved, ", "))
+	}
+
 	dir := filepath.Dir(path)
 	if err := os.MkdirAll(dir, os.ModePerm); err != nil {
 		return err
@@ -82,7 +105,53 @@ func SaveConfig(config *Config, path string) error {
 	}
 	defer file.Close()
 
-	return json.NewEncoder(file).Encode(config)
+	switch ConfigFormatOf(path) {
+	case ConfigFormatYAML:
+		encoder := yaml.NewEncoder(file)
+		encoder.SetIndent(2)
+		if err := encoder.Encode(values); err != nil {
+			return err
+		}
+		return encoder.Close()
+	case ConfigFormatTOML:
+		return toml.NewEncoder(file).Encode(values)
+	default:
+		return json.NewEncoder(file).Encode(values)
+	}
+}
+
+// configFileValues converts a configuration struct into file values with
+// secret references in plain text, collecting secrets that are not references
+func configFileValues(value reflect.Value, prefix string, resolved *[]string) map[string]interface{} {
+	values := make(map[string]interface{})
+
+	typ := value.Type()
+	for i := 0; i < typ.NumField(); i++ {
+		field := typ.Field(i)
+		name := configFieldName(field)
+		if !field.IsExported() || name == "-" {
+			continue
+		}
+		path := name
+		if prefix != "" {
+			path = prefix + "." + name
+		}
+
+		fieldValue := value.Field(i)
+		switch {
+		case fieldValue.Type() == secretType:
+			secret := fieldValue.String()
+			if secret != "" && !isSecretReference(secret) {
+				*resolved = append(*resolved, path)
+			}
+			values[name] = secret
+		case isConfigSection(field.Type):
+			values[name] = configFileValues(fieldValue, path, resolved)
+		default:
+			values[name] = fieldValue.Interface()
+		}
+	}
+	return values
 }
 
 // GetEnv returns an environment variable or a default value
@@ -104,10 +173,14 @@ func GetEnvInt(key string, defaultValue int) int {
 	return defaultValue
 }
 
-// GetEnvBool returns an environment variable as a boolean or a default value
+// GetEnvBool returns an environment variable as a boolean or a default value.
+// It accepts 1/0, true/false, yes/no and on/off and returns the default for
+// anything else.
 func GetEnvBool(key string, defaultValue bool) bool {
 	if value := os.Getenv(key); value != "" {
-		return value == "true"
+		if b, err := parseBool(value); err == nil {
+			return b
+		}
 	}
 	return defaultValue
 }
\ No newline at end of file
@@ -0,0 +1,301 @@
+package core
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+// DotEnvOptions defines which dotenv files are loaded
+type DotEnvOptions struct {
+	// Dir contains the dotenv files, defaults to the working directory
+	Dir string
+	// Env is the profile, defaults to APP_ENV and then "development"
+	Env string
+	// Override replaces variables that are already set in the environment
+	Override bool
+}
+
+// DotEnvFiles returns the dotenv files of a profile from lowest to highest
+// precedence: .env, .env.local, .env.<env> and .env.<env>.local. The
+// .env.local file is skipped for the "test" profile so tests are reproducible.
+func DotEnvFiles(dir, env string) []string {
+	files := []string{".env"}
+	if env != "test" {
+		files = append(files, ".env.local")
+	}
+	if env != "" {
+		files = append(files, ".env."+env, ".env."+env+".local")
+	}
+
+	for i, file := range files {
+		files[i] = filepath.Join(dir, file)
+	}
+	return files
+}
+
+// ReadDotEnv reads the dotenv files of a profile without changing the
+// environment. Missing files are skipped. Values may reference variables
+// as $VAR or ${VAR}, which are expanded from the environment first and then
+// from earlier lines and files of lower precedence.
+func ReadDotEnv(options DotEnvOptions) (map[string]string, error) {
+	values, _, err := readDotEnv(existingFiles(DotEnvFiles(options.Dir, dotEnvProfile(options.Env, os.LookupEnv))), os.LookupEnv)
+	return values, err
+}
+
+// LoadEnv loads the dotenv files of a profile into the environment, see
+// ReadDotEnv. Variables that are already set win unless Override is set,
+// so a deployment's environment is never replaced by files, and it is not
+// an error if there are no files at all.
+func LoadEnv(options ...DotEnvOptions) error {
+	var opts DotEnvOptions
+	if len(options) > 0 {
+		opts = options[0]
+	}
+
+	values, err := ReadDotEnv(opts)
+	if err != nil {
+		return err
+	}
+
+	for key, value := range values {
+		if _, exists := os.LookupEnv(key); exists && !opts.Override {
+			continue
+		}
+		if err := os.Setenv(key, value); err != nil {
+			return fmt.Errorf("failed to set %s: %v", key, err)
+		}
+	}
+	return nil
+}
+
+func dotEnvProfile(env string, lookup func(string) (string, bool)) string {
+	if env != "" {
+		return env
+	}
+	if env, ok := lookup("APP_ENV"); ok && env != "" {
+		return env
+	}
+	return "development"
+}
+
+func existingFiles(paths []string) []string {
+	var existing []string
+	for _, path := range paths {
+		if _, err := os.Stat(path); err == nil {
+			existing = append(existing, path)
+		}
+	}
+	return existing
+}
+
+// readDotEnv merges dotenv files, later files overriding earlier ones, and
+// returns the values with the file each value was read from. References are
+// expanded from the environment first, since it wins over the files, and
+// then from earlier lines and files.
+func readDotEnv(paths []string, lookup func(string) (string, bool)) (map[string]string, map[string]string, error) {
+	values := make(map[string]string)
+	origins := make(map[string]string)
+
+	for _, path := range paths {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed to read %s: %v", path, err)
+		}
+
+		parser := &dotEnvParser{input: string(data), line: 1}
+		err = parser.parse(func(key, value string) {
+			values[key] = value
+			origins[key] = path
+		}, func(name string) string {
+			if value, ok := lookup(name); ok {
+				return value
+			}
+			return values[name]
+		})
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed to parse %s: %v", path, err)
+		}
+	}
+
+	return values, origins, nil
+}
+
+// dotEnvParser parses KEY=value lines. Single-quoted values are literal,
+// double-quoted values support escapes and may span lines, and unquoted
+// values end at a " #" comment. References are expanded outside single
+// quotes, \$ is a literal dollar sign.
+type dotEnvParser struct {
+	input string
+	pos   int
+	line  int
+}
+
+func (p *dotEnvParser) parse(set func(key, value string), expand func(name string) string) error {
+	for p.pos < len(p.input) {
+		p.skip(" \t\r\n")
+		if p.pos >= len(p.input) {
+			break
+		}
+		if p.input[p.pos] == '#' {
+			p.skipLine()
+			continue
+		}
+
+		line := p.line
+		key := p.key()
+		if key == "export" && p.peek() == ' ' {
+			p.skip(" \t")
+			key = p.key()
+		}
+		p.skip(" \t")
+		if key == "" || (p.peek() != '=' && p.peek() != ':') {
+			return fmt.Errorf("line %d: expected KEY=value", line)
+		}
+		p.pos++
+		p.skip(" \t")
+
+		value, err := p.value(expand)
+		if err != nil {
+			return fmt.Errorf("line %d: %v", line, err)
+		}
+		set(key, value)
+	}
+	return nil
+}
+
+func (p *dotEnvParser) value(expand func(name string) string) (string, error) {
+	switch p.peek() {
+	case '\'':
+		end := strings.IndexByte(p.input[p.pos+1:], '\'')
+		if end < 0 {
+			return "", fmt.Errorf("unterminated single-quoted value")
+		}
+		value := p.input[p.pos+1 : p.pos+1+end]
+		p.advance(end + 2)
+		p.skipLine()
+		return value, nil
+
+	case '"':
+		p.advance(1)
+		var value strings.Builder
+		for p.pos < len(p.input) {
+			c := p.input[p.pos]
+			switch {
+			case c == '"':
+				p.advance(1)
+				p.skipLine()
+				return value.String(), nil
+			case c == '\\' && p.pos+1 < len(p.input):
+				value.WriteString(dotEnvEscape(p.input[p.pos+1]))
+				p.advance(2)
+			case c == '$':
+				value.WriteString(p.reference(expand))
+			default:
+				value.WriteByte(c)
+				p.advance(1)
+			}
+		}
+		return "", fmt.Errorf("unterminated double-quoted value")
+	}
+
+	end := strings.IndexByte(p.input[p.pos:], '\n')
+	if end < 0 {
+		end = len(p.input) - p.pos
+	}
+	raw := p.input[p.pos : p.pos+end]
+	if comment := dotEnvComment.FindStringIndex(raw); comment != nil {
+		raw = raw[:comment[0]]
+	}
+	raw = strings.TrimSpace(raw)
+	p.pos += end
+
+	var value strings.Builder
+	inline := &dotEnvParser{input: raw}
+	for inline.pos < len(raw) {
+		c := raw[inline.pos]
+		switch {
+		case c == '\\' && inline.pos+1 < len(raw) && raw[inline.pos+1] == '$':
+			value.WriteByte('$')
+			inline.pos += 2
+		case c == '$':
+			value.WriteString(inline.reference(expand))
+		default:
+			value.WriteByte(c)
+			inline.pos++
+		}
+	}
+	return value.String(), nil
+}
+
+// reference expands $NAME or ${NAME} at the current position, a dollar
+// sign without a name is kept
+func (p *dotEnvParser) reference(expand func(name string) string) string {
+	match := dotEnvReference.FindStringSubmatch(p.input[p.pos:])
+	if match == nil {
+		p.advance(1)
+		return "$"
+	}
+	p.advance(len(match[0]))
+	if match[1] != "" {
+		return expand(match[1])
+	}
+	return expand(match[2])
+}
+
+func (p *dotEnvParser) key() string {
+	start := p.pos
+	for p.pos < len(p.input) && isDotEnvKeyChar(p.input[p.pos]) {
+		p.pos++
+	}
+	return p.input[start:p.pos]
+}
+
+func (p *dotEnvParser) peek() byte {
+	if p.pos < len(p.input) {
+		return p.input[p.pos]
+	}
+	return 0
+}
+
+func (p *dotEnvParser) advance(n int) {
+	p.line += strings.Count(p.input[p.pos:p.pos+n], "\n")
+	p.pos += n
+}
+
+func (p *dotEnvParser) skip(chars string) {
+	for p.pos < len(p.input) && strings.IndexByte(chars, p.input[p.pos]) >= 0 {
+		p.advance(1)
+	}
+}
+
+// skipLine ignores the rest of a line, e.g. a comment after a quoted value
+func (p *dotEnvParser) skipLine() {
+	for p.pos < len(p.input) && p.input[p.pos] != '\n' {
+		p.pos++
+	}
+}
+
+func isDotEnvKeyChar(c byte) bool {
+	return c == '_' || c == '.' || c == '-' || c >= '0' && c <= '9' || c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z'
+}
+
+func dotEnvEscape(c byte) string {
+	switch c {
+	case 'n':
+		return "\n"
+	case 'r':
+		return "\r"
+	case 't':
+		return "\t"
+	default:
+		return string(c)
+	}
+}
+
+var (
+	dotEnvReference = regexp.MustCompile(`^\$(?:\{([A-Za-z0-9_]+)\}|([A-Za-z0-9_]+))`)
+	dotEnvComment   = regexp.MustCompile(`\s#`)
+)
@@ -0,0 +1,105 @@
+package core
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestReadDotEnvSyntax(t *testing.T) {
+	dir := t.TempDir()
+	path := writeTestFile(t, dir, ".env", `# comment
+export NAME=sato
+PLAIN = hello world # trailing comment
+HASH=a#b
+EMPTY=
+SINGLE='literal $NAME\n' # comment
+DOUBLE="tab\there \"quoted\" $NAME"
+MULTI="first
+second"
+ESCAPED=price \$5
+BRACED=${NAME}-api
+`)
+
+	values, origins, err := readDotEnv([]string{path}, testLookupEnv(nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := map[string]string{
+		"NAME":    "sato",
+		"PLAIN":   "hello world",
+		"HASH":    "a#b",
+		"EMPTY":   "",
+		"SINGLE":  `literal $NAME\n`,
+		"DOUBLE":  "tab\there \"quoted\" sato",
+		"MULTI":   "first\nsecond",
+		"ESCAPED": "price $5",
+		"BRACED":  "sato-api",
+	}
+	if !reflect.DeepEqual(values, want) {
+		t.Fatalf("unexpected values %q", values)
+	}
+	if origins["MULTI"] != path {
+		t.Fatalf("unexpected origin %q", origins["MULTI"])
+	}
+}
+
+func TestReadDotEnvExpansionPrecedence(t *testing.T) {
+	dir := t.TempDir()
+	base := writeTestFile(t, dir, ".env", "HOST=file-host\nuser_name=file-user\nSCHEME=http\n")
+	local := writeTestFile(t, dir, ".env.local", "URL=$SCHEME://${user_name}@${HOST}\nSCHEME=https\nAFTER=$SCHEME\n")
+
+	// The environment wins over the files on load, so it wins for expansion too
+	values, _, err := readDotEnv([]string{base, local}, testLookupEnv(map[string]string{
+		"HOST":      "env-host",
+		"user_name": "env-user",
+	}))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if values["URL"] != "http://env-user@env-host" {
+		t.Errorf("unexpected URL %q", values["URL"])
+	}
+	if values["AFTER"] != "https" {
+		t.Errorf("unexpected AFTER %q", values["AFTER"])
+	}
+}
+
+func TestReadDotEnvErrors(t *testing.T) {
+	dir := t.TempDir()
+
+	for name, content := range map[string]string{
+		"unterminated": "A=1\nB=\"open\n",
+		"single":       "A='open\n",
+		"key":          "=value\n",
+	} {
+		path := writeTestFile(t, dir, name, content)
+		if _, _, err := readDotEnv([]string{path}, testLookupEnv(nil)); err == nil {
+			t.Errorf("%s: expected an error", name)
+		}
+	}
+}
+
+func TestLoadEnvKeepsEnvironment(t *testing.T) {
+	dir := t.TempDir()
+	writeTestFile(t, dir, ".env", "SATO_TEST_KEPT=file\nSATO_TEST_SET=file\n")
+	t.Setenv("SATO_TEST_KEPT", "env")
+	t.Setenv("SATO_TEST_SET", "")
+	os.Unsetenv("SATO_TEST_SET")
+
+	if err := LoadEnv(DotEnvOptions{Dir: dir, Env: "test"}); err != nil {
+		t.Fatal(err)
+	}
+	if os.Getenv("SATO_TEST_KEPT") != "env" || os.Getenv("SATO_TEST_SET") != "file" {
+		t.Fatalf("unexpected environment %q %q", os.Getenv("SATO_TEST_KEPT"), os.Getenv("SATO_TEST_SET"))
+	}
+
+	if got := DotEnvFiles(dir, "test"); !reflect.DeepEqual(got, []string{
+		filepath.Join(dir, ".env"), filepath.Join(dir, ".env.test"), filepath.Join(dir, ".env.test.local"),
+	}) {
+		t.Fatalf("unexpected files %q", got)
+	}
+}
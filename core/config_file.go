@@ -0,0 +1,230 @@
+package core
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/BurntSushi/toml"
+	"gopkg.in/yaml.v3"
+)
+
+// ConfigFormat is the format of a configuration file
+type ConfigFormat string
+
+const (
+	ConfigFormatJSON ConfigFormat = "json"
+	ConfigFormatYAML ConfigFormat = "yaml"
+	ConfigFormatTOML ConfigFormat = "toml"
+)
+
+// ConfigFormatOf detects the format of a configuration file from its
+// extension. Files with other extensions are read as JSON.
+func ConfigFormatOf(path string) ConfigFormat {
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".yaml", ".yml":
+		return ConfigFormatYAML
+	case ".toml":
+		return ConfigFormatTOML
+	default:
+		return ConfigFormatJSON
+	}
+}
+
+// DecodeConfigFiles merges configuration files in order and decodes the
+// result into target. Keys match the json tags of target in every format,
+// so files of different formats can be merged; yaml and toml tags are not read.
+// A file may list other files under the "include" key; they are merged
+// first, relative to the including file, and the file overrides them.
+func DecodeConfigFiles(target interface{}, paths ...string) error {
+	values, err := readConfigFiles(paths...)
+	if err != nil {
+		return err
+	}
+	return decodeConfigMap(values, target)
+}
+
+// readConfigFiles merges configuration files into one map
+func readConfigFiles(paths ...string) (map[string]interface{}, error) {
+	merged := make(map[string]interface{})
+	for _, path := range paths {
+		values, err := readConfigFile(path, nil, nil)
+		if err != nil {
+			return nil, err
+		}
+		mergeConfigMaps(merged, values)
+	}
+	return merged, nil
+}
+
+// readConfigFile reads a file and its includes, calling visit with every
+// file read when it is not nil
+func readConfigFile(path string, including []string, visit func(path string)) (map[string]interface{}, error) {
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		return nil, err
+	}
+	for _, parent := range including {
+		if parent == abs {
+			return nil, fmt.Errorf("config include cycle: %s", strings.Join(append(including, abs), " -> "))
+		}
+	}
+
+	if visit != nil {
+		visit(path)
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	values, err := decodeConfig(ConfigFormatOf(path), data)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse config %s: %v", path, err)
+	}
+
+	includes, err := configIncludes(values["include"])
+	if err != nil {
+		return nil, fmt.Errorf("invalid include in %s: %v", path, err)
+	}
+	delete(values, "include")
+
+	merged := make(map[string]interface{})
+	for _, include := range includes {
+		if !filepath.IsAbs(include) {
+			include = filepath.Join(filepath.Dir(path), include)
+		}
+		included, err := readConfigFile(include, append(including, abs), visit)
+		if err != nil {
+			return nil, err
+		}
+		mergeConfigMaps(merged, included)
+	}
+	mergeConfigMaps(merged, values)
+
+	return merged, nil
+}
+
+func decodeConfig(format ConfigFormat, data []byte) (map[string]interface{}, error) {
+	values := make(map[string]interface{})
+
+	var err error
+	switch format {
+	case ConfigFormatYAML:
+		if err = yaml.Unmarshal(data, &values); err == nil {
+			err = stringYAMLKeys(values, "")
+		}
+	case ConfigFormatTOML:
+		err = toml.Unmarshal(data, &values)
+	default:
+		err = json.Unmarshal(data, &values)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if values == nil {
+		values = make(map[string]interface{})
+	}
+	return values, nil
+}
+
+// stringYAMLKeys converts nested YAML mappings with non-string keys, such as
+// numbers, to maps with string keys so they merge and decode like the
+// other formats
+func stringYAMLKeys(values map[string]interface{}, path string) error {
+	for key, value := range values {
+		keyPath := key
+		if path != "" {
+			keyPath = path + "." + key
+		}
+		converted, err := stringYAMLValue(value, keyPath)
+		if err != nil {
+			return err
+		}
+		values[key] = converted
+	}
+	return nil
+}
+
+func stringYAMLValue(value interface{}, path string) (interface{}, error) {
+	switch v := value.(type) {
+	case map[string]interface{}:
+		return v, stringYAMLKeys(v, path)
+	case map[interface{}]interface{}:
+		converted := make(map[string]interface{}, len(v))
+		for key, item := range v {
+			switch key.(type) {
+			case string, int, int64, uint64, float64, bool:
+			default:
+				return nil, fmt.Errorf("key %v of %s is not a string", key, path)
+			}
+			name := fmt.Sprint(key)
+			item, err := stringYAMLValue(item, path+"."+name)
+			if err != nil {
+				return nil, err
+			}
+			converted[name] = item
+		}
+		return converted, nil
+	case []interface{}:
+		for i, item := range v {
+			item, err := stringYAMLValue(item, fmt.Sprintf("%s[%d]", path, i))
+			if err != nil {
+				return nil, err
+			}
+			v[i] = item
+		}
+		return v, nil
+	default:
+		return value, nil
+	}
+}
+
+func configIncludes(value interface{}) ([]string, error) {
+	switch v := value.(type) {
+	case nil:
+		return nil, nil
+	case string:
+		return []string{v}, nil
+	case []interface{}:
+		includes := make([]string, 0, len(v))
+		for _, item := range v {
+			path, ok := item.(string)
+			if !ok {
+				return nil, fmt.Errorf("expected a path, got %v", item)
+			}
+			includes = append(includes, path)
+		}
+		return includes, nil
+	default:
+		return nil, fmt.Errorf("expected a path or a list of paths, got %v", value)
+	}
+}
+
+// mergeConfigMaps merges src into dst. Nested maps are merged, other values
+// including lists are replaced.
+func mergeConfigMaps(dst, src map[string]interface{}) {
+	for key, value := range src {
+		if srcMap, ok := value.(map[string]interface{}); ok {
+			if dstMap, ok := dst[key].(map[string]interface{}); ok {
+				mergeConfigMaps(dstMap, srcMap)
+				continue
+			}
+			copied := make(map[string]interface{}, len(srcMap))
+			mergeConfigMaps(copied, srcMap)
+			dst[key] = copied
+			continue
+		}
+		dst[key] = value
+	}
+}
+
+func decodeConfigMap(values map[string]interface{}, target interface{}) error {
+	data, err := json.Marshal(values)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, target)
+}
@@ -0,0 +1,134 @@
+package core
+
+import (
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestDecodeConfigFilesFormats(t *testing.T) {
+	dir := t.TempDir()
+	files := map[string]string{
+		"config.json": `{"server": {"host": "h", "port": 81, "timeout": 5000000000}, "tags": ["a", "b"], "labels": {"80": "http"}}`,
+		"config.yaml": "server:\n  host: h\n  port: 81\n  timeout: 5000000000\ntags: [a, b]\nlabels:\n  80: http\n",
+		"config.yml":  "server: {host: h, port: 81, timeout: 5000000000}\ntags:\n  - a\n  - b\nlabels: {80: http}\n",
+		"config.toml": "tags = [\"a\", \"b\"]\n\n[server]\nhost = \"h\"\nport = 81\ntimeout = 5000000000\n\n[labels]\n80 = \"http\"\n",
+	}
+
+	for name, content := range files {
+		path := writeTestFile(t, dir, name, content)
+
+		var config loaderTestConfig
+		if err := DecodeConfigFiles(&config, path); err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		if config.Server.Host != "h" || config.Server.Port != 81 || config.Server.Timeout != 5*time.Second {
+			t.Errorf("%s: unexpected server config %+v", name, config.Server)
+		}
+		if !reflect.DeepEqual(config.Tags, []string{"a", "b"}) || !reflect.DeepEqual(config.Labels, map[string]string{"80": "http"}) {
+			t.Errorf("%s: unexpected tags %v or labels %v", name, config.Tags, config.Labels)
+		}
+	}
+}
+
+func TestDecodeConfigFilesYAMLKeys(t *testing.T) {
+	dir := t.TempDir()
+
+	path := writeTestFile(t, dir, "nested.yaml", "labels:\n  - {1: one, true: yes}\n")
+	values, err := readConfigFiles(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []interface{}{map[string]interface{}{"1": "one", "true": "yes"}}
+	if !reflect.DeepEqual(values["labels"], expected) {
+		t.Fatalf("expected keys in lists to be converted, got %#v", values["labels"])
+	}
+
+	path = writeTestFile(t, dir, "null.yaml", "labels:\n  ~: a\n")
+	var config loaderTestConfig
+	err = DecodeConfigFiles(&config, path)
+	if err == nil || !strings.Contains(err.Error(), "key <nil> of labels is not a string") {
+		t.Fatalf("expected a clear key error, got %v", err)
+	}
+}
+
+func TestDecodeConfigFilesIncludes(t *testing.T) {
+	dir := t.TempDir()
+	writeTestFile(t, dir, "shared.toml", "tags = [\"shared\"]\n\n[server]\nhost = \"shared\"\nport = 80\n")
+	writeTestFile(t, dir, "labels.json", `{"labels": {"team": "core"}, "server": {"port": 81}}`)
+	path := writeTestFile(t, dir, "config.yaml", "include: [shared.toml, labels.json]\nserver:\n  host: app\n")
+
+	var config loaderTestConfig
+	if err := DecodeConfigFiles(&config, path); err != nil {
+		t.Fatal(err)
+	}
+	if config.Server.Host != "app" || config.Server.Port != 81 {
+		t.Fatalf("expected later includes and the file to override earlier ones, got %+v", config.Server)
+	}
+	if !reflect.DeepEqual(config.Tags, []string{"shared"}) || config.Labels["team"] != "core" {
+		t.Fatalf("expected included values, got tags %v labels %v", config.Tags, config.Labels)
+	}
+}
+
+func TestDecodeConfigFilesIncludeCycle(t *testing.T) {
+	dir := t.TempDir()
+	writeTestFile(t, dir, "a.json", `{"include": "b.yaml"}`)
+	writeTestFile(t, dir, "b.yaml", "include: a.json\n")
+
+	var config loaderTestConfig
+	err := DecodeConfigFiles(&config, filepath.Join(dir, "a.json"))
+	if err == nil || !strings.Contains(err.Error(), "config include cycle") {
+		t.Fatalf("expected an include cycle error, got %v", err)
+	}
+
+	writeTestFile(t, dir, "self.yaml", "include: ./self.yaml\n")
+	err = DecodeConfigFiles(&config, filepath.Join(dir, "self.yaml"))
+	if err == nil || !strings.Contains(err.Error(), "config include cycle") {
+		t.Fatalf("expected a self include to be a cycle, got %v", err)
+	}
+}
+
+func TestDecodeConfigFilesOverlay(t *testing.T) {
+	dir := t.TempDir()
+	base := writeTestFile(t, dir, "config.yaml", "server:\n  host: base\n  port: 80\ntags: [a, b]\nlabels:\n  team: core\n  tier: web\n")
+	overlay := writeTestFile(t, dir, "config.production.toml", "tags = [\"c\"]\n\n[server]\nport = 443\n\n[labels]\ntier = \"edge\"\n")
+
+	var config loaderTestConfig
+	if err := DecodeConfigFiles(&config, base, overlay); err != nil {
+		t.Fatal(err)
+	}
+	if config.Server.Host != "base" || config.Server.Port != 443 {
+		t.Fatalf("expected nested values to be merged, got %+v", config.Server)
+	}
+	if !reflect.DeepEqual(config.Tags, []string{"c"}) {
+		t.Fatalf("expected lists to be replaced, got %v", config.Tags)
+	}
+	if !reflect.DeepEqual(config.Labels, map[string]string{"team": "core", "tier": "edge"}) {
+		t.Fatalf("expected maps to be merged, got %v", config.Labels)
+	}
+}
+
+func TestSaveConfigFormatsUseJSONNames(t *testing.T) {
+	dir := t.TempDir()
+	config := &Config{
+		App:   AppConfig{Port: 8080, Env: "development", LogLevel: "debug", LogFormat: "json"},
+		Cache: CacheConfig{Enabled: true, TTL: 60},
+	}
+
+	for _, name := range []string{"config.json", "config.yaml", "config.toml"} {
+		path := filepath.Join(dir, name)
+		if err := SaveConfig(config, path); err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+
+		loaded, err := LoadConfig(path)
+		if err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		if loaded.App != config.App || loaded.Cache != config.Cache {
+			t.Errorf("%s: expected %+v, got %+v", name, config, loaded)
+		}
+	}
+}
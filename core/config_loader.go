@@ -0,0 +1,689 @@
+package core
+
+import (
+	"context"
+	"encoding"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"os"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ConfigSourceKind is the layer a configuration value was resolved from
+type ConfigSourceKind string
+
+const (
+	ConfigSourceDefault ConfigSourceKind = "default"
+	ConfigSourceFile    ConfigSourceKind = "file"
+	ConfigSourceDotEnv  ConfigSourceKind = "dotenv"
+	ConfigSourceEnv     ConfigSourceKind = "env"
+	ConfigSourceFlag    ConfigSourceKind = "flag"
+)
+
+// ConfigSource describes where a configuration value came from
+type ConfigSource struct {
+	Kind ConfigSourceKind
+	// Name is the file, variable or flag the value was read from
+	Name string
+}
+
+func (s ConfigSource) String() string {
+	if s.Name == "" {
+		return string(s.Kind)
+	}
+	return fmt.Sprintf("%s %s", s.Kind, s.Name)
+}
+
+// ConfigLoaderOptions defines the layers of a config loader
+type ConfigLoaderOptions struct {
+	// Files are JSON, YAML or TOML files, later files override earlier ones
+	Files []string
+	// DotEnvFiles are dotenv files, later files override earlier ones
+	DotEnvFiles []string
+	// DotEnv adds the dotenv files of a profile after DotEnvFiles, see
+	// DotEnvFiles. Missing profile files are skipped.
+	DotEnv *DotEnvOptions
+	// EnvPrefix binds fields without an env tag to PREFIX_PATH_TO_FIELD
+	EnvPrefix string
+	// Args are command-line arguments such as os.Args[1:]. Fields are set
+	// with --path.to.field=value or the name in their flag tag.
+	Args []string
+	// LookupEnv reads the environment, defaults to os.LookupEnv
+	LookupEnv func(key string) (string, bool)
+	// DisableEnv skips the environment layer. LookupEnv is still used for
+	// env: secret references, CONFIG_SECRET_KEY and the dotenv profile.
+	DisableEnv bool
+	// AllowUnknownKeys accepts file keys that match no field instead of
+	// reporting them as errors
+	AllowUnknownKeys bool
+	// KnownKeys are top-level file keys loaded elsewhere, e.g. the sections
+	// of feature configs, that are not reported as unknown
+	KnownKeys []string
+	// Section loads the target from a nested key of the files such as
+	// "mail". Paths, generated env names and flags include the section.
+	Section string
+	// SecretProviders resolve Secret values such as "vault:path#key" by
+	// scheme. The "file" and "env" schemes are always available.
+	SecretProviders map[string]SecretProvider
+	// SecretKey decrypts "enc:" Secret values, defaults to the base64
+	// encoded key in CONFIG_SECRET_KEY
+	SecretKey []byte
+}
+
+// ConfigLoader loads configuration into any struct from layered sources:
+// defaults < files < dotenv files < environment < command-line flags.
+//
+// Fields are addressed by the path of their json names, e.g. "database.host",
+// and accept the tags env:"DB_HOST", default:"localhost" and flag:"db-host".
+// Strings are converted to durations, slices and maps ("a,b" and "k=v,k2=v2"),
+// and to any type implementing encoding.TextUnmarshaler.
+//
+// Secret fields are resolved from their references, see Secret, and can be
+// read from a file named by the env tag with a _FILE suffix, e.g. DB_PASSWORD_FILE.
+//
+// The result is validated with validate tags and ConfigValidator. Invalid
+// values and unknown file keys are returned together as ConfigErrors.
+type ConfigLoader struct {
+	options ConfigLoaderOptions
+	sources map[string]ConfigSource
+	files   []string
+	mu      sync.RWMutex
+}
+
+// NewConfigLoader creates a new config loader
+func NewConfigLoader(options ConfigLoaderOptions) *ConfigLoader {
+	if options.LookupEnv == nil {
+		options.LookupEnv = os.LookupEnv
+	}
+	return &ConfigLoader{
+		options: options,
+		sources: make(map[string]ConfigSource),
+	}
+}
+
+// configField is a settable leaf of a configuration struct
+type configField struct {
+	path  string
+	env   string
+	flag  string
+	def   string
+	field reflect.StructField
+	value reflect.Value
+}
+
+// Load resolves all layers into target, which must be a pointer to a struct,
+// and validates it
+func (l *ConfigLoader) Load(target interface{}) error {
+	root := reflect.ValueOf(target)
+	if root.Kind() != reflect.Ptr || root.Elem().Kind() != reflect.Struct {
+		return fmt.Errorf("config target must be a pointer to a struct, got %T", target)
+	}
+
+	sources := make(map[string]ConfigSource)
+	fields := l.fields(root.Elem(), l.options.Section)
+	var problems ConfigErrors
+	var files []string
+
+	for _, f := range fields {
+		if f.def == "" {
+			continue
+		}
+		if err := setConfigString(f.value, f.def); err != nil {
+			return fmt.Errorf("invalid default for %s: %v", f.path, err)
+		}
+		sources[f.path] = ConfigSource{Kind: ConfigSourceDefault}
+	}
+
+	for _, path := range l.options.Files {
+		values, err := readConfigFile(path, nil, func(path string) { files = append(files, path) })
+		if err != nil {
+			return err
+		}
+		values, err = configSection(values, l.options.Section)
+		if err != nil {
+			return fmt.Errorf("invalid config %s: %v", path, err)
+		}
+		if err := applyConfigMap(root.Elem(), values, l.options.Section, ConfigSource{Kind: ConfigSourceFile, Name: path}, sources, &problems); err != nil {
+			return err
+		}
+	}
+	problems = withoutKeys(problems, l.options.KnownKeys)
+
+	dotEnvFiles := append(append([]string(nil), l.options.DotEnvFiles...), existingFiles(l.profileDotEnvFiles())...)
+	dotEnv, origins, err := readDotEnv(dotEnvFiles, l.options.LookupEnv)
+	if err != nil {
+		return err
+	}
+	for _, f := range fields {
+		if value, ok := dotEnv[f.env]; ok && f.env != "" {
+			if err := setConfigString(f.value, value); err != nil {
+				return fmt.Errorf("invalid value for %s from %s in %s: %v", f.path, f.env, origins[f.env], err)
+			}
+			sources[f.path] = ConfigSource{Kind: ConfigSourceDotEnv, Name: origins[f.env] + ":" + f.env}
+		}
+	}
+
+	for _, f := range fields {
+		if f.env == "" || l.options.DisableEnv {
+			continue
+		}
+		if f.value.Type() == secretType {
+			if path, ok := l.options.LookupEnv(f.env + "_FILE"); ok {
+				data, err := os.ReadFile(path)
+				if err != nil {
+					return fmt.Errorf("failed to read %s from %s_FILE: %v", f.path, f.env, err)
+				}
+				f.value.SetString(strings.TrimRight(string(data), "\r\n"))
+				sources[f.path] = ConfigSource{Kind: ConfigSourceEnv, Name: f.env + "_FILE"}
+			}
+		}
+		if value, ok := l.options.LookupEnv(f.env); ok {
+			if err := setConfigString(f.value, value); err != nil {
+				return fmt.Errorf("invalid value for %s from %s: %v", f.path, f.env, err)
+			}
+			sources[f.path] = ConfigSource{Kind: ConfigSourceEnv, Name: f.env}
+		}
+	}
+
+	if err := l.applyFlags(fields, sources); err != nil {
+		return err
+	}
+
+	if l.options.AllowUnknownKeys {
+		problems = nil
+	}
+	if err := l.resolveSecrets(fields, sources, &problems); err != nil {
+		return err
+	}
+
+	l.mu.Lock()
+	l.sources = sources
+	l.files = append(files, dotEnvFiles...)
+	l.mu.Unlock()
+
+	return validateConfig(target, problems, l.options.Section, l.Source)
+}
+
+var secretType = reflect.TypeOf(Secret(""))
+
+// resolveSecrets replaces secret references with their values
+func (l *ConfigLoader) resolveSecrets(fields []configField, sources map[string]ConfigSource, problems *ConfigErrors) error {
+	key := l.options.SecretKey
+	if key == nil {
+		if encoded, ok := l.options.LookupEnv("CONFIG_SECRET_KEY"); ok {
+			decoded, err := base64.StdEncoding.DecodeString(encoded)
+			if err != nil {
+				return fmt.Errorf("invalid CONFIG_SECRET_KEY: %v", err)
+			}
+			key = decoded
+		}
+	}
+
+	providers := map[string]SecretProvider{
+		"file": FileSecretProvider{},
+		"env":  EnvSecretProvider{LookupEnv: l.options.LookupEnv},
+	}
+	for scheme, provider := range l.options.SecretProviders {
+		providers[scheme] = provider
+	}
+
+	for _, f := range fields {
+		if f.value.Type() != secretType || f.value.String() == "" {
+			continue
+		}
+		// A redacted secret written back to a file must not become the secret
+		if f.value.String() == redacted {
+			*problems = append(*problems, ConfigFieldError{
+				Key:     f.path,
+				Message: fmt.Sprintf("is the redaction marker %s, set the secret or a reference to it", redacted),
+				Source:  sources[f.path],
+			})
+			continue
+		}
+		value, err := resolveSecret(context.Background(), f.value.String(), key, providers)
+		if err != nil {
+			*problems = append(*problems, ConfigFieldError{
+				Key:     f.path,
+				Message: fmt.Sprintf("could not be resolved: %v", err),
+				Source:  sources[f.path],
+			})
+			continue
+		}
+		f.value.SetString(value)
+	}
+	return nil
+}
+
+// Sources returns the source of every value resolved by the last Load, by path
+func (l *ConfigLoader) Sources() map[string]ConfigSource {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+
+	sources := make(map[string]ConfigSource, len(l.sources))
+	for path, source := range l.sources {
+		sources[path] = source
+	}
+	return sources
+}
+
+// Source returns the source of the value at a path such as "database.host".
+// Values set by a nested file object are reported for the object's path.
+func (l *ConfigLoader) Source(path string) (ConfigSource, bool) {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+
+	for {
+		if source, ok := l.sources[path]; ok {
+			return source, true
+		}
+		i := strings.LastIndex(path, ".")
+		if i < 0 {
+			return ConfigSource{}, false
+		}
+		path = path[:i]
+	}
+}
+
+// Report lists every resolved value with its source, one per line
+func (l *ConfigLoader) Report() string {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+
+	paths := make([]string, 0, len(l.sources))
+	for path := range l.sources {
+		paths = append(paths, path)
+	}
+	sort.Strings(paths)
+
+	var b strings.Builder
+	for _, path := range paths {
+		fmt.Fprintf(&b, "%s: %s\n", path, l.sources[path])
+	}
+	return b.String()
+}
+
+// profileDotEnvFiles returns the candidate dotenv files of the profile
+func (l *ConfigLoader) profileDotEnvFiles() []string {
+	if l.options.DotEnv == nil {
+		return nil
+	}
+	return DotEnvFiles(l.options.DotEnv.Dir, dotEnvProfile(l.options.DotEnv.Env, l.options.LookupEnv))
+}
+
+// Files returns the files read by the last Load, including included files
+func (l *ConfigLoader) Files() []string {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+	return append([]string(nil), l.files...)
+}
+
+func (l *ConfigLoader) fields(value reflect.Value, prefix string) []configField {
+	var fields []configField
+
+	typ := value.Type()
+	for i := 0; i < typ.NumField(); i++ {
+		field := typ.Field(i)
+		if !field.IsExported() {
+			continue
+		}
+
+		name := configFieldName(field)
+		if name == "-" {
+			continue
+		}
+		path := name
+		if prefix != "" {
+			path = prefix + "." + name
+		}
+
+		fieldValue := value.Field(i)
+		if isConfigSection(field.Type) {
+			fields = append(fields, l.fields(fieldValue, path)...)
+			continue
+		}
+
+		env, hasEnv := field.Tag.Lookup("env")
+		if !hasEnv && l.options.EnvPrefix != "" {
+			env = l.options.EnvPrefix + "_" + strings.ToUpper(strings.NewReplacer(".", "_", "-", "_").Replace(path))
+		}
+		if env == "-" {
+			env = ""
+		}
+
+		flag := field.Tag.Get("flag")
+		if flag == "" {
+			flag = path
+		}
+
+		fields = append(fields, configField{
+			path:  path,
+			env:   env,
+			flag:  flag,
+			def:   field.Tag.Get("default"),
+			field: field,
+			value: fieldValue,
+		})
+	}
+
+	return fields
+}
+
+func (l *ConfigLoader) applyFlags(fields []configField, sources map[string]ConfigSource) error {
+	byFlag := make(map[string]configField, len(fields))
+	for _, f := range fields {
+		byFlag[f.flag] = f
+	}
+
+	args := l.options.Args
+	for i := 0; i < len(args); i++ {
+		arg := args[i]
+		if arg == "--" {
+			break
+		}
+		if !strings.HasPrefix(arg, "-") {
+			continue
+		}
+
+		name := strings.TrimLeft(arg, "-")
+		value, hasValue := "", false
+		if eq := strings.Index(name, "="); eq >= 0 {
+			name, value, hasValue = name[:eq], name[eq+1:], true
+		}
+
+		// Flags that do not belong to the config are left to the application
+		f, ok := byFlag[name]
+		if !ok {
+			continue
+		}
+
+		if !hasValue {
+			if f.value.Kind() == reflect.Bool {
+				value = "true"
+			} else if i+1 < len(args) {
+				i++
+				value = args[i]
+			} else {
+				return fmt.Errorf("flag --%s needs a value", name)
+			}
+		}
+
+		if err := setConfigString(f.value, value); err != nil {
+			return fmt.Errorf("invalid value for %s from --%s: %v", f.path, name, err)
+		}
+		sources[f.path] = ConfigSource{Kind: ConfigSourceFlag, Name: "--" + name}
+	}
+	return nil
+}
+
+// applyConfigMap sets struct fields from decoded file values and collects
+// keys that match no field
+func applyConfigMap(value reflect.Value, values map[string]interface{}, prefix string, source ConfigSource, sources map[string]ConfigSource, unknown *ConfigErrors) error {
+	known := make(map[string]bool, len(values))
+
+	typ := value.Type()
+	for i := 0; i < typ.NumField(); i++ {
+		field := typ.Field(i)
+		if !field.IsExported() {
+			continue
+		}
+
+		name := configFieldName(field)
+		if name == "-" {
+			continue
+		}
+		key, raw, ok := lookupConfigKey(values, name)
+		if !ok {
+			continue
+		}
+		known[key] = true
+
+		path := name
+		if prefix != "" {
+			path = prefix + "." + name
+		}
+
+		fieldValue := value.Field(i)
+		if isConfigSection(field.Type) {
+			if nested, ok := raw.(map[string]interface{}); ok {
+				if err := applyConfigMap(fieldValue, nested, path, source, sources, unknown); err != nil {
+					return err
+				}
+				continue
+			}
+		}
+
+		if err := setConfigValue(fieldValue, raw); err != nil {
+			return fmt.Errorf("invalid value for %s in %s: %v", path, source.Name, err)
+		}
+		sources[path] = source
+	}
+
+	keys := make([]string, 0, len(values))
+	for key := range values {
+		if !known[key] {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		path := key
+		if prefix != "" {
+			path = prefix + "." + key
+		}
+		*unknown = append(*unknown, ConfigFieldError{Key: path, Message: "is not a known setting", Source: source})
+	}
+	return nil
+}
+
+// configSection returns the values below a dotted section path, or no values
+// if the section is missing
+func configSection(values map[string]interface{}, section string) (map[string]interface{}, error) {
+	if section == "" {
+		return values, nil
+	}
+	for _, name := range strings.Split(section, ".") {
+		_, raw, ok := lookupConfigKey(values, name)
+		if !ok {
+			return map[string]interface{}{}, nil
+		}
+		nested, ok := raw.(map[string]interface{})
+		if !ok {
+			return nil, fmt.Errorf("%s is not a section", section)
+		}
+		values = nested
+	}
+	return values, nil
+}
+
+func withoutKeys(problems ConfigErrors, keys []string) ConfigErrors {
+	if len(keys) == 0 {
+		return problems
+	}
+	filtered := problems[:0]
+	for _, problem := range problems {
+		if !containsString(keys, problem.Key) {
+			filtered = append(filtered, problem)
+		}
+	}
+	return filtered
+}
+
+func lookupConfigKey(values map[string]interface{}, name string) (string, interface{}, bool) {
+	if value, ok := values[name]; ok {
+		return name, value, true
+	}
+	for key, value := range values {
+		if strings.EqualFold(key, name) {
+			return key, value, true
+		}
+	}
+	return "", nil, false
+}
+
+func configFieldName(field reflect.StructField) string {
+	if tag := field.Tag.Get("json"); tag != "" {
+		if name := strings.Split(tag, ",")[0]; name != "" {
+			return name
+		}
+	}
+	return field.Name
+}
+
+var textUnmarshalerType = reflect.TypeOf((*encoding.TextUnmarshaler)(nil)).Elem()
+
+// isConfigSection reports whether a field type is a nested struct whose
+// fields are configured individually
+func isConfigSection(typ reflect.Type) bool {
+	return typ.Kind() == reflect.Struct && !reflect.PtrTo(typ).Implements(textUnmarshalerType)
+}
+
+// setConfigValue sets a field from a value decoded from a file
+func setConfigValue(value reflect.Value, raw interface{}) error {
+	if s, ok := raw.(string); ok {
+		return setConfigString(value, s)
+	}
+
+	data, err := json.Marshal(raw)
+	if err != nil {
+		return err
+	}
+
+	// Durations may be given as numbers of seconds in files
+	if value.Type() == reflect.TypeOf(time.Duration(0)) {
+		var seconds float64
+		if err := json.Unmarshal(data, &seconds); err != nil {
+			return err
+		}
+		value.SetInt(int64(seconds * float64(time.Second)))
+		return nil
+	}
+
+	target := reflect.New(value.Type())
+	if err := json.Unmarshal(data, target.Interface()); err != nil {
+		return err
+	}
+	value.Set(target.Elem())
+	return nil
+}
+
+// setConfigString sets a field from its string representation
+func setConfigString(value reflect.Value, s string) error {
+	if value.CanAddr() {
+		if unmarshaler, ok := value.Addr().Interface().(encoding.TextUnmarshaler); ok {
+			return unmarshaler.UnmarshalText([]byte(s))
+		}
+	}
+
+	if value.Type() == reflect.TypeOf(time.Duration(0)) {
+		d, err := time.ParseDuration(s)
+		if err != nil {
+			return err
+		}
+		value.SetInt(int64(d))
+		return nil
+	}
+
+	switch value.Kind() {
+	case reflect.String:
+		value.SetString(s)
+	case reflect.Bool:
+		b, err := parseBool(s)
+		if err != nil {
+			return err
+		}
+		value.SetBool(b)
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		n, err := strconv.ParseInt(strings.TrimSpace(s), 0, value.Type().Bits())
+		if err != nil {
+			return err
+		}
+		value.SetInt(n)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		n, err := strconv.ParseUint(strings.TrimSpace(s), 0, value.Type().Bits())
+		if err != nil {
+			return err
+		}
+		value.SetUint(n)
+	case reflect.Float32, reflect.Float64:
+		n, err := strconv.ParseFloat(strings.TrimSpace(s), value.Type().Bits())
+		if err != nil {
+			return err
+		}
+		value.SetFloat(n)
+	case reflect.Ptr:
+		elem := reflect.New(value.Type().Elem())
+		if err := setConfigString(elem.Elem(), s); err != nil {
+			return err
+		}
+		value.Set(elem)
+	case reflect.Slice:
+		if strings.HasPrefix(strings.TrimSpace(s), "[") {
+			return json.Unmarshal([]byte(s), value.Addr().Interface())
+		}
+		parts := splitConfigList(s)
+		slice := reflect.MakeSlice(value.Type(), len(parts), len(parts))
+		for i, part := range parts {
+			if err := setConfigString(slice.Index(i), part); err != nil {
+				return err
+			}
+		}
+		value.Set(slice)
+	case reflect.Map:
+		if strings.HasPrefix(strings.TrimSpace(s), "{") {
+			return json.Unmarshal([]byte(s), value.Addr().Interface())
+		}
+		m := reflect.MakeMap(value.Type())
+		for _, part := range splitConfigList(s) {
+			k, v, found := strings.Cut(part, "=")
+			if !found {
+				k, v, found = strings.Cut(part, ":")
+			}
+			if !found {
+				return fmt.Errorf("expected key=value, got %q", part)
+			}
+			key := reflect.New(value.Type().Key()).Elem()
+			if err := setConfigString(key, strings.TrimSpace(k)); err != nil {
+				return err
+			}
+			elem := reflect.New(value.Type().Elem()).Elem()
+			if err := setConfigString(elem, strings.TrimSpace(v)); err != nil {
+				return err
+			}
+			m.SetMapIndex(key, elem)
+		}
+		value.Set(m)
+	case reflect.Struct:
+		return json.Unmarshal([]byte(s), value.Addr().Interface())
+	default:
+		return fmt.Errorf("unsupported type %s", value.Type())
+	}
+	return nil
+}
+
+func splitConfigList(s string) []string {
+	if strings.TrimSpace(s) == "" {
+		return nil
+	}
+	parts := strings.Split(s, ",")
+	for i := range parts {
+		parts[i] = strings.TrimSpace(parts[i])
+	}
+	return parts
+}
+
+// parseBool parses booleans including yes/no, on/off and y/n
+func parseBool(s string) (bool, error) {
+	switch strings.ToLower(strings.TrimSpace(s)) {
+	case "1", "t", "true", "y", "yes", "on":
+		return true, nil
+	case "0", "f", "false", "n", "no", "off", "":
+		return false, nil
+	}
+	return false, fmt.Errorf("invalid boolean %q", s)
+}
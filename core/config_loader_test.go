@@ -0,0 +1,155 @@
+package core
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+type loaderTestConfig struct {
+	Server struct {
+		Host    string        `json:"host" env:"HOST" default:"localhost"`
+		Port    int           `json:"port" env:"PORT" default:"80"`
+		Timeout time.Duration `json:"timeout" env:"TIMEOUT" default:"5s"`
+	} `json:"server"`
+	Tags   []string          `json:"tags" env:"TAGS"`
+	Limits map[string]int    `json:"limits" env:"LIMITS"`
+	Debug  bool              `json:"debug" env:"DEBUG"`
+	Labels map[string]string `json:"labels"`
+}
+
+func writeTestFile(t *testing.T, dir, name, content string) string {
+	t.Helper()
+	path := filepath.Join(dir, name)
+	if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func testLookupEnv(env map[string]string) func(string) (string, bool) {
+	return func(key string) (string, bool) {
+		value, ok := env[key]
+		return value, ok
+	}
+}
+
+func TestConfigLoaderPrecedence(t *testing.T) {
+	dir := t.TempDir()
+	file := writeTestFile(t, dir, "config.json", `{"server": {"host": "file-host", "port": 8080, "timeout": 10}, "tags": ["a"]}`)
+	dotEnv := writeTestFile(t, dir, ".env", "PORT=8081\nTIMEOUT=30s\nDEBUG=yes\n")
+
+	loader := NewConfigLoader(ConfigLoaderOptions{
+		Files:       []string{file},
+		DotEnvFiles: []string{dotEnv},
+		LookupEnv:   testLookupEnv(map[string]string{"TIMEOUT": "1m", "TAGS": "x, y", "LIMITS": "read=10,write=2"}),
+		Args:        []string{"--server.port=9090", "serve"},
+	})
+
+	var config loaderTestConfig
+	if err := loader.Load(&config); err != nil {
+		t.Fatal(err)
+	}
+
+	if config.Server.Host != "file-host" || config.Server.Port != 9090 || config.Server.Timeout != time.Minute {
+		t.Fatalf("unexpected server config %+v", config.Server)
+	}
+	if !config.Debug || !reflect.DeepEqual(config.Tags, []string{"x", "y"}) {
+		t.Fatalf("unexpected values debug=%v tags=%v", config.Debug, config.Tags)
+	}
+	if !reflect.DeepEqual(config.Limits, map[string]int{"read": 10, "write": 2}) {
+		t.Fatalf("unexpected limits %v", config.Limits)
+	}
+
+	expected := map[string]ConfigSource{
+		"server.host":    {Kind: ConfigSourceFile, Name: file},
+		"server.port":    {Kind: ConfigSourceFlag, Name: "--server.port"},
+		"server.timeout": {Kind: ConfigSourceEnv, Name: "TIMEOUT"},
+		"debug":          {Kind: ConfigSourceDotEnv, Name: dotEnv + ":DEBUG"},
+		"tags":           {Kind: ConfigSourceEnv, Name: "TAGS"},
+	}
+	for path, want := range expected {
+		if got, _ := loader.Source(path); got != want {
+			t.Errorf("source of %s: expected %v, got %v", path, want, got)
+		}
+	}
+}
+
+func TestConfigLoaderDefaultsAndPrefix(t *testing.T) {
+	loader := NewConfigLoader(ConfigLoaderOptions{
+		EnvPrefix: "APP",
+		LookupEnv: testLookupEnv(map[string]string{"APP_LABELS": "team=core"}),
+	})
+
+	var config loaderTestConfig
+	if err := loader.Load(&config); err != nil {
+		t.Fatal(err)
+	}
+	if config.Server.Host != "localhost" || config.Server.Port != 80 || config.Server.Timeout != 5*time.Second {
+		t.Fatalf("expected defaults, got %+v", config.Server)
+	}
+	if source, _ := loader.Source("server.port"); source.Kind != ConfigSourceDefault {
+		t.Fatalf("expected the default source, got %v", source)
+	}
+	if config.Labels["team"] != "core" {
+		t.Fatalf("expected labels from APP_LABELS, got %v", config.Labels)
+	}
+}
+
+func TestConfigLoaderReportsInvalidValues(t *testing.T) {
+	dir := t.TempDir()
+	file := writeTestFile(t, dir, "config.json", `{"server": {"host": "h"}, "unknown": 1}`)
+
+	var config loaderTestConfig
+	err := NewConfigLoader(ConfigLoaderOptions{Files: []string{file}, LookupEnv: testLookupEnv(nil)}).Load(&config)
+	var problems ConfigErrors
+	if !errors.As(err, &problems) || len(problems) != 1 || problems[0].Key != "unknown" {
+		t.Fatalf("expected the unknown key to be reported, got %v", err)
+	}
+
+	err = NewConfigLoader(ConfigLoaderOptions{LookupEnv: testLookupEnv(map[string]string{"PORT": "eighty"})}).Load(&config)
+	if err == nil {
+		t.Fatal("expected an invalid port to fail")
+	}
+}
+
+func TestConfigLogLevelMatchesParseLogLevel(t *testing.T) {
+	for _, level := range []string{"INFO", "warning", " Debug ", "error"} {
+		var config Config
+		err := NewConfigLoader(ConfigLoaderOptions{LookupEnv: testLookupEnv(map[string]string{
+			"APP_ENV":    "development",
+			"LOG_LEVEL":  level,
+			"LOG_FORMAT": "JSON",
+		})}).Load(&config)
+		if err != nil {
+			t.Errorf("LOG_LEVEL=%q: %v", level, err)
+		}
+	}
+
+	var config Config
+	err := NewConfigLoader(ConfigLoaderOptions{LookupEnv: testLookupEnv(map[string]string{
+		"APP_ENV":   "development",
+		"LOG_LEVEL": "verbose",
+	})}).Load(&config)
+	var problems ConfigErrors
+	if !errors.As(err, &problems) || len(problems) != 1 || problems[0].Key != "app.logLevel" {
+		t.Fatalf("expected app.logLevel to be rejected, got %v", err)
+	}
+}
+
+func TestGetEnvBool(t *testing.T) {
+	for value, want := range map[string]bool{"true": true, "1": true, "yes": true, "ON": true, "no": false, "0": false} {
+		t.Setenv("SATO_TEST_BOOL", value)
+		if got := GetEnvBool("SATO_TEST_BOOL", !want); got != want {
+			t.Errorf("GetEnvBool(%q) = %v", value, got)
+		}
+	}
+
+	t.Setenv("SATO_TEST_BOOL", "maybe")
+	if !GetEnvBool("SATO_TEST_BOOL", true) {
+		t.Error("expected the default for an invalid value")
+	}
+}
@@ -0,0 +1,138 @@
+package core
+
+import (
+	"fmt"
+	"reflect"
+)
+
+// Config provider names registered by ConfigModule.ForRoot
+const (
+	ConfigProviderName         = "config"
+	AppConfigProviderName      = "config.app"
+	DatabaseConfigProviderName = "config.database"
+	AuthConfigProviderName     = "config.auth"
+	CacheConfigProviderName    = "config.cache"
+)
+
+// ConfigModuleOptions defines how configuration is loaded for a container
+type ConfigModuleOptions struct {
+	// Loader loads the root configuration and every feature section
+	Loader ConfigLoaderOptions
+	// Overrides replace providers by name instead of loading them, e.g.
+	// "config" with a *Config, "config.database" with a *DatabaseConfig or
+	// "config.mail" with a *MailConfig in tests. Overrides of another type
+	// are rejected.
+	Overrides map[string]interface{}
+}
+
+// ConfigModule registers configuration as providers of a container, so
+// services receive it by injection:
+//
+//	type UserService struct {
+//		Database *core.DatabaseConfig `inject:"config.database"`
+//	}
+type ConfigModule struct {
+	container *Container
+	options   ConfigModuleOptions
+	loader    *ConfigLoader
+}
+
+// NewConfigModule creates a new config module
+func NewConfigModule(container *Container, options ConfigModuleOptions) *ConfigModule {
+	return &ConfigModule{
+		container: container,
+		options:   options,
+	}
+}
+
+// ForRoot loads the application configuration and registers it as "config"
+// and its sections as "config.app", "config.database", "config.auth" and
+// "config.cache". Sections of feature configs must be listed in the
+// loader's KnownKeys so they are not reported as unknown.
+func (m *ConfigModule) ForRoot() (*Config, error) {
+	config, err := m.rootConfig()
+	if err != nil {
+		return nil, err
+	}
+
+	providers := []struct {
+		name  string
+		value interface{}
+	}{
+		{ConfigProviderName, config},
+		{AppConfigProviderName, &config.App},
+		{DatabaseConfigProviderName, &config.Database},
+		{AuthConfigProviderName, &config.Auth},
+		{CacheConfigProviderName, &config.Cache},
+	}
+	// Check every override before registering anything
+	for i, provider := range providers[1:] {
+		override, ok := m.options.Overrides[provider.name]
+		if !ok {
+			continue
+		}
+		if reflect.TypeOf(override) != reflect.TypeOf(provider.value) {
+			return nil, fmt.Errorf("%s override must be a %T, got %T", provider.name, provider.value, override)
+		}
+		providers[i+1].value = override
+	}
+
+	for _, provider := range providers {
+		if err := m.container.Register(provider.name, provider.value); err != nil {
+			return nil, err
+		}
+	}
+	return config, nil
+}
+
+// Loader returns the loader of the root configuration, nil before ForRoot or
+// when the configuration was overridden
+func (m *ConfigModule) Loader() *ConfigLoader {
+	return m.loader
+}
+
+func (m *ConfigModule) rootConfig() (*Config, error) {
+	if override, ok := m.options.Overrides[ConfigProviderName]; ok {
+		config, ok := override.(*Config)
+		if !ok {
+			return nil, fmt.Errorf("config override must be a *Config, got %T", override)
+		}
+		return config, nil
+	}
+
+	m.loader = NewConfigLoader(m.options.Loader)
+	config := new(Config)
+	if err := m.loader.Load(config); err != nil {
+		return nil, err
+	}
+	return config, nil
+}
+
+// ForFeature loads a module-specific configuration from a section of the
+// module's files, e.g. "mail", and registers it as "config.<section>". The
+// section's fields use the same defaults, env, flag and validate tags as the
+// root configuration.
+func ForFeature[T any](m *ConfigModule, section string) (*T, error) {
+	name := ConfigProviderName + "." + section
+
+	if override, ok := m.options.Overrides[name]; ok {
+		config, ok := override.(*T)
+		if !ok {
+			return nil, fmt.Errorf("%s override must be a %T, got %T", name, new(T), override)
+		}
+		return config, m.container.Register(name, config)
+	}
+
+	options := m.options.Loader
+	options.Section = section
+	options.KnownKeys = nil
+
+	config := new(T)
+	if err := NewConfigLoader(options).Load(config); err != nil {
+		return nil, err
+	}
+	if err := m.container.Register(name, config); err != nil {
+		return nil, err
+	}
+	return config, nil
+}
@@ -0,0 +1,155 @@
+package core
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+type mailTestConfig struct {
+	Host string `json:"host" default:"localhost"`
+	Port int    `json:"port" env:"MAIL_PORT" default:"25" validate:"min=1,max=65535"`
+}
+
+type configTestService struct {
+	Config   *Config         `inject:"config"`
+	Database *DatabaseConfig `inject:"config.database"`
+	Mail     *mailTestConfig `inject:"config.mail"`
+}
+
+func newTestConfigModule(t *testing.T, content string, overrides map[string]interface{}) (*ConfigModule, *Container) {
+	t.Helper()
+	path := writeTestFile(t, t.TempDir(), "config.yaml", content)
+	container := NewContainer()
+	return NewConfigModule(container, ConfigModuleOptions{
+		Loader: ConfigLoaderOptions{
+			Files:     []string{path},
+			KnownKeys: []string{"mail"},
+			LookupEnv: testLookupEnv(nil),
+		},
+		Overrides: overrides,
+	}), container
+}
+
+func TestConfigModuleForRootAndForFeature(t *testing.T) {
+	module, container := newTestConfigModule(t, "app:\n  port: 8080\ndatabase:\n  host: db\nmail:\n  host: smtp\n", nil)
+
+	config, err := module.ForRoot()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if config.App.Port != 8080 || config.Database.Host != "db" || module.Loader() == nil {
+		t.Fatalf("unexpected root config %+v", config)
+	}
+	for name, want := range map[string]interface{}{
+		ConfigProviderName:         config,
+		AppConfigProviderName:      &config.App,
+		DatabaseConfigProviderName: &config.Database,
+		AuthConfigProviderName:     &config.Auth,
+		CacheConfigProviderName:    &config.Cache,
+	} {
+		if got, err := container.Get(name); err != nil || got != want {
+			t.Errorf("expected %s to be registered from the root config, got %v, %v", name, got, err)
+		}
+	}
+
+	mail, err := ForFeature[mailTestConfig](module, "mail")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if mail.Host != "smtp" || mail.Port != 25 {
+		t.Fatalf("expected the mail section with defaults, got %+v", mail)
+	}
+
+	var service configTestService
+	if err := container.Inject(&service); err != nil {
+		t.Fatal(err)
+	}
+	if service.Config != config || service.Database != &config.Database || service.Mail != mail {
+		t.Fatalf("expected the registered configs to be injected, got %+v", service)
+	}
+}
+
+func TestConfigModuleForFeatureReportsSectionKeys(t *testing.T) {
+	module, _ := newTestConfigModule(t, "mail:\n  port: 0\n", nil)
+
+	_, err := ForFeature[mailTestConfig](module, "mail")
+	var problems ConfigErrors
+	if !errors.As(err, &problems) || len(problems) != 1 || problems[0].Key != "mail.port" {
+		t.Fatalf("expected mail.port to be reported, got %v", err)
+	}
+}
+
+func TestConfigModuleRootOverride(t *testing.T) {
+	override := &Config{App: AppConfig{Port: 9999}, Database: DatabaseConfig{Host: "test-db"}}
+	module, container := newTestConfigModule(t, "app:\n  port: 8080\n", map[string]interface{}{
+		ConfigProviderName: override,
+	})
+
+	config, err := module.ForRoot()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if config != override || module.Loader() != nil {
+		t.Fatal("expected the override instead of the loaded config")
+	}
+	if database, _ := container.Get(DatabaseConfigProviderName); database != &override.Database {
+		t.Fatalf("expected the sections of the override, got %v", database)
+	}
+
+	module, _ = newTestConfigModule(t, "", map[string]interface{}{ConfigProviderName: Config{}})
+	if _, err := module.ForRoot(); err == nil || !strings.Contains(err.Error(), "must be a *Config") {
+		t.Fatalf("expected a wrong override type to be rejected, got %v", err)
+	}
+}
+
+func TestConfigModuleSectionOverrides(t *testing.T) {
+	database := &DatabaseConfig{Host: "test-db"}
+	mail := &mailTestConfig{Host: "mail.test"}
+	module, container := newTestConfigModule(t, "database:\n  host: db\nmail:\n  host: smtp\n", map[string]interface{}{
+		DatabaseConfigProviderName:   database,
+		ConfigProviderName + ".mail": mail,
+	})
+
+	config, err := module.ForRoot()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if config.Database.Host != "db" {
+		t.Fatalf("expected the root config to stay loaded, got %+v", config.Database)
+	}
+	if _, err := ForFeature[mailTestConfig](module, "mail"); err != nil {
+		t.Fatal(err)
+	}
+
+	var service configTestService
+	if err := container.Inject(&service); err != nil {
+		t.Fatal(err)
+	}
+	if service.Database != database || service.Mail != mail {
+		t.Fatalf("expected the overrides to be injected, got %+v", service)
+	}
+}
+
+func TestConfigModuleRejectsMistypedOverrides(t *testing.T) {
+	for name, override := range map[string]interface{}{
+		AppConfigProviderName:      AppConfig{},
+		DatabaseConfigProviderName: &AuthConfig{},
+		AuthConfigProviderName:     "secret",
+		CacheConfigProviderName:    nil,
+	} {
+		module, container := newTestConfigModule(t, "", map[string]interface{}{name: override})
+		_, err := module.ForRoot()
+		if err == nil || !strings.Contains(err.Error(), name+" override must be a") {
+			t.Errorf("expected the %s override %T to be rejected, got %v", name, override, err)
+		}
+		if _, err := container.Get(ConfigProviderName); err == nil {
+			t.Errorf("expected nothing to be registered after the %s override was rejected", name)
+		}
+	}
+
+	module, _ := newTestConfigModule(t, "", map[string]interface{}{ConfigProviderName + ".mail": &AppConfig{}})
+	if _, err := ForFeature[mailTestConfig](module, "mail"); err == nil {
+		t.Fatal("expected a mistyped feature override to be rejected")
+	}
+}
@@ -0,0 +1,339 @@
+package core
+
+import (
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// redacted replaces secret values in output
+const redacted = "******"
+
+// Secret is a configuration value that never reveals itself when printed,
+// logged or marshaled. Value returns the actual secret.
+//
+// Configuration loaded by ConfigLoader may reference secrets instead of
+// containing them:
+//
+//	file:/run/secrets/db_password   contents of a mounted secret file
+//	env:DB_PASSWORD                 an environment variable
+//	enc:BASE64                      a value encrypted with EncryptSecret
+//	vault:secret/data/db#password   any scheme registered as a SecretProvider
+type Secret string
+
+// Value returns the secret in plain text
+func (s Secret) Value() string {
+	return string(s)
+}
+
+// IsZero reports whether the secret is empty
+func (s Secret) IsZero() bool {
+	return s == ""
+}
+
+func (s Secret) String() string {
+	if s == "" {
+		return ""
+	}
+	return redacted
+}
+
+// GoString redacts the secret for %#v
+func (s Secret) GoString() string {
+	return fmt.Sprintf("core.Secret(%q)", s.String())
+}
+
+// MarshalText redacts the secret in JSON, YAML and TOML
+func (s Secret) MarshalText() ([]byte, error) {
+	return []byte(s.String()), nil
+}
+
+// UnmarshalText sets the secret
+func (s *Secret) UnmarshalText(text []byte) error {
+	*s = Secret(text)
+	return nil
+}
+
+// LogValue redacts the secret in structured logs
+func (s Secret) LogValue() slog.Value {
+	return slog.StringValue(s.String())
+}
+
+// SecretProvider resolves secret references of one scheme, e.g. "vault"
+type SecretProvider interface {
+	// GetSecret returns the secret for a reference without its scheme
+	GetSecret(ctx context.Context, ref string) (string, error)
+}
+
+// SecretProviderFunc adapts a function to a SecretProvider
+type SecretProviderFunc func(ctx context.Context, ref string) (string, error)
+
+// GetSecret implements SecretProvider
+func (f SecretProviderFunc) GetSecret(ctx context.Context, ref string) (string, error) {
+	return f(ctx, ref)
+}
+
+// FileSecretProvider reads secrets from files such as Docker and Kubernetes
+// mounted secrets. Relative references are resolved against Dir.
+type FileSecretProvider struct {
+	Dir string
+}
+
+// GetSecret implements SecretProvider. Trailing newlines are removed.
+func (p FileSecretProvider) GetSecret(ctx context.Context, ref string) (string, error) {
+	path := ref
+	if p.Dir != "" && !filepath.IsAbs(path) {
+		path = filepath.Join(p.Dir, path)
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimRight(string(data), "\r\n"), nil
+}
+
+// EnvSecretProvider reads secrets from environment variables
+type EnvSecretProvider struct {
+	LookupEnv func(key string) (string, bool)
+}
+
+// GetSecret implements SecretProvider
+func (p EnvSecretProvider) GetSecret(ctx context.Context, ref string) (string, error) {
+	lookup := p.LookupEnv
+	if lookup == nil {
+		lookup = os.LookupEnv
+	}
+	value, ok := lookup(ref)
+	if !ok {
+		return "", fmt.Errorf("environment variable %s is not set", ref)
+	}
+	return value, nil
+}
+
+// MemorySecretProvider serves secrets from memory, a local stand-in for a
+// secret manager in development and tests. References are "path#key".
+type MemorySecretProvider struct {
+	secrets map[string]map[string]string
+	mu      sync.RWMutex
+}
+
+// NewMemorySecretProvider creates a new in-memory secret provider
+func NewMemorySecretProvider() *MemorySecretProvider {
+	return &MemorySecretProvider{secrets: make(map[string]map[string]string)}
+}
+
+// Set stores a secret under a path and key
+func (p *MemorySecretProvider) Set(path, key, value string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.secrets[path] == nil {
+		p.secrets[path] = make(map[string]string)
+	}
+	p.secrets[path][key] = value
+}
+
+// GetSecret implements SecretProvider
+func (p *MemorySecretProvider) GetSecret(ctx context.Context, ref string) (string, error) {
+	path, key := splitSecretRef(ref)
+
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+
+	value, ok := p.secrets[path][key]
+	if !ok {
+		return "", fmt.Errorf("secret %s not found", ref)
+	}
+	return value, nil
+}
+
+// VaultSecretProviderOptions defines HashiCorp Vault configuration
+type VaultSecretProviderOptions struct {
+	// Address defaults to VAULT_ADDR
+	Address string
+	// Token defaults to VAULT_TOKEN
+	Token  string
+	Client *http.Client
+}
+
+// VaultSecretProvider reads secrets from HashiCorp Vault. References are
+// "path#key", e.g. "secret/data/db#password" for the KV version 2 engine.
+type VaultSecretProvider struct {
+	options VaultSecretProviderOptions
+}
+
+// NewVaultSecretProvider creates a new Vault secret provider
+func NewVaultSecretProvider(options VaultSecretProviderOptions) *VaultSecretProvider {
+	if options.Address == "" {
+		options.Address = os.Getenv("VAULT_ADDR")
+	}
+	if options.Token == "" {
+		options.Token = os.Getenv("VAULT_TOKEN")
+	}
+	if options.Client == nil {
+		options.Client = &http.Client{Timeout: 10 * time.Second}
+	}
+	options.Address = strings.TrimRight(options.Address, "/")
+	return &VaultSecretProvider{options: options}
+}
+
+// GetSecret implements SecretProvider
+func (p *VaultSecretProvider) GetSecret(ctx context.Context, ref string) (string, error) {
+	path, key := splitSecretRef(ref)
+	if key == "" {
+		return "", fmt.Errorf("vault reference %s has no #key", ref)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, p.options.Address+"/v1/"+strings.TrimLeft(path, "/"), nil)
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("X-Vault-Token", p.options.Token)
+
+	resp, err := p.options.Client.Do(req)
+	if err != nil {
+		return "", fmt.Errorf("vault request failed: %v", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return "", fmt.Errorf("vault returned %d for %s: %s", resp.StatusCode, path, strings.TrimSpace(string(body)))
+	}
+
+	var result struct {
+		Data map[string]interface{} `json:"data"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return "", fmt.Errorf("invalid vault response: %v", err)
+	}
+
+	// KV version 2 nests the secret under data.data
+	data := result.Data
+	if nested, ok := data["data"].(map[string]interface{}); ok {
+		if _, isMetadata := data["metadata"]; isMetadata {
+			data = nested
+		}
+	}
+
+	value, ok := data[key]
+	if !ok {
+		return "", fmt.Errorf("vault secret %s has no key %s", path, key)
+	}
+	if s, ok := value.(string); ok {
+		return s, nil
+	}
+	return fmt.Sprint(value), nil
+}
+
+func splitSecretRef(ref string) (string, string) {
+	if i := strings.LastIndex(ref, "#"); i >= 0 {
+		return ref[:i], ref[i+1:]
+	}
+	return ref, ""
+}
+
+// encryptedSecretPrefix marks values encrypted with EncryptSecret
+const encryptedSecretPrefix = "enc:"
+
+// GenerateSecretKey returns a random key for EncryptSecret, base64 encoded
+func GenerateSecretKey() (string, error) {
+	key := make([]byte, 32)
+	if _, err := rand.Read(key); err != nil {
+		return "", err
+	}
+	return base64.StdEncoding.EncodeToString(key), nil
+}
+
+// EncryptSecret encrypts a value with AES-GCM for storage in configuration
+// files. The key is 16, 24 or 32 bytes; the result starts with "enc:".
+func EncryptSecret(key []byte, plaintext string) (string, error) {
+	gcm, err := secretCipher(key)
+	if err != nil {
+		return "", err
+	}
+
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return "", err
+	}
+	sealed := gcm.Seal(nonce, nonce, []byte(plaintext), nil)
+	return encryptedSecretPrefix + base64.StdEncoding.EncodeToString(sealed), nil
+}
+
+// DecryptSecret decrypts a value produced by EncryptSecret
+func DecryptSecret(key []byte, value string) (string, error) {
+	gcm, err := secretCipher(key)
+	if err != nil {
+		return "", err
+	}
+
+	sealed, err := base64.StdEncoding.DecodeString(strings.TrimPrefix(value, encryptedSecretPrefix))
+	if err != nil {
+		return "", fmt.Errorf("invalid encrypted value: %v", err)
+	}
+	if len(sealed) < gcm.NonceSize() {
+		return "", fmt.Errorf("invalid encrypted value: too short")
+	}
+
+	plaintext, err := gcm.Open(nil, sealed[:gcm.NonceSize()], sealed[gcm.NonceSize():], nil)
+	if err != nil {
+		return "", fmt.Errorf("failed to decrypt value: wrong key or corrupted data")
+	}
+	return string(plaintext), nil
+}
+
+func secretCipher(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, fmt.Errorf("invalid secret key: %v", err)
+	}
+	return cipher.NewGCM(block)
+}
+
+// isSecretReference reports whether a secret is a reference to the secret
+// with one of the built-in schemes, which is safe to write to files
+func isSecretReference(value string) bool {
+	scheme, ref, found := strings.Cut(value, ":")
+	if !found || ref == "" {
+		return false
+	}
+	switch scheme {
+	case "file", "env", "enc", "vault":
+		return true
+	}
+	return false
+}
+
+// resolveSecret returns the value of a secret reference, or the value itself
+// if it does not start with a known scheme
+func resolveSecret(ctx context.Context, value string, key []byte, providers map[string]SecretProvider) (string, error) {
+	if strings.HasPrefix(value, encryptedSecretPrefix) {
+		if key == nil {
+			return "", fmt.Errorf("value is encrypted but no secret key is configured")
+		}
+		return DecryptSecret(key, value)
+	}
+
+	scheme, ref, found := strings.Cut(value, ":")
+	if !found {
+		return value, nil
+	}
+	provider, ok := providers[scheme]
+	if !ok {
+		return value, nil
+	}
+	return provider.GetSecret(ctx, ref)
+}
@@ -0,0 +1,123 @@
+package core
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestSecretIsRedacted(t *testing.T) {
+	secret := Secret("p@ssw0rd")
+
+	data, _ := json.Marshal(struct{ Password Secret }{secret})
+	for _, out := range []string{fmt.Sprint(secret), fmt.Sprintf("%#v", secret), string(data)} {
+		if strings.Contains(out, "p@ssw0rd") {
+			t.Errorf("secret revealed in %s", out)
+		}
+	}
+	if secret.Value() != "p@ssw0rd" {
+		t.Error("expected Value to return the secret")
+	}
+}
+
+func TestLoadConfigResolvesSecretReferences(t *testing.T) {
+	dir := t.TempDir()
+	key, _ := GenerateSecretKey()
+	rawKey, _ := base64.StdEncoding.DecodeString(key)
+	encrypted, err := EncryptSecret(rawKey, "jwt-secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	t.Setenv("CONFIG_SECRET_KEY", key)
+	t.Setenv("SATO_TEST_DBPW", "db-secret")
+	// The environment layer is disabled, so AUTH_SECRET is ignored
+	t.Setenv("AUTH_SECRET", "from-env")
+
+	path := writeTestFile(t, dir, "config.json", fmt.Sprintf(
+		`{"database": {"password": "env:SATO_TEST_DBPW"}, "auth": {"secret": %q}}`, encrypted))
+	config, err := LoadConfig(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if config.Database.Password.Value() != "db-secret" || config.Auth.Secret.Value() != "jwt-secret" {
+		t.Fatalf("unexpected secrets %q %q", config.Database.Password.Value(), config.Auth.Secret.Value())
+	}
+}
+
+func TestConfigLoaderSecretSources(t *testing.T) {
+	dir := t.TempDir()
+	passwordFile := writeTestFile(t, dir, "db_password", "from-file\n")
+	vault := NewMemorySecretProvider()
+	vault.Set("secret/app", "jwt", "from-vault")
+
+	path := writeTestFile(t, dir, "config.json", `{"auth": {"secret": "vault:secret/app#jwt"}}`)
+	var config Config
+	err := NewConfigLoader(ConfigLoaderOptions{
+		Files:           []string{path},
+		LookupEnv:       testLookupEnv(map[string]string{"DB_PASSWORD_FILE": passwordFile}),
+		SecretProviders: map[string]SecretProvider{"vault": vault},
+	}).Load(&config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if config.Database.Password.Value() != "from-file" || config.Auth.Secret.Value() != "from-vault" {
+		t.Fatalf("unexpected secrets %q %q", config.Database.Password.Value(), config.Auth.Secret.Value())
+	}
+}
+
+func TestConfigLoaderRejectsUnresolvableSecrets(t *testing.T) {
+	dir := t.TempDir()
+
+	for name, content := range map[string]string{
+		"redacted":  `{"auth": {"secret": "******"}}`,
+		"missing":   `{"auth": {"secret": "env:SATO_TEST_MISSING"}}`,
+		"encrypted": `{"auth": {"secret": "enc:AAAA"}}`,
+	} {
+		path := writeTestFile(t, dir, name+".json", content)
+		var config Config
+		err := NewConfigLoader(ConfigLoaderOptions{Files: []string{path}, LookupEnv: testLookupEnv(nil)}).Load(&config)
+
+		var problems ConfigErrors
+		if !errors.As(err, &problems) || len(problems) != 1 || problems[0].Key != "auth.secret" {
+			t.Errorf("%s: expected auth.secret to be rejected, got %v", name, err)
+		}
+	}
+}
+
+func TestSaveConfigSecrets(t *testing.T) {
+	dir := t.TempDir()
+
+	config := &Config{}
+	config.App.Port = 3000
+	config.App.LogLevel = "info"
+	config.Auth.Secret = "resolved-secret"
+	path := filepath.Join(dir, "config.yaml")
+	if err := SaveConfig(config, path); err == nil || !strings.Contains(err.Error(), "auth.secret") {
+		t.Fatalf("expected the resolved secret to be refused, got %v", err)
+	}
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		t.Fatal("expected no file to be written")
+	}
+
+	t.Setenv("SATO_TEST_AUTH", "from-env")
+	config.Auth.Secret = "env:SATO_TEST_AUTH"
+	for _, name := range []string{"config.json", "config.yaml", "config.toml"} {
+		path := filepath.Join(dir, name)
+		if err := SaveConfig(config, path); err != nil {
+			t.Fatal(err)
+		}
+		loaded, err := LoadConfig(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if loaded.Auth.Secret.Value() != "from-env" || loaded.App.Port != 3000 {
+			t.Fatalf("%s: unexpected config after saving %+v", name, loaded)
+		}
+	}
+}
@@ -0,0 +1,147 @@
+package core
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"strings"
+
+	"github.com/go-playground/validator/v10"
+)
+
+// ConfigFieldError is an invalid configuration value
+type ConfigFieldError struct {
+	// Key is the path of the value, e.g. "database.port"
+	Key     string
+	Message string
+	// Source is where the value came from, empty if it was never set
+	Source ConfigSource
+}
+
+func (e ConfigFieldError) Error() string {
+	if e.Source.Kind == "" {
+		return fmt.Sprintf("%s %s (not set)", e.Key, e.Message)
+	}
+	return fmt.Sprintf("%s %s (from %s)", e.Key, e.Message, e.Source)
+}
+
+// ConfigErrors lists every invalid value of a configuration
+type ConfigErrors []ConfigFieldError
+
+func (e ConfigErrors) Error() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "invalid configuration: %d error(s)", len(e))
+	for _, err := range e {
+		b.WriteString("\n  - ")
+		b.WriteString(err.Error())
+	}
+	return b.String()
+}
+
+// ConfigValidator is implemented by configuration structs with rules that
+// validate tags cannot express, such as rules spanning several sections
+type ConfigValidator interface {
+	ValidateConfig() []ConfigFieldError
+}
+
+// configValidate reports fields by their json path, unlike the request validator
+var configValidate = func() *validator.Validate {
+	v := validator.New()
+	v.RegisterTagNameFunc(func(field reflect.StructField) string {
+		name := configFieldName(field)
+		if name == "-" {
+			return ""
+		}
+		return name
+	})
+	// Log settings accept what the logger parses, e.g. "WARNING"
+	v.RegisterValidation("loglevel", func(field validator.FieldLevel) bool {
+		_, err := ParseLogLevel(field.Field().String())
+		return err == nil
+	})
+	v.RegisterValidation("logformat", func(field validator.FieldLevel) bool {
+		_, err := ParseLogFormat(field.Field().String())
+		return err == nil
+	})
+	return v
+}()
+
+// ValidateConfig validates a configuration struct with its validate tags and
+// its ValidateConfig method. All problems are returned together as ConfigErrors.
+func ValidateConfig(config interface{}) error {
+	return validateConfig(config, nil, "", nil)
+}
+
+// validateConfig appends the problems of config to problems. Keys are
+// reported below prefix when the config is a section of a larger file.
+func validateConfig(config interface{}, problems ConfigErrors, prefix string, source func(key string) (ConfigSource, bool)) error {
+	var found []ConfigFieldError
+
+	err := configValidate.Struct(config)
+
+	var invalid validator.ValidationErrors
+	if errors.As(err, &invalid) {
+		for _, fieldErr := range invalid {
+			key := fieldErr.Namespace()
+			if i := strings.Index(key, "."); i >= 0 {
+				key = key[i+1:]
+			}
+			found = append(found, ConfigFieldError{Key: key, Message: configErrorMessage(fieldErr)})
+		}
+	} else if err != nil {
+		return err
+	}
+
+	if v, ok := config.(ConfigValidator); ok {
+		found = append(found, v.ValidateConfig()...)
+	}
+	for _, problem := range found {
+		if prefix != "" {
+			problem.Key = prefix + "." + problem.Key
+		}
+		problems = append(problems, problem)
+	}
+
+	if len(problems) == 0 {
+		return nil
+	}
+	if source != nil {
+		for i := range problems {
+			if problems[i].Source.Kind == "" {
+				problems[i].Source, _ = source(problems[i].Key)
+			}
+		}
+	}
+	return problems
+}
+
+func configErrorMessage(err validator.FieldError) string {
+	switch err.Tag() {
+	case "required":
+		return "is required"
+	case "min", "gte":
+		if err.Kind() == reflect.String || err.Kind() == reflect.Slice || err.Kind() == reflect.Map {
+			return fmt.Sprintf("must have at least %s characters or items", err.Param())
+		}
+		return fmt.Sprintf("must be at least %s", err.Param())
+	case "max", "lte":
+		if err.Kind() == reflect.String || err.Kind() == reflect.Slice || err.Kind() == reflect.Map {
+			return fmt.Sprintf("must have at most %s characters or items", err.Param())
+		}
+		return fmt.Sprintf("must be at most %s", err.Param())
+	case "oneof":
+		return fmt.Sprintf("must be one of [%s], got %q", err.Param(), fmt.Sprint(err.Value()))
+	case "loglevel":
+		return fmt.Sprintf("must be one of [debug info warn warning error fatal], got %q", fmt.Sprint(err.Value()))
+	case "logformat":
+		return fmt.Sprintf("must be one of [text json logfmt], got %q", fmt.Sprint(err.Value()))
+	case "url":
+		return "must be a URL"
+	case "email":
+		return "must be an email address"
+	case "hostname":
+		return "must be a hostname"
+	default:
+		return fmt.Sprintf("failed the %s check", err.Tag())
+	}
+}
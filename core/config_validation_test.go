@@ -0,0 +1,155 @@
+package core
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+type validationTestConfig struct {
+	Server struct {
+		Host string `json:"host" env:"HOST" validate:"required"`
+		Port int    `json:"port" env:"PORT" validate:"min=1,max=65535"`
+	} `json:"server"`
+	Mode  string `json:"mode" env:"MODE" default:"fast" validate:"oneof=fast safe"`
+	Admin string `json:"admin"`
+}
+
+// ValidateConfig implements ConfigValidator
+func (c *validationTestConfig) ValidateConfig() []ConfigFieldError {
+	if c.Mode == "safe" && c.Admin == "" {
+		return []ConfigFieldError{{Key: "admin", Message: "is required in safe mode"}}
+	}
+	return nil
+}
+
+func TestConfigErrorsAggregateProblemsWithSources(t *testing.T) {
+	dir := t.TempDir()
+	file := writeTestFile(t, dir, "config.json", `{"server": {"port": 70000}, "retries": 3}`)
+
+	loader := NewConfigLoader(ConfigLoaderOptions{
+		Files:     []string{file},
+		LookupEnv: testLookupEnv(map[string]string{"MODE": "safe"}),
+	})
+
+	var config validationTestConfig
+	err := loader.Load(&config)
+
+	var problems ConfigErrors
+	if !errors.As(err, &problems) {
+		t.Fatalf("expected ConfigErrors, got %v", err)
+	}
+
+	expected := map[string]ConfigSource{
+		"retries":     {Kind: ConfigSourceFile, Name: file},
+		"server.host": {},
+		"server.port": {Kind: ConfigSourceFile, Name: file},
+		"admin":       {},
+	}
+	if len(problems) != len(expected) {
+		t.Fatalf("expected %d problems, got %v", len(expected), problems)
+	}
+	for _, problem := range problems {
+		source, ok := expected[problem.Key]
+		if !ok {
+			t.Errorf("unexpected problem %v", problem)
+			continue
+		}
+		if problem.Source != source {
+			t.Errorf("source of %s: expected %v, got %v", problem.Key, source, problem.Source)
+		}
+	}
+
+	message := err.Error()
+	for _, want := range []string{
+		"invalid configuration: 4 error(s)",
+		"server.port must be at most 65535 (from file " + file + ")",
+		"server.host is required (not set)",
+		"retries is not a known setting",
+		"admin is required in safe mode (not set)",
+	} {
+		if !strings.Contains(message, want) {
+			t.Errorf("expected %q in:\n%s", want, message)
+		}
+	}
+}
+
+func TestConfigErrorsUseSectionPrefix(t *testing.T) {
+	dir := t.TempDir()
+	file := writeTestFile(t, dir, "config.yaml", "mail:\n  server:\n    host: smtp\n  mode: slow\n")
+
+	loader := NewConfigLoader(ConfigLoaderOptions{
+		Files:     []string{file},
+		Section:   "mail",
+		LookupEnv: testLookupEnv(map[string]string{"PORT": "0"}),
+	})
+
+	var config validationTestConfig
+	err := loader.Load(&config)
+
+	var problems ConfigErrors
+	if !errors.As(err, &problems) || len(problems) != 2 {
+		t.Fatalf("expected two problems, got %v", err)
+	}
+	for _, problem := range problems {
+		switch problem.Key {
+		case "mail.mode":
+			if problem.Source != (ConfigSource{Kind: ConfigSourceFile, Name: file}) {
+				t.Errorf("expected mail.mode from the file, got %v", problem.Source)
+			}
+		case "mail.server.port":
+			if problem.Source != (ConfigSource{Kind: ConfigSourceEnv, Name: "PORT"}) {
+				t.Errorf("expected mail.server.port from PORT, got %v", problem.Source)
+			}
+		default:
+			t.Errorf("expected keys below the section, got %v", problem)
+		}
+	}
+}
+
+func TestValidateConfigWithoutLoader(t *testing.T) {
+	var config validationTestConfig
+	config.Server.Host = "localhost"
+	config.Server.Port = 80
+	config.Mode = "fast"
+	if err := ValidateConfig(&config); err != nil {
+		t.Fatalf("expected a valid config, got %v", err)
+	}
+
+	config.Mode = "safe"
+	err := ValidateConfig(&config)
+	var problems ConfigErrors
+	if !errors.As(err, &problems) || len(problems) != 1 || problems[0].Key != "admin" {
+		t.Fatalf("expected the ConfigValidator problem, got %v", err)
+	}
+}
+
+func TestConfigRequiresSecretOutsideDevelopment(t *testing.T) {
+	config := &Config{App: AppConfig{Port: 3000, Env: "development", LogLevel: "info", LogFormat: "text"}}
+	if err := ValidateConfig(config); err != nil {
+		t.Fatalf("expected no secret to be needed in development, got %v", err)
+	}
+
+	config.App.Env = "production"
+	err := ValidateConfig(config)
+	var problems ConfigErrors
+	if !errors.As(err, &problems) || len(problems) != 1 || problems[0].Key != "auth.secret" {
+		t.Fatalf("expected auth.secret to be required, got %v", err)
+	}
+	if !strings.Contains(err.Error(), `auth.secret is required when app.env is "production"`) {
+		t.Fatalf("unexpected message %q", err.Error())
+	}
+
+	config.Auth.Secret = "s3cret"
+	if err := ValidateConfig(config); err != nil {
+		t.Fatalf("expected a set secret to pass, got %v", err)
+	}
+
+	// LoadConfig applies the same rule
+	dir := t.TempDir()
+	path := writeTestFile(t, dir, "config.json", `{"app": {"env": "staging"}}`)
+	_, err = LoadConfig(path)
+	if !errors.As(err, &problems) || len(problems) != 1 || problems[0].Key != "auth.secret" {
+		t.Fatalf("expected LoadConfig to require auth.secret, got %v", err)
+	}
+}
@@ -0,0 +1,333 @@
+package core
+
+import (
+	"context"
+	"fmt"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+)
+
+// ConfigChange describes a configuration reload
+type ConfigChange[T any] struct {
+	Old *T
+	New *T
+	// Changed lists the paths of values that differ, e.g. "app.logLevel"
+	Changed []string
+}
+
+// Has reports whether the value at a path or any value below it changed
+func (c ConfigChange[T]) Has(path string) bool {
+	return configPathsMatch(c.Changed, []string{path})
+}
+
+// ConfigReloadedEvent is published on the event bus after a reload
+type ConfigReloadedEvent struct {
+	Changed   []string    `json:"changed"`
+	Config    interface{} `json:"-"`
+	Timestamp time.Time   `json:"timestamp"`
+}
+
+// GetName implements Event
+func (e *ConfigReloadedEvent) GetName() string {
+	return "config.reloaded"
+}
+
+// GetTimestamp implements Event
+func (e *ConfigReloadedEvent) GetTimestamp() time.Time {
+	return e.Timestamp
+}
+
+// ConfigReloadFailedEvent is published on the event bus when a changed
+// configuration is invalid and the previous one stays active
+type ConfigReloadFailedEvent struct {
+	Error     string    `json:"error"`
+	Timestamp time.Time `json:"timestamp"`
+}
+
+// GetName implements Event
+func (e *ConfigReloadFailedEvent) GetName() string {
+	return "config.reload_failed"
+}
+
+// GetTimestamp implements Event
+func (e *ConfigReloadFailedEvent) GetTimestamp() time.Time {
+	return e.Timestamp
+}
+
+// ConfigWatcherOptions defines config watcher configuration
+type ConfigWatcherOptions struct {
+	// Bus receives ConfigReloadedEvent and ConfigReloadFailedEvent if set
+	Bus *EventBus
+	// Debounce waits for writes to settle before reloading, defaults to 100ms
+	Debounce time.Duration
+	// OnError is called when a reload fails
+	OnError func(err error)
+}
+
+type configSubscription[T any] struct {
+	id      uint64
+	paths   []string
+	handler func(ConfigChange[T])
+}
+
+// ConfigWatcher holds the active configuration of a loader and reloads it
+// when its files change. A configuration that fails to load or validate is
+// rejected and the previous one stays active.
+type ConfigWatcher[T any] struct {
+	loader        *ConfigLoader
+	options       ConfigWatcherOptions
+	current       atomic.Pointer[T]
+	subscriptions []configSubscription[T]
+	nextID        uint64
+	cancel        context.CancelFunc
+	done          chan struct{}
+	reloadMu      sync.Mutex
+	mu            sync.Mutex
+}
+
+// NewConfigWatcher loads the initial configuration and returns its watcher
+func NewConfigWatcher[T any](loader *ConfigLoader, options ConfigWatcherOptions) (*ConfigWatcher[T], error) {
+	if options.Debounce == 0 {
+		options.Debounce = 100 * time.Millisecond
+	}
+
+	w := &ConfigWatcher[T]{
+		loader:  loader,
+		options: options,
+	}
+
+	config := new(T)
+	if err := loader.Load(config); err != nil {
+		return nil, err
+	}
+	w.current.Store(config)
+	return w, nil
+}
+
+// Get returns the active configuration. It must not be modified, a reload
+// replaces it with a new value instead.
+func (w *ConfigWatcher[T]) Get() *T {
+	return w.current.Load()
+}
+
+// Subscribe calls handler after every reload that changes a value at or
+// below one of the paths, or after every changing reload if none are given.
+// It returns a function that removes the subscription.
+func (w *ConfigWatcher[T]) Subscribe(handler func(ConfigChange[T]), paths ...string) func() {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	w.nextID++
+	id := w.nextID
+	w.subscriptions = append(w.subscriptions, configSubscription[T]{id: id, paths: paths, handler: handler})
+
+	return func() {
+		w.mu.Lock()
+		defer w.mu.Unlock()
+		for i, sub := range w.subscriptions {
+			if sub.id == id {
+				w.subscriptions = append(w.subscriptions[:i:i], w.subscriptions[i+1:]...)
+				return
+			}
+		}
+	}
+}
+
+// Reload loads the configuration again and swaps it in when it is valid.
+// Subscribers are notified only when a value changed.
+func (w *ConfigWatcher[T]) Reload() error {
+	w.reloadMu.Lock()
+	defer w.reloadMu.Unlock()
+
+	config := new(T)
+	if err := w.loader.Load(config); err != nil {
+		if w.options.Bus != nil {
+			w.options.Bus.Publish(&ConfigReloadFailedEvent{Error: err.Error(), Timestamp: time.Now()})
+		}
+		return err
+	}
+
+	old := w.current.Load()
+	changed := configChanges(old, config, w.loader.options.Section)
+	if len(changed) == 0 {
+		return nil
+	}
+	w.current.Store(config)
+
+	change := ConfigChange[T]{Old: old, New: config, Changed: changed}
+
+	w.mu.Lock()
+	subscriptions := append([]configSubscription[T](nil), w.subscriptions...)
+	w.mu.Unlock()
+
+	for _, sub := range subscriptions {
+		if len(sub.paths) == 0 || configPathsMatch(changed, sub.paths) {
+			sub.handler(change)
+		}
+	}
+
+	if w.options.Bus != nil {
+		if err := w.options.Bus.Publish(&ConfigReloadedEvent{Changed: changed, Config: config, Timestamp: time.Now()}); err != nil {
+			return fmt.Errorf("config reloaded but publishing failed: %v", err)
+		}
+	}
+	return nil
+}
+
+// Start watches the configuration files and reloads on changes. Directories
+// are watched so that files replaced by editors or mounted config maps are seen.
+func (w *ConfigWatcher[T]) Start() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if w.cancel != nil {
+		return nil
+	}
+
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		return fmt.Errorf("failed to create watcher: %v", err)
+	}
+
+	files := make(map[string]bool)
+	dirs := make(map[string]bool)
+	for _, path := range w.watchedFiles() {
+		abs, err := filepath.Abs(path)
+		if err != nil {
+			watcher.Close()
+			return err
+		}
+		files[abs] = true
+		dirs[filepath.Dir(abs)] = true
+	}
+	for dir := range dirs {
+		if err := watcher.Add(dir); err != nil {
+			watcher.Close()
+			return fmt.Errorf("failed to watch %s: %v", dir, err)
+		}
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	w.cancel = cancel
+	w.done = make(chan struct{})
+
+	go func() {
+		defer close(w.done)
+		defer watcher.Close()
+
+		timer := time.NewTimer(w.options.Debounce)
+		timer.Stop()
+		defer timer.Stop()
+
+		for {
+			select {
+			case event, ok := <-watcher.Events:
+				if !ok {
+					return
+				}
+				if abs, err := filepath.Abs(event.Name); err == nil && files[abs] {
+					timer.Reset(w.options.Debounce)
+				}
+			case err, ok := <-watcher.Errors:
+				if !ok {
+					return
+				}
+				w.reportError(fmt.Errorf("error watching config files: %v", err))
+			case <-timer.C:
+				if err := w.Reload(); err != nil {
+					w.reportError(err)
+				}
+				// Includes may have changed, watch the files read by this load as well
+				for _, path := range w.watchedFiles() {
+					if abs, err := filepath.Abs(path); err == nil && !files[abs] {
+						files[abs] = true
+						if !dirs[filepath.Dir(abs)] {
+							dirs[filepath.Dir(abs)] = true
+							if err := watcher.Add(filepath.Dir(abs)); err != nil {
+								w.reportError(fmt.Errorf("failed to watch %s: %v", filepath.Dir(abs), err))
+							}
+						}
+					}
+				}
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	return nil
+}
+
+// Stop stops watching the configuration files
+func (w *ConfigWatcher[T]) Stop() {
+	w.mu.Lock()
+	cancel, done := w.cancel, w.done
+	w.cancel = nil
+	w.mu.Unlock()
+
+	if cancel != nil {
+		cancel()
+		<-done
+	}
+}
+
+// WatchLogLevel applies app.logLevel to a logger now and after every reload
+// that changes it. It returns a function that stops updating the logger.
+func WatchLogLevel(watcher *ConfigWatcher[Config], logger *Logger) func() {
+	if level, err := ParseLogLevel(watcher.Get().App.LogLevel); err == nil {
+		logger.SetLevel(level)
+	}
+	return watcher.Subscribe(func(change ConfigChange[Config]) {
+		if level, err := ParseLogLevel(change.New.App.LogLevel); err == nil {
+			logger.SetLevel(level)
+		}
+	}, "app.logLevel")
+}
+
+func (w *ConfigWatcher[T]) watchedFiles() []string {
+	files := append([]string(nil), w.loader.options.Files...)
+	files = append(files, w.loader.options.DotEnvFiles...)
+	files = append(files, w.loader.profileDotEnvFiles()...)
+	return append(files, w.loader.Files()...)
+}
+
+func (w *ConfigWatcher[T]) reportError(err error) {
+	if w.options.OnError != nil {
+		w.options.OnError(err)
+	}
+}
+
+// configChanges returns the paths of the leaf values that differ
+func configChanges[T any](old, new *T, prefix string) []string {
+	oldFields := configLeaves(reflect.ValueOf(old).Elem(), prefix)
+	newFields := configLeaves(reflect.ValueOf(new).Elem(), prefix)
+
+	var changed []string
+	for i, field := range newFields {
+		if !reflect.DeepEqual(oldFields[i].value.Interface(), field.value.Interface()) {
+			changed = append(changed, field.path)
+		}
+	}
+	return changed
+}
+
+func configLeaves(value reflect.Value, prefix string) []configField {
+	return (&ConfigLoader{}).fields(value, prefix)
+}
+
+// configPathsMatch reports whether a changed path equals or lies below one of the paths
+func configPathsMatch(changed, paths []string) bool {
+	for _, c := range changed {
+		for _, p := range paths {
+			if c == p || strings.HasPrefix(c, p+".") {
+				return true
+			}
+		}
+	}
+	return false
+}
@@ -0,0 +1,184 @@
+package core
+
+import (
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func newTestConfigWatcher(t *testing.T, content string, options ConfigWatcherOptions) (*ConfigWatcher[loaderTestConfig], string) {
+	t.Helper()
+	path := writeTestFile(t, t.TempDir(), "config.json", content)
+	loader := NewConfigLoader(ConfigLoaderOptions{
+		Files:     []string{path},
+		LookupEnv: testLookupEnv(nil),
+	})
+	if options.Debounce == 0 {
+		options.Debounce = 10 * time.Millisecond
+	}
+
+	watcher, err := NewConfigWatcher[loaderTestConfig](loader, options)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return watcher, path
+}
+
+func TestConfigWatcherReloadsOnFileChange(t *testing.T) {
+	watcher, path := newTestConfigWatcher(t, `{"server": {"port": 8080}}`, ConfigWatcherOptions{})
+
+	changes := make(chan ConfigChange[loaderTestConfig], 1)
+	watcher.Subscribe(func(change ConfigChange[loaderTestConfig]) { changes <- change })
+	if err := watcher.Start(); err != nil {
+		t.Fatal(err)
+	}
+	defer watcher.Stop()
+
+	writeTestFile(t, filepath.Dir(path), "config.json", `{"server": {"port": 9090}}`)
+
+	change := waitFor(t, changes, "the reload")
+	if change.Old.Server.Port != 8080 || change.New.Server.Port != 9090 {
+		t.Fatalf("expected the port to change from 8080 to 9090, got %d to %d", change.Old.Server.Port, change.New.Server.Port)
+	}
+	if !reflect.DeepEqual(change.Changed, []string{"server.port"}) {
+		t.Fatalf("expected only server.port to change, got %v", change.Changed)
+	}
+	if watcher.Get().Server.Port != 9090 {
+		t.Fatalf("expected Get to return the new config, got port %d", watcher.Get().Server.Port)
+	}
+}
+
+func TestConfigWatcherKeepsConfigOnInvalidFile(t *testing.T) {
+	bus := NewEventBus()
+	failures := make(chan *ConfigReloadFailedEvent, 1)
+	bus.Subscribe("config.reload_failed", func(event Event) error {
+		select {
+		case failures <- event.(*ConfigReloadFailedEvent):
+		default:
+		}
+		return nil
+	})
+
+	// Editors may write a file in several steps, each failing the reload
+	errs := make(chan error, 1)
+	watcher, path := newTestConfigWatcher(t, `{"server": {"port": 8080}}`, ConfigWatcherOptions{
+		Bus: bus,
+		OnError: func(err error) {
+			select {
+			case errs <- err:
+			default:
+			}
+		},
+	})
+	active := watcher.Get()
+
+	called := false
+	watcher.Subscribe(func(change ConfigChange[loaderTestConfig]) { called = true })
+	if err := watcher.Start(); err != nil {
+		t.Fatal(err)
+	}
+	defer watcher.Stop()
+
+	writeTestFile(t, filepath.Dir(path), "config.json", `{"server": {"port": "not a number"}}`)
+
+	waitFor(t, errs, "the reload error")
+	event := waitFor(t, failures, "config.reload_failed")
+	if event.Error == "" {
+		t.Fatal("expected the event to describe the error")
+	}
+	if watcher.Get() != active || called {
+		t.Fatal("expected the previous config to stay active")
+	}
+}
+
+func TestConfigWatcherSubscribePaths(t *testing.T) {
+	watcher, path := newTestConfigWatcher(t, `{"server": {"host": "a", "port": 80}, "tags": ["x"]}`, ConfigWatcherOptions{})
+
+	var server, tags, all int
+	watcher.Subscribe(func(ConfigChange[loaderTestConfig]) { server++ }, "server")
+	watcher.Subscribe(func(ConfigChange[loaderTestConfig]) { tags++ }, "tags")
+	unsubscribe := watcher.Subscribe(func(ConfigChange[loaderTestConfig]) { all++ })
+
+	writeTestFile(t, filepath.Dir(path), "config.json", `{"server": {"host": "a", "port": 81}, "tags": ["x"]}`)
+	if err := watcher.Reload(); err != nil {
+		t.Fatal(err)
+	}
+	if server != 1 || tags != 0 || all != 1 {
+		t.Fatalf("expected only server and catch-all subscribers, got server=%d tags=%d all=%d", server, tags, all)
+	}
+
+	// A reload without changes notifies nobody
+	if err := watcher.Reload(); err != nil {
+		t.Fatal(err)
+	}
+	unsubscribe()
+
+	writeTestFile(t, filepath.Dir(path), "config.json", `{"server": {"host": "a", "port": 81}, "tags": ["y"]}`)
+	if err := watcher.Reload(); err != nil {
+		t.Fatal(err)
+	}
+	if server != 1 || tags != 1 || all != 1 {
+		t.Fatalf("expected the tags subscriber only, got server=%d tags=%d all=%d", server, tags, all)
+	}
+}
+
+func TestConfigWatcherStop(t *testing.T) {
+	watcher, path := newTestConfigWatcher(t, `{"server": {"port": 8080}}`, ConfigWatcherOptions{})
+
+	changes := make(chan ConfigChange[loaderTestConfig], 1)
+	watcher.Subscribe(func(change ConfigChange[loaderTestConfig]) { changes <- change })
+	if err := watcher.Start(); err != nil {
+		t.Fatal(err)
+	}
+
+	stopped := make(chan struct{})
+	go func() {
+		watcher.Stop()
+		close(stopped)
+	}()
+	waitFor(t, stopped, "Stop")
+	watcher.Stop()
+
+	writeTestFile(t, filepath.Dir(path), "config.json", `{"server": {"port": 9090}}`)
+	select {
+	case <-changes:
+		t.Fatal("expected no reload after Stop")
+	case <-time.After(100 * time.Millisecond):
+	}
+	if watcher.Get().Server.Port != 8080 {
+		t.Fatalf("expected the config to stay unchanged, got port %d", watcher.Get().Server.Port)
+	}
+}
+
+func TestWatchLogLevel(t *testing.T) {
+	path := writeTestFile(t, t.TempDir(), "config.json", `{"app": {"logLevel": "warn"}}`)
+	loader := NewConfigLoader(ConfigLoaderOptions{Files: []string{path}, LookupEnv: testLookupEnv(nil)})
+	watcher, err := NewConfigWatcher[Config](loader, ConfigWatcherOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	logger := NewLogger(Info)
+	stop := WatchLogLevel(watcher, logger)
+	if logger.Level() != Warn {
+		t.Fatalf("expected the current level to be applied, got %v", logger.Level())
+	}
+
+	writeTestFile(t, filepath.Dir(path), "config.json", `{"app": {"logLevel": "DEBUG"}}`)
+	if err := watcher.Reload(); err != nil {
+		t.Fatal(err)
+	}
+	if logger.Level() != Debug {
+		t.Fatalf("expected the reloaded level, got %v", logger.Level())
+	}
+
+	stop()
+	writeTestFile(t, filepath.Dir(path), "config.json", `{"app": {"logLevel": "error"}}`)
+	if err := watcher.Reload(); err != nil {
+		t.Fatal(err)
+	}
+	if logger.Level() != Debug {
+		t.Fatalf("expected no updates after stop, got %v", logger.Level())
+	}
+}
@@ -0,0 +1,369 @@
+package core
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"reflect"
+	"sync"
+	"time"
+
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// CommandHandler handles commands of type C
+type CommandHandler[C any] interface {
+	Handle(ctx context.Context, command C) error
+}
+
+// CommandHandlerFunc adapts a function to a CommandHandler
+type CommandHandlerFunc[C any] func(ctx context.Context, command C) error
+
+// Handle implements CommandHandler
+func (f CommandHandlerFunc[C]) Handle(ctx context.Context, command C) error {
+	return f(ctx, command)
+}
+
+// QueryHandler handles queries of type Q returning R
+type QueryHandler[Q any, R any] interface {
+	Handle(ctx context.Context, query Q) (R, error)
+}
+
+// QueryHandlerFunc adapts a function to a QueryHandler
+type QueryHandlerFunc[Q any, R any] func(ctx context.Context, query Q) (R, error)
+
+// Handle implements QueryHandler
+func (f QueryHandlerFunc[Q, R]) Handle(ctx context.Context, query Q) (R, error) {
+	return f(ctx, query)
+}
+
+// BusHandler handles a command or query, commands return a nil result
+type BusHandler func(ctx context.Context, message interface{}) (interface{}, error)
+
+// BusMiddleware wraps the handling of commands and queries
+type BusMiddleware func(ctx context.Context, message interface{}, next BusHandler) (interface{}, error)
+
+// messageBus routes messages to one handler per message type
+type messageBus struct {
+	kind       string
+	handlers   map[reflect.Type]BusHandler
+	middleware []BusMiddleware
+	mu         sync.RWMutex
+}
+
+func (b *messageBus) register(typ reflect.Type, handler BusHandler) error {
+	// Messages are routed by their dynamic type, which is never an interface
+	if typ.Kind() == reflect.Interface {
+		return fmt.Errorf("%s type %v is an interface, register a concrete type", b.kind, typ)
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if _, exists := b.handlers[typ]; exists {
+		return fmt.Errorf("%s handler for %s already registered", b.kind, typ)
+	}
+	b.handlers[typ] = handler
+	return nil
+}
+
+func (b *messageBus) use(middleware ...BusMiddleware) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.middleware = append(b.middleware, middleware...)
+}
+
+func (b *messageBus) dispatch(ctx context.Context, message interface{}) (interface{}, error) {
+	typ := reflect.TypeOf(message)
+
+	b.mu.RLock()
+	handler, exists := b.handlers[typ]
+	middleware := b.middleware
+	b.mu.RUnlock()
+
+	if !exists {
+		return nil, fmt.Errorf("no %s handler registered for %v", b.kind, typ)
+	}
+
+	for i := len(middleware) - 1; i >= 0; i-- {
+		next, mw := handler, middleware[i]
+		handler = func(ctx context.Context, message interface{}) (interface{}, error) {
+			return mw(ctx, message, next)
+		}
+	}
+	return handler(ctx, message)
+}
+
+// CommandBus dispatches commands to their handlers. Events recorded with
+// RecordEvent while handling a command are published on the EventBus once
+// the command and all middleware succeeded.
+type CommandBus struct {
+	bus    messageBus
+	events *EventBus
+}
+
+// NewCommandBus creates a new command bus. The event bus may be nil.
+func NewCommandBus(events *EventBus) *CommandBus {
+	return &CommandBus{
+		bus:    messageBus{kind: "command", handlers: make(map[reflect.Type]BusHandler)},
+		events: events,
+	}
+}
+
+// Use adds middleware, the first added runs outermost
+func (b *CommandBus) Use(middleware ...BusMiddleware) {
+	b.bus.use(middleware...)
+}
+
+// Dispatch handles a command. Commands are routed by their exact type,
+// so a handler for a struct does not receive pointers to it.
+func (b *CommandBus) Dispatch(ctx context.Context, command interface{}) error {
+	recorder := &eventRecorder{}
+	if _, err := b.bus.dispatch(context.WithValue(ctx, eventRecorderKey{}, recorder), command); err != nil {
+		return err
+	}
+
+	// Commands dispatched by a command handler publish their events with the
+	// outer command, and only if they succeeded
+	if parent, nested := ctx.Value(eventRecorderKey{}).(*eventRecorder); nested {
+		parent.record(recorder.events...)
+		return nil
+	}
+
+	if b.events == nil {
+		return nil
+	}
+	for _, event := range recorder.events {
+		if err := b.events.PublishContext(ctx, event); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// RegisterProviders registers container services as command handlers. Each
+// service must have a Handle(context.Context, C) error method and becomes
+// the handler for commands of type C.
+func (b *CommandBus) RegisterProviders(container *Container, names ...string) error {
+	return registerProviders(container, names, func(name string, service interface{}) error {
+		typ, handler, ok := commandHandlerOf(service)
+		if !ok {
+			return fmt.Errorf("service %s is not a command handler", name)
+		}
+		return b.bus.register(typ, handler)
+	})
+}
+
+// RegisterCommandHandler registers the handler for commands of type C.
+// C must be a concrete type, commands are never routed by interface.
+func RegisterCommandHandler[C any](bus *CommandBus, handler CommandHandler[C]) error {
+	return bus.bus.register(reflect.TypeOf((*C)(nil)).Elem(), func(ctx context.Context, message interface{}) (interface{}, error) {
+		return nil, handler.Handle(ctx, message.(C))
+	})
+}
+
+// QueryBus dispatches queries to their handlers
+type QueryBus struct {
+	bus messageBus
+}
+
+// NewQueryBus creates a new query bus
+func NewQueryBus() *QueryBus {
+	return &QueryBus{
+		bus: messageBus{kind: "query", handlers: make(map[reflect.Type]BusHandler)},
+	}
+}
+
+// Use adds middleware, the first added runs outermost
+func (b *QueryBus) Use(middleware ...BusMiddleware) {
+	b.bus.use(middleware...)
+}
+
+// Execute handles a query and returns its result. Queries are routed by
+// their exact type.
+func (b *QueryBus) Execute(ctx context.Context, query interface{}) (interface{}, error) {
+	return b.bus.dispatch(ctx, query)
+}
+
+// RegisterProviders registers container services as query handlers. Each
+// service must have a Handle(context.Context, Q) (R, error) method and becomes
+// the handler for queries of type Q.
+func (b *QueryBus) RegisterProviders(container *Container, names ...string) error {
+	return registerProviders(container, names, func(name string, service interface{}) error {
+		typ, handler, ok := queryHandlerOf(service)
+		if !ok {
+			return fmt.Errorf("service %s is not a query handler", name)
+		}
+		return b.bus.register(typ, handler)
+	})
+}
+
+// RegisterQueryHandler registers the handler for queries of type Q.
+// Q must be a concrete type, queries are never routed by interface.
+func RegisterQueryHandler[Q any, R any](bus *QueryBus, handler QueryHandler[Q, R]) error {
+	return bus.bus.register(reflect.TypeOf((*Q)(nil)).Elem(), func(ctx context.Context, message interface{}) (interface{}, error) {
+		return handler.Handle(ctx, message.(Q))
+	})
+}
+
+// ExecuteQuery handles a query and returns its result as R
+func ExecuteQuery[R any](ctx context.Context, bus *QueryBus, query interface{}) (R, error) {
+	var zero R
+
+	result, err := bus.Execute(ctx, query)
+	if err != nil {
+		return zero, err
+	}
+	if result == nil {
+		return zero, nil
+	}
+
+	typed, ok := result.(R)
+	if !ok {
+		return zero, fmt.Errorf("query %T returned %T, not %v", query, result, reflect.TypeOf((*R)(nil)).Elem())
+	}
+	return typed, nil
+}
+
+type eventRecorderKey struct{}
+
+type eventRecorder struct {
+	events []Event
+	mu     sync.Mutex
+}
+
+// RecordEvent records domain events while handling a command. The command
+// bus publishes them after the command succeeded and discards them otherwise.
+func RecordEvent(ctx context.Context, events ...Event) error {
+	recorder, ok := ctx.Value(eventRecorderKey{}).(*eventRecorder)
+	if !ok {
+		return fmt.Errorf("RecordEvent called outside of a command handler")
+	}
+
+	recorder.record(events...)
+	return nil
+}
+
+func (r *eventRecorder) record(events ...Event) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.events = append(r.events, events...)
+}
+
+// LoggingBusMiddleware logs every command or query with its duration
+func LoggingBusMiddleware(logger *Logger) BusMiddleware {
+	return func(ctx context.Context, message interface{}, next BusHandler) (interface{}, error) {
+		start := time.Now()
+		result, err := next(ctx, message)
+		if err != nil {
+			logger.Error("%T failed after %v: %v", message, time.Since(start), err)
+		} else {
+			logger.Debug("%T handled in %v", message, time.Since(start))
+		}
+		return result, err
+	}
+}
+
+// ValidationBusMiddleware validates struct commands and queries with Validate
+// before they are handled
+func ValidationBusMiddleware() BusMiddleware {
+	return func(ctx context.Context, message interface{}, next BusHandler) (interface{}, error) {
+		value := reflect.ValueOf(message)
+		if value.Kind() == reflect.Ptr {
+			value = value.Elem()
+		}
+		if value.Kind() == reflect.Struct {
+			if err := Validate(message); err != nil {
+				return nil, err
+			}
+		}
+		return next(ctx, message)
+	}
+}
+
+// TransactionBusMiddleware handles every message inside a SQL transaction,
+// available to handlers through TxFromContext. SQL stores on the same
+// database, such as SQLEventStore, join it through RunInTransaction.
+func TransactionBusMiddleware(db *sql.DB) BusMiddleware {
+	return func(ctx context.Context, message interface{}, next BusHandler) (interface{}, error) {
+		var result interface{}
+		err := RunInTransaction(ctx, db, func(tx *sql.Tx) error {
+			var err error
+			result, err = next(ContextWithTx(ctx, tx), message)
+			return err
+		})
+		return result, err
+	}
+}
+
+// MongoTransactionBusMiddleware handles every message inside a MongoDB
+// transaction. Handlers pass the context to MongoDB operations to join it.
+func MongoTransactionBusMiddleware(provider *MongoDBProvider) BusMiddleware {
+	return func(ctx context.Context, message interface{}, next BusHandler) (interface{}, error) {
+		var result interface{}
+		err := provider.WithTransaction(ctx, func(sessCtx mongo.SessionContext) error {
+			var err error
+			result, err = next(sessCtx, message)
+			return err
+		})
+		return result, err
+	}
+}
+
+var (
+	contextType = reflect.TypeOf((*context.Context)(nil)).Elem()
+	errorType   = reflect.TypeOf((*error)(nil)).Elem()
+)
+
+func registerProviders(container *Container, names []string, register func(name string, service interface{}) error) error {
+	for _, name := range names {
+		service, err := container.Get(name)
+		if err != nil {
+			return err
+		}
+		if err := register(name, service); err != nil {
+			return fmt.Errorf("failed to register %s: %v", name, err)
+		}
+	}
+	return nil
+}
+
+// handleMethod returns the Handle(context.Context, M) method of a service
+func handleMethod(service interface{}) (reflect.Value, reflect.Type, bool) {
+	method := reflect.ValueOf(service).MethodByName("Handle")
+	if !method.IsValid() {
+		return reflect.Value{}, nil, false
+	}
+
+	typ := method.Type()
+	if typ.NumIn() != 2 || typ.In(0) != contextType || typ.IsVariadic() {
+		return reflect.Value{}, nil, false
+	}
+	return method, typ.In(1), true
+}
+
+func commandHandlerOf(service interface{}) (reflect.Type, BusHandler, bool) {
+	method, messageType, ok := handleMethod(service)
+	if !ok || method.Type().NumOut() != 1 || method.Type().Out(0) != errorType {
+		return nil, nil, false
+	}
+
+	return messageType, func(ctx context.Context, message interface{}) (interface{}, error) {
+		out := method.Call([]reflect.Value{reflect.ValueOf(ctx), reflect.ValueOf(message)})
+		err, _ := out[0].Interface().(error)
+		return nil, err
+	}, true
+}
+
+func queryHandlerOf(service interface{}) (reflect.Type, BusHandler, bool) {
+	method, messageType, ok := handleMethod(service)
+	if !ok || method.Type().NumOut() != 2 || method.Type().Out(1) != errorType {
+		return nil, nil, false
+	}
+
+	return messageType, func(ctx context.Context, message interface{}) (interface{}, error) {
+		out := method.Call([]reflect.Value{reflect.ValueOf(ctx), reflect.ValueOf(message)})
+		err, _ := out[1].Interface().(error)
+		return out[0].Interface(), err
+	}, true
+}
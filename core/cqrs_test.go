@@ -0,0 +1,262 @@
+package core
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"reflect"
+	"testing"
+)
+
+type placeOrder struct{ FailInner bool }
+
+type reserveStock struct{ Fail bool }
+
+func TestCommandBusPublishesNestedEventsOnlyOnSuccess(t *testing.T) {
+	events := NewEventBus()
+	var published []string
+	events.Subscribe("#", func(event Event) error {
+		published = append(published, event.GetName())
+		return nil
+	})
+
+	commands := NewCommandBus(events)
+	RegisterCommandHandler[reserveStock](commands, CommandHandlerFunc[reserveStock](func(ctx context.Context, cmd reserveStock) error {
+		RecordEvent(ctx, &testEvent{Name: "stock.reserved"})
+		if cmd.Fail {
+			return errTestHandler
+		}
+		return nil
+	}))
+	RegisterCommandHandler[placeOrder](commands, CommandHandlerFunc[placeOrder](func(ctx context.Context, cmd placeOrder) error {
+		RecordEvent(ctx, &testEvent{Name: "order.placed"})
+		// The order is placed even when the stock cannot be reserved
+		commands.Dispatch(ctx, reserveStock{Fail: cmd.FailInner})
+		return nil
+	}))
+
+	if err := commands.Dispatch(context.Background(), placeOrder{FailInner: true}); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(published, []string{"order.placed"}) {
+		t.Fatalf("expected the failed inner command's events to be discarded, got %v", published)
+	}
+
+	published = nil
+	if err := commands.Dispatch(context.Background(), placeOrder{}); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(published, []string{"order.placed", "stock.reserved"}) {
+		t.Fatalf("expected the inner command's events with the outer ones, got %v", published)
+	}
+
+	published = nil
+	if err := commands.Dispatch(context.Background(), reserveStock{Fail: true}); !errors.Is(err, errTestHandler) {
+		t.Fatalf("expected the handler error, got %v", err)
+	}
+	if len(published) != 0 {
+		t.Fatalf("expected no events from a failed command, got %v", published)
+	}
+}
+
+type getOrder struct{ ID string }
+
+type validatedCommand struct {
+	Name string `validate:"required"`
+}
+
+type orderCommands struct{ placed []placeOrder }
+
+func (h *orderCommands) Handle(ctx context.Context, cmd placeOrder) error {
+	h.placed = append(h.placed, cmd)
+	return nil
+}
+
+type orderQueries struct{}
+
+func (orderQueries) Handle(ctx context.Context, query getOrder) (string, error) {
+	return "order " + query.ID, nil
+}
+
+type anyCommands struct{}
+
+func (anyCommands) Handle(ctx context.Context, cmd interface{}) error { return nil }
+
+func TestBusRegisterProviders(t *testing.T) {
+	container := NewContainer()
+	handler := &orderCommands{}
+	container.Register("orders.commands", handler)
+	container.Register("orders.queries", orderQueries{})
+	container.Register("orders.any", anyCommands{})
+	container.Register("orders.repository", struct{}{})
+
+	commands := NewCommandBus(nil)
+	if err := commands.RegisterProviders(container, "orders.commands"); err != nil {
+		t.Fatal(err)
+	}
+	if err := commands.Dispatch(context.Background(), placeOrder{FailInner: true}); err != nil {
+		t.Fatal(err)
+	}
+	if len(handler.placed) != 1 || !handler.placed[0].FailInner {
+		t.Fatalf("expected the provider to handle the command, got %v", handler.placed)
+	}
+
+	queries := NewQueryBus()
+	if err := queries.RegisterProviders(container, "orders.queries"); err != nil {
+		t.Fatal(err)
+	}
+	result, err := ExecuteQuery[string](context.Background(), queries, getOrder{ID: "1"})
+	if err != nil || result != "order 1" {
+		t.Fatalf("expected the provider to answer the query, got %q, %v", result, err)
+	}
+
+	for _, name := range []string{"orders.repository", "orders.queries", "orders.any", "orders.missing"} {
+		if err := commands.RegisterProviders(container, name); err == nil {
+			t.Errorf("expected %s to be rejected as a command handler", name)
+		}
+	}
+	if err := queries.RegisterProviders(container, "orders.commands"); err == nil {
+		t.Error("expected a command handler to be rejected as a query handler")
+	}
+}
+
+func TestRegisterHandlerRejectsInterfaceTypes(t *testing.T) {
+	commands := NewCommandBus(nil)
+	err := RegisterCommandHandler[fmt.Stringer](commands, CommandHandlerFunc[fmt.Stringer](func(ctx context.Context, cmd fmt.Stringer) error {
+		return nil
+	}))
+	if err == nil {
+		t.Fatal("expected an interface command type to be rejected")
+	}
+
+	queries := NewQueryBus()
+	err = RegisterQueryHandler[interface{}, string](queries, QueryHandlerFunc[interface{}, string](func(ctx context.Context, query interface{}) (string, error) {
+		return "", nil
+	}))
+	if err == nil {
+		t.Fatal("expected an interface query type to be rejected")
+	}
+}
+
+func TestValidationBusMiddleware(t *testing.T) {
+	commands := NewCommandBus(nil)
+	commands.Use(ValidationBusMiddleware())
+
+	handled := 0
+	RegisterCommandHandler[validatedCommand](commands, CommandHandlerFunc[validatedCommand](func(ctx context.Context, cmd validatedCommand) error {
+		handled++
+		return nil
+	}))
+	RegisterCommandHandler[*validatedCommand](commands, CommandHandlerFunc[*validatedCommand](func(ctx context.Context, cmd *validatedCommand) error {
+		handled++
+		return nil
+	}))
+	RegisterCommandHandler[string](commands, CommandHandlerFunc[string](func(ctx context.Context, cmd string) error {
+		handled++
+		return nil
+	}))
+
+	if err := commands.Dispatch(context.Background(), validatedCommand{}); err == nil {
+		t.Fatal("expected an invalid command to be rejected")
+	}
+	if err := commands.Dispatch(context.Background(), &validatedCommand{}); err == nil {
+		t.Fatal("expected an invalid command pointer to be rejected")
+	}
+	if handled != 0 {
+		t.Fatalf("expected invalid commands not to be handled, got %d", handled)
+	}
+
+	for _, cmd := range []interface{}{validatedCommand{Name: "a"}, &validatedCommand{Name: "a"}, ""} {
+		if err := commands.Dispatch(context.Background(), cmd); err != nil {
+			t.Fatalf("expected %#v to pass validation, got %v", cmd, err)
+		}
+	}
+	if handled != 3 {
+		t.Fatalf("expected valid commands to be handled, got %d", handled)
+	}
+}
+
+func TestTransactionBusMiddlewareJoinsSQLStores(t *testing.T) {
+	ctx := context.Background()
+	db := openTestDB(t)
+	store := NewSQLEventStore(db, SQLDialectSQLite, "")
+	if err := store.CreateTable(); err != nil {
+		t.Fatal(err)
+	}
+
+	commands := NewCommandBus(nil)
+	commands.Use(TransactionBusMiddleware(db))
+	RegisterCommandHandler[reserveStock](commands, CommandHandlerFunc[reserveStock](func(ctx context.Context, cmd reserveStock) error {
+		if _, ok := TxFromContext(ctx); !ok {
+			t.Error("expected the transaction in the handler context")
+		}
+		if _, err := store.Append(ctx, "stock-1", AnyVersion, &testEvent{Name: "stock.reserved"}); err != nil {
+			return err
+		}
+		if cmd.Fail {
+			return errTestHandler
+		}
+		return nil
+	}))
+
+	if err := commands.Dispatch(ctx, reserveStock{Fail: true}); !errors.Is(err, errTestHandler) {
+		t.Fatalf("expected the handler error, got %v", err)
+	}
+	if n := countRows(t, db, "events"); n != 0 {
+		t.Fatalf("expected the append to roll back with the command, got %d events", n)
+	}
+
+	if err := commands.Dispatch(ctx, reserveStock{}); err != nil {
+		t.Fatal(err)
+	}
+	if n := countRows(t, db, "events"); n != 1 {
+		t.Fatalf("expected the append to commit with the command, got %d events", n)
+	}
+}
+
+func TestRunInTransactionJoinsContextTransaction(t *testing.T) {
+	ctx := context.Background()
+	db := openTestDB(t)
+	if _, err := db.Exec("CREATE TABLE items (name TEXT)"); err != nil {
+		t.Fatal(err)
+	}
+
+	err := RunInTransaction(ctx, db, func(outer *sql.Tx) error {
+		err := RunInTransaction(ContextWithTx(ctx, outer), db, func(inner *sql.Tx) error {
+			if inner != outer {
+				t.Error("expected the inner call to join the outer transaction")
+			}
+			_, err := inner.Exec("INSERT INTO items (name) VALUES ('a')")
+			return err
+		})
+		if err != nil {
+			return err
+		}
+		return errTestHandler
+	})
+	if !errors.Is(err, errTestHandler) {
+		t.Fatalf("expected the outer error, got %v", err)
+	}
+	if n := countRows(t, db, "items"); n != 0 {
+		t.Fatalf("expected the inner write to roll back with the outer transaction, got %d rows", n)
+	}
+}
+
+func TestMongoTransactionBusMiddlewareRequiresConnection(t *testing.T) {
+	commands := NewCommandBus(nil)
+	commands.Use(MongoTransactionBusMiddleware(NewMongoDBProvider("mongodb://localhost:27017", "test")))
+
+	handled := false
+	RegisterCommandHandler[reserveStock](commands, CommandHandlerFunc[reserveStock](func(ctx context.Context, cmd reserveStock) error {
+		handled = true
+		return nil
+	}))
+
+	if err := commands.Dispatch(context.Background(), reserveStock{}); err == nil {
+		t.Fatal("expected an error without a MongoDB connection")
+	}
+	if handled {
+		t.Fatal("expected the handler not to run outside a transaction")
+	}
+}
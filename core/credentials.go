@@ -0,0 +1,694 @@
+package core
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+	"unicode"
+
+	"github.com/go-playground/validator/v10"
+	"github.com/gofiber/fiber/v2"
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// PasswordHasher defines the interface for password hashing algorithms
+type PasswordHasher interface {
+	// Hash returns an encoded hash of the password
+	Hash(password string) (string, error)
+	// Verify checks a password against an encoded hash and reports
+	// whether the hash should be upgraded to the current parameters
+	Verify(password, encoded string) (match bool, needsRehash bool, err error)
+	// Supports reports whether the hasher can verify the encoded hash
+	Supports(encoded string) bool
+}
+
+// Argon2idHasher hashes passwords with argon2id
+type Argon2idHasher struct {
+	Memory      uint32
+	Iterations  uint32
+	Parallelism uint8
+	SaltLength  uint32
+	KeyLength   uint32
+}
+
+// NewArgon2idHasher creates an argon2id hasher with recommended parameters
+func NewArgon2idHasher() *Argon2idHasher {
+	return &Argon2idHasher{
+		Memory:      64 * 1024,
+		Iterations:  3,
+		Parallelism: 2,
+		SaltLength:  16,
+		KeyLength:   32,
+	}
+}
+
+// Hash implements PasswordHasher
+func (h *Argon2idHasher) Hash(password string) (string, error) {
+	salt := make([]byte, h.SaltLength)
+	if _, err := rand.Read(salt); err != nil {
+		return "", err
+	}
+
+	key := argon2.IDKey([]byte(password), salt, h.Iterations, h.Memory, h.Parallelism, h.KeyLength)
+
+	return fmt.Sprintf("$argon2id$v=%d$m=%d,t=%d,p=%d$%s$%s",
+		argon2.Version, h.Memory, h.Iterations, h.Parallelism,
+		base64.RawStdEncoding.EncodeToString(salt),
+		base64.RawStdEncoding.EncodeToString(key)), nil
+}
+
+// Verify implements PasswordHasher
+func (h *Argon2idHasher) Verify(password, encoded string) (bool, bool, error) {
+	parts := strings.Split(encoded, "$")
+	if len(parts) != 6 || parts[1] != "argon2id" {
+		return false, false, ErrInvalidPasswordHash
+	}
+
+	var version int
+	if _, err := fmt.Sscanf(parts[2], "v=%d", &version); err != nil || version != argon2.Version {
+		return false, false, ErrInvalidPasswordHash
+	}
+
+	var memory, iterations uint32
+	var parallelism uint8
+	if _, err := fmt.Sscanf(parts[3], "m=%d,t=%d,p=%d", &memory, &iterations, &parallelism); err != nil {
+		return false, false, ErrInvalidPasswordHash
+	}
+
+	salt, err := base64.RawStdEncoding.DecodeString(parts[4])
+	if err != nil {
+		return false, false, ErrInvalidPasswordHash
+	}
+
+	key, err := base64.RawStdEncoding.DecodeString(parts[5])
+	if err != nil {
+		return false, false, ErrInvalidPasswordHash
+	}
+
+	if !validArgon2Params(memory, iterations, parallelism, len(salt), len(key)) {
+		return false, false, ErrInvalidPasswordHash
+	}
+
+	computed := argon2.IDKey([]byte(password), salt, iterations, memory, parallelism, uint32(len(key)))
+	if subtle.ConstantTimeCompare(key, computed) != 1 {
+		return false, false, nil
+	}
+
+	needsRehash := memory != h.Memory || iterations != h.Iterations || parallelism != h.Parallelism ||
+		uint32(len(salt)) != h.SaltLength || uint32(len(key)) != h.KeyLength
+
+	return true, needsRehash, nil
+}
+
+// Bounds for argon2id parameters read from stored hashes
+const (
+	argon2MaxMemory     = 4 * 1024 * 1024 // KiB
+	argon2MaxIterations = 64
+	argon2MinSalt       = 8
+	argon2MinKey        = 16
+	argon2MaxKey        = 1024
+)
+
+// validArgon2Params rejects parameters that would panic in argon2, match
+// any password with an empty key or take unbounded time and memory
+func validArgon2Params(memory, iterations uint32, parallelism uint8, saltLength, keyLength int) bool {
+	return parallelism > 0 &&
+		memory >= 8*uint32(parallelism) && memory <= argon2MaxMemory &&
+		iterations > 0 && iterations <= argon2MaxIterations &&
+		saltLength >= argon2MinSalt &&
+		keyLength >= argon2MinKey && keyLength <= argon2MaxKey
+}
+
+// Supports implements PasswordHasher
+func (h *Argon2idHasher) Supports(encoded string) bool {
+	return strings.HasPrefix(encoded, "$argon2id$")
+}
+
+// bcryptMaxBytes is the longest password bcrypt accepts
+const bcryptMaxBytes = 72
+
+// BcryptHasher hashes passwords with bcrypt
+type BcryptHasher struct {
+	Cost int
+}
+
+// NewBcryptHasher creates a bcrypt hasher with the given cost
+func NewBcryptHasher(cost int) *BcryptHasher {
+	if cost == 0 {
+		cost = bcrypt.DefaultCost
+	}
+	return &BcryptHasher{Cost: cost}
+}
+
+// Hash implements PasswordHasher
+func (h *BcryptHasher) Hash(password string) (string, error) {
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), h.Cost)
+	if err != nil {
+		return "", err
+	}
+	return string(hash), nil
+}
+
+// Verify implements PasswordHasher
+func (h *BcryptHasher) Verify(password, encoded string) (bool, bool, error) {
+	err := bcrypt.CompareHashAndPassword([]byte(encoded), []byte(password))
+	if err == bcrypt.ErrMismatchedHashAndPassword {
+		return false, false, nil
+	}
+	if err != nil {
+		return false, false, ErrInvalidPasswordHash
+	}
+
+	cost, err := bcrypt.Cost([]byte(encoded))
+	if err != nil {
+		return false, false, ErrInvalidPasswordHash
+	}
+
+	return true, cost != h.Cost, nil
+}
+
+// Supports implements PasswordHasher
+func (h *BcryptHasher) Supports(encoded string) bool {
+	return strings.HasPrefix(encoded, "$2a$") || strings.HasPrefix(encoded, "$2b$") || strings.HasPrefix(encoded, "$2y$")
+}
+
+// PasswordPolicy defines password strength requirements.
+// Lengths count characters, MaxBytes limits the UTF-8 encoded length.
+type PasswordPolicy struct {
+	MinLength      int
+	MaxLength      int
+	MaxBytes       int
+	RequireUpper   bool
+	RequireLower   bool
+	RequireDigit   bool
+	RequireSpecial bool
+}
+
+// DefaultPasswordPolicy returns the default password policy
+func DefaultPasswordPolicy() PasswordPolicy {
+	return PasswordPolicy{
+		MinLength:    12,
+		MaxLength:    128,
+		RequireUpper: true,
+		RequireLower: true,
+		RequireDigit: true,
+	}
+}
+
+// Validate checks a password against the policy
+func (p PasswordPolicy) Validate(password string) error {
+	var problems []string
+
+	length := len([]rune(password))
+	if p.MinLength > 0 && length < p.MinLength {
+		problems = append(problems, fmt.Sprintf("at least %d characters", p.MinLength))
+	}
+	if p.MaxLength > 0 && length > p.MaxLength {
+		problems = append(problems, fmt.Sprintf("at most %d characters", p.MaxLength))
+	}
+	if p.MaxBytes > 0 && len(password) > p.MaxBytes {
+		problems = append(problems, fmt.Sprintf("at most %d bytes", p.MaxBytes))
+	}
+
+	var upper, lower, digit, special bool
+	for _, r := range password {
+		switch {
+		case unicode.IsUpper(r):
+			upper = true
+		case unicode.IsLower(r):
+			lower = true
+		case unicode.IsDigit(r):
+			digit = true
+		case unicode.IsPunct(r) || unicode.IsSymbol(r) || unicode.IsSpace(r):
+			special = true
+		}
+	}
+
+	if p.RequireUpper && !upper {
+		problems = append(problems, "an uppercase letter")
+	}
+	if p.RequireLower && !lower {
+		problems = append(problems, "a lowercase letter")
+	}
+	if p.RequireDigit && !digit {
+		problems = append(problems, "a digit")
+	}
+	if p.RequireSpecial && !special {
+		problems = append(problems, "a special character")
+	}
+
+	if len(problems) > 0 {
+		return fiber.NewError(fiber.StatusBadRequest, "password must contain "+strings.Join(problems, ", "))
+	}
+
+	return nil
+}
+
+// RegisterPasswordPolicy registers the policy as the "password" validation tag
+func RegisterPasswordPolicy(policy PasswordPolicy) error {
+	return validate.RegisterValidation("password", func(fl validator.FieldLevel) bool {
+		return policy.Validate(fl.Field().String()) == nil
+	})
+}
+
+func init() {
+	// RegisterValidation only fails for an empty tag or a nil function
+	_ = RegisterPasswordPolicy(DefaultPasswordPolicy())
+}
+
+// Credential represents the stored login credentials of a user
+type Credential struct {
+	UserID       string
+	Identifier   string
+	PasswordHash string
+	User         interface{}
+}
+
+// CredentialStore defines the interface for credential persistence
+type CredentialStore interface {
+	FindByIdentifier(identifier string) (*Credential, error)
+	FindByUserID(userID string) (*Credential, error)
+	UpdatePasswordHash(userID, hash string) error
+}
+
+// MemoryCredentialStore is an in-memory credential store
+type MemoryCredentialStore struct {
+	credentials map[string]*Credential
+	mu          sync.RWMutex
+}
+
+// NewMemoryCredentialStore creates a new in-memory credential store
+func NewMemoryCredentialStore() *MemoryCredentialStore {
+	return &MemoryCredentialStore{
+		credentials: make(map[string]*Credential),
+	}
+}
+
+// Create adds a credential to the store
+func (s *MemoryCredentialStore) Create(credential Credential) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, c := range s.credentials {
+		if c.Identifier == credential.Identifier {
+			return fmt.Errorf("identifier %s already registered", credential.Identifier)
+		}
+	}
+
+	s.credentials[credential.UserID] = &credential
+	return nil
+}
+
+// FindByIdentifier implements CredentialStore
+func (s *MemoryCredentialStore) FindByIdentifier(identifier string) (*Credential, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	for _, c := range s.credentials {
+		if c.Identifier == identifier {
+			credential := *c
+			return &credential, nil
+		}
+	}
+
+	return nil, ErrCredentialNotFound
+}
+
+// FindByUserID implements CredentialStore
+func (s *MemoryCredentialStore) FindByUserID(userID string) (*Credential, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	c, exists := s.credentials[userID]
+	if !exists {
+		return nil, ErrCredentialNotFound
+	}
+
+	credential := *c
+	return &credential, nil
+}
+
+// UpdatePasswordHash implements CredentialStore
+func (s *MemoryCredentialStore) UpdatePasswordHash(userID, hash string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	c, exists := s.credentials[userID]
+	if !exists {
+		return ErrCredentialNotFound
+	}
+
+	c.PasswordHash = hash
+	return nil
+}
+
+// CredentialOptions defines credential service configuration
+type CredentialOptions struct {
+	// Hasher is used for new hashes, Legacy hashers are only used to verify
+	Hasher PasswordHasher
+	Legacy []PasswordHasher
+	Policy PasswordPolicy
+
+	MaxAttempts     int
+	AttemptWindow   time.Duration
+	LockoutDuration time.Duration
+
+	ResetTokenTTL time.Duration
+}
+
+// CredentialService manages password hashing, login throttling and resets
+type CredentialService struct {
+	store   CredentialStore
+	cache   CacheStore
+	options CredentialOptions
+	// mu serializes counters on caches that are not a CacheCounter
+	mu sync.Mutex
+}
+
+// NewCredentialService creates a new credential service
+func NewCredentialService(store CredentialStore, cache CacheStore, options CredentialOptions) *CredentialService {
+	if options.Hasher == nil {
+		options.Hasher = NewArgon2idHasher()
+	}
+	if options.Policy == (PasswordPolicy{}) {
+		options.Policy = DefaultPasswordPolicy()
+	}
+	// bcrypt rejects passwords longer than 72 bytes
+	if _, ok := options.Hasher.(*BcryptHasher); ok && (options.Policy.MaxBytes == 0 || options.Policy.MaxBytes > bcryptMaxBytes) {
+		options.Policy.MaxBytes = bcryptMaxBytes
+	}
+	if options.MaxAttempts == 0 {
+		options.MaxAttempts = 5
+	}
+	if options.AttemptWindow == 0 {
+		options.AttemptWindow = 15 * time.Minute
+	}
+	if options.LockoutDuration == 0 {
+		options.LockoutDuration = 15 * time.Minute
+	}
+	if options.ResetTokenTTL == 0 {
+		options.ResetTokenTTL = time.Hour
+	}
+
+	return &CredentialService{
+		store:   store,
+		cache:   cache,
+		options: options,
+	}
+}
+
+// HashPassword validates a password against the policy and hashes it
+func (s *CredentialService) HashPassword(password string) (string, error) {
+	if err := s.options.Policy.Validate(password); err != nil {
+		return "", err
+	}
+	return s.options.Hasher.Hash(password)
+}
+
+// Login verifies credentials, applies throttling and upgrades outdated hashes
+func (s *CredentialService) Login(identifier, password string) (*Credential, error) {
+	if s.isLocked(identifier) {
+		return nil, ErrAccountLocked
+	}
+
+	credential, err := s.store.FindByIdentifier(identifier)
+	if err == ErrCredentialNotFound {
+		// Hash anyway so unknown identifiers take as long as wrong passwords
+		s.options.Hasher.Hash(password)
+		s.recordFailure(identifier)
+		return nil, ErrInvalidCredentials
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	hasher, upgrade := s.hasherFor(credential.PasswordHash)
+	if hasher == nil {
+		return nil, ErrInvalidPasswordHash
+	}
+
+	match, needsRehash, err := hasher.Verify(password, credential.PasswordHash)
+	if err != nil {
+		return nil, err
+	}
+	if !match {
+		s.recordFailure(identifier)
+		return nil, ErrInvalidCredentials
+	}
+
+	s.cache.Delete(loginAttemptsKey(identifier))
+
+	if upgrade || needsRehash {
+		if hash, err := s.options.Hasher.Hash(password); err == nil {
+			if err := s.store.UpdatePasswordHash(credential.UserID, hash); err == nil {
+				credential.PasswordHash = hash
+			}
+		}
+	}
+
+	return credential, nil
+}
+
+// ChangePassword verifies the current password and sets a new one. Wrong
+// passwords count towards the same lockout as failed logins.
+func (s *CredentialService) ChangePassword(userID, current, next string) error {
+	credential, err := s.store.FindByUserID(userID)
+	if err != nil {
+		return err
+	}
+	if s.isLocked(credential.Identifier) {
+		return ErrAccountLocked
+	}
+
+	hasher, _ := s.hasherFor(credential.PasswordHash)
+	if hasher == nil {
+		return ErrInvalidPasswordHash
+	}
+
+	match, _, err := hasher.Verify(current, credential.PasswordHash)
+	if err != nil {
+		return err
+	}
+	if !match {
+		s.recordFailure(credential.Identifier)
+		return ErrInvalidCredentials
+	}
+	s.cache.Delete(loginAttemptsKey(credential.Identifier))
+
+	hash, err := s.HashPassword(next)
+	if err != nil {
+		return err
+	}
+
+	return s.store.UpdatePasswordHash(userID, hash)
+}
+
+// CreateResetToken creates a single-use password reset token.
+// Only a hash of the token is kept, the raw token must be sent to the user.
+// Unknown identifiers return an empty token and no error, so callers can
+// respond the same way whether or not the account exists.
+func (s *CredentialService) CreateResetToken(identifier string) (string, error) {
+	credential, err := s.store.FindByIdentifier(identifier)
+	if err == ErrCredentialNotFound {
+		return "", nil
+	}
+	if err != nil {
+		return "", err
+	}
+
+	buf := make([]byte, 32)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+	token := base64.RawURLEncoding.EncodeToString(buf)
+
+	if err := s.cache.Set(resetTokenKey(token), credential.UserID, s.options.ResetTokenTTL); err != nil {
+		return "", err
+	}
+	return token, nil
+}
+
+// ResetPassword consumes a reset token and sets a new password. The token
+// is consumed before the password changes, so concurrent resets with the
+// same token cannot both succeed.
+func (s *CredentialService) ResetPassword(token, password string) error {
+	// Check the policy first so a rejected password does not burn the token
+	hash, err := s.HashPassword(password)
+	if err != nil {
+		return err
+	}
+
+	userID, err := s.consumeResetToken(token)
+	if err != nil {
+		return err
+	}
+
+	if err := s.store.UpdatePasswordHash(userID, hash); err != nil {
+		return err
+	}
+
+	if credential, err := s.store.FindByUserID(userID); err == nil {
+		s.Unlock(credential.Identifier)
+	}
+
+	return nil
+}
+
+// Unlock clears failed login attempts and any lockout for an identifier
+func (s *CredentialService) Unlock(identifier string) {
+	s.cache.Delete(loginAttemptsKey(identifier))
+	s.cache.Delete(loginLockKey(identifier))
+}
+
+func (s *CredentialService) hasherFor(encoded string) (PasswordHasher, bool) {
+	if s.options.Hasher.Supports(encoded) {
+		return s.options.Hasher, false
+	}
+	for _, hasher := range s.options.Legacy {
+		if hasher.Supports(encoded) {
+			return hasher, true
+		}
+	}
+	return nil, false
+}
+
+func (s *CredentialService) isLocked(identifier string) bool {
+	var locked bool
+	found, err := s.cache.Get(loginLockKey(identifier), &locked)
+	return err == nil && found && locked
+}
+
+func (s *CredentialService) recordFailure(identifier string) {
+	key := loginAttemptsKey(identifier)
+
+	attempts, err := s.increment(key, s.options.AttemptWindow)
+	if err != nil || attempts < int64(s.options.MaxAttempts) {
+		return
+	}
+
+	s.cache.Set(loginLockKey(identifier), true, s.options.LockoutDuration)
+	s.cache.Delete(key)
+}
+
+// consumeResetToken claims a reset token once and returns its user ID
+func (s *CredentialService) consumeResetToken(token string) (string, error) {
+	key := resetTokenKey(token)
+
+	var userID string
+	found, err := s.cache.Get(key, &userID)
+	if err != nil {
+		return "", err
+	}
+	if !found {
+		return "", ErrInvalidResetToken
+	}
+
+	claims, err := s.increment(key+":claim", s.options.ResetTokenTTL)
+	if err != nil {
+		return "", err
+	}
+	if claims != 1 {
+		return "", ErrInvalidResetToken
+	}
+
+	if err := s.cache.Delete(key); err != nil {
+		return "", err
+	}
+	return userID, nil
+}
+
+// increment atomically increments a counter, falling back to a process-local
+// lock for caches that cannot
+func (s *CredentialService) increment(key string, ttl time.Duration) (int64, error) {
+	if counter, ok := s.cache.(CacheCounter); ok {
+		return counter.Increment(key, ttl)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var value int64
+	if _, err := s.cache.Get(key, &value); err != nil {
+		return 0, err
+	}
+	value++
+	return value, s.cache.Set(key, value, ttl)
+}
+
+func loginAttemptsKey(identifier string) string {
+	return "login:attempts:" + identifier
+}
+
+func loginLockKey(identifier string) string {
+	return "login:lock:" + identifier
+}
+
+func resetTokenKey(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return "password:reset:" + hex.EncodeToString(sum[:])
+}
+
+// LoginRequest represents a login request body
+type LoginRequest struct {
+	Identifier string `json:"identifier" validate:"required"`
+	Password   string `json:"password" validate:"required"`
+}
+
+// CredentialsProvider authenticates requests with an identifier and password
+type CredentialsProvider struct {
+	Service *CredentialService
+}
+
+// NewCredentialsProvider creates a new credentials provider
+func NewCredentialsProvider(service *CredentialService) *CredentialsProvider {
+	return &CredentialsProvider{Service: service}
+}
+
+// Authenticate implements AuthProvider using HTTP basic auth or a JSON body
+func (p *CredentialsProvider) Authenticate(ctx *fiber.Ctx) (interface{}, error) {
+	var request LoginRequest
+
+	if auth := ctx.Get(fiber.HeaderAuthorization); strings.HasPrefix(auth, "Basic ") {
+		raw, err := base64.StdEncoding.DecodeString(strings.TrimPrefix(auth, "Basic "))
+		if err != nil {
+			return nil, ErrInvalidCredentials
+		}
+		identifier, password, ok := strings.Cut(string(raw), ":")
+		if !ok {
+			return nil, ErrInvalidCredentials
+		}
+		request = LoginRequest{Identifier: identifier, Password: password}
+	} else if err := ctx.BodyParser(&request); err != nil {
+		return nil, fiber.NewError(fiber.StatusBadRequest, err.Error())
+	}
+
+	if err := Validate(request); err != nil {
+		return nil, fiber.NewError(fiber.StatusBadRequest, err.Error())
+	}
+
+	credential, err := p.Service.Login(request.Identifier, request.Password)
+	if err != nil {
+		return nil, err
+	}
+
+	if credential.User != nil {
+		return credential.User, nil
+	}
+	return credential.UserID, nil
+}
+
+// Credential errors
+var (
+	ErrInvalidCredentials  = fiber.NewError(fiber.StatusUnauthorized, "invalid credentials")
+	ErrAccountLocked       = fiber.NewError(fiber.StatusTooManyRequests, "too many failed login attempts")
+	ErrCredentialNotFound  = fiber.NewError(fiber.StatusNotFound, "credential not found")
+	ErrInvalidPasswordHash = fiber.NewError(fiber.StatusInternalServerError, "invalid password hash")
+	ErrInvalidResetToken   = fiber.NewError(fiber.StatusBadRequest, "invalid or expired reset token")
+)
@@ -0,0 +1,190 @@
+package core
+
+import (
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func newTestCredentials(t *testing.T, cache CacheStore, options CredentialOptions) (*CredentialService, *MemoryCredentialStore) {
+	t.Helper()
+
+	if options.Hasher == nil {
+		options.Hasher = &Argon2idHasher{Memory: 1024, Iterations: 1, Parallelism: 1, SaltLength: 16, KeyLength: 32}
+	}
+	store := NewMemoryCredentialStore()
+	service := NewCredentialService(store, cache, options)
+
+	hash, err := service.HashPassword("Correct-horse-1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := store.Create(Credential{UserID: "u1", Identifier: "jane", PasswordHash: hash}); err != nil {
+		t.Fatal(err)
+	}
+	return service, store
+}
+
+// counterlessStore hides the CacheCounter implementation of a store
+type counterlessStore struct {
+	CacheStore
+}
+
+func TestCredentialLockout(t *testing.T) {
+	stores := map[string]CacheStore{
+		"counter":  NewMemoryCacheStore(nil, nil),
+		"fallback": counterlessStore{NewMemoryCacheStore(nil, nil)},
+	}
+	for name, cache := range stores {
+		t.Run(name, func(t *testing.T) {
+			service, _ := newTestCredentials(t, cache, CredentialOptions{MaxAttempts: 5})
+
+			// Concurrent failures must all be counted
+			var wg sync.WaitGroup
+			for i := 0; i < 5; i++ {
+				wg.Add(1)
+				go func() {
+					defer wg.Done()
+					service.Login("jane", "wrong")
+				}()
+			}
+			wg.Wait()
+
+			if _, err := service.Login("jane", "Correct-horse-1"); err != ErrAccountLocked {
+				t.Fatalf("Login after 5 failures = %v, want ErrAccountLocked", err)
+			}
+
+			service.Unlock("jane")
+			if _, err := service.Login("jane", "Correct-horse-1"); err != nil {
+				t.Fatalf("Login after Unlock = %v", err)
+			}
+		})
+	}
+}
+
+func TestMemoryCacheStoreIncrementKeepsWindow(t *testing.T) {
+	store := NewMemoryCacheStore(nil, nil)
+
+	for want := int64(1); want <= 3; want++ {
+		got, err := store.Increment("n", 50*time.Millisecond)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got != want {
+			t.Fatalf("Increment = %d, want %d", got, want)
+		}
+	}
+
+	time.Sleep(60 * time.Millisecond)
+	if got, _ := store.Increment("n", 50*time.Millisecond); got != 1 {
+		t.Fatalf("Increment after window = %d, want 1", got)
+	}
+}
+
+func TestResetPasswordIsSingleUse(t *testing.T) {
+	service, store := newTestCredentials(t, NewMemoryCacheStore(nil, nil), CredentialOptions{})
+
+	token, err := service.CreateResetToken("jane")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// A password rejected by the policy leaves the token usable
+	if err := service.ResetPassword(token, "short"); err == nil {
+		t.Fatal("ResetPassword accepted a weak password")
+	}
+
+	var succeeded int32
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if service.ResetPassword(token, "Another-horse-2") == nil {
+				atomic.AddInt32(&succeeded, 1)
+			}
+		}()
+	}
+	wg.Wait()
+
+	if succeeded != 1 {
+		t.Fatalf("%d resets succeeded with one token, want 1", succeeded)
+	}
+	if err := service.ResetPassword(token, "Third-horse-3"); err != ErrInvalidResetToken {
+		t.Fatalf("reused token = %v, want ErrInvalidResetToken", err)
+	}
+
+	credential, _ := store.FindByUserID("u1")
+	if match, _, _ := service.options.Hasher.Verify("Another-horse-2", credential.PasswordHash); !match {
+		t.Fatal("password was not reset")
+	}
+}
+
+func TestCreateResetTokenHidesUnknownAccounts(t *testing.T) {
+	service, _ := newTestCredentials(t, NewMemoryCacheStore(nil, nil), CredentialOptions{})
+
+	token, err := service.CreateResetToken("nobody")
+	if err != nil || token != "" {
+		t.Fatalf("CreateResetToken for an unknown identifier = %q, %v, want no token and no error", token, err)
+	}
+	if token, err := service.CreateResetToken("jane"); err != nil || token == "" {
+		t.Fatalf("CreateResetToken = %q, %v", token, err)
+	}
+}
+
+func TestChangePasswordLockout(t *testing.T) {
+	service, _ := newTestCredentials(t, NewMemoryCacheStore(nil, nil), CredentialOptions{MaxAttempts: 3})
+
+	for i := 0; i < 3; i++ {
+		if err := service.ChangePassword("u1", "wrong", "Another-horse-2"); err != ErrInvalidCredentials {
+			t.Fatalf("attempt %d = %v, want ErrInvalidCredentials", i, err)
+		}
+	}
+	if err := service.ChangePassword("u1", "Correct-horse-1", "Another-horse-2"); err != ErrAccountLocked {
+		t.Fatalf("ChangePassword while locked = %v, want ErrAccountLocked", err)
+	}
+	if _, err := service.Login("jane", "Correct-horse-1"); err != ErrAccountLocked {
+		t.Fatalf("Login while locked = %v, want ErrAccountLocked", err)
+	}
+
+	service.Unlock("jane")
+	if err := service.ChangePassword("u1", "Correct-horse-1", "Another-horse-2"); err != nil {
+		t.Fatalf("ChangePassword after Unlock = %v", err)
+	}
+}
+
+func TestArgon2idRejectsInvalidParameters(t *testing.T) {
+	hasher := NewArgon2idHasher()
+	salt := "c2FsdHNhbHRzYWx0c2FsdA"
+	key := "a2V5a2V5a2V5a2V5a2V5a2V5a2V5a2V5a2V5a2U"
+
+	for _, encoded := range []string{
+		"$argon2id$v=19$m=1024,t=1,p=0$" + salt + "$" + key,
+		"$argon2id$v=19$m=1024,t=0,p=1$" + salt + "$" + key,
+		"$argon2id$v=19$m=0,t=1,p=1$" + salt + "$" + key,
+		"$argon2id$v=19$m=4294967295,t=1,p=1$" + salt + "$" + key,
+		"$argon2id$v=19$m=1024,t=1000000,p=1$" + salt + "$" + key,
+		// An empty key would match every password
+		"$argon2id$v=19$m=1024,t=1,p=1$" + salt + "$",
+		"$argon2id$v=19$m=1024,t=1,p=1$$" + key,
+	} {
+		if match, _, err := hasher.Verify("password", encoded); match || err != ErrInvalidPasswordHash {
+			t.Errorf("Verify(%q) = %v, %v, want ErrInvalidPasswordHash", encoded, match, err)
+		}
+	}
+}
+
+func TestBcryptCapsPasswordBytes(t *testing.T) {
+	service, _ := newTestCredentials(t, NewMemoryCacheStore(nil, nil), CredentialOptions{Hasher: NewBcryptHasher(4)})
+
+	if service.options.Policy.MaxBytes != 72 {
+		t.Fatalf("MaxBytes = %d, want 72", service.options.Policy.MaxBytes)
+	}
+	// 70 characters but 73 bytes
+	password := "Aa1" + strings.Repeat("é", 3) + strings.Repeat("x", 64)
+	if _, err := service.HashPassword(password); err == nil {
+		t.Fatal("HashPassword accepted a password longer than 72 bytes")
+	}
+}
@@ -4,6 +4,8 @@ import (
 	"context"
 	"database/sql"
 	"fmt"
+	"strconv"
+	"strings"
 	"sync"
 	"time"
 
@@ -61,6 +63,11 @@ func (p *MySQLProvider) GetDB() *sql.DB {
 	return p.db
 }
 
+// WithTransaction runs fn in a transaction that is committed when fn succeeds
+func (p *MySQLProvider) WithTransaction(ctx context.Context, fn func(tx *sql.Tx) error) error {
+	return RunInTransaction(ctx, p.db, fn)
+}
+
 // PostgreSQLProvider implements PostgreSQL database provider
 type PostgreSQLProvider struct {
 	Host     string
@@ -105,6 +112,11 @@ func (p *PostgreSQLProvider) GetDB() *sql.DB {
 	return p.db
 }
 
+// WithTransaction runs fn in a transaction that is committed when fn succeeds
+func (p *PostgreSQLProvider) WithTransaction(ctx context.Context, fn func(tx *sql.Tx) error) error {
+	return RunInTransaction(ctx, p.db, fn)
+}
+
 // MongoDBProvider implements MongoDB database provider
 type MongoDBProvider struct {
 	URI      string
@@ -172,6 +184,59 @@ func (p *MongoDBProvider) GetCollection(name string) *mongo.Collection {
 	return p.db.Collection(name)
 }
 
+// WithTransaction runs fn in a session transaction. Operations inside fn must
+// use the session context to take part in the transaction, which requires a
+// replica set or sharded cluster.
+func (p *MongoDBProvider) WithTransaction(ctx context.Context, fn func(sessCtx mongo.SessionContext) error) error {
+	p.mu.RLock()
+	client := p.client
+	p.mu.RUnlock()
+
+	if client == nil {
+		return fmt.Errorf("MongoDB is not connected")
+	}
+
+	session, err := client.StartSession()
+	if err != nil {
+		return err
+	}
+	defer session.EndSession(ctx)
+
+	_, err = session.WithTransaction(ctx, func(sessCtx mongo.SessionContext) (interface{}, error) {
+		return nil, fn(sessCtx)
+	})
+	return err
+}
+
+// RunInTransaction runs fn in a SQL transaction, rolling back on error or panic.
+// When ctx already carries a transaction (see ContextWithTx), fn joins it
+// and the owner of that transaction commits or rolls back.
+func RunInTransaction(ctx context.Context, db *sql.DB, fn func(tx *sql.Tx) error) (err error) {
+	if tx, ok := TxFromContext(ctx); ok {
+		return fn(tx)
+	}
+
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+
+	defer func() {
+		if r := recover(); r != nil {
+			tx.Rollback()
+			panic(r)
+		}
+		if err != nil {
+			tx.Rollback()
+		}
+	}()
+
+	if err = fn(tx); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
 // DatabaseManager manages database connections
 type DatabaseManager struct {
 	providers map[string]DatabaseProvider
@@ -224,4 +289,71 @@ func (m *DatabaseManager) DisconnectAll() error {
 	}
 
 	return nil
-} 
\ No newline at end of file
+}
+
+type txContextKey struct{}
+
+// ContextWithTx returns a context carrying a SQL transaction
+func ContextWithTx(ctx context.Context, tx *sql.Tx) context.Context {
+	return context.WithValue(ctx, txContextKey{}, tx)
+}
+
+// TxFromContext returns the SQL transaction carried by a context
+func TxFromContext(ctx context.Context) (*sql.Tx, bool) {
+	tx, ok := ctx.Value(txContextKey{}).(*sql.Tx)
+	return tx, ok
+}
+
+// SQLDialect describes the differences between SQL databases used by the
+// framework's SQL backed stores
+type SQLDialect int
+
+const (
+	SQLDialectPostgres SQLDialect = iota
+	SQLDialectMySQL
+	SQLDialectSQLite
+)
+
+// Rebind rewrites "?" placeholders into the dialect's placeholder style
+func (d SQLDialect) Rebind(query string) string {
+	if d != SQLDialectPostgres {
+		return query
+	}
+
+	var b strings.Builder
+	n := 0
+	for _, r := range query {
+		if r == '?' {
+			n++
+			b.WriteString("$")
+			b.WriteString(strconv.Itoa(n))
+			continue
+		}
+		b.WriteRune(r)
+	}
+	return b.String()
+}
+
+// BlobType returns the column type used for binary payloads
+func (d SQLDialect) BlobType() string {
+	switch d {
+	case SQLDialectPostgres:
+		return "BYTEA"
+	case SQLDialectMySQL:
+		return "LONGBLOB"
+	default:
+		return "BLOB"
+	}
+}
+
+// SerialPrimaryKey returns the column definition of an auto-incrementing primary key
+func (d SQLDialect) SerialPrimaryKey() string {
+	switch d {
+	case SQLDialectPostgres:
+		return "BIGSERIAL PRIMARY KEY"
+	case SQLDialectMySQL:
+		return "BIGINT AUTO_INCREMENT PRIMARY KEY"
+	default:
+		return "INTEGER PRIMARY KEY AUTOINCREMENT"
+	}
+}
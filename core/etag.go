@@ -0,0 +1,212 @@
+package core
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// ETagOptions defines ETag middleware configuration
+type ETagOptions struct {
+	// Weak generates weak ETags (W/"...") from response bodies
+	Weak bool
+}
+
+// ETagMiddleware creates a middleware that adds ETags to GET and HEAD responses
+// and answers fresh conditional requests with 304 Not Modified.
+// Register it before CacheMiddleware so cached responses are revalidated too.
+func ETagMiddleware(options ...ETagOptions) fiber.Handler {
+	interceptor := ETagInterceptor(options...)
+	return func(ctx *fiber.Ctx) error {
+		return interceptor(ctx, func(c *fiber.Ctx) error {
+			return c.Next()
+		})
+	}
+}
+
+// ETagInterceptor is the interceptor form of ETagMiddleware
+func ETagInterceptor(options ...ETagOptions) Interceptor {
+	var opts ETagOptions
+	if len(options) > 0 {
+		opts = options[0]
+	}
+
+	return func(ctx *fiber.Ctx, next fiber.Handler) error {
+		if err := next(ctx); err != nil {
+			return err
+		}
+
+		method := ctx.Method()
+		if method != fiber.MethodGet && method != fiber.MethodHead {
+			return nil
+		}
+
+		response := ctx.Response()
+		if response.StatusCode() != fiber.StatusOK {
+			return nil
+		}
+
+		etag := string(response.Header.Peek(fiber.HeaderETag))
+		if etag == "" {
+			body := response.Body()
+			if len(body) == 0 {
+				return nil
+			}
+			etag = GenerateETag(body, opts.Weak)
+			ctx.Set(fiber.HeaderETag, etag)
+		}
+
+		if isNotModified(ctx, etag) {
+			response.ResetBody()
+			ctx.Status(fiber.StatusNotModified)
+		}
+
+		return nil
+	}
+}
+
+// GenerateETag builds an ETag from a response body
+func GenerateETag(body []byte, weak bool) string {
+	sum := sha256.Sum256(body)
+	return formatETag(hex.EncodeToString(sum[:16]), weak)
+}
+
+// SetETag sets an ETag derived from an entity version, such as a revision
+// number or an updated-at timestamp, instead of hashing the response body
+func SetETag(ctx *fiber.Ctx, version string, weak bool) {
+	ctx.Set(fiber.HeaderETag, formatETag(version, weak))
+}
+
+// SetLastModified sets the Last-Modified header used for If-Modified-Since
+func SetLastModified(ctx *fiber.Ctx, t time.Time) {
+	ctx.Set(fiber.HeaderLastModified, t.UTC().Format(http.TimeFormat))
+}
+
+// CheckPreconditions evaluates If-Match, If-None-Match and If-Unmodified-Since
+// against the current version of an entity. Call it in PUT, PATCH and DELETE
+// handlers after loading the entity and before changing it.
+// An empty etag or zero lastModified skips the corresponding checks.
+func CheckPreconditions(ctx *fiber.Ctx, etag string, lastModified time.Time) error {
+	if etag != "" && !strings.HasPrefix(etag, `"`) && !strings.HasPrefix(etag, `W/"`) {
+		etag = formatETag(etag, false)
+	}
+
+	if ifMatch := ctx.Get(fiber.HeaderIfMatch); ifMatch != "" {
+		// If-Match uses the strong comparison, weak ETags never match
+		if etag == "" || !matchETag(ifMatch, etag, false) {
+			return ErrPreconditionFailed
+		}
+	} else if ius := ctx.Get(fiber.HeaderIfUnmodifiedSince); ius != "" && !lastModified.IsZero() {
+		if t, err := http.ParseTime(ius); err == nil && lastModified.Truncate(time.Second).After(t) {
+			return ErrPreconditionFailed
+		}
+	}
+
+	if ifNoneMatch := ctx.Get(fiber.HeaderIfNoneMatch); ifNoneMatch != "" && etag != "" {
+		if matchETag(ifNoneMatch, etag, true) {
+			return ErrPreconditionFailed
+		}
+	}
+
+	return nil
+}
+
+// PreconditionResolver returns the current ETag and modification time of the
+// entity addressed by a request
+type PreconditionResolver func(ctx *fiber.Ctx) (etag string, lastModified time.Time, err error)
+
+// PreconditionMiddleware checks preconditions of PUT, PATCH and DELETE
+// requests before the handler runs and rejects stale writes with 412
+func PreconditionMiddleware(resolve PreconditionResolver) fiber.Handler {
+	return func(ctx *fiber.Ctx) error {
+		switch ctx.Method() {
+		case fiber.MethodPut, fiber.MethodPatch, fiber.MethodDelete:
+		default:
+			return ctx.Next()
+		}
+
+		if ctx.Get(fiber.HeaderIfMatch) == "" && ctx.Get(fiber.HeaderIfNoneMatch) == "" &&
+			ctx.Get(fiber.HeaderIfUnmodifiedSince) == "" {
+			return ctx.Next()
+		}
+
+		etag, lastModified, err := resolve(ctx)
+		if err != nil {
+			return err
+		}
+
+		if err := CheckPreconditions(ctx, etag, lastModified); err != nil {
+			return ctx.Status(fiber.StatusPreconditionFailed).JSON(fiber.Map{
+				"error": err.Error(),
+			})
+		}
+
+		return ctx.Next()
+	}
+}
+
+// isNotModified reports whether the client's cached copy is still fresh.
+// If-None-Match takes precedence over If-Modified-Since.
+func isNotModified(ctx *fiber.Ctx, etag string) bool {
+	if ifNoneMatch := ctx.Get(fiber.HeaderIfNoneMatch); ifNoneMatch != "" {
+		return matchETag(ifNoneMatch, etag, true)
+	}
+
+	ims := ctx.Get(fiber.HeaderIfModifiedSince)
+	lastModified := string(ctx.Response().Header.Peek(fiber.HeaderLastModified))
+	if ims == "" || lastModified == "" {
+		return false
+	}
+
+	since, err := http.ParseTime(ims)
+	if err != nil {
+		return false
+	}
+	modified, err := http.ParseTime(lastModified)
+	if err != nil {
+		return false
+	}
+
+	return !modified.After(since)
+}
+
+// matchETag checks an If-Match or If-None-Match header value against an ETag
+func matchETag(header, etag string, weak bool) bool {
+	header = strings.TrimSpace(header)
+	if header == "*" {
+		return true
+	}
+
+	if !weak && strings.HasPrefix(etag, "W/") {
+		return false
+	}
+
+	for _, candidate := range strings.Split(header, ",") {
+		candidate = strings.TrimSpace(candidate)
+		if weak {
+			if strings.TrimPrefix(candidate, "W/") == strings.TrimPrefix(etag, "W/") {
+				return true
+			}
+		} else if candidate == etag {
+			return true
+		}
+	}
+
+	return false
+}
+
+func formatETag(value string, weak bool) string {
+	if weak {
+		return `W/"` + value + `"`
+	}
+	return `"` + value + `"`
+}
+
+// ETag errors
+var (
+	ErrPreconditionFailed = fiber.NewError(fiber.StatusPreconditionFailed, "precondition failed")
+)
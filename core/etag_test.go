@@ -0,0 +1,146 @@
+package core
+
+import (
+	"io"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+func etagTestRequest(t *testing.T, app *fiber.App, method, target string, headers map[string]string) (int, string, string) {
+	t.Helper()
+	req := httptest.NewRequest(method, target, nil)
+	for name, value := range headers {
+		req.Header.Set(name, value)
+	}
+	resp, err := app.Test(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, _ := io.ReadAll(resp.Body)
+	return resp.StatusCode, resp.Header.Get(fiber.HeaderETag), string(body)
+}
+
+func TestGenerateETag(t *testing.T) {
+	strong := GenerateETag([]byte("hello"), false)
+	weak := GenerateETag([]byte("hello"), true)
+
+	if !strings.HasPrefix(strong, `"`) || !strings.HasSuffix(strong, `"`) || len(strong) != 34 {
+		t.Errorf("unexpected strong ETag %s", strong)
+	}
+	if weak != "W/"+strong {
+		t.Errorf("weak ETag = %s, want W/%s", weak, strong)
+	}
+	if GenerateETag([]byte("hello"), false) != strong || GenerateETag([]byte("world"), false) == strong {
+		t.Error("expected ETags to depend only on the body")
+	}
+}
+
+func TestETagMiddlewareIfNoneMatch(t *testing.T) {
+	for name, weak := range map[string]bool{"strong": false, "weak": true} {
+		t.Run(name, func(t *testing.T) {
+			app := fiber.New()
+			app.Use(ETagMiddleware(ETagOptions{Weak: weak}))
+			app.Get("/", func(c *fiber.Ctx) error { return c.SendString("hello") })
+
+			status, etag, body := etagTestRequest(t, app, "GET", "/", nil)
+			if status != 200 || etag != GenerateETag([]byte("hello"), weak) || body != "hello" {
+				t.Fatalf("first request = %d %s %q", status, etag, body)
+			}
+
+			// If-None-Match uses the weak comparison, so W/ prefixes do not matter
+			for _, header := range []string{etag, "W/" + strings.TrimPrefix(etag, "W/"), `"other", ` + etag, "*"} {
+				if status, _, body := etagTestRequest(t, app, "GET", "/", map[string]string{fiber.HeaderIfNoneMatch: header}); status != 304 || body != "" {
+					t.Errorf("If-None-Match %s = %d %q, want 304", header, status, body)
+				}
+			}
+			if status, _, _ := etagTestRequest(t, app, "GET", "/", map[string]string{fiber.HeaderIfNoneMatch: `"other"`}); status != 200 {
+				t.Errorf("non-matching If-None-Match = %d, want 200", status)
+			}
+		})
+	}
+}
+
+func TestETagMiddlewareIfModifiedSince(t *testing.T) {
+	modified := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	app := fiber.New()
+	app.Use(ETagMiddleware())
+	app.Get("/", func(c *fiber.Ctx) error {
+		SetLastModified(c, modified)
+		return c.SendString("hello")
+	})
+
+	since := func(t time.Time) map[string]string {
+		return map[string]string{fiber.HeaderIfModifiedSince: t.Format("Mon, 02 Jan 2006 15:04:05 GMT")}
+	}
+	if status, _, _ := etagTestRequest(t, app, "GET", "/", since(modified)); status != 304 {
+		t.Errorf("If-Modified-Since at Last-Modified = %d, want 304", status)
+	}
+	if status, _, _ := etagTestRequest(t, app, "GET", "/", since(modified.Add(-time.Hour))); status != 200 {
+		t.Errorf("If-Modified-Since before Last-Modified = %d, want 200", status)
+	}
+	// If-None-Match takes precedence
+	headers := since(modified)
+	headers[fiber.HeaderIfNoneMatch] = `"other"`
+	if status, _, _ := etagTestRequest(t, app, "GET", "/", headers); status != 200 {
+		t.Errorf("non-matching If-None-Match with If-Modified-Since = %d, want 200", status)
+	}
+}
+
+func TestPreconditionMiddleware(t *testing.T) {
+	version := "3"
+	app := fiber.New()
+	app.Use(PreconditionMiddleware(func(c *fiber.Ctx) (string, time.Time, error) {
+		return version, time.Time{}, nil
+	}))
+	handler := func(c *fiber.Ctx) error { return c.SendStatus(fiber.StatusNoContent) }
+	app.Put("/", handler)
+	app.Patch("/", handler)
+	app.Delete("/", handler)
+
+	for _, method := range []string{"PUT", "PATCH", "DELETE"} {
+		if status, _, _ := etagTestRequest(t, app, method, "/", map[string]string{fiber.HeaderIfMatch: `"2"`}); status != 412 {
+			t.Errorf("%s with a stale If-Match = %d, want 412", method, status)
+		}
+		// If-Match uses the strong comparison
+		if status, _, _ := etagTestRequest(t, app, method, "/", map[string]string{fiber.HeaderIfMatch: `W/"3"`}); status != 412 {
+			t.Errorf("%s with a weak If-Match = %d, want 412", method, status)
+		}
+		for _, header := range []string{`"3"`, `"1", "3"`, "*"} {
+			if status, _, _ := etagTestRequest(t, app, method, "/", map[string]string{fiber.HeaderIfMatch: header}); status != 204 {
+				t.Errorf("%s with If-Match %s = %d, want 204", method, header, status)
+			}
+		}
+		if status, _, _ := etagTestRequest(t, app, method, "/", nil); status != 204 {
+			t.Errorf("%s without preconditions = %d, want 204", method, status)
+		}
+	}
+}
+
+func TestETagMiddlewareWithCache(t *testing.T) {
+	calls := 0
+	app := fiber.New()
+	app.Use(ETagMiddleware())
+	app.Use(CacheMiddleware(NewCache(), time.Minute))
+	app.Get("/", func(c *fiber.Ctx) error {
+		calls++
+		return c.SendString("hello")
+	})
+
+	_, etag, _ := etagTestRequest(t, app, "GET", "/", nil)
+	status, cachedETag, body := etagTestRequest(t, app, "GET", "/", nil)
+	if status != 200 || cachedETag != etag || body != "hello" {
+		t.Fatalf("cached response = %d %s %q", status, cachedETag, body)
+	}
+
+	// Cached responses are revalidated as well
+	if status, _, body := etagTestRequest(t, app, "GET", "/", map[string]string{fiber.HeaderIfNoneMatch: etag}); status != 304 || body != "" {
+		t.Fatalf("revalidated cached response = %d %q, want 304", status, body)
+	}
+	if calls != 1 {
+		t.Fatalf("handler called %d times, want 1", calls)
+	}
+}
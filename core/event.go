@@ -1,9 +1,15 @@
 package core
 
 import (
+	"context"
 	"fmt"
+	"reflect"
+	"sort"
 	"sync"
+	"sync/atomic"
 	"time"
+
+	"github.com/gofiber/fiber/v2"
 )
 
 // Event represents an event that can be published
@@ -15,74 +21,481 @@ type Event interface {
 // EventHandler is a function that handles an event
 type EventHandler func(Event) error
 
+// EventFilter decides whether a subscription receives an event
+type EventFilter func(Event) bool
+
+// SubscriptionID identifies a subscription on an event bus
+type SubscriptionID uint64
+
+// SubscribeOptions defines subscription configuration
+type SubscribeOptions struct {
+	// Priority orders handlers, higher priorities run first
+	Priority int
+	// Once removes the subscription after its first delivery
+	Once bool
+	// Filter is evaluated before the handler is invoked
+	Filter EventFilter
+	// Name identifies the subscription across restarts, e.g. for dead letter replay
+	Name string
+	// Retry retries failed deliveries before they are dead-lettered
+	Retry *RetryPolicy
+}
+
+// Subscription is a handle to a registered event handler
+type Subscription struct {
+	ID        SubscriptionID
+	Name      string
+	EventName string
+	Priority  int
+	Once      bool
+	Retry     *RetryPolicy
+
+	handler EventHandler
+	filter  EventFilter
+	bus     *EventBus
+	fired   int32
+	// active is cleared when the subscription is removed, so publishers
+	// holding an older snapshot skip it
+	active int32
+}
+
+// Unsubscribe removes the subscription from its event bus. Once it returns
+// the handler is not invoked again; a call that is already running finishes.
+func (s *Subscription) Unsubscribe() error {
+	return s.bus.Unsubscribe(s.ID)
+}
+
+func (s *Subscription) isActive() bool {
+	return atomic.LoadInt32(&s.active) == 1
+}
+
+// OverflowPolicy decides what happens when an async event queue is full
+type OverflowPolicy int
+
+const (
+	// OverflowBlock waits until the queue has room or the context is done
+	OverflowBlock OverflowPolicy = iota
+	// OverflowDrop silently discards the event
+	OverflowDrop
+	// OverflowError rejects the event with ErrEventQueueFull
+	OverflowError
+)
+
+// AsyncOptions defines asynchronous delivery configuration
+type AsyncOptions struct {
+	// Workers is the number of goroutines handling events, defaults to 1
+	Workers int
+	// QueueSize is the number of events buffered before the overflow policy applies
+	QueueSize int
+	// Overflow decides what happens when the queue is full
+	Overflow OverflowPolicy
+	// OnError is called with handler errors, which cannot be returned to the publisher
+	OnError func(Event, error)
+	// OnDrop is called for events discarded by OverflowDrop
+	OnDrop func(Event)
+}
+
 // EventBus manages event subscriptions and publishing
 type EventBus struct {
-	handlers map[string][]EventHandler
-	mu       sync.RWMutex
+	// subscriptions are kept sorted by priority and replaced on every
+	// change, so publishers can iterate a snapshot without holding the lock
+	subscriptions map[string][]*Subscription
+	patterns      map[string]bool
+	nextID        uint64
+	mu            sync.RWMutex
+
+	// pools deliver events asynchronously, the global pool is used for
+	// events without a pool of their own
+	pools      map[string]*eventPool
+	globalPool *eventPool
+	closed     bool
+	poolMu     sync.RWMutex
+
+	deadLetters DeadLetterStore
+	onError     func(HandlerError)
+
+	// id tells the dead letters of this bus instance apart from those of
+	// other processes, whose subscription IDs mean nothing here
+	id string
+	// closing interrupts retry backoffs when the bus is closed
+	closing chan struct{}
+}
+
+type eventJob struct {
+	ctx           context.Context
+	event         Event
+	subscriptions []*Subscription
+}
+
+type eventPool struct {
+	queue   chan eventJob
+	options AsyncOptions
+	wg      sync.WaitGroup
+	// closing is closed by CloseContext to release blocked publishers, the
+	// queue is closed once every sender has left
+	closing chan struct{}
+	senders sync.WaitGroup
 }
 
-// NewEventBus creates a new event bus
+// NewEventBus creates a new event bus that delivers events synchronously
 func NewEventBus() *EventBus {
 	return &EventBus{
-		handlers: make(map[string][]EventHandler),
+		subscriptions: make(map[string][]*Subscription),
+		patterns:      make(map[string]bool),
+		pools:         make(map[string]*eventPool),
+		id:            generateID(),
+		closing:       make(chan struct{}),
 	}
 }
 
-// Subscribe subscribes to an event
-func (b *EventBus) Subscribe(eventName string, handler EventHandler) error {
+// NewAsyncEventBus creates a new event bus that delivers all events on a worker pool
+func NewAsyncEventBus(options AsyncOptions) *EventBus {
+	b := NewEventBus()
+	b.globalPool = b.startPool(options)
+	return b
+}
+
+// SetAsync delivers an event asynchronously on its own worker pool,
+// so a slow handler cannot hold up other events
+func (b *EventBus) SetAsync(eventName string, options AsyncOptions) error {
+	b.poolMu.Lock()
+	defer b.poolMu.Unlock()
+
+	if b.closed {
+		return ErrEventBusClosed
+	}
+	if _, exists := b.pools[eventName]; exists {
+		return fmt.Errorf("event %s is already async", eventName)
+	}
+
+	b.pools[eventName] = b.startPool(options)
+	return nil
+}
+
+func (b *EventBus) startPool(options AsyncOptions) *eventPool {
+	if options.Workers <= 0 {
+		options.Workers = 1
+	}
+	if options.QueueSize < 0 {
+		options.QueueSize = 0
+	}
+
+	pool := &eventPool{
+		queue:   make(chan eventJob, options.QueueSize),
+		options: options,
+		closing: make(chan struct{}),
+	}
+
+	pool.wg.Add(options.Workers)
+	for i := 0; i < options.Workers; i++ {
+		go func() {
+			defer pool.wg.Done()
+			for job := range pool.queue {
+				if err := b.dispatch(job.ctx, job.event, job.subscriptions); err != nil && options.OnError != nil {
+					options.OnError(job.event, err)
+				}
+			}
+		}()
+	}
+
+	return pool
+}
+
+// Subscribe subscribes to an event and returns a handle to cancel the subscription.
+// Event names are dot separated topics, a "*" segment matches exactly one
+// segment and a "#" segment matches zero or more, e.g. "user.*" or "order.#".
+func (b *EventBus) Subscribe(eventName string, handler EventHandler, options ...SubscribeOptions) (*Subscription, error) {
+	if handler == nil {
+		return nil, fmt.Errorf("handler cannot be nil")
+	}
+
+	var opts SubscribeOptions
+	if len(options) > 0 {
+		opts = options[0]
+	}
+
 	b.mu.Lock()
 	defer b.mu.Unlock()
 
-	if handler == nil {
-		return fmt.Errorf("handler cannot be nil")
+	b.nextID++
+	sub := &Subscription{
+		ID:        SubscriptionID(b.nextID),
+		Name:      opts.Name,
+		EventName: eventName,
+		Priority:  opts.Priority,
+		Once:      opts.Once,
+		Retry:     opts.Retry,
+		handler:   handler,
+		filter:    opts.Filter,
+		bus:       b,
+		active:    1,
 	}
 
-	b.handlers[eventName] = append(b.handlers[eventName], handler)
-	return nil
+	current := b.subscriptions[eventName]
+	next := make([]*Subscription, 0, len(current)+1)
+	next = append(next, current...)
+	next = append(next, sub)
+	sort.SliceStable(next, func(i, j int) bool {
+		return next[i].Priority > next[j].Priority
+	})
+	b.subscriptions[eventName] = next
+
+	if isTopicPattern(eventName) {
+		b.patterns[eventName] = true
+	}
+
+	return sub, nil
+}
+
+// SubscribeOnce subscribes to the next matching occurrence of an event only
+func (b *EventBus) SubscribeOnce(eventName string, handler EventHandler, options ...SubscribeOptions) (*Subscription, error) {
+	var opts SubscribeOptions
+	if len(options) > 0 {
+		opts = options[0]
+	}
+	opts.Once = true
+	return b.Subscribe(eventName, handler, opts)
 }
 
 // Publish publishes an event to all subscribers
 func (b *EventBus) Publish(event Event) error {
+	return b.PublishContext(context.Background(), event)
+}
+
+// PublishContext publishes an event to all subscribers. For async events the
+// context bounds the wait for queue space; once queued, the event is
+// delivered with the context's values even if it is cancelled, since the
+// publisher was told it was accepted.
+// Events implementing RequestScopedEvent receive the request ID of the context.
+func (b *EventBus) PublishContext(ctx context.Context, event Event) error {
 	if event == nil {
 		return fmt.Errorf("event cannot be nil")
 	}
+	stampRequestID(ctx, event)
+
+	subscriptions := b.match(event)
+
+	// The lock only guards the pool lookup, a publisher blocked on a full
+	// queue must not hold up CloseContext or handlers that publish
+	b.poolMu.RLock()
+	if b.closed {
+		b.poolMu.RUnlock()
+		return ErrEventBusClosed
+	}
+	pool := b.pools[event.GetName()]
+	if pool == nil {
+		pool = b.globalPool
+	}
+	if pool != nil && len(subscriptions) > 0 {
+		pool.senders.Add(1)
+	}
+	b.poolMu.RUnlock()
+
+	if pool == nil {
+		return b.dispatch(ctx, event, subscriptions)
+	}
+	if len(subscriptions) == 0 {
+		return nil
+	}
+
+	defer pool.senders.Done()
+	return pool.enqueue(ctx, eventJob{ctx: context.WithoutCancel(ctx), event: event, subscriptions: subscriptions})
+}
+
+// enqueue queues a job, waiting for room under OverflowBlock until ctx is done
+func (p *eventPool) enqueue(ctx context.Context, job eventJob) error {
+	switch p.options.Overflow {
+	case OverflowDrop:
+		select {
+		case p.queue <- job:
+		default:
+			if p.options.OnDrop != nil {
+				p.options.OnDrop(job.event)
+			}
+		}
+		return nil
+	case OverflowError:
+		select {
+		case p.queue <- job:
+			return nil
+		default:
+			return ErrEventQueueFull
+		}
+	default:
+		select {
+		case p.queue <- job:
+			return nil
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-p.closing:
+			return ErrEventBusClosed
+		}
+	}
+}
+
+// Close stops accepting events and waits until queued events are delivered
+func (b *EventBus) Close() error {
+	return b.CloseContext(context.Background())
+}
+
+// CloseContext stops accepting events and drains queued events until the context is done
+func (b *EventBus) CloseContext(ctx context.Context) error {
+	b.poolMu.Lock()
+	if b.closed {
+		b.poolMu.Unlock()
+		return nil
+	}
+	b.closed = true
+	close(b.closing)
+
+	pools := make([]*eventPool, 0, len(b.pools)+1)
+	for _, pool := range b.pools {
+		pools = append(pools, pool)
+	}
+	if b.globalPool != nil {
+		pools = append(pools, b.globalPool)
+	}
+	for _, pool := range pools {
+		close(pool.closing)
+	}
+	b.poolMu.Unlock()
+
+	done := make(chan struct{})
+	go func() {
+		for _, pool := range pools {
+			pool.senders.Wait()
+			close(pool.queue)
+			pool.wg.Wait()
+		}
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// match collects the subscriptions for an event by name, by Go type and by
+// wildcard pattern, ordered by priority. A subscription matching through
+// several sources is delivered once.
+func (b *EventBus) match(event Event) []*Subscription {
+	name := event.GetName()
 
 	b.mu.RLock()
-	handlers := b.handlers[event.GetName()]
-	b.mu.RUnlock()
+	defer b.mu.RUnlock()
+
+	sources := [][]*Subscription{
+		b.subscriptions[name],
+		b.subscriptions[eventTypeKey(reflect.TypeOf(event))],
+	}
+	for pattern := range b.patterns {
+		// An event named like a pattern was already matched by name
+		if pattern != name && matchTopic(pattern, name) {
+			sources = append(sources, b.subscriptions[pattern])
+		}
+	}
+
+	var matched []*Subscription
+	count := 0
+	for _, source := range sources {
+		if len(source) > 0 {
+			matched = source
+			count++
+		}
+	}
+	if count <= 1 {
+		// A single source is already sorted and never modified in place
+		return matched
+	}
+
+	matched = nil
+	seen := make(map[SubscriptionID]bool)
+	for _, source := range sources {
+		for _, sub := range source {
+			if !seen[sub.ID] {
+				seen[sub.ID] = true
+				matched = append(matched, sub)
+			}
+		}
+	}
+	sort.SliceStable(matched, func(i, j int) bool {
+		if matched[i].Priority != matched[j].Priority {
+			return matched[i].Priority > matched[j].Priority
+		}
+		return matched[i].ID < matched[j].ID
+	})
+
+	return matched
+}
+
+// dispatch runs the handlers of an event on the calling goroutine. The
+// context and closing the bus cut retry backoffs short.
+func (b *EventBus) dispatch(ctx context.Context, event Event, subscriptions []*Subscription) error {
+	var errs []HandlerError
+	for _, sub := range subscriptions {
+		if !sub.isActive() {
+			continue
+		}
+		if sub.filter != nil && !sub.filter(event) {
+			continue
+		}
 
-	var errs []error
-	for _, handler := range handlers {
-		if err := handler(event); err != nil {
-			errs = append(errs, err)
+		if sub.Once {
+			// Concurrent publishers must not deliver a once subscription twice
+			if !atomic.CompareAndSwapInt32(&sub.fired, 0, 1) {
+				continue
+			}
+			b.Unsubscribe(sub.ID)
+		}
+
+		if err := b.deliver(ctx, sub, event); err != nil {
+			errs = append(errs, *err)
 		}
 	}
 
 	if len(errs) > 0 {
-		return fmt.Errorf("failed to handle event: %v", errs)
+		return &EventError{Event: event, Errors: errs}
 	}
 
 	return nil
 }
 
-// Unsubscribe removes a handler from an event
-func (b *EventBus) Unsubscribe(eventName string, handler EventHandler) error {
+// Unsubscribe removes a subscription by its ID
+func (b *EventBus) Unsubscribe(id SubscriptionID) error {
 	b.mu.Lock()
 	defer b.mu.Unlock()
 
-	handlers, exists := b.handlers[eventName]
-	if !exists {
-		return fmt.Errorf("event %s not found", eventName)
-	}
+	for eventName, subscriptions := range b.subscriptions {
+		for i, sub := range subscriptions {
+			if sub.ID != id {
+				continue
+			}
+			atomic.StoreInt32(&sub.active, 0)
 
-	for i, h := range handlers {
-		if &h == &handler {
-			b.handlers[eventName] = append(handlers[:i], handlers[i+1:]...)
+			next := make([]*Subscription, 0, len(subscriptions)-1)
+			next = append(next, subscriptions[:i]...)
+			next = append(next, subscriptions[i+1:]...)
+			if len(next) == 0 {
+				delete(b.subscriptions, eventName)
+				delete(b.patterns, eventName)
+			} else {
+				b.subscriptions[eventName] = next
+			}
 			return nil
 		}
 	}
 
-	return fmt.Errorf("handler not found for event %s", eventName)
+	return fmt.Errorf("subscription %d not found", id)
+}
+
+// Subscriptions returns the subscriptions of an event in delivery order
+func (b *EventBus) Subscriptions(eventName string) []*Subscription {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	return append([]*Subscription(nil), b.subscriptions[eventName]...)
 }
 
 // Clear removes all handlers for an event
@@ -90,11 +503,16 @@ func (b *EventBus) Clear(eventName string) error {
 	b.mu.Lock()
 	defer b.mu.Unlock()
 
-	if _, exists := b.handlers[eventName]; !exists {
+	subscriptions, exists := b.subscriptions[eventName]
+	if !exists {
 		return fmt.Errorf("event %s not found", eventName)
 	}
+	for _, sub := range subscriptions {
+		atomic.StoreInt32(&sub.active, 0)
+	}
 
-	delete(b.handlers, eventName)
+	delete(b.subscriptions, eventName)
+	delete(b.patterns, eventName)
 	return nil
 }
 
@@ -103,5 +521,17 @@ func (b *EventBus) ClearAll() {
 	b.mu.Lock()
 	defer b.mu.Unlock()
 
-	b.handlers = make(map[string][]EventHandler)
-} 
\ No newline at end of file
+	for _, subscriptions := range b.subscriptions {
+		for _, sub := range subscriptions {
+			atomic.StoreInt32(&sub.active, 0)
+		}
+	}
+	b.subscriptions = make(map[string][]*Subscription)
+	b.patterns = make(map[string]bool)
+}
+
+// Event errors
+var (
+	ErrEventBusClosed = fiber.NewError(fiber.StatusServiceUnavailable, "event bus closed")
+	ErrEventQueueFull = fiber.NewError(fiber.StatusServiceUnavailable, "event queue full")
+)
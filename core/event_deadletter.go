@@ -0,0 +1,422 @@
+package core
+
+import (
+	"context"
+	"crypto/rand"
+	"database/sql"
+	"encoding/hex"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// DeadLetter is an event delivery that failed after all retries. BusID
+// identifies the event bus instance that recorded it.
+type DeadLetter struct {
+	ID               string         `json:"id" bson:"_id"`
+	EventName        string         `json:"eventName" bson:"eventName"`
+	Event            Event          `json:"-" bson:"-"`
+	Payload          []byte         `json:"payload" bson:"payload"`
+	SubscriptionID   SubscriptionID `json:"subscriptionId" bson:"subscriptionId"`
+	SubscriptionName string         `json:"subscriptionName" bson:"subscriptionName"`
+	BusID            string         `json:"busId" bson:"busId"`
+	Error            string         `json:"error" bson:"error"`
+	Attempts         int            `json:"attempts" bson:"attempts"`
+	FailedAt         time.Time      `json:"failedAt" bson:"failedAt"`
+}
+
+// NewDeadLetter creates a dead letter for a failed delivery
+func NewDeadLetter(event Event, failure HandlerError) DeadLetter {
+	payload, _ := DefaultEventRegistry.Encode(event)
+
+	return DeadLetter{
+		ID:               generateID(),
+		EventName:        event.GetName(),
+		Event:            event,
+		Payload:          payload,
+		SubscriptionID:   failure.SubscriptionID,
+		SubscriptionName: failure.SubscriptionName,
+		Error:            failure.Err.Error(),
+		Attempts:         failure.Attempts,
+		FailedAt:         time.Now(),
+	}
+}
+
+// DeadLetterStore defines the interface for dead letter persistence
+type DeadLetterStore interface {
+	Add(letter DeadLetter) error
+	// List returns dead letters, oldest first, limit 0 means all
+	List(limit int) ([]DeadLetter, error)
+	Get(id string) (*DeadLetter, error)
+	Delete(id string) error
+}
+
+// UseDeadLetterStore stores failed deliveries in a dead letter store
+func (b *EventBus) UseDeadLetterStore(store DeadLetterStore) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.deadLetters = store
+}
+
+// DeadLetters returns the configured dead letter store
+func (b *EventBus) DeadLetters() DeadLetterStore {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	return b.deadLetters
+}
+
+// ReplayDeadLetter delivers a dead letter again and removes it on success.
+// The original subscription is looked up by name, then by ID. IDs are only
+// used for letters recorded by this bus instance, since they are reassigned
+// after a restart; name subscriptions when dead letters are persisted. When
+// no subscription is found ErrDeadLetterSubscriptionNotFound is returned and
+// the letter is kept, so other handlers never receive the event twice.
+func (b *EventBus) ReplayDeadLetter(id string) error {
+	store := b.DeadLetters()
+	if store == nil {
+		return ErrDeadLetterNotFound
+	}
+
+	letter, err := store.Get(id)
+	if err != nil {
+		return err
+	}
+
+	event := letter.Event
+	if event == nil {
+		if event, err = DefaultEventRegistry.Decode(letter.EventName, letter.Payload); err != nil {
+			return err
+		}
+	}
+
+	sub := b.findSubscription(letter)
+	if sub == nil {
+		return ErrDeadLetterSubscriptionNotFound
+	}
+	if err := invokeHandler(sub.handler, event); err != nil {
+		return err
+	}
+	return store.Delete(id)
+}
+
+// ReplayDeadLetters replays all dead letters and returns how many succeeded
+func (b *EventBus) ReplayDeadLetters() (int, error) {
+	store := b.DeadLetters()
+	if store == nil {
+		return 0, nil
+	}
+
+	letters, err := store.List(0)
+	if err != nil {
+		return 0, err
+	}
+
+	replayed := 0
+	var errs []error
+	for _, letter := range letters {
+		if err := b.ReplayDeadLetter(letter.ID); err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		replayed++
+	}
+
+	if len(errs) > 0 {
+		return replayed, fmt.Errorf("failed to replay %d dead letter(s): %v", len(errs), errs)
+	}
+	return replayed, nil
+}
+
+func (b *EventBus) findSubscription(letter *DeadLetter) *Subscription {
+	name := letter.SubscriptionName
+	if name == "" && letter.BusID != b.id {
+		return nil
+	}
+
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+
+	for _, subscriptions := range b.subscriptions {
+		for _, sub := range subscriptions {
+			if name != "" && sub.Name == name {
+				return sub
+			}
+			if name == "" && sub.ID == letter.SubscriptionID {
+				return sub
+			}
+		}
+	}
+	return nil
+}
+
+// MemoryDeadLetterStore is an in-memory dead letter store
+type MemoryDeadLetterStore struct {
+	letters map[string]DeadLetter
+	mu      sync.RWMutex
+}
+
+// NewMemoryDeadLetterStore creates a new in-memory dead letter store
+func NewMemoryDeadLetterStore() *MemoryDeadLetterStore {
+	return &MemoryDeadLetterStore{
+		letters: make(map[string]DeadLetter),
+	}
+}
+
+// Add implements DeadLetterStore
+func (s *MemoryDeadLetterStore) Add(letter DeadLetter) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.letters[letter.ID] = letter
+	return nil
+}
+
+// List implements DeadLetterStore
+func (s *MemoryDeadLetterStore) List(limit int) ([]DeadLetter, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	letters := make([]DeadLetter, 0, len(s.letters))
+	for _, letter := range s.letters {
+		letters = append(letters, letter)
+	}
+	sort.Slice(letters, func(i, j int) bool {
+		return letters[i].FailedAt.Before(letters[j].FailedAt)
+	})
+
+	if limit > 0 && len(letters) > limit {
+		letters = letters[:limit]
+	}
+	return letters, nil
+}
+
+// Get implements DeadLetterStore
+func (s *MemoryDeadLetterStore) Get(id string) (*DeadLetter, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	letter, exists := s.letters[id]
+	if !exists {
+		return nil, ErrDeadLetterNotFound
+	}
+	return &letter, nil
+}
+
+// Delete implements DeadLetterStore
+func (s *MemoryDeadLetterStore) Delete(id string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.letters, id)
+	return nil
+}
+
+// SQLDeadLetterStore is a dead letter store backed by a SQL table
+type SQLDeadLetterStore struct {
+	db       *sql.DB
+	dialect  SQLDialect
+	table    string
+	registry *EventRegistry
+}
+
+// NewSQLDeadLetterStore creates a new SQL dead letter store
+func NewSQLDeadLetterStore(db *sql.DB, dialect SQLDialect, table string) *SQLDeadLetterStore {
+	if table == "" {
+		table = "dead_letters"
+	}
+	return &SQLDeadLetterStore{
+		db:       db,
+		dialect:  dialect,
+		table:    table,
+		registry: DefaultEventRegistry,
+	}
+}
+
+// CreateTable creates the dead letter table if it does not exist
+func (s *SQLDeadLetterStore) CreateTable() error {
+	_, err := s.db.Exec(fmt.Sprintf(`CREATE TABLE IF NOT EXISTS %s (
+		id VARCHAR(64) PRIMARY KEY,
+		event_name VARCHAR(255) NOT NULL,
+		payload %s NOT NULL,
+		subscription_id BIGINT NOT NULL,
+		subscription_name VARCHAR(255) NOT NULL,
+		bus_id VARCHAR(64) NOT NULL,
+		error TEXT NOT NULL,
+		attempts INT NOT NULL,
+		failed_at TIMESTAMP NOT NULL
+	)`, s.table, s.dialect.BlobType()))
+	return err
+}
+
+// Add implements DeadLetterStore
+func (s *SQLDeadLetterStore) Add(letter DeadLetter) error {
+	_, err := s.db.Exec(s.dialect.Rebind(fmt.Sprintf(`INSERT INTO %s
+		(id, event_name, payload, subscription_id, subscription_name, bus_id, error, attempts, failed_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)`, s.table)),
+		letter.ID, letter.EventName, letter.Payload, int64(letter.SubscriptionID),
+		letter.SubscriptionName, letter.BusID, letter.Error, letter.Attempts, letter.FailedAt.UTC())
+	return err
+}
+
+// List implements DeadLetterStore
+func (s *SQLDeadLetterStore) List(limit int) ([]DeadLetter, error) {
+	query := fmt.Sprintf(`SELECT id, event_name, payload, subscription_id, subscription_name, bus_id, error, attempts, failed_at
+		FROM %s ORDER BY failed_at`, s.table)
+	if limit > 0 {
+		query += fmt.Sprintf(" LIMIT %d", limit)
+	}
+
+	rows, err := s.db.Query(query)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var letters []DeadLetter
+	for rows.Next() {
+		letter, err := s.scan(rows)
+		if err != nil {
+			return nil, err
+		}
+		letters = append(letters, *letter)
+	}
+	return letters, rows.Err()
+}
+
+// Get implements DeadLetterStore
+func (s *SQLDeadLetterStore) Get(id string) (*DeadLetter, error) {
+	row := s.db.QueryRow(s.dialect.Rebind(fmt.Sprintf(`SELECT id, event_name, payload, subscription_id, subscription_name, bus_id, error, attempts, failed_at
+		FROM %s WHERE id = ?`, s.table)), id)
+
+	letter, err := s.scan(row)
+	if err == sql.ErrNoRows {
+		return nil, ErrDeadLetterNotFound
+	}
+	return letter, err
+}
+
+// Delete implements DeadLetterStore
+func (s *SQLDeadLetterStore) Delete(id string) error {
+	_, err := s.db.Exec(s.dialect.Rebind(fmt.Sprintf("DELETE FROM %s WHERE id = ?", s.table)), id)
+	return err
+}
+
+func (s *SQLDeadLetterStore) scan(row interface{ Scan(...interface{}) error }) (*DeadLetter, error) {
+	var letter DeadLetter
+	var subscriptionID int64
+	if err := row.Scan(&letter.ID, &letter.EventName, &letter.Payload, &subscriptionID,
+		&letter.SubscriptionName, &letter.BusID, &letter.Error, &letter.Attempts, &letter.FailedAt); err != nil {
+		return nil, err
+	}
+	letter.SubscriptionID = SubscriptionID(subscriptionID)
+
+	event, err := s.registry.Decode(letter.EventName, letter.Payload)
+	if err != nil {
+		return nil, err
+	}
+	letter.Event = event
+
+	return &letter, nil
+}
+
+// MongoDeadLetterStore is a dead letter store backed by a MongoDB collection
+type MongoDeadLetterStore struct {
+	collection *mongo.Collection
+	registry   *EventRegistry
+	timeout    time.Duration
+}
+
+// NewMongoDeadLetterStore creates a new MongoDB dead letter store
+func NewMongoDeadLetterStore(collection *mongo.Collection) *MongoDeadLetterStore {
+	return &MongoDeadLetterStore{
+		collection: collection,
+		registry:   DefaultEventRegistry,
+		timeout:    10 * time.Second,
+	}
+}
+
+// Add implements DeadLetterStore
+func (s *MongoDeadLetterStore) Add(letter DeadLetter) error {
+	ctx, cancel := context.WithTimeout(context.Background(), s.timeout)
+	defer cancel()
+
+	_, err := s.collection.InsertOne(ctx, letter)
+	return err
+}
+
+// List implements DeadLetterStore
+func (s *MongoDeadLetterStore) List(limit int) ([]DeadLetter, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), s.timeout)
+	defer cancel()
+
+	opts := options.Find().SetSort(bson.D{{Key: "failedAt", Value: 1}})
+	if limit > 0 {
+		opts.SetLimit(int64(limit))
+	}
+
+	cursor, err := s.collection.Find(ctx, bson.D{}, opts)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	var letters []DeadLetter
+	if err := cursor.All(ctx, &letters); err != nil {
+		return nil, err
+	}
+
+	for i := range letters {
+		if letters[i].Event, err = s.registry.Decode(letters[i].EventName, letters[i].Payload); err != nil {
+			return nil, err
+		}
+	}
+	return letters, nil
+}
+
+// Get implements DeadLetterStore
+func (s *MongoDeadLetterStore) Get(id string) (*DeadLetter, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), s.timeout)
+	defer cancel()
+
+	var letter DeadLetter
+	err := s.collection.FindOne(ctx, bson.D{{Key: "_id", Value: id}}).Decode(&letter)
+	if err == mongo.ErrNoDocuments {
+		return nil, ErrDeadLetterNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if letter.Event, err = s.registry.Decode(letter.EventName, letter.Payload); err != nil {
+		return nil, err
+	}
+	return &letter, nil
+}
+
+// Delete implements DeadLetterStore
+func (s *MongoDeadLetterStore) Delete(id string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), s.timeout)
+	defer cancel()
+
+	_, err := s.collection.DeleteOne(ctx, bson.D{{Key: "_id", Value: id}})
+	return err
+}
+
+// generateID returns a random 128 bit hex identifier
+func generateID() string {
+	buf := make([]byte, 16)
+	if _, err := rand.Read(buf); err != nil {
+		panic(err)
+	}
+	return hex.EncodeToString(buf)
+}
+
+// Dead letter errors
+var (
+	ErrDeadLetterNotFound             = fiber.NewError(fiber.StatusNotFound, "dead letter not found")
+	ErrDeadLetterSubscriptionNotFound = fiber.NewError(fiber.StatusNotFound, "dead letter subscription not found")
+)
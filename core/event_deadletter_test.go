@@ -0,0 +1,117 @@
+package core
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+var errTestHandler = errors.New("handler failed")
+
+func TestEventBusBackoffInterruptedByContext(t *testing.T) {
+	bus := NewEventBus()
+	store := NewMemoryDeadLetterStore()
+	bus.UseDeadLetterStore(store)
+	bus.Subscribe("job", func(Event) error { return errTestHandler }, SubscribeOptions{
+		Name:  "job-handler",
+		Retry: &RetryPolicy{MaxAttempts: 5, InitialBackoff: time.Hour},
+	})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+
+	published := make(chan error, 1)
+	go func() { published <- bus.PublishContext(ctx, &testEvent{Name: "job"}) }()
+
+	var eventErr *EventError
+	if err := waitFor(t, published, "the publish"); !errors.As(err, &eventErr) {
+		t.Fatalf("expected an EventError, got %v", err)
+	}
+	letters, _ := store.List(0)
+	if len(letters) != 1 || letters[0].Attempts != 1 {
+		t.Fatalf("expected one dead letter after one attempt, got %+v", letters)
+	}
+}
+
+func TestEventBusBackoffInterruptedByClose(t *testing.T) {
+	bus := NewAsyncEventBus(AsyncOptions{})
+	store := NewMemoryDeadLetterStore()
+	bus.UseDeadLetterStore(store)
+
+	failed := make(chan struct{}, 1)
+	bus.Subscribe("job", func(Event) error {
+		failed <- struct{}{}
+		return errTestHandler
+	}, SubscribeOptions{Retry: &RetryPolicy{MaxAttempts: 5, InitialBackoff: time.Hour}})
+
+	bus.Publish(&testEvent{Name: "job"})
+	waitFor(t, failed, "the first attempt")
+
+	closed := make(chan error, 1)
+	go func() { closed <- bus.Close() }()
+	if err := waitFor(t, closed, "Close"); err != nil {
+		t.Fatal(err)
+	}
+
+	letters, _ := store.List(0)
+	if len(letters) != 1 || letters[0].BusID != bus.id {
+		t.Fatalf("expected one dead letter from this bus, got %+v", letters)
+	}
+}
+
+func TestEventBusReplayMatchesIDsOnlyFromSameBus(t *testing.T) {
+	bus := NewEventBus()
+	store := NewMemoryDeadLetterStore()
+	bus.UseDeadLetterStore(store)
+
+	var target, other int32
+	sub, _ := bus.Subscribe("job", func(Event) error {
+		atomic.AddInt32(&target, 1)
+		return nil
+	})
+	bus.Subscribe("job", func(Event) error {
+		atomic.AddInt32(&other, 1)
+		return nil
+	})
+
+	letter := DeadLetter{ID: "same", EventName: "job", Event: &testEvent{Name: "job"}, SubscriptionID: sub.ID, BusID: bus.id}
+	store.Add(letter)
+	if err := bus.ReplayDeadLetter("same"); err != nil {
+		t.Fatal(err)
+	}
+	if target != 1 || other != 0 {
+		t.Fatalf("expected only the recorded subscription, got target=%d other=%d", target, other)
+	}
+
+	// IDs from another bus instance may belong to a different handler now,
+	// so the letter is kept instead of reaching handlers that succeeded
+	letter.ID, letter.BusID = "foreign", "another-bus"
+	store.Add(letter)
+	if err := bus.ReplayDeadLetter("foreign"); err != ErrDeadLetterSubscriptionNotFound {
+		t.Fatalf("replay of a foreign letter = %v, want ErrDeadLetterSubscriptionNotFound", err)
+	}
+	if target != 1 || other != 0 {
+		t.Fatalf("expected no delivery, got target=%d other=%d", target, other)
+	}
+	if _, err := store.Get("foreign"); err != nil {
+		t.Fatalf("expected the letter to be kept, got %v", err)
+	}
+
+	// Named subscriptions are found across bus instances
+	bus.Subscribe("job", func(Event) error {
+		atomic.AddInt32(&target, 10)
+		return nil
+	}, SubscribeOptions{Name: "welcome"})
+	store.Add(DeadLetter{ID: "named", EventName: "job", Event: &testEvent{Name: "job"}, SubscriptionName: "welcome", BusID: "another-bus"})
+	if err := bus.ReplayDeadLetter("named"); err != nil {
+		t.Fatal(err)
+	}
+	if target != 11 || other != 0 {
+		t.Fatalf("expected only the named subscription, got target=%d other=%d", target, other)
+	}
+	if _, err := store.Get("named"); err != ErrDeadLetterNotFound {
+		t.Fatalf("expected the replayed letter to be deleted, got %v", err)
+	}
+}
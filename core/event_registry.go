@@ -0,0 +1,96 @@
+package core
+
+import (
+	"encoding/json"
+	"reflect"
+	"sync"
+	"time"
+)
+
+// EventRegistry maps event names to Go types so serialized events can be
+// decoded back into their original type
+type EventRegistry struct {
+	types map[string]reflect.Type
+	mu    sync.RWMutex
+}
+
+// NewEventRegistry creates a new event registry
+func NewEventRegistry() *EventRegistry {
+	return &EventRegistry{
+		types: make(map[string]reflect.Type),
+	}
+}
+
+// Register registers prototypes of events under their names
+func (r *EventRegistry) Register(events ...Event) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for _, event := range events {
+		r.types[event.GetName()] = reflect.TypeOf(event)
+	}
+}
+
+// Encode serializes an event as JSON
+func (r *EventRegistry) Encode(event Event) ([]byte, error) {
+	if raw, ok := event.(*RawEvent); ok {
+		return raw.Payload, nil
+	}
+	return json.Marshal(event)
+}
+
+// Decode deserializes an event. Unregistered names decode into a RawEvent.
+func (r *EventRegistry) Decode(name string, data []byte) (Event, error) {
+	return r.DecodeWith(name, data, json.Unmarshal)
+}
+
+// DecodeWith deserializes an event using the given unmarshal function.
+// Unregistered names decode into a RawEvent.
+func (r *EventRegistry) DecodeWith(name string, data []byte, unmarshal func([]byte, interface{}) error) (Event, error) {
+	r.mu.RLock()
+	typ, exists := r.types[name]
+	r.mu.RUnlock()
+
+	if !exists {
+		return &RawEvent{Name: name, Timestamp: time.Now(), Payload: append(json.RawMessage(nil), data...)}, nil
+	}
+
+	if typ.Kind() == reflect.Ptr {
+		value := reflect.New(typ.Elem())
+		if err := unmarshal(data, value.Interface()); err != nil {
+			return nil, err
+		}
+		return value.Interface().(Event), nil
+	}
+
+	value := reflect.New(typ)
+	if err := unmarshal(data, value.Interface()); err != nil {
+		return nil, err
+	}
+	return value.Elem().Interface().(Event), nil
+}
+
+// DefaultEventRegistry is the registry used when none is configured
+var DefaultEventRegistry = NewEventRegistry()
+
+// RegisterEvent registers event prototypes with the default registry
+func RegisterEvent(events ...Event) {
+	DefaultEventRegistry.Register(events...)
+}
+
+// RawEvent is an event whose Go type is unknown, carrying its serialized payload
+type RawEvent struct {
+	Name      string          `json:"name"`
+	Timestamp time.Time       `json:"timestamp"`
+	Payload   json.RawMessage `json:"payload"`
+}
+
+// GetName implements Event
+func (e *RawEvent) GetName() string {
+	return e.Name
+}
+
+// GetTimestamp implements Event
+func (e *RawEvent) GetTimestamp() time.Time {
+	return e.Timestamp
+}
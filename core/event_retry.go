@@ -0,0 +1,186 @@
+package core
+
+import (
+	"context"
+	"fmt"
+	"math/rand"
+	"runtime/debug"
+	"strings"
+	"time"
+)
+
+// RetryPolicy defines how failed event deliveries are retried
+type RetryPolicy struct {
+	// MaxAttempts is the total number of attempts including the first one
+	MaxAttempts int
+	// InitialBackoff is the wait before the first retry
+	InitialBackoff time.Duration
+	// MaxBackoff caps the wait between retries
+	MaxBackoff time.Duration
+	// Multiplier grows the backoff after every retry, defaults to 2
+	Multiplier float64
+	// Jitter randomizes each wait by up to the given fraction, e.g. 0.2
+	Jitter float64
+}
+
+// Backoff returns the wait before the given retry, starting at 1
+func (p *RetryPolicy) Backoff(retry int) time.Duration {
+	multiplier := p.Multiplier
+	if multiplier <= 0 {
+		multiplier = 2
+	}
+
+	backoff := float64(p.InitialBackoff)
+	for i := 1; i < retry; i++ {
+		backoff *= multiplier
+	}
+	if p.MaxBackoff > 0 && backoff > float64(p.MaxBackoff) {
+		backoff = float64(p.MaxBackoff)
+	}
+	if p.Jitter > 0 {
+		backoff += backoff * p.Jitter * (rand.Float64()*2 - 1)
+	}
+
+	return time.Duration(backoff)
+}
+
+// HandlerError describes a failed delivery to one subscription
+type HandlerError struct {
+	SubscriptionID   SubscriptionID
+	SubscriptionName string
+	EventName        string
+	Attempts         int
+	Err              error
+}
+
+func (e HandlerError) Error() string {
+	name := e.SubscriptionName
+	if name == "" {
+		name = fmt.Sprintf("#%d", e.SubscriptionID)
+	}
+	return fmt.Sprintf("handler %s failed after %d attempt(s): %v", name, e.Attempts, e.Err)
+}
+
+func (e HandlerError) Unwrap() error {
+	return e.Err
+}
+
+// EventError is returned by Publish when one or more handlers failed
+type EventError struct {
+	Event  Event
+	Errors []HandlerError
+}
+
+func (e *EventError) Error() string {
+	messages := make([]string, len(e.Errors))
+	for i, err := range e.Errors {
+		messages[i] = err.Error()
+	}
+	return fmt.Sprintf("failed to handle event %s: %s", e.Event.GetName(), strings.Join(messages, "; "))
+}
+
+// Unwrap returns the handler errors so errors.Is and errors.As can inspect them
+func (e *EventError) Unwrap() []error {
+	errs := make([]error, len(e.Errors))
+	for i, err := range e.Errors {
+		errs[i] = err
+	}
+	return errs
+}
+
+// PanicError is returned when an event handler panics
+type PanicError struct {
+	Value interface{}
+	Stack []byte
+}
+
+func (e *PanicError) Error() string {
+	return fmt.Sprintf("handler panicked: %v", e.Value)
+}
+
+// OnError registers a callback for every failed delivery, after retries
+func (b *EventBus) OnError(callback func(HandlerError)) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.onError = callback
+}
+
+// deliver invokes a subscription, retrying according to its policy.
+// Deliveries that still fail, or whose retries are interrupted by the
+// context or Close, are reported and dead-lettered.
+func (b *EventBus) deliver(ctx context.Context, sub *Subscription, event Event) *HandlerError {
+	attempts := 1
+	if sub.Retry != nil && sub.Retry.MaxAttempts > 1 {
+		attempts = sub.Retry.MaxAttempts
+	}
+
+	var err error
+	attempt := 0
+	for attempt < attempts {
+		if attempt > 0 {
+			if !b.backoff(ctx, sub.Retry.Backoff(attempt)) {
+				break
+			}
+			// Removed subscriptions are not retried, the failure is kept
+			if !sub.isActive() {
+				break
+			}
+		}
+		attempt++
+
+		if err = invokeHandler(sub.handler, event); err == nil {
+			return nil
+		}
+	}
+
+	failure := &HandlerError{
+		SubscriptionID:   sub.ID,
+		SubscriptionName: sub.Name,
+		EventName:        event.GetName(),
+		Attempts:         attempt,
+		Err:              err,
+	}
+
+	b.mu.RLock()
+	onError, deadLetters := b.onError, b.deadLetters
+	b.mu.RUnlock()
+
+	if deadLetters != nil {
+		letter := NewDeadLetter(event, *failure)
+		letter.BusID = b.id
+		if dlErr := deadLetters.Add(letter); dlErr != nil {
+			failure.Err = fmt.Errorf("%v (dead letter failed: %v)", err, dlErr)
+		}
+	}
+	if onError != nil {
+		onError(*failure)
+	}
+
+	return failure
+}
+
+// backoff waits before a retry and reports false when the context is done
+// or the bus is closed first
+func (b *EventBus) backoff(ctx context.Context, wait time.Duration) bool {
+	timer := time.NewTimer(wait)
+	defer timer.Stop()
+
+	select {
+	case <-timer.C:
+		return true
+	case <-ctx.Done():
+		return false
+	case <-b.closing:
+		return false
+	}
+}
+
+// invokeHandler calls a handler and turns panics into errors
+func invokeHandler(handler EventHandler, event Event) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = &PanicError{Value: r, Stack: debug.Stack()}
+		}
+	}()
+	return handler(event)
+}
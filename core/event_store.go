@@ -0,0 +1,423 @@
+package core
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// Event store errors
+var (
+	ErrConcurrencyConflict = fiber.NewError(fiber.StatusConflict, "Stream was modified concurrently")
+)
+
+// Expected stream versions for Append
+const (
+	// AnyVersion appends regardless of the current stream version
+	AnyVersion int64 = -1
+	// NoStream requires the stream to be empty
+	NoStream int64 = 0
+)
+
+// StoredEvent is an event persisted in an event store
+type StoredEvent struct {
+	StreamID string `bson:"streamId"`
+	// Version is the position of the event in its stream, starting at 1
+	Version int64 `bson:"version"`
+	// Position is the global position of the event across all streams
+	Position  int64     `bson:"position"`
+	Name      string    `bson:"name"`
+	Data      []byte    `bson:"data"`
+	Timestamp time.Time `bson:"timestamp"`
+	// Event is the decoded event
+	Event Event `bson:"-"`
+}
+
+// EventStore is an append-only store of event streams
+type EventStore interface {
+	// Append adds events to a stream if its current version equals
+	// expectedVersion and returns the new version. It fails with
+	// ErrConcurrencyConflict otherwise.
+	Append(ctx context.Context, streamID string, expectedVersion int64, events ...Event) (int64, error)
+	// Load returns the events of a stream after the given version
+	Load(ctx context.Context, streamID string, afterVersion int64) ([]StoredEvent, error)
+	// ReadAll returns events of all streams after the given global position
+	ReadAll(ctx context.Context, afterPosition int64, limit int) ([]StoredEvent, error)
+}
+
+// MemoryEventStore is an in-memory EventStore
+type MemoryEventStore struct {
+	events   []StoredEvent
+	streams  map[string][]int
+	registry *EventRegistry
+	mu       sync.RWMutex
+}
+
+// NewMemoryEventStore creates a new in-memory event store
+func NewMemoryEventStore() *MemoryEventStore {
+	return &MemoryEventStore{
+		streams:  make(map[string][]int),
+		registry: DefaultEventRegistry,
+	}
+}
+
+// Append implements EventStore
+func (s *MemoryEventStore) Append(ctx context.Context, streamID string, expectedVersion int64, events ...Event) (int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	version := int64(len(s.streams[streamID]))
+	if expectedVersion != AnyVersion && version != expectedVersion {
+		return version, ErrConcurrencyConflict
+	}
+
+	// Encode the whole batch first so a failure appends nothing
+	encoded := make([][]byte, len(events))
+	for i, event := range events {
+		data, err := s.registry.Encode(event)
+		if err != nil {
+			return version, err
+		}
+		encoded[i] = data
+	}
+
+	for i, event := range events {
+		version++
+		s.events = append(s.events, StoredEvent{
+			StreamID:  streamID,
+			Version:   version,
+			Position:  int64(len(s.events) + 1),
+			Name:      event.GetName(),
+			Data:      encoded[i],
+			Timestamp: time.Now().UTC(),
+			Event:     event,
+		})
+		s.streams[streamID] = append(s.streams[streamID], len(s.events)-1)
+	}
+
+	return version, nil
+}
+
+// Load implements EventStore
+func (s *MemoryEventStore) Load(ctx context.Context, streamID string, afterVersion int64) ([]StoredEvent, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	indexes := s.streams[streamID]
+	if afterVersion >= int64(len(indexes)) {
+		return nil, nil
+	}
+	if afterVersion < 0 {
+		afterVersion = 0
+	}
+
+	events := make([]StoredEvent, 0, int64(len(indexes))-afterVersion)
+	for _, index := range indexes[afterVersion:] {
+		events = append(events, s.events[index])
+	}
+	return events, nil
+}
+
+// ReadAll implements EventStore
+func (s *MemoryEventStore) ReadAll(ctx context.Context, afterPosition int64, limit int) ([]StoredEvent, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	if afterPosition < 0 {
+		afterPosition = 0
+	}
+	if afterPosition >= int64(len(s.events)) {
+		return nil, nil
+	}
+
+	events := s.events[afterPosition:]
+	if limit > 0 && len(events) > limit {
+		events = events[:limit]
+	}
+	return append([]StoredEvent(nil), events...), nil
+}
+
+// SQLEventStore is an EventStore stored in a SQL table
+type SQLEventStore struct {
+	db       *sql.DB
+	dialect  SQLDialect
+	table    string
+	registry *EventRegistry
+}
+
+// NewSQLEventStore creates a new SQL event store
+func NewSQLEventStore(db *sql.DB, dialect SQLDialect, table string) *SQLEventStore {
+	if table == "" {
+		table = "events"
+	}
+	return &SQLEventStore{
+		db:       db,
+		dialect:  dialect,
+		table:    table,
+		registry: DefaultEventRegistry,
+	}
+}
+
+// CreateTable creates the events table if it does not exist
+func (s *SQLEventStore) CreateTable() error {
+	_, err := s.db.Exec(fmt.Sprintf(`CREATE TABLE IF NOT EXISTS %s (
+		position %s,
+		stream_id VARCHAR(255) NOT NULL,
+		version BIGINT NOT NULL,
+		event_name VARCHAR(255) NOT NULL,
+		payload %s NOT NULL,
+		created_at TIMESTAMP NOT NULL,
+		UNIQUE (stream_id, version)
+	)`, s.table, s.dialect.SerialPrimaryKey(), s.dialect.BlobType()))
+	return err
+}
+
+// Append implements EventStore. The unique (stream_id, version) constraint
+// rejects concurrent appends that passed the version check at the same time.
+func (s *SQLEventStore) Append(ctx context.Context, streamID string, expectedVersion int64, events ...Event) (int64, error) {
+	var version int64
+	err := RunInTransaction(ctx, s.db, func(tx *sql.Tx) error {
+		current, err := s.version(ctx, tx, streamID)
+		if err != nil {
+			return err
+		}
+		version = current
+		if expectedVersion != AnyVersion && current != expectedVersion {
+			return ErrConcurrencyConflict
+		}
+
+		query := s.dialect.Rebind(fmt.Sprintf(
+			"INSERT INTO %s (stream_id, version, event_name, payload, created_at) VALUES (?, ?, ?, ?, ?)", s.table))
+		for _, event := range events {
+			data, err := s.registry.Encode(event)
+			if err != nil {
+				return err
+			}
+
+			version++
+			if _, err := tx.ExecContext(ctx, query, streamID, version, event.GetName(), data, time.Now().UTC()); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil && err != ErrConcurrencyConflict {
+		// A concurrent append won the race for the unique version
+		if current, versionErr := s.version(ctx, s.db, streamID); versionErr == nil && expectedVersion != AnyVersion && current != expectedVersion {
+			return current, ErrConcurrencyConflict
+		}
+	}
+	return version, err
+}
+
+// Load implements EventStore
+func (s *SQLEventStore) Load(ctx context.Context, streamID string, afterVersion int64) ([]StoredEvent, error) {
+	return s.query(ctx, s.dialect.Rebind(fmt.Sprintf(`SELECT position, stream_id, version, event_name, payload, created_at
+		FROM %s WHERE stream_id = ? AND version > ? ORDER BY version`, s.table)), streamID, afterVersion)
+}
+
+// ReadAll implements EventStore
+func (s *SQLEventStore) ReadAll(ctx context.Context, afterPosition int64, limit int) ([]StoredEvent, error) {
+	query := fmt.Sprintf(`SELECT position, stream_id, version, event_name, payload, created_at
+		FROM %s WHERE position > ? ORDER BY position`, s.table)
+	if limit > 0 {
+		query += fmt.Sprintf(" LIMIT %d", limit)
+	}
+	return s.query(ctx, s.dialect.Rebind(query), afterPosition)
+}
+
+func (s *SQLEventStore) version(ctx context.Context, q interface {
+	QueryRowContext(ctx context.Context, query string, args ...interface{}) *sql.Row
+}, streamID string) (int64, error) {
+	var version sql.NullInt64
+	err := q.QueryRowContext(ctx, s.dialect.Rebind(fmt.Sprintf(
+		"SELECT MAX(version) FROM %s WHERE stream_id = ?", s.table)), streamID).Scan(&version)
+	return version.Int64, err
+}
+
+func (s *SQLEventStore) query(ctx context.Context, query string, args ...interface{}) ([]StoredEvent, error) {
+	rows, err := s.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var events []StoredEvent
+	for rows.Next() {
+		var event StoredEvent
+		if err := rows.Scan(&event.Position, &event.StreamID, &event.Version, &event.Name, &event.Data, &event.Timestamp); err != nil {
+			return nil, err
+		}
+		if event.Event, err = s.registry.Decode(event.Name, event.Data); err != nil {
+			return nil, fmt.Errorf("failed to decode event %s: %v", event.Name, err)
+		}
+		events = append(events, event)
+	}
+	return events, rows.Err()
+}
+
+// MongoEventStore is an EventStore stored in a MongoDB collection.
+// Global positions are allocated from a counter in a "<collection>_sequence"
+// collection inside the append transaction.
+type MongoEventStore struct {
+	collection *mongo.Collection
+	sequence   *mongo.Collection
+	registry   *EventRegistry
+}
+
+// NewMongoEventStore creates a new MongoDB event store
+func NewMongoEventStore(collection *mongo.Collection) *MongoEventStore {
+	return &MongoEventStore{
+		collection: collection,
+		sequence:   collection.Database().Collection(collection.Name() + "_sequence"),
+		registry:   DefaultEventRegistry,
+	}
+}
+
+// CreateIndexes creates the indexes enforcing unique stream versions
+func (s *MongoEventStore) CreateIndexes(ctx context.Context) error {
+	_, err := s.collection.Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{
+			Keys:    bson.D{{Key: "streamId", Value: 1}, {Key: "version", Value: 1}},
+			Options: options.Index().SetUnique(true),
+		},
+		{
+			Keys:    bson.D{{Key: "position", Value: 1}},
+			Options: options.Index().SetUnique(true),
+		},
+	})
+	return err
+}
+
+// Append implements EventStore. Requires the indexes from CreateIndexes to
+// detect concurrent appends. The events and their positions are written in
+// a transaction, joining the one of a session context from
+// MongoDBProvider.WithTransaction, so MongoDB must run as a replica set or
+// sharded cluster.
+func (s *MongoEventStore) Append(ctx context.Context, streamID string, expectedVersion int64, events ...Event) (int64, error) {
+	if mongo.SessionFromContext(ctx) != nil {
+		return s.append(ctx, streamID, expectedVersion, events)
+	}
+
+	session, err := s.collection.Database().Client().StartSession()
+	if err != nil {
+		return 0, err
+	}
+	defer session.EndSession(ctx)
+
+	var version int64
+	_, err = session.WithTransaction(ctx, func(sessCtx mongo.SessionContext) (interface{}, error) {
+		var err error
+		version, err = s.append(sessCtx, streamID, expectedVersion, events)
+		return nil, err
+	})
+	return version, err
+}
+
+func (s *MongoEventStore) append(ctx context.Context, streamID string, expectedVersion int64, events []Event) (int64, error) {
+	version, err := s.version(ctx, streamID)
+	if err != nil {
+		return 0, err
+	}
+	if expectedVersion != AnyVersion && version != expectedVersion {
+		return version, ErrConcurrencyConflict
+	}
+	if len(events) == 0 {
+		return version, nil
+	}
+
+	var counter struct {
+		Value int64 `bson:"value"`
+	}
+	err = s.sequence.FindOneAndUpdate(ctx,
+		bson.D{{Key: "_id", Value: s.collection.Name()}},
+		bson.D{{Key: "$inc", Value: bson.D{{Key: "value", Value: int64(len(events))}}}},
+		options.FindOneAndUpdate().SetUpsert(true).SetReturnDocument(options.After),
+	).Decode(&counter)
+	if err != nil {
+		return version, err
+	}
+
+	position := counter.Value - int64(len(events))
+	documents := make([]interface{}, 0, len(events))
+	for _, event := range events {
+		data, err := s.registry.Encode(event)
+		if err != nil {
+			return version, err
+		}
+
+		version++
+		position++
+		documents = append(documents, StoredEvent{
+			StreamID:  streamID,
+			Version:   version,
+			Position:  position,
+			Name:      event.GetName(),
+			Data:      data,
+			Timestamp: time.Now().UTC(),
+		})
+	}
+
+	if _, err := s.collection.InsertMany(ctx, documents); err != nil {
+		if mongo.IsDuplicateKeyError(err) {
+			return version, ErrConcurrencyConflict
+		}
+		return version, err
+	}
+	return version, nil
+}
+
+// Load implements EventStore
+func (s *MongoEventStore) Load(ctx context.Context, streamID string, afterVersion int64) ([]StoredEvent, error) {
+	return s.find(ctx, bson.D{
+		{Key: "streamId", Value: streamID},
+		{Key: "version", Value: bson.D{{Key: "$gt", Value: afterVersion}}},
+	}, options.Find().SetSort(bson.D{{Key: "version", Value: 1}}))
+}
+
+// ReadAll implements EventStore
+func (s *MongoEventStore) ReadAll(ctx context.Context, afterPosition int64, limit int) ([]StoredEvent, error) {
+	opts := options.Find().SetSort(bson.D{{Key: "position", Value: 1}})
+	if limit > 0 {
+		opts.SetLimit(int64(limit))
+	}
+	return s.find(ctx, bson.D{{Key: "position", Value: bson.D{{Key: "$gt", Value: afterPosition}}}}, opts)
+}
+
+func (s *MongoEventStore) version(ctx context.Context, streamID string) (int64, error) {
+	var last StoredEvent
+	err := s.collection.FindOne(ctx,
+		bson.D{{Key: "streamId", Value: streamID}},
+		options.FindOne().SetSort(bson.D{{Key: "version", Value: -1}}),
+	).Decode(&last)
+	if err == mongo.ErrNoDocuments {
+		return 0, nil
+	}
+	return last.Version, err
+}
+
+func (s *MongoEventStore) find(ctx context.Context, filter bson.D, opts *options.FindOptions) ([]StoredEvent, error) {
+	cursor, err := s.collection.Find(ctx, filter, opts)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	var events []StoredEvent
+	if err := cursor.All(ctx, &events); err != nil {
+		return nil, err
+	}
+	for i := range events {
+		if events[i].Event, err = s.registry.Decode(events[i].Name, events[i].Data); err != nil {
+			return nil, fmt.Errorf("failed to decode event %s: %v", events[i].Name, err)
+		}
+	}
+	return events, nil
+}
@@ -0,0 +1,274 @@
+package core
+
+import (
+	"context"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+type testEvent struct {
+	Name string `json:"name"`
+	N    int    `json:"n"`
+}
+
+func (e *testEvent) GetName() string         { return e.Name }
+func (e *testEvent) GetTimestamp() time.Time { return time.Time{} }
+
+// waitFor fails the test if ch does not deliver within a second
+func waitFor[T any](t *testing.T, ch <-chan T, what string) T {
+	t.Helper()
+	select {
+	case v := <-ch:
+		return v
+	case <-time.After(time.Second):
+		t.Fatalf("timed out waiting for %s", what)
+		var zero T
+		return zero
+	}
+}
+
+func TestEventBusCloseReleasesBlockedPublishers(t *testing.T) {
+	bus := NewAsyncEventBus(AsyncOptions{QueueSize: 1})
+
+	started := make(chan struct{}, 1)
+	release := make(chan struct{})
+	var handled int32
+	bus.Subscribe("job", func(Event) error {
+		select {
+		case started <- struct{}{}:
+		default:
+		}
+		<-release
+		atomic.AddInt32(&handled, 1)
+		return nil
+	})
+
+	bus.Publish(&testEvent{Name: "job"})
+	waitFor(t, started, "the worker")
+	if err := bus.Publish(&testEvent{Name: "job"}); err != nil {
+		t.Fatal(err)
+	}
+
+	// The queue is full, so this publisher blocks
+	published := make(chan error, 1)
+	go func() { published <- bus.Publish(&testEvent{Name: "job"}) }()
+	time.Sleep(10 * time.Millisecond)
+
+	closed := make(chan error, 1)
+	go func() { closed <- bus.Close() }()
+
+	if err := waitFor(t, published, "the blocked publisher"); err != ErrEventBusClosed {
+		t.Fatalf("blocked Publish = %v, want ErrEventBusClosed", err)
+	}
+	if err := bus.Publish(&testEvent{Name: "job"}); err != ErrEventBusClosed {
+		t.Fatalf("Publish after Close = %v, want ErrEventBusClosed", err)
+	}
+
+	close(release)
+	if err := waitFor(t, closed, "Close"); err != nil {
+		t.Fatal(err)
+	}
+	// Queued events are drained before Close returns
+	if n := atomic.LoadInt32(&handled); n != 2 {
+		t.Fatalf("handled %d events, want 2", n)
+	}
+}
+
+func TestEventBusHandlerPublishingDuringClose(t *testing.T) {
+	bus := NewAsyncEventBus(AsyncOptions{QueueSize: 1})
+
+	entered := make(chan struct{})
+	proceed := make(chan struct{})
+	result := make(chan error, 1)
+	bus.Subscribe("outer", func(Event) error {
+		close(entered)
+		<-proceed
+		// Fill the queue, then block on it while the bus closes
+		bus.Publish(&testEvent{Name: "inner"})
+		result <- bus.Publish(&testEvent{Name: "inner"})
+		return nil
+	})
+	bus.Subscribe("inner", func(Event) error { return nil })
+
+	bus.Publish(&testEvent{Name: "outer"})
+	<-entered
+
+	closed := make(chan error, 1)
+	go func() { closed <- bus.Close() }()
+	time.Sleep(10 * time.Millisecond)
+	close(proceed)
+
+	if err := waitFor(t, result, "the handler's publish"); err != ErrEventBusClosed {
+		t.Fatalf("Publish from handler = %v, want ErrEventBusClosed", err)
+	}
+	if err := waitFor(t, closed, "Close"); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestEventBusOverflow(t *testing.T) {
+	release := make(chan struct{})
+	defer close(release)
+
+	var dropped int32
+	drop := NewAsyncEventBus(AsyncOptions{QueueSize: 1, Overflow: OverflowDrop, OnDrop: func(Event) {
+		atomic.AddInt32(&dropped, 1)
+	}})
+	reject := NewAsyncEventBus(AsyncOptions{QueueSize: 1, Overflow: OverflowError})
+
+	for _, bus := range []*EventBus{drop, reject} {
+		started := make(chan struct{}, 1)
+		bus.Subscribe("job", func(Event) error {
+			select {
+			case started <- struct{}{}:
+			default:
+			}
+			<-release
+			return nil
+		})
+		bus.Publish(&testEvent{Name: "job"})
+		waitFor(t, started, "the worker")
+		if err := bus.Publish(&testEvent{Name: "job"}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := drop.Publish(&testEvent{Name: "job"}); err != nil {
+		t.Fatalf("OverflowDrop Publish = %v", err)
+	}
+	if dropped != 1 {
+		t.Fatalf("dropped %d events, want 1", dropped)
+	}
+	if err := reject.Publish(&testEvent{Name: "job"}); err != ErrEventQueueFull {
+		t.Fatalf("OverflowError Publish = %v, want ErrEventQueueFull", err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	block := NewAsyncEventBus(AsyncOptions{})
+	block.Subscribe("job", func(Event) error { <-release; return nil })
+	block.Publish(&testEvent{Name: "job"})
+	if err := block.PublishContext(ctx, &testEvent{Name: "job"}); err != context.DeadlineExceeded {
+		t.Fatalf("OverflowBlock PublishContext = %v, want DeadlineExceeded", err)
+	}
+}
+
+func TestEventBusDeliversQueuedEventsAfterCancel(t *testing.T) {
+	bus := NewAsyncEventBus(AsyncOptions{QueueSize: 10})
+	defer bus.Close()
+
+	release := make(chan struct{})
+	bus.Subscribe("block", func(Event) error { <-release; return nil })
+
+	delivered := make(chan string, 1)
+	bus.Subscribe("job", func(event Event) error {
+		delivered <- event.(*testEvent).Name
+		return nil
+	})
+
+	// The event is accepted while the worker is busy, then the publisher's
+	// context, such as a finished request's, is cancelled
+	bus.Publish(&testEvent{Name: "block"})
+	ctx, cancel := context.WithCancel(context.Background())
+	if err := bus.PublishContext(ctx, &testEvent{Name: "job"}); err != nil {
+		t.Fatal(err)
+	}
+	cancel()
+	close(release)
+
+	if name := waitFor(t, delivered, "the accepted event"); name != "job" {
+		t.Fatalf("delivered %s", name)
+	}
+}
+
+func TestEventBusUnsubscribeStopsQueuedDeliveries(t *testing.T) {
+	bus := NewAsyncEventBus(AsyncOptions{QueueSize: 10})
+	defer bus.Close()
+
+	release := make(chan struct{})
+	bus.Subscribe("block", func(Event) error { <-release; return nil })
+
+	var calls int32
+	sub, _ := bus.Subscribe("job", func(Event) error {
+		atomic.AddInt32(&calls, 1)
+		return nil
+	})
+
+	// The worker is busy, so these events wait in the queue with a
+	// snapshot of the subscriptions taken before Unsubscribe
+	bus.Publish(&testEvent{Name: "block"})
+	for i := 0; i < 3; i++ {
+		bus.Publish(&testEvent{Name: "job"})
+	}
+	if err := sub.Unsubscribe(); err != nil {
+		t.Fatal(err)
+	}
+	close(release)
+	bus.Close()
+
+	if n := atomic.LoadInt32(&calls); n != 0 {
+		t.Fatalf("handler ran %d times after Unsubscribe", n)
+	}
+}
+
+func TestEventBusClearStopsDeliveries(t *testing.T) {
+	bus := NewEventBus()
+
+	var calls int32
+	sub, _ := bus.Subscribe("job", func(Event) error {
+		atomic.AddInt32(&calls, 1)
+		return nil
+	})
+	snapshot := bus.match(&testEvent{Name: "job"})
+
+	bus.ClearAll()
+	bus.dispatch(context.Background(), &testEvent{Name: "job"}, snapshot)
+
+	if calls != 0 || sub.isActive() {
+		t.Fatalf("cleared subscription ran %d times", calls)
+	}
+}
+
+type auditable interface {
+	Event
+	Audit() string
+}
+
+func (e *testEvent) Audit() string { return e.Name }
+
+func TestEventBusDeliversOncePerSubscription(t *testing.T) {
+	bus := NewEventBus()
+
+	var patternCalls, typedCalls, wildcardCalls int32
+	bus.Subscribe("user.*", func(Event) error {
+		atomic.AddInt32(&patternCalls, 1)
+		return nil
+	})
+	On[auditable](bus, func(auditable) error {
+		atomic.AddInt32(&typedCalls, 1)
+		return nil
+	})
+	bus.Subscribe("#", func(Event) error {
+		atomic.AddInt32(&wildcardCalls, 1)
+		return nil
+	})
+
+	// An event literally named like a pattern matches it by name only once
+	if err := bus.Publish(&testEvent{Name: "user.*"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := bus.Publish(&testEvent{Name: "#"}); err != nil {
+		t.Fatal(err)
+	}
+
+	if patternCalls != 1 {
+		t.Errorf("pattern handler ran %d times, want 1", patternCalls)
+	}
+	if typedCalls != 2 {
+		t.Errorf("interface handler ran %d times, want 2", typedCalls)
+	}
+	if wildcardCalls != 2 {
+		t.Errorf("wildcard handler ran %d times, want 2", wildcardCalls)
+	}
+}
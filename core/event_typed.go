@@ -0,0 +1,106 @@
+package core
+
+import (
+	"reflect"
+	"strings"
+)
+
+// On subscribes a typed handler to every published event of type T.
+// When T is an interface, all events implementing it are delivered. Such a
+// subscription listens to "#" and runs a type assertion for every event
+// published on the bus, so prefer concrete types on busy buses.
+func On[T Event](bus *EventBus, handler func(T) error, options ...SubscribeOptions) (*Subscription, error) {
+	var opts SubscribeOptions
+	if len(options) > 0 {
+		opts = options[0]
+	}
+
+	typ := reflect.TypeOf((*T)(nil)).Elem()
+	key := eventTypeKey(typ)
+
+	if typ.Kind() == reflect.Interface {
+		// Interfaces cannot be looked up by dynamic type, so listen to
+		// everything and let the filter pick implementations
+		key = "#"
+		filter := opts.Filter
+		opts.Filter = func(e Event) bool {
+			if _, ok := e.(T); !ok {
+				return false
+			}
+			return filter == nil || filter(e)
+		}
+	}
+
+	return bus.Subscribe(key, func(e Event) error {
+		return handler(e.(T))
+	}, opts)
+}
+
+// OnceOn subscribes a typed handler to the next published event of type T
+func OnceOn[T Event](bus *EventBus, handler func(T) error, options ...SubscribeOptions) (*Subscription, error) {
+	var opts SubscribeOptions
+	if len(options) > 0 {
+		opts = options[0]
+	}
+	opts.Once = true
+	return On[T](bus, handler, opts)
+}
+
+// eventTypeKey returns the subscription key used for typed subscriptions
+func eventTypeKey(typ reflect.Type) string {
+	return "@type:" + typeName(typ)
+}
+
+func typeName(typ reflect.Type) string {
+	if typ.Kind() == reflect.Ptr {
+		return "*" + typeName(typ.Elem())
+	}
+	if typ.Name() != "" && typ.PkgPath() != "" {
+		return typ.PkgPath() + "." + typ.Name()
+	}
+	return typ.String()
+}
+
+// isTopicPattern reports whether an event name contains wildcard segments
+func isTopicPattern(name string) bool {
+	for _, segment := range strings.Split(name, ".") {
+		if segment == "*" || segment == "#" {
+			return true
+		}
+	}
+	return false
+}
+
+// matchTopic matches a dot separated topic against a pattern where "*"
+// matches exactly one segment and "#" matches zero or more segments
+func matchTopic(pattern, topic string) bool {
+	return matchSegments(strings.Split(pattern, "."), strings.Split(topic, "."))
+}
+
+func matchSegments(pattern, topic []string) bool {
+	for len(pattern) > 0 {
+		switch pattern[0] {
+		case "#":
+			if len(pattern) == 1 {
+				return true
+			}
+			for i := 0; i <= len(topic); i++ {
+				if matchSegments(pattern[1:], topic[i:]) {
+					return true
+				}
+			}
+			return false
+		case "*":
+			if len(topic) == 0 {
+				return false
+			}
+		default:
+			if len(topic) == 0 || pattern[0] != topic[0] {
+				return false
+			}
+		}
+		pattern = pattern[1:]
+		topic = topic[1:]
+	}
+	return len(topic) == 0
+}
@@ -1,9 +1,20 @@
 package core
 
 import (
+	"context"
+	"encoding/json"
 	"fmt"
+	"io"
 	"log"
+	"log/slog"
 	"os"
+	"path/filepath"
+	"reflect"
+	"runtime"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/gofiber/fiber/v2"
@@ -20,80 +31,447 @@ const (
 	Fatal
 )
 
-// Logger is a configurable logger
+func (l LogLevel) String() string {
+	switch l {
+	case Debug:
+		return "debug"
+	case Info:
+		return "info"
+	case Warn:
+		return "warn"
+	case Error:
+		return "error"
+	case Fatal:
+		return "fatal"
+	}
+	return fmt.Sprintf("level(%d)", int(l))
+}
+
+// ParseLogLevel parses debug, info, warn, error or fatal
+func ParseLogLevel(level string) (LogLevel, error) {
+	switch strings.ToLower(strings.TrimSpace(level)) {
+	case "debug":
+		return Debug, nil
+	case "info", "":
+		return Info, nil
+	case "warn", "warning":
+		return Warn, nil
+	case "error":
+		return Error, nil
+	case "fatal":
+		return Fatal, nil
+	}
+	return Info, fmt.Errorf("unknown log level %q", level)
+}
+
+// LogFormat is the encoding of log lines
+type LogFormat string
+
+const (
+	// LogFormatText writes "[timestamp] LEVEL message key=value"
+	LogFormatText LogFormat = "text"
+	// LogFormatJSON writes one JSON object per line
+	LogFormatJSON LogFormat = "json"
+	// LogFormatLogfmt writes "time=... level=info msg=... key=value"
+	LogFormatLogfmt LogFormat = "logfmt"
+)
+
+// ParseLogFormat parses text, json or logfmt
+func ParseLogFormat(format string) (LogFormat, error) {
+	switch LogFormat(strings.ToLower(strings.TrimSpace(format))) {
+	case LogFormatText, "":
+		return LogFormatText, nil
+	case LogFormatJSON:
+		return LogFormatJSON, nil
+	case LogFormatLogfmt:
+		return LogFormatLogfmt, nil
+	}
+	return LogFormatText, fmt.Errorf("unknown log format %q", format)
+}
+
+// LoggerOptions defines logger configuration
+type LoggerOptions struct {
+	Level LogLevel
+	// Format defaults to LogFormatText
+	Format LogFormat
+	// Output defaults to os.Stdout
+	Output io.Writer
+	// Caller adds the file and line of the log call
+	Caller bool
+}
+
+// Logger is a configurable logger. Loggers created with With share the
+// level, format and output of their parent.
 type Logger struct {
-	level  LogLevel
+	sink   *logSink
+	fields []logField
+}
+
+// logSink is the state shared by a logger and its children
+type logSink struct {
+	level  int32
+	format LogFormat
+	caller bool
 	output *log.Logger
+	mu     sync.RWMutex
+}
+
+type logField struct {
+	key   string
+	value interface{}
 }
 
 // NewLogger creates a new logger
 func NewLogger(level LogLevel) *Logger {
+	return NewLoggerWithOptions(LoggerOptions{Level: level})
+}
+
+// NewLoggerWithOptions creates a new logger with custom options
+func NewLoggerWithOptions(options LoggerOptions) *Logger {
+	if options.Format == "" {
+		options.Format = LogFormatText
+	}
+	if options.Output == nil {
+		options.Output = os.Stdout
+	}
 	return &Logger{
-		level:  level,
-		output: log.New(os.Stdout, "", 0),
+		sink: &logSink{
+			level:  int32(options.Level),
+			format: options.Format,
+			caller: options.Caller,
+			output: log.New(options.Output, "", 0),
+		},
+	}
+}
+
+// NewLoggerFromConfig creates a logger with the level and format of the
+// application configuration
+func NewLoggerFromConfig(config AppConfig) (*Logger, error) {
+	level, err := ParseLogLevel(config.LogLevel)
+	if err != nil {
+		return nil, err
+	}
+	format, err := ParseLogFormat(config.LogFormat)
+	if err != nil {
+		return nil, err
 	}
+	return NewLoggerWithOptions(LoggerOptions{Level: level, Format: format}), nil
+}
+
+// Level returns the minimum level that is logged
+func (l *Logger) Level() LogLevel {
+	return LogLevel(atomic.LoadInt32(&l.sink.level))
+}
+
+// SetLevel changes the minimum level that is logged, e.g. on config reload
+func (l *Logger) SetLevel(level LogLevel) {
+	atomic.StoreInt32(&l.sink.level, int32(level))
 }
 
 // SetOutput sets the output destination
 func (l *Logger) SetOutput(output *log.Logger) {
-	l.output = output
+	l.sink.mu.Lock()
+	defer l.sink.mu.Unlock()
+	l.sink.output = output
+}
+
+// With returns a logger that adds key-value pairs to every message, e.g.
+// logger.With("user_id", id). Arguments may also be slog.Attr values.
+// The keys time, level, msg and caller are written as fields.time and so on.
+func (l *Logger) With(args ...interface{}) *Logger {
+	return &Logger{sink: l.sink, fields: appendLogFields(l.fields, args)}
 }
 
 // Debug logs a debug message
 func (l *Logger) Debug(format string, args ...interface{}) {
-	if l.level <= Debug {
-		l.log("DEBUG", format, args...)
+	if l.Level() <= Debug {
+		l.logf(Debug, format, args...)
 	}
 }
 
 // Info logs an info message
 func (l *Logger) Info(format string, args ...interface{}) {
-	if l.level <= Info {
-		l.log("INFO", format, args...)
+	if l.Level() <= Info {
+		l.logf(Info, format, args...)
 	}
 }
 
 // Warn logs a warning message
 func (l *Logger) Warn(format string, args ...interface{}) {
-	if l.level <= Warn {
-		l.log("WARN", format, args...)
+	if l.Level() <= Warn {
+		l.logf(Warn, format, args...)
 	}
 }
 
 // Error logs an error message
 func (l *Logger) Error(format string, args ...interface{}) {
-	if l.level <= Error {
-		l.log("ERROR", format, args...)
+	if l.Level() <= Error {
+		l.logf(Error, format, args...)
 	}
 }
 
 // Fatal logs a fatal message and exits
 func (l *Logger) Fatal(format string, args ...interface{}) {
-	l.log("FATAL", format, args...)
+	l.logf(Fatal, format, args...)
 	os.Exit(1)
 }
 
-// log logs a message with the given level
-func (l *Logger) log(level string, format string, args ...interface{}) {
-	message := fmt.Sprintf(format, args...)
-	timestamp := time.Now().Format("2006-01-02 15:04:05")
-	l.output.Printf("[%s] %s %s", timestamp, level, message)
+// logf formats a message, it must be called directly by the exported methods
+func (l *Logger) logf(level LogLevel, format string, args ...interface{}) {
+	var pc uintptr
+	if l.sink.caller {
+		var pcs [1]uintptr
+		// Skip runtime.Callers, logf and the exported method
+		runtime.Callers(3, pcs[:])
+		pc = pcs[0]
+	}
+	l.sink.write(level, fmt.Sprintf(format, args...), l.fields, time.Now(), pc)
+}
+
+func (s *logSink) write(level LogLevel, message string, fields []logField, t time.Time, pc uintptr) {
+	var caller string
+	if s.caller && pc != 0 {
+		frame, _ := runtime.CallersFrames([]uintptr{pc}).Next()
+		caller = filepath.Base(filepath.Dir(frame.File)) + "/" + filepath.Base(frame.File) + ":" + strconv.Itoa(frame.Line)
+	}
+
+	var b strings.Builder
+	switch s.format {
+	case LogFormatJSON:
+		b.WriteString(`{"time":`)
+		writeJSONLogValue(&b, t.Format(time.RFC3339Nano))
+		b.WriteString(`,"level":`)
+		writeJSONLogValue(&b, level.String())
+		b.WriteString(`,"msg":`)
+		writeJSONLogValue(&b, message)
+		if caller != "" {
+			b.WriteString(`,"caller":`)
+			writeJSONLogValue(&b, caller)
+		}
+		for _, field := range fields {
+			b.WriteByte(',')
+			writeJSONLogValue(&b, logFieldKey(field.key))
+			b.WriteByte(':')
+			writeJSONLogValue(&b, field.value)
+		}
+		b.WriteByte('}')
+	case LogFormatLogfmt:
+		fmt.Fprintf(&b, "time=%s level=%s msg=%s", t.Format(time.RFC3339Nano), level, logfmtValue(message))
+		if caller != "" {
+			fmt.Fprintf(&b, " caller=%s", logfmtValue(caller))
+		}
+		writeLogfmtFields(&b, fields)
+	default:
+		fmt.Fprintf(&b, "[%s] %s %s", t.Format("2006-01-02 15:04:05"), strings.ToUpper(level.String()), message)
+		if caller != "" {
+			fmt.Fprintf(&b, " caller=%s", logfmtValue(caller))
+		}
+		writeLogfmtFields(&b, fields)
+	}
+
+	s.mu.RLock()
+	output := s.output
+	s.mu.RUnlock()
+	output.Print(b.String())
+}
+
+func appendLogFields(fields []logField, args []interface{}) []logField {
+	result := make([]logField, len(fields), len(fields)+len(args)/2)
+	copy(result, fields)
+
+	for i := 0; i < len(args); i++ {
+		switch arg := args[i].(type) {
+		case slog.Attr:
+			result = appendLogAttr(result, "", arg)
+		case string:
+			if i+1 < len(args) {
+				result = append(result, logField{key: arg, value: args[i+1]})
+				i++
+			} else {
+				result = append(result, logField{key: "!BADKEY", value: arg})
+			}
+		default:
+			result = append(result, logField{key: "!BADKEY", value: arg})
+		}
+	}
+	return result
+}
+
+// appendLogAttr flattens slog attributes, groups become dotted keys
+func appendLogAttr(fields []logField, prefix string, attr slog.Attr) []logField {
+	attr.Value = attr.Value.Resolve()
+	if attr.Equal(slog.Attr{}) {
+		return fields
+	}
+
+	key := attr.Key
+	if prefix != "" && key != "" {
+		key = prefix + "." + key
+	} else if prefix != "" {
+		key = prefix
+	}
+
+	if attr.Value.Kind() == slog.KindGroup {
+		for _, nested := range attr.Value.Group() {
+			fields = appendLogAttr(fields, key, nested)
+		}
+		return fields
+	}
+	return append(fields, logField{key: key, value: attr.Value.Any()})
+}
+
+// logValue resolves values for encoding
+func logValue(value interface{}) interface{} {
+	switch value.(type) {
+	case slog.LogValuer, error:
+		// Methods of typed nil values such as a nil *MyErr may panic
+		if isNilLogValue(value) {
+			return nil
+		}
+	}
+
+	switch v := value.(type) {
+	case slog.LogValuer:
+		return v.LogValue().Resolve().Any()
+	case error:
+		return v.Error()
+	case time.Duration:
+		return v.String()
+	}
+	return value
+}
+
+func isNilLogValue(value interface{}) bool {
+	v := reflect.ValueOf(value)
+	switch v.Kind() {
+	case reflect.Ptr, reflect.Map, reflect.Slice, reflect.Func, reflect.Chan, reflect.Interface:
+		return v.IsNil()
+	}
+	return false
+}
+
+func writeJSONLogValue(b *strings.Builder, value interface{}) {
+	data, err := json.Marshal(logValue(value))
+	if err != nil {
+		data, _ = json.Marshal(fmt.Sprint(value))
+	}
+	b.Write(data)
+}
+
+func writeLogfmtFields(b *strings.Builder, fields []logField) {
+	for _, field := range fields {
+		fmt.Fprintf(b, " %s=%s", logFieldKey(field.key), logfmtValue(logValue(field.value)))
+	}
+}
+
+// logFieldKey renames fields that would repeat the keys every line starts
+// with, e.g. a "time" field is written as "fields.time"
+func logFieldKey(key string) string {
+	switch key {
+	case "time", "level", "msg", "caller":
+		return "fields." + key
+	}
+	return key
+}
+
+func logfmtValue(value interface{}) string {
+	var s string
+	switch v := value.(type) {
+	case string:
+		s = v
+	case time.Time:
+		s = v.Format(time.RFC3339Nano)
+	default:
+		s = fmt.Sprint(v)
+	}
+
+	if s == "" || strings.ContainsAny(s, " =\"\\\t\r\n") {
+		return strconv.Quote(s)
+	}
+	return s
+}
+
+// Handler returns a log/slog handler writing to the logger, so the logger
+// can be used through slog:
+//
+//	slog.SetDefault(slog.New(logger.Handler()))
+func (l *Logger) Handler() slog.Handler {
+	return &slogHandler{logger: l}
 }
 
-// LogMiddleware creates a logging middleware
+// slogHandler adapts a Logger to slog.Handler
+type slogHandler struct {
+	logger *Logger
+	group  string
+}
+
+// Enabled implements slog.Handler
+func (h *slogHandler) Enabled(_ context.Context, level slog.Level) bool {
+	return h.logger.Level() <= logLevelFromSlog(level)
+}
+
+// Handle implements slog.Handler
+func (h *slogHandler) Handle(_ context.Context, record slog.Record) error {
+	fields := make([]logField, len(h.logger.fields), len(h.logger.fields)+record.NumAttrs())
+	copy(fields, h.logger.fields)
+	record.Attrs(func(attr slog.Attr) bool {
+		fields = appendLogAttr(fields, h.group, attr)
+		return true
+	})
+
+	h.logger.sink.write(logLevelFromSlog(record.Level), record.Message, fields, record.Time, record.PC)
+	return nil
+}
+
+// WithAttrs implements slog.Handler
+func (h *slogHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	fields := append([]logField(nil), h.logger.fields...)
+	for _, attr := range attrs {
+		fields = appendLogAttr(fields, h.group, attr)
+	}
+	return &slogHandler{logger: &Logger{sink: h.logger.sink, fields: fields}, group: h.group}
+}
+
+// WithGroup implements slog.Handler
+func (h *slogHandler) WithGroup(name string) slog.Handler {
+	if name == "" {
+		return h
+	}
+	group := name
+	if h.group != "" {
+		group = h.group + "." + name
+	}
+	return &slogHandler{logger: h.logger, group: group}
+}
+
+func logLevelFromSlog(level slog.Level) LogLevel {
+	switch {
+	case level < slog.LevelInfo:
+		return Debug
+	case level < slog.LevelWarn:
+		return Info
+	case level < slog.LevelError:
+		return Warn
+	default:
+		return Error
+	}
+}
+
+// LogMiddleware creates a logging middleware. Behind RequestIDMiddleware the
+// lines carry the request ID, route and principal, see RequestLogger.
 func LogMiddleware(logger *Logger) fiber.Handler {
 	return func(ctx *fiber.Ctx) error {
 		start := time.Now()
 
 		err := ctx.Next()
 		if err != nil {
-			logger.Error("Request failed: %v", err)
+			RequestLogger(ctx, logger).Error("Request failed: %v", err)
 			return err
 		}
 
 		duration := time.Since(start)
-		logger.Info("%s %s %d %v", ctx.Method(), ctx.Path(), ctx.Response().StatusCode(), duration)
+		RequestLogger(ctx, logger).Info("%s %s %d %v", ctx.Method(), ctx.Path(), ctx.Response().StatusCode(), duration)
 
 		return nil
 	}
-} 
\ No newline at end of file
+}
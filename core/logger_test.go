@@ -0,0 +1,214 @@
+package core
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"strings"
+	"testing"
+	"time"
+)
+
+type testLogError struct{ code int }
+
+func (e *testLogError) Error() string { return fmt.Sprintf("code %d", e.code) }
+
+func newTestLogger(format LogFormat) (*Logger, *bytes.Buffer) {
+	var buf bytes.Buffer
+	return NewLoggerWithOptions(LoggerOptions{Level: Debug, Format: format, Output: &buf}), &buf
+}
+
+func logLines(buf *bytes.Buffer) []string {
+	return strings.Split(strings.TrimSuffix(buf.String(), "\n"), "\n")
+}
+
+func decodeLogLine(t *testing.T, line string) map[string]interface{} {
+	t.Helper()
+	var entry map[string]interface{}
+	if err := json.Unmarshal([]byte(line), &entry); err != nil {
+		t.Fatalf("expected a JSON line, got %q: %v", line, err)
+	}
+	return entry
+}
+
+func TestLoggerJSONFormat(t *testing.T) {
+	logger, buf := newTestLogger(LogFormatJSON)
+
+	var nilErr *testLogError
+	logger.With(
+		"user_id", 7,
+		"err", errors.New("boom"),
+		"nil_err", nilErr,
+		"took", 1500*time.Millisecond,
+		"msg", "field",
+		"level", "field",
+		"time", "field",
+	).Info("login from %s", "10.0.0.1")
+
+	entry := decodeLogLine(t, logLines(buf)[0])
+	expected := map[string]interface{}{
+		"level":        "info",
+		"msg":          "login from 10.0.0.1",
+		"user_id":      float64(7),
+		"err":          "boom",
+		"nil_err":      nil,
+		"took":         "1.5s",
+		"fields.msg":   "field",
+		"fields.level": "field",
+		"fields.time":  "field",
+	}
+	for key, want := range expected {
+		if got, ok := entry[key]; !ok || got != want {
+			t.Errorf("%s: expected %v, got %v", key, want, got)
+		}
+	}
+	if _, err := time.Parse(time.RFC3339Nano, entry["time"].(string)); err != nil {
+		t.Errorf("expected an RFC 3339 time, got %v", entry["time"])
+	}
+	if len(entry) != len(expected)+1 {
+		t.Errorf("expected no duplicate or extra keys, got %v", entry)
+	}
+}
+
+func TestLoggerLogfmtFormat(t *testing.T) {
+	logger, buf := newTestLogger(LogFormatLogfmt)
+
+	var nilErr *testLogError
+	logger.With("path", "/a b", "empty", "", "quote", `say "hi"`, "err", nilErr, "msg", "x").Warn("done")
+
+	line := logLines(buf)[0]
+	if !strings.HasPrefix(line, "time=") {
+		t.Fatalf("expected the line to start with the time, got %q", line)
+	}
+	for _, want := range []string{
+		" level=warn msg=done ",
+		` path="/a b"`,
+		` empty=""`,
+		` quote="say \"hi\""`,
+		" err=<nil>",
+		" fields.msg=x",
+	} {
+		if !strings.Contains(line, want) {
+			t.Errorf("expected %q in %q", want, line)
+		}
+	}
+}
+
+func TestLoggerTextFormatAndCaller(t *testing.T) {
+	var buf bytes.Buffer
+	logger := NewLoggerWithOptions(LoggerOptions{Level: Info, Output: &buf, Caller: true})
+	logger.Debug("hidden")
+	logger.With("n", 1).Error("failed %d", 2)
+
+	lines := logLines(&buf)
+	if len(lines) != 1 {
+		t.Fatalf("expected the debug line to be filtered, got %q", lines)
+	}
+	if !strings.Contains(lines[0], "] ERROR failed 2 caller=core/logger_test.go:") || !strings.HasSuffix(lines[0], " n=1") {
+		t.Fatalf("unexpected text line %q", lines[0])
+	}
+}
+
+func TestLoggerWithChaining(t *testing.T) {
+	parent, buf := newTestLogger(LogFormatJSON)
+	child := parent.With("a", 1)
+	grandchild := child.With("b", 2, slog.String("c", "3"), "dangling")
+
+	parent.Info("parent")
+	child.Info("child")
+	grandchild.Info("grandchild")
+
+	lines := logLines(buf)
+	if entry := decodeLogLine(t, lines[0]); entry["a"] != nil {
+		t.Errorf("expected the parent to stay without fields, got %v", entry)
+	}
+	if entry := decodeLogLine(t, lines[1]); entry["a"] != float64(1) || entry["b"] != nil {
+		t.Errorf("expected the child to have only its own fields, got %v", entry)
+	}
+	entry := decodeLogLine(t, lines[2])
+	if entry["a"] != float64(1) || entry["b"] != float64(2) || entry["c"] != "3" || entry["!BADKEY"] != "dangling" {
+		t.Errorf("expected inherited and new fields, got %v", entry)
+	}
+
+	// Children share the level of their parent
+	parent.SetLevel(Error)
+	grandchild.Info("hidden")
+	if len(logLines(buf)) != 3 {
+		t.Fatal("expected SetLevel on the parent to apply to its children")
+	}
+}
+
+func TestLoggerSlogHandler(t *testing.T) {
+	logger, buf := newTestLogger(LogFormatJSON)
+	logger.SetLevel(Info)
+	base := logger.With("service", "api")
+
+	handler := base.Handler()
+	if handler.Enabled(context.Background(), slog.LevelDebug) || !handler.Enabled(context.Background(), slog.LevelWarn) {
+		t.Fatal("expected Enabled to follow the logger level")
+	}
+
+	log := slog.New(handler).
+		With("request_id", "r1").
+		WithGroup("http").
+		With("method", "GET").
+		WithGroup("response")
+	log.Warn("slow", "status", 200, slog.Group("timing", slog.Int("ms", 950)))
+	log.Debug("hidden")
+
+	lines := logLines(buf)
+	if len(lines) != 1 {
+		t.Fatalf("expected one line, got %q", lines)
+	}
+	entry := decodeLogLine(t, lines[0])
+	expected := map[string]interface{}{
+		"level":                   "warn",
+		"msg":                     "slow",
+		"service":                 "api",
+		"request_id":              "r1",
+		"http.method":             "GET",
+		"http.response.status":    float64(200),
+		"http.response.timing.ms": float64(950),
+	}
+	for key, want := range expected {
+		if got := entry[key]; got != want {
+			t.Errorf("%s: expected %v, got %v", key, want, got)
+		}
+	}
+
+	// WithGroup and WithAttrs leave the logger and earlier handlers unchanged
+	slog.New(handler).Info("plain", "k", "v")
+	entry = decodeLogLine(t, logLines(buf)[1])
+	if entry["k"] != "v" || entry["request_id"] != nil {
+		t.Errorf("expected an ungrouped line without derived attrs, got %v", entry)
+	}
+}
+
+func TestParseLogLevelAndFormat(t *testing.T) {
+	levels := map[string]LogLevel{
+		"debug": Debug, "INFO": Info, "": Info, " warn ": Warn, "Warning": Warn, "error": Error, "fatal": Fatal,
+	}
+	for input, want := range levels {
+		if got, err := ParseLogLevel(input); err != nil || got != want {
+			t.Errorf("ParseLogLevel(%q): expected %v, got %v, %v", input, want, got, err)
+		}
+	}
+	if _, err := ParseLogLevel("verbose"); err == nil {
+		t.Error("expected an unknown level to fail")
+	}
+
+	formats := map[string]LogFormat{
+		"text": LogFormatText, "": LogFormatText, "JSON": LogFormatJSON, " logfmt ": LogFormatLogfmt,
+	}
+	for input, want := range formats {
+		if got, err := ParseLogFormat(input); err != nil || got != want {
+			t.Errorf("ParseLogFormat(%q): expected %v, got %v, %v", input, want, got, err)
+		}
+	}
+	if _, err := ParseLogFormat("xml"); err == nil {
+		t.Error("expected an unknown format to fail")
+	}
+}
@@ -0,0 +1,643 @@
+package core
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/sha512"
+	"crypto/subtle"
+	"encoding/base32"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"hash"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// TOTPAlgorithm represents the HMAC algorithm used for TOTP codes
+type TOTPAlgorithm string
+
+const (
+	TOTPSHA1   TOTPAlgorithm = "SHA1"
+	TOTPSHA256 TOTPAlgorithm = "SHA256"
+	TOTPSHA512 TOTPAlgorithm = "SHA512"
+)
+
+// TOTPOptions defines TOTP configuration
+type TOTPOptions struct {
+	Issuer string
+	// Digits must be between 1 and 9
+	Digits int
+	// Period must be at least one second
+	Period time.Duration
+	// Skew is the number of periods accepted before and after the current
+	// one, defaults to 1. Use NoTOTPSkew to accept the current period only.
+	Skew      int
+	Algorithm TOTPAlgorithm
+}
+
+// NoTOTPSkew disables the drift window of TOTPOptions.Skew
+const NoTOTPSkew = -1
+
+// DefaultTOTPOptions returns the options used by most authenticator apps
+func DefaultTOTPOptions() TOTPOptions {
+	return TOTPOptions{
+		Digits:    6,
+		Period:    30 * time.Second,
+		Skew:      1,
+		Algorithm: TOTPSHA1,
+	}
+}
+
+// GenerateTOTPSecret generates a random base32 encoded secret
+func GenerateTOTPSecret() (string, error) {
+	secret := make([]byte, 20)
+	if _, err := rand.Read(secret); err != nil {
+		return "", err
+	}
+	return base32.StdEncoding.WithPadding(base32.NoPadding).EncodeToString(secret), nil
+}
+
+// TOTPURI builds an otpauth URI that can be rendered as a QR code
+func TOTPURI(secret, account string, opts TOTPOptions) string {
+	opts = opts.withDefaults()
+
+	label := url.PathEscape(account)
+	if opts.Issuer != "" {
+		label = url.PathEscape(opts.Issuer) + ":" + label
+	}
+
+	query := url.Values{}
+	query.Set("secret", secret)
+	if opts.Issuer != "" {
+		query.Set("issuer", opts.Issuer)
+	}
+	query.Set("algorithm", string(opts.Algorithm))
+	query.Set("digits", fmt.Sprintf("%d", opts.Digits))
+	query.Set("period", fmt.Sprintf("%d", int(opts.Period/time.Second)))
+
+	return "otpauth://totp/" + label + "?" + query.Encode()
+}
+
+// GenerateTOTPCode generates the code for the given secret and time
+func GenerateTOTPCode(secret string, t time.Time, opts TOTPOptions) (string, error) {
+	opts = opts.withDefaults()
+	if err := opts.validate(); err != nil {
+		return "", err
+	}
+	return totpCode(secret, totpCounter(t, opts.Period), opts)
+}
+
+func (o TOTPOptions) withDefaults() TOTPOptions {
+	defaults := DefaultTOTPOptions()
+	if o.Digits == 0 {
+		o.Digits = defaults.Digits
+	}
+	if o.Period == 0 {
+		o.Period = defaults.Period
+	}
+	if o.Skew == 0 {
+		o.Skew = defaults.Skew
+	} else if o.Skew < 0 {
+		o.Skew = 0
+	}
+	if o.Algorithm == "" {
+		o.Algorithm = defaults.Algorithm
+	}
+	return o
+}
+
+func (o TOTPOptions) validate() error {
+	if o.Digits < 1 || o.Digits > 9 {
+		return fmt.Errorf("TOTP digits must be between 1 and 9, got %d", o.Digits)
+	}
+	if o.Period < time.Second {
+		return fmt.Errorf("TOTP period must be at least 1s, got %v", o.Period)
+	}
+	return nil
+}
+
+func totpCounter(t time.Time, period time.Duration) int64 {
+	return t.Unix() / int64(period/time.Second)
+}
+
+func totpCode(secret string, counter int64, opts TOTPOptions) (string, error) {
+	key, err := base32.StdEncoding.WithPadding(base32.NoPadding).DecodeString(strings.ToUpper(strings.TrimRight(secret, "=")))
+	if err != nil {
+		return "", fmt.Errorf("invalid TOTP secret: %v", err)
+	}
+
+	var newHash func() hash.Hash
+	switch opts.Algorithm {
+	case TOTPSHA1:
+		newHash = sha1.New
+	case TOTPSHA256:
+		newHash = sha256.New
+	case TOTPSHA512:
+		newHash = sha512.New
+	default:
+		return "", fmt.Errorf("unsupported TOTP algorithm %s", opts.Algorithm)
+	}
+
+	var msg [8]byte
+	binary.BigEndian.PutUint64(msg[:], uint64(counter))
+
+	mac := hmac.New(newHash, key)
+	mac.Write(msg[:])
+	sum := mac.Sum(nil)
+
+	offset := sum[len(sum)-1] & 0x0f
+	value := binary.BigEndian.Uint32(sum[offset:offset+4]) & 0x7fffffff
+
+	mod := uint32(1)
+	for i := 0; i < opts.Digits; i++ {
+		mod *= 10
+	}
+
+	return fmt.Sprintf("%0*d", opts.Digits, value%mod), nil
+}
+
+// MFARecord stores the MFA state of a user
+type MFARecord struct {
+	Secret         string
+	Enabled        bool
+	LastCounter    int64
+	RecoveryCodes  []string
+	VerifiedAt     time.Time
+	FailedAttempts int
+	LockedUntil    time.Time
+}
+
+// MFAStore defines the interface for MFA persistence
+type MFAStore interface {
+	Get(userID string) (*MFARecord, error)
+	Save(userID string, record *MFARecord) error
+	Delete(userID string) error
+}
+
+// MemoryMFAStore is an in-memory MFA store
+type MemoryMFAStore struct {
+	records map[string]MFARecord
+	mu      sync.RWMutex
+}
+
+// NewMemoryMFAStore creates a new in-memory MFA store
+func NewMemoryMFAStore() *MemoryMFAStore {
+	return &MemoryMFAStore{
+		records: make(map[string]MFARecord),
+	}
+}
+
+// Get returns the MFA record of a user
+func (s *MemoryMFAStore) Get(userID string) (*MFARecord, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	record, exists := s.records[userID]
+	if !exists {
+		return nil, ErrMFANotEnrolled
+	}
+
+	record.RecoveryCodes = append([]string(nil), record.RecoveryCodes...)
+	return &record, nil
+}
+
+// Save stores the MFA record of a user
+func (s *MemoryMFAStore) Save(userID string, record *MFARecord) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	stored := *record
+	stored.RecoveryCodes = append([]string(nil), record.RecoveryCodes...)
+	s.records[userID] = stored
+	return nil
+}
+
+// Delete removes the MFA record of a user
+func (s *MemoryMFAStore) Delete(userID string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.records, userID)
+	return nil
+}
+
+// MFAEnrollment is returned when a user starts MFA enrollment
+type MFAEnrollment struct {
+	Secret        string   `json:"secret"`
+	URI           string   `json:"uri"`
+	RecoveryCodes []string `json:"recoveryCodes"`
+}
+
+// MFAOptions defines MFA service configuration
+type MFAOptions struct {
+	TOTP              TOTPOptions
+	RecoveryCodeCount int
+
+	// MaxAttempts failed codes lock verification for LockoutDuration
+	MaxAttempts     int
+	LockoutDuration time.Duration
+}
+
+// MFAService manages TOTP enrollment, verification and recovery codes
+type MFAService struct {
+	store   MFAStore
+	options MFAOptions
+	now     func() time.Time
+	mu      sync.Mutex
+}
+
+// NewMFAService creates a new MFA service
+func NewMFAService(store MFAStore, options MFAOptions) (*MFAService, error) {
+	options.TOTP = options.TOTP.withDefaults()
+	if err := options.TOTP.validate(); err != nil {
+		return nil, err
+	}
+	if options.RecoveryCodeCount == 0 {
+		options.RecoveryCodeCount = 10
+	}
+	if options.MaxAttempts == 0 {
+		options.MaxAttempts = 5
+	}
+	if options.LockoutDuration == 0 {
+		options.LockoutDuration = 15 * time.Minute
+	}
+
+	return &MFAService{
+		store:   store,
+		options: options,
+		now:     time.Now,
+	}, nil
+}
+
+// Enroll generates a new secret and recovery codes for a user.
+// MFA stays disabled until the first code is confirmed.
+func (s *MFAService) Enroll(userID, account string) (*MFAEnrollment, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if record, err := s.store.Get(userID); err == nil && record.Enabled {
+		return nil, ErrMFAAlreadyEnabled
+	}
+
+	secret, err := GenerateTOTPSecret()
+	if err != nil {
+		return nil, err
+	}
+
+	codes, hashed, err := generateRecoveryCodes(s.options.RecoveryCodeCount)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := s.store.Save(userID, &MFARecord{
+		Secret:        secret,
+		RecoveryCodes: hashed,
+	}); err != nil {
+		return nil, err
+	}
+
+	return &MFAEnrollment{
+		Secret:        secret,
+		URI:           TOTPURI(secret, account, s.options.TOTP),
+		RecoveryCodes: codes,
+	}, nil
+}
+
+// Confirm verifies the first code after enrollment and enables MFA
+func (s *MFAService) Confirm(userID, code string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	record, err := s.store.Get(userID)
+	if err != nil {
+		return err
+	}
+
+	if err := s.attempt(userID, record, s.verifyTOTP(record, code)); err != nil {
+		return err
+	}
+
+	record.Enabled = true
+	return s.store.Save(userID, record)
+}
+
+// Verify checks a TOTP code for an enabled user and marks the MFA step as
+// fresh. After MaxAttempts failed codes verification is locked.
+func (s *MFAService) Verify(userID, code string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	record, err := s.enabledRecord(userID)
+	if err != nil {
+		return err
+	}
+
+	if err := s.attempt(userID, record, s.verifyTOTP(record, code)); err != nil {
+		return err
+	}
+
+	return s.store.Save(userID, record)
+}
+
+// VerifyRecoveryCode consumes a one-time recovery code
+func (s *MFAService) VerifyRecoveryCode(userID, code string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	record, err := s.enabledRecord(userID)
+	if err != nil {
+		return err
+	}
+
+	if err := s.attempt(userID, record, verifyRecoveryCode(record, code)); err != nil {
+		return err
+	}
+
+	record.VerifiedAt = s.now()
+	return s.store.Save(userID, record)
+}
+
+func verifyRecoveryCode(record *MFARecord, code string) error {
+	for i, stored := range record.RecoveryCodes {
+		if matchRecoveryCode(stored, code) {
+			record.RecoveryCodes = append(record.RecoveryCodes[:i], record.RecoveryCodes[i+1:]...)
+			return nil
+		}
+	}
+	return ErrMFAInvalidCode
+}
+
+// attempt applies the lockout to the result of a code check. Failures are
+// counted on the record, success clears them.
+func (s *MFAService) attempt(userID string, record *MFARecord, result error) error {
+	now := s.now()
+	if now.Before(record.LockedUntil) {
+		return ErrMFATooManyAttempts
+	}
+
+	if result == nil {
+		record.FailedAttempts = 0
+		record.LockedUntil = time.Time{}
+		return nil
+	}
+	if result != ErrMFAInvalidCode && result != ErrMFACodeReused {
+		return result
+	}
+
+	record.FailedAttempts++
+	if record.FailedAttempts >= s.options.MaxAttempts {
+		record.FailedAttempts = 0
+		record.LockedUntil = now.Add(s.options.LockoutDuration)
+	}
+	if err := s.store.Save(userID, record); err != nil {
+		return err
+	}
+	return result
+}
+
+// RegenerateRecoveryCodes replaces all recovery codes of a user
+func (s *MFAService) RegenerateRecoveryCodes(userID string) ([]string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	record, err := s.enabledRecord(userID)
+	if err != nil {
+		return nil, err
+	}
+
+	codes, hashed, err := generateRecoveryCodes(s.options.RecoveryCodeCount)
+	if err != nil {
+		return nil, err
+	}
+
+	record.RecoveryCodes = hashed
+	if err := s.store.Save(userID, record); err != nil {
+		return nil, err
+	}
+
+	return codes, nil
+}
+
+// RemainingRecoveryCodes returns the number of unused recovery codes
+func (s *MFAService) RemainingRecoveryCodes(userID string) (int, error) {
+	record, err := s.enabledRecord(userID)
+	if err != nil {
+		return 0, err
+	}
+	return len(record.RecoveryCodes), nil
+}
+
+// Disable removes MFA for a user
+func (s *MFAService) Disable(userID string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.store.Delete(userID)
+}
+
+// IsEnabled reports whether a user has confirmed MFA
+func (s *MFAService) IsEnabled(userID string) bool {
+	record, err := s.store.Get(userID)
+	return err == nil && record.Enabled
+}
+
+// IsFresh reports whether the user completed an MFA step within maxAge.
+// A maxAge of 0 or less is never fresh.
+func (s *MFAService) IsFresh(userID string, maxAge time.Duration) bool {
+	record, err := s.enabledRecord(userID)
+	if err != nil || record.VerifiedAt.IsZero() {
+		return false
+	}
+	return s.now().Sub(record.VerifiedAt) <= maxAge
+}
+
+func (s *MFAService) enabledRecord(userID string) (*MFARecord, error) {
+	record, err := s.store.Get(userID)
+	if err != nil {
+		return nil, err
+	}
+	if !record.Enabled {
+		return nil, ErrMFANotEnrolled
+	}
+	return record, nil
+}
+
+// verifyTOTP checks the code against the drift window and rejects counters
+// that were already used, so a captured code cannot be replayed.
+func (s *MFAService) verifyTOTP(record *MFARecord, code string) error {
+	opts := s.options.TOTP
+	now := s.now()
+	current := totpCounter(now, opts.Period)
+
+	for i := -opts.Skew; i <= opts.Skew; i++ {
+		counter := current + int64(i)
+		expected, err := totpCode(record.Secret, counter, opts)
+		if err != nil {
+			return err
+		}
+
+		if subtle.ConstantTimeCompare([]byte(expected), []byte(code)) != 1 {
+			continue
+		}
+
+		if counter <= record.LastCounter {
+			return ErrMFACodeReused
+		}
+
+		record.LastCounter = counter
+		record.VerifiedAt = now
+		return nil
+	}
+
+	return ErrMFAInvalidCode
+}
+
+// generateRecoveryCodes returns codes with 80 random bits each, formatted as
+// xxxx-xxxx-xxxx-xxxx, and their salted hashes
+func generateRecoveryCodes(count int) ([]string, []string, error) {
+	codes := make([]string, count)
+	hashed := make([]string, count)
+
+	for i := 0; i < count; i++ {
+		buf := make([]byte, 10)
+		if _, err := rand.Read(buf); err != nil {
+			return nil, nil, err
+		}
+		raw := strings.ToLower(base32.StdEncoding.WithPadding(base32.NoPadding).EncodeToString(buf))
+		codes[i] = raw[:4] + "-" + raw[4:8] + "-" + raw[8:12] + "-" + raw[12:]
+
+		salt := make([]byte, 16)
+		if _, err := rand.Read(salt); err != nil {
+			return nil, nil, err
+		}
+		hashed[i] = hex.EncodeToString(salt) + ":" + hashRecoveryCode(salt, codes[i])
+	}
+
+	return codes, hashed, nil
+}
+
+// matchRecoveryCode compares a code with a stored salt:hash pair
+func matchRecoveryCode(stored, code string) bool {
+	encodedSalt, hash, found := strings.Cut(stored, ":")
+	if !found {
+		return false
+	}
+	salt, err := hex.DecodeString(encodedSalt)
+	if err != nil {
+		return false
+	}
+	return subtle.ConstantTimeCompare([]byte(hash), []byte(hashRecoveryCode(salt, code))) == 1
+}
+
+func hashRecoveryCode(salt []byte, code string) string {
+	normalized := strings.ToLower(strings.ReplaceAll(strings.TrimSpace(code), "-", ""))
+	sum := sha256.Sum256(append(append([]byte(nil), salt...), normalized...))
+	return hex.EncodeToString(sum[:])
+}
+
+// DefaultMFAMaxAge is how long an MFA step stays fresh for MFAGuard
+const DefaultMFAMaxAge = 15 * time.Minute
+
+// MFAGuard requires a fresh MFA step before a route can be activated
+type MFAGuard struct {
+	Service *MFAService
+	// MaxAge defaults to DefaultMFAMaxAge
+	MaxAge  time.Duration
+	Roles   []Role
+	Subject func(*fiber.Ctx) (string, []Role)
+}
+
+// NewMFAGuard creates a guard for sensitive routes, a maxAge of 0 uses
+// DefaultMFAMaxAge.
+// When roles are given, only users with one of those roles must pass MFA.
+// Users whose roles are unknown, such as plain string IDs, always must.
+func NewMFAGuard(service *MFAService, maxAge time.Duration, roles ...Role) *MFAGuard {
+	return &MFAGuard{
+		Service: service,
+		MaxAge:  maxAge,
+		Roles:   roles,
+		Subject: defaultMFASubject,
+	}
+}
+
+// CanActivate implements Guard
+func (g *MFAGuard) CanActivate(c *fiber.Ctx) error {
+	userID, roles := g.Subject(c)
+	if userID == "" {
+		return fiber.NewError(fiber.StatusUnauthorized, "Unauthorized")
+	}
+
+	// Nil roles are unknown, so the guard fails closed
+	if len(g.Roles) > 0 && roles != nil && !hasAnyRole(roles, g.Roles) {
+		return nil
+	}
+
+	if !g.Service.IsEnabled(userID) {
+		return ErrMFARequired
+	}
+
+	maxAge := g.MaxAge
+	if maxAge <= 0 {
+		maxAge = DefaultMFAMaxAge
+	}
+	if !g.Service.IsFresh(userID, maxAge) {
+		return ErrMFAStepUpRequired
+	}
+
+	return nil
+}
+
+// MFAMiddleware creates a middleware that requires a fresh MFA step
+func MFAMiddleware(guard *MFAGuard) fiber.Handler {
+	return func(ctx *fiber.Ctx) error {
+		if err := guard.CanActivate(ctx); err != nil {
+			return err
+		}
+		return ctx.Next()
+	}
+}
+
+// MFASubject is implemented by user values that expose an ID and roles.
+// GetRoles returns nil when the roles are unknown.
+type MFASubject interface {
+	GetID() string
+	GetRoles() []Role
+}
+
+func defaultMFASubject(c *fiber.Ctx) (string, []Role) {
+	switch user := c.Locals("user").(type) {
+	case MFASubject:
+		return user.GetID(), user.GetRoles()
+	case string:
+		return user, nil
+	default:
+		return "", nil
+	}
+}
+
+func hasAnyRole(have, want []Role) bool {
+	for _, h := range have {
+		for _, w := range want {
+			if h == w {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// MFA errors
+var (
+	ErrMFANotEnrolled     = fiber.NewError(fiber.StatusNotFound, "mfa not enrolled")
+	ErrMFAAlreadyEnabled  = fiber.NewError(fiber.StatusConflict, "mfa already enabled")
+	ErrMFAInvalidCode     = fiber.NewError(fiber.StatusUnauthorized, "invalid mfa code")
+	ErrMFACodeReused      = fiber.NewError(fiber.StatusUnauthorized, "mfa code already used")
+	ErrMFATooManyAttempts = fiber.NewError(fiber.StatusTooManyRequests, "too many failed mfa attempts")
+	ErrMFARequired        = fiber.NewError(fiber.StatusForbidden, "mfa enrollment required")
+	ErrMFAStepUpRequired  = fiber.NewError(fiber.StatusForbidden, "fresh mfa verification required")
+)
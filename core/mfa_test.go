@@ -0,0 +1,266 @@
+package core
+
+import (
+	"net/http/httptest"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+func TestGenerateTOTPCodeRFC6238(t *testing.T) {
+	// RFC 6238 appendix B, secret "12345678901234567890"
+	secret := "GEZDGNBVGY3TQOJQGEZDGNBVGY3TQOJQ"
+	opts := TOTPOptions{Digits: 8}
+
+	tests := []struct {
+		unix int64
+		code string
+	}{
+		{59, "94287082"},
+		{1111111109, "07081804"},
+		{1234567890, "89005924"},
+		{2000000000, "69279037"},
+	}
+	for _, tt := range tests {
+		code, err := GenerateTOTPCode(secret, time.Unix(tt.unix, 0), opts)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if code != tt.code {
+			t.Errorf("code at %d = %s, want %s", tt.unix, code, tt.code)
+		}
+	}
+}
+
+func TestTOTPOptionsValidation(t *testing.T) {
+	for _, opts := range []TOTPOptions{
+		{Digits: 10},
+		{Digits: -1},
+		{Period: 500 * time.Millisecond},
+		{Period: -time.Second},
+	} {
+		if _, err := NewMFAService(NewMemoryMFAStore(), MFAOptions{TOTP: opts}); err == nil {
+			t.Errorf("NewMFAService accepted %+v", opts)
+		}
+		if _, err := GenerateTOTPCode("GEZDGNBV", time.Now(), opts); err == nil {
+			t.Errorf("GenerateTOTPCode accepted %+v", opts)
+		}
+	}
+}
+
+func TestTOTPSkewDefaults(t *testing.T) {
+	if skew := (TOTPOptions{}).withDefaults().Skew; skew != 1 {
+		t.Errorf("default skew = %d, want 1", skew)
+	}
+	if skew := (TOTPOptions{Skew: NoTOTPSkew}).withDefaults().Skew; skew != 0 {
+		t.Errorf("NoTOTPSkew = %d, want 0", skew)
+	}
+}
+
+func newTestMFA(t *testing.T, options MFAOptions) (*MFAService, *time.Time) {
+	t.Helper()
+
+	service, err := NewMFAService(NewMemoryMFAStore(), options)
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := time.Unix(1700000000, 0)
+	service.now = func() time.Time { return now }
+	return service, &now
+}
+
+func enrollTestUser(t *testing.T, service *MFAService, now *time.Time, userID string) string {
+	t.Helper()
+
+	enrollment, err := service.Enroll(userID, userID+"@example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	code, _ := GenerateTOTPCode(enrollment.Secret, *now, service.options.TOTP)
+	if err := service.Confirm(userID, code); err != nil {
+		t.Fatal(err)
+	}
+	*now = now.Add(service.options.TOTP.Period)
+	return enrollment.Secret
+}
+
+func TestMFAVerifyRejectsReplay(t *testing.T) {
+	service, now := newTestMFA(t, MFAOptions{})
+	secret := enrollTestUser(t, service, now, "u1")
+
+	code, _ := GenerateTOTPCode(secret, *now, service.options.TOTP)
+	if err := service.Verify("u1", code); err != nil {
+		t.Fatalf("Verify = %v", err)
+	}
+	if err := service.Verify("u1", code); err != ErrMFACodeReused {
+		t.Fatalf("replayed Verify = %v, want ErrMFACodeReused", err)
+	}
+}
+
+func TestMFAVerifySkew(t *testing.T) {
+	service, now := newTestMFA(t, MFAOptions{TOTP: TOTPOptions{Skew: NoTOTPSkew}})
+	secret := enrollTestUser(t, service, now, "u1")
+
+	previous, _ := GenerateTOTPCode(secret, now.Add(-30*time.Second), service.options.TOTP)
+	if err := service.Verify("u1", previous); err != ErrMFAInvalidCode {
+		t.Fatalf("Verify with previous code = %v, want ErrMFAInvalidCode", err)
+	}
+}
+
+func TestMFAVerifyLockout(t *testing.T) {
+	service, now := newTestMFA(t, MFAOptions{MaxAttempts: 3, LockoutDuration: time.Minute})
+	secret := enrollTestUser(t, service, now, "u1")
+
+	for i := 0; i < 3; i++ {
+		if err := service.Verify("u1", "000000"); err != ErrMFAInvalidCode {
+			t.Fatalf("attempt %d = %v, want ErrMFAInvalidCode", i, err)
+		}
+	}
+
+	code, _ := GenerateTOTPCode(secret, *now, service.options.TOTP)
+	if err := service.Verify("u1", code); err != ErrMFATooManyAttempts {
+		t.Fatalf("Verify while locked = %v, want ErrMFATooManyAttempts", err)
+	}
+	if err := service.VerifyRecoveryCode("u1", "aaaa-aaaa"); err != ErrMFATooManyAttempts {
+		t.Fatalf("VerifyRecoveryCode while locked = %v, want ErrMFATooManyAttempts", err)
+	}
+
+	*now = now.Add(time.Minute)
+	code, _ = GenerateTOTPCode(secret, *now, service.options.TOTP)
+	if err := service.Verify("u1", code); err != nil {
+		t.Fatalf("Verify after lockout = %v", err)
+	}
+}
+
+func TestMFARecoveryCodes(t *testing.T) {
+	service, now := newTestMFA(t, MFAOptions{RecoveryCodeCount: 3})
+	enrollment, err := service.Enroll("u1", "u1@example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	code, _ := GenerateTOTPCode(enrollment.Secret, *now, service.options.TOTP)
+	if err := service.Confirm("u1", code); err != nil {
+		t.Fatal(err)
+	}
+
+	// 16 base32 characters carry 80 bits
+	format := regexp.MustCompile(`^[a-z2-7]{4}-[a-z2-7]{4}-[a-z2-7]{4}-[a-z2-7]{4}$`)
+	for _, code := range enrollment.RecoveryCodes {
+		if !format.MatchString(code) {
+			t.Fatalf("unexpected recovery code %q", code)
+		}
+	}
+
+	// Codes are stored as salt:hash, never as a plain hash of the code
+	record, _ := service.store.Get("u1")
+	salt, hash, found := strings.Cut(record.RecoveryCodes[0], ":")
+	if !found || len(salt) != 32 || hash == hashRecoveryCode(nil, enrollment.RecoveryCodes[0]) {
+		t.Fatalf("unexpected stored recovery code %q", record.RecoveryCodes[0])
+	}
+
+	if err := service.VerifyRecoveryCode("u1", " "+strings.ToUpper(enrollment.RecoveryCodes[1])+" "); err != nil {
+		t.Fatalf("VerifyRecoveryCode = %v", err)
+	}
+	if err := service.VerifyRecoveryCode("u1", enrollment.RecoveryCodes[1]); err != ErrMFAInvalidCode {
+		t.Fatalf("reused recovery code = %v, want ErrMFAInvalidCode", err)
+	}
+	if remaining, _ := service.RemainingRecoveryCodes("u1"); remaining != 2 {
+		t.Fatalf("remaining recovery codes = %d, want 2", remaining)
+	}
+}
+
+func TestMFAGuardDefaultMaxAge(t *testing.T) {
+	service, now := newTestMFA(t, MFAOptions{})
+	enrollTestUser(t, service, now, "u1")
+	guard := &MFAGuard{Service: service, Subject: defaultMFASubject}
+
+	app := fiber.New()
+	app.Get("/", func(c *fiber.Ctx) error {
+		c.Locals("user", "u1")
+		return c.Next()
+	}, MFAMiddleware(guard), func(c *fiber.Ctx) error {
+		return c.SendStatus(fiber.StatusOK)
+	})
+	status := func() int {
+		resp, err := app.Test(httptest.NewRequest("GET", "/", nil))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return resp.StatusCode
+	}
+
+	// Confirming the enrollment is a fresh MFA step
+	if got := status(); got != fiber.StatusOK {
+		t.Fatalf("fresh MFA with MaxAge 0 = %d, want 200", got)
+	}
+	*now = now.Add(DefaultMFAMaxAge + time.Second)
+	if got := status(); got != fiber.StatusForbidden {
+		t.Fatalf("stale MFA with MaxAge 0 = %d, want 403", got)
+	}
+}
+
+type testMFAUser struct {
+	id    string
+	roles []Role
+}
+
+func (u testMFAUser) GetID() string    { return u.id }
+func (u testMFAUser) GetRoles() []Role { return u.roles }
+
+func TestMFAGuard(t *testing.T) {
+	service, now := newTestMFA(t, MFAOptions{})
+	secret := enrollTestUser(t, service, now, "admin")
+	guard := NewMFAGuard(service, 10*time.Minute, RoleAdmin)
+
+	status := func(user interface{}) int {
+		app := fiber.New(fiber.Config{ErrorHandler: func(c *fiber.Ctx, err error) error {
+			return c.SendStatus(err.(*fiber.Error).Code)
+		}})
+		app.Get("/", func(c *fiber.Ctx) error {
+			if user != nil {
+				c.Locals("user", user)
+			}
+			return c.Next()
+		}, MFAMiddleware(guard), func(c *fiber.Ctx) error {
+			return c.SendStatus(fiber.StatusOK)
+		})
+
+		resp, err := app.Test(httptest.NewRequest("GET", "/", nil))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return resp.StatusCode
+	}
+
+	if got := status(nil); got != fiber.StatusUnauthorized {
+		t.Errorf("anonymous = %d, want 401", got)
+	}
+	if got := status(testMFAUser{id: "u2", roles: []Role{RoleUser}}); got != fiber.StatusOK {
+		t.Errorf("user without guarded role = %d, want 200", got)
+	}
+	// Plain string users have unknown roles and must not bypass MFA
+	if got := status("u2"); got != fiber.StatusForbidden {
+		t.Errorf("string user without MFA = %d, want 403", got)
+	}
+
+	*now = now.Add(11 * time.Minute)
+	if got := status("admin"); got != fiber.StatusForbidden {
+		t.Errorf("string admin without fresh MFA = %d, want 403", got)
+	}
+
+	code, _ := GenerateTOTPCode(secret, *now, service.options.TOTP)
+	if err := service.Verify("admin", code); err != nil {
+		t.Fatal(err)
+	}
+	if got := status(testMFAUser{id: "admin", roles: []Role{RoleAdmin}}); got != fiber.StatusOK {
+		t.Errorf("admin with fresh MFA = %d, want 200", got)
+	}
+
+	*now = now.Add(11 * time.Minute)
+	if got := status(testMFAUser{id: "admin", roles: []Role{RoleAdmin}}); got != fiber.StatusForbidden {
+		t.Errorf("admin with stale MFA = %d, want 403", got)
+	}
+}
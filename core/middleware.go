@@ -59,13 +59,23 @@ func GlobalErrorHandler() fiber.Handler {
 	return func(ctx *fiber.Ctx) error {
 		err := ctx.Next()
 		if err != nil {
+			requestID := RequestID(ctx)
+
 			// Log the error
-			fmt.Printf("Error: %v\n", err)
+			if logger, ok := ctx.Locals(LocalsRequestLogger).(*Logger); ok {
+				RequestLogger(ctx, logger).Error("%v", err)
+			} else {
+				fmt.Printf("Error: %v\n", err)
+			}
 
 			// Return appropriate error response
-			return ctx.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			response := fiber.Map{
 				"error": err.Error(),
-			})
+			}
+			if requestID != "" {
+				response["requestId"] = requestID
+			}
+			return ctx.Status(fiber.StatusInternalServerError).JSON(response)
 		}
 		return nil
 	}
@@ -0,0 +1,519 @@
+package core
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"sync"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// IdentifiableEvent is implemented by events carrying a unique ID, which is
+// used as outbox message ID and for idempotent consumers
+type IdentifiableEvent interface {
+	Event
+	GetEventID() string
+}
+
+// EventPublisher publishes events, implemented by EventBus and broker transports
+type EventPublisher interface {
+	Publish(event Event) error
+}
+
+// OutboxMessage is an event stored in the outbox until it has been relayed
+type OutboxMessage struct {
+	ID          string     `bson:"_id"`
+	EventName   string     `bson:"eventName"`
+	Payload     []byte     `bson:"payload"`
+	CreatedAt   time.Time  `bson:"createdAt"`
+	Attempts    int        `bson:"attempts"`
+	LastError   string     `bson:"lastError"`
+	ProcessedAt *time.Time `bson:"processedAt"`
+}
+
+// NewOutboxMessage serializes an event into an outbox message
+func NewOutboxMessage(registry *EventRegistry, event Event) (OutboxMessage, error) {
+	payload, err := registry.Encode(event)
+	if err != nil {
+		return OutboxMessage{}, err
+	}
+
+	id := ""
+	if identifiable, ok := event.(IdentifiableEvent); ok {
+		id = identifiable.GetEventID()
+	}
+	if id == "" {
+		id = generateID()
+	}
+
+	return OutboxMessage{
+		ID:        id,
+		EventName: event.GetName(),
+		Payload:   payload,
+		CreatedAt: time.Now().UTC(),
+	}, nil
+}
+
+// OutboxStore is the relay side of an outbox
+type OutboxStore interface {
+	// Pending returns unprocessed messages that failed less than maxAttempts
+	// times, oldest first. A maxAttempts of 0 returns all unprocessed messages.
+	Pending(ctx context.Context, limit, maxAttempts int) ([]OutboxMessage, error)
+	MarkProcessed(ctx context.Context, id string) error
+	MarkFailed(ctx context.Context, id string, cause error) error
+}
+
+// SQLOutbox is an outbox stored in a SQL table
+type SQLOutbox struct {
+	db       *sql.DB
+	dialect  SQLDialect
+	table    string
+	registry *EventRegistry
+}
+
+// NewSQLOutbox creates a new SQL outbox
+func NewSQLOutbox(db *sql.DB, dialect SQLDialect, table string) *SQLOutbox {
+	if table == "" {
+		table = "outbox"
+	}
+	return &SQLOutbox{
+		db:       db,
+		dialect:  dialect,
+		table:    table,
+		registry: DefaultEventRegistry,
+	}
+}
+
+// CreateTable creates the outbox table if it does not exist
+func (o *SQLOutbox) CreateTable() error {
+	_, err := o.db.Exec(fmt.Sprintf(`CREATE TABLE IF NOT EXISTS %s (
+		id VARCHAR(64) PRIMARY KEY,
+		event_name VARCHAR(255) NOT NULL,
+		payload %s NOT NULL,
+		created_at TIMESTAMP NOT NULL,
+		attempts INT NOT NULL DEFAULT 0,
+		last_error TEXT,
+		processed_at TIMESTAMP NULL
+	)`, o.table, o.dialect.BlobType()))
+	return err
+}
+
+// Add writes events to the outbox inside the caller's transaction, so they
+// are stored if and only if the business change is committed
+func (o *SQLOutbox) Add(ctx context.Context, tx *sql.Tx, events ...Event) error {
+	query := o.dialect.Rebind(fmt.Sprintf(
+		"INSERT INTO %s (id, event_name, payload, created_at, attempts) VALUES (?, ?, ?, ?, 0)", o.table))
+
+	for _, event := range events {
+		msg, err := NewOutboxMessage(o.registry, event)
+		if err != nil {
+			return err
+		}
+		if _, err := tx.ExecContext(ctx, query, msg.ID, msg.EventName, msg.Payload, msg.CreatedAt); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Pending implements OutboxStore
+func (o *SQLOutbox) Pending(ctx context.Context, limit, maxAttempts int) ([]OutboxMessage, error) {
+	where := "processed_at IS NULL"
+	if maxAttempts > 0 {
+		where += fmt.Sprintf(" AND attempts < %d", maxAttempts)
+	}
+
+	rows, err := o.db.QueryContext(ctx, fmt.Sprintf(`SELECT id, event_name, payload, created_at, attempts
+		FROM %s WHERE %s ORDER BY created_at LIMIT %d`, o.table, where, limit))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var messages []OutboxMessage
+	for rows.Next() {
+		var msg OutboxMessage
+		if err := rows.Scan(&msg.ID, &msg.EventName, &msg.Payload, &msg.CreatedAt, &msg.Attempts); err != nil {
+			return nil, err
+		}
+		messages = append(messages, msg)
+	}
+	return messages, rows.Err()
+}
+
+// MarkProcessed implements OutboxStore
+func (o *SQLOutbox) MarkProcessed(ctx context.Context, id string) error {
+	_, err := o.db.ExecContext(ctx, o.dialect.Rebind(fmt.Sprintf(
+		"UPDATE %s SET processed_at = ? WHERE id = ?", o.table)), time.Now().UTC(), id)
+	return err
+}
+
+// MarkFailed implements OutboxStore
+func (o *SQLOutbox) MarkFailed(ctx context.Context, id string, cause error) error {
+	_, err := o.db.ExecContext(ctx, o.dialect.Rebind(fmt.Sprintf(
+		"UPDATE %s SET attempts = attempts + 1, last_error = ? WHERE id = ?", o.table)), cause.Error(), id)
+	return err
+}
+
+// Cleanup deletes messages processed before the given time
+func (o *SQLOutbox) Cleanup(ctx context.Context, before time.Time) error {
+	_, err := o.db.ExecContext(ctx, o.dialect.Rebind(fmt.Sprintf(
+		"DELETE FROM %s WHERE processed_at IS NOT NULL AND processed_at < ?", o.table)), before.UTC())
+	return err
+}
+
+// MongoOutbox is an outbox stored in a MongoDB collection
+type MongoOutbox struct {
+	collection *mongo.Collection
+	registry   *EventRegistry
+}
+
+// NewMongoOutbox creates a new MongoDB outbox
+func NewMongoOutbox(collection *mongo.Collection) *MongoOutbox {
+	return &MongoOutbox{
+		collection: collection,
+		registry:   DefaultEventRegistry,
+	}
+}
+
+// Add writes events to the outbox. Pass the session context from
+// MongoDBProvider.WithTransaction to write them in the same transaction.
+func (o *MongoOutbox) Add(ctx context.Context, events ...Event) error {
+	documents := make([]interface{}, 0, len(events))
+	for _, event := range events {
+		msg, err := NewOutboxMessage(o.registry, event)
+		if err != nil {
+			return err
+		}
+		documents = append(documents, msg)
+	}
+
+	if len(documents) == 0 {
+		return nil
+	}
+
+	_, err := o.collection.InsertMany(ctx, documents)
+	return err
+}
+
+// Pending implements OutboxStore
+func (o *MongoOutbox) Pending(ctx context.Context, limit, maxAttempts int) ([]OutboxMessage, error) {
+	opts := options.Find().
+		SetSort(bson.D{{Key: "createdAt", Value: 1}}).
+		SetLimit(int64(limit))
+
+	filter := bson.D{{Key: "processedAt", Value: nil}}
+	if maxAttempts > 0 {
+		filter = append(filter, bson.E{Key: "attempts", Value: bson.D{{Key: "$lt", Value: maxAttempts}}})
+	}
+
+	cursor, err := o.collection.Find(ctx, filter, opts)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	var messages []OutboxMessage
+	if err := cursor.All(ctx, &messages); err != nil {
+		return nil, err
+	}
+	return messages, nil
+}
+
+// MarkProcessed implements OutboxStore
+func (o *MongoOutbox) MarkProcessed(ctx context.Context, id string) error {
+	_, err := o.collection.UpdateByID(ctx, id, bson.D{{Key: "$set", Value: bson.D{
+		{Key: "processedAt", Value: time.Now().UTC()},
+	}}})
+	return err
+}
+
+// MarkFailed implements OutboxStore
+func (o *MongoOutbox) MarkFailed(ctx context.Context, id string, cause error) error {
+	_, err := o.collection.UpdateByID(ctx, id, bson.D{
+		{Key: "$inc", Value: bson.D{{Key: "attempts", Value: 1}}},
+		{Key: "$set", Value: bson.D{{Key: "lastError", Value: cause.Error()}}},
+	})
+	return err
+}
+
+// OutboxRelayOptions defines outbox relay configuration
+type OutboxRelayOptions struct {
+	Interval  time.Duration
+	BatchSize int
+	// MaxAttempts stops relaying a message after it failed this often, 0 means never
+	MaxAttempts int
+	Registry    *EventRegistry
+	OnError     func(msg OutboxMessage, err error)
+}
+
+// OutboxRelay polls an outbox and forwards its messages to a publisher.
+// Messages are marked processed only after publishing succeeded, so delivery
+// is at-least-once and consumers should be idempotent.
+type OutboxRelay struct {
+	store     OutboxStore
+	publisher EventPublisher
+	options   OutboxRelayOptions
+	cancel    context.CancelFunc
+	done      chan struct{}
+	mu        sync.Mutex
+}
+
+// NewOutboxRelay creates a new outbox relay
+func NewOutboxRelay(store OutboxStore, publisher EventPublisher, options OutboxRelayOptions) *OutboxRelay {
+	if options.Interval == 0 {
+		options.Interval = time.Second
+	}
+	if options.BatchSize == 0 {
+		options.BatchSize = 100
+	}
+	if options.Registry == nil {
+		options.Registry = DefaultEventRegistry
+	}
+
+	return &OutboxRelay{
+		store:     store,
+		publisher: publisher,
+		options:   options,
+	}
+}
+
+// RunOnce relays one batch of pending messages and returns how many were published
+func (r *OutboxRelay) RunOnce(ctx context.Context) (int, error) {
+	messages, err := r.store.Pending(ctx, r.options.BatchSize, r.options.MaxAttempts)
+	if err != nil {
+		return 0, err
+	}
+
+	published := 0
+	for _, msg := range messages {
+		if err := r.relay(msg); err != nil {
+			if r.options.OnError != nil {
+				r.options.OnError(msg, err)
+			}
+			if markErr := r.store.MarkFailed(ctx, msg.ID, err); markErr != nil {
+				return published, markErr
+			}
+			continue
+		}
+
+		if err := r.store.MarkProcessed(ctx, msg.ID); err != nil {
+			return published, err
+		}
+		published++
+	}
+
+	return published, nil
+}
+
+func (r *OutboxRelay) relay(msg OutboxMessage) error {
+	event, err := r.options.Registry.Decode(msg.EventName, msg.Payload)
+	if err != nil {
+		return err
+	}
+	return r.publisher.Publish(event)
+}
+
+// Start polls the outbox in the background until Stop is called
+func (r *OutboxRelay) Start() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.cancel != nil {
+		return
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	r.cancel = cancel
+	r.done = make(chan struct{})
+
+	go func() {
+		defer close(r.done)
+
+		ticker := time.NewTicker(r.options.Interval)
+		defer ticker.Stop()
+
+		for {
+			// Keep draining while full batches are returned
+			for {
+				messages, err := r.RunOnce(ctx)
+				if err != nil && r.options.OnError != nil && ctx.Err() == nil {
+					r.options.OnError(OutboxMessage{}, err)
+				}
+				if err != nil || messages < r.options.BatchSize {
+					break
+				}
+			}
+
+			select {
+			case <-ticker.C:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+}
+
+// Stop stops the background relay and waits for the current batch
+func (r *OutboxRelay) Stop() {
+	r.mu.Lock()
+	cancel, done := r.cancel, r.done
+	r.cancel = nil
+	r.mu.Unlock()
+
+	if cancel != nil {
+		cancel()
+		<-done
+	}
+}
+
+// ProcessedEventStore remembers which events a consumer has handled
+type ProcessedEventStore interface {
+	// Process runs fn unless the event was already processed by consumer,
+	// and records the event ID only when fn succeeds
+	Process(ctx context.Context, consumer, eventID string, fn func(ctx context.Context) error) error
+}
+
+// MemoryProcessedEventStore is an in-memory processed event store
+type MemoryProcessedEventStore struct {
+	processed map[string]bool
+	inFlight  map[string]chan struct{}
+	mu        sync.Mutex
+}
+
+// NewMemoryProcessedEventStore creates a new in-memory processed event store
+func NewMemoryProcessedEventStore() *MemoryProcessedEventStore {
+	return &MemoryProcessedEventStore{
+		processed: make(map[string]bool),
+		inFlight:  make(map[string]chan struct{}),
+	}
+}
+
+// Process implements ProcessedEventStore. Concurrent deliveries of the same
+// event wait for the one in flight and run fn again only if it failed.
+func (s *MemoryProcessedEventStore) Process(ctx context.Context, consumer, eventID string, fn func(ctx context.Context) error) error {
+	key := consumer + "/" + eventID
+
+	for {
+		s.mu.Lock()
+		if s.processed[key] {
+			s.mu.Unlock()
+			return nil
+		}
+		done, busy := s.inFlight[key]
+		if !busy {
+			done = make(chan struct{})
+			s.inFlight[key] = done
+			s.mu.Unlock()
+			break
+		}
+		s.mu.Unlock()
+
+		select {
+		case <-done:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+
+	err := fn(ctx)
+
+	s.mu.Lock()
+	if err == nil {
+		s.processed[key] = true
+	}
+	close(s.inFlight[key])
+	delete(s.inFlight, key)
+	s.mu.Unlock()
+
+	return err
+}
+
+// SQLProcessedEventStore is a processed event store relying on a primary key
+type SQLProcessedEventStore struct {
+	db      *sql.DB
+	dialect SQLDialect
+	table   string
+}
+
+// NewSQLProcessedEventStore creates a new SQL processed event store
+func NewSQLProcessedEventStore(db *sql.DB, dialect SQLDialect, table string) *SQLProcessedEventStore {
+	if table == "" {
+		table = "processed_events"
+	}
+	return &SQLProcessedEventStore{db: db, dialect: dialect, table: table}
+}
+
+// CreateTable creates the processed events table if it does not exist
+func (s *SQLProcessedEventStore) CreateTable() error {
+	_, err := s.db.Exec(fmt.Sprintf(`CREATE TABLE IF NOT EXISTS %s (
+		consumer VARCHAR(255) NOT NULL,
+		event_id VARCHAR(64) NOT NULL,
+		processed_at TIMESTAMP NOT NULL,
+		PRIMARY KEY (consumer, event_id)
+	)`, s.table))
+	return err
+}
+
+// Process implements ProcessedEventStore. fn runs inside a transaction that
+// also records the event ID, available through TxFromContext, so the
+// handler's writes and the marker are committed together. When a concurrent
+// delivery commits the same event first, this transaction is rolled back.
+func (s *SQLProcessedEventStore) Process(ctx context.Context, consumer, eventID string, fn func(ctx context.Context) error) error {
+	processed, err := s.processed(ctx, consumer, eventID)
+	if err != nil || processed {
+		return err
+	}
+
+	err = RunInTransaction(ctx, s.db, func(tx *sql.Tx) error {
+		if err := fn(ContextWithTx(ctx, tx)); err != nil {
+			return err
+		}
+		_, err := tx.ExecContext(ctx, s.dialect.Rebind(fmt.Sprintf(
+			"INSERT INTO %s (consumer, event_id, processed_at) VALUES (?, ?, ?)", s.table)),
+			consumer, eventID, time.Now().UTC())
+		return err
+	})
+	if err != nil {
+		// A concurrent delivery may have recorded the same event first
+		if processed, checkErr := s.processed(ctx, consumer, eventID); checkErr == nil && processed {
+			return nil
+		}
+		return err
+	}
+	return nil
+}
+
+func (s *SQLProcessedEventStore) processed(ctx context.Context, consumer, eventID string) (bool, error) {
+	var exists int
+	err := s.db.QueryRowContext(ctx, s.dialect.Rebind(fmt.Sprintf(
+		"SELECT 1 FROM %s WHERE consumer = ? AND event_id = ?", s.table)), consumer, eventID).Scan(&exists)
+	if err == sql.ErrNoRows {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+// IdempotentHandler wraps a handler so each event ID is handled once per
+// consumer. The event is recorded only after handler succeeds, so a crash
+// or failure leads to redelivery rather than a lost event. With a SQL store
+// handler runs in the store's transaction and should write through
+// TxFromContext. Events must implement IdentifiableEvent, others are always handled.
+func IdempotentHandler(store ProcessedEventStore, consumer string, handler func(ctx context.Context, event Event) error) EventHandler {
+	return func(event Event) error {
+		ctx := context.Background()
+
+		identifiable, ok := event.(IdentifiableEvent)
+		if !ok || identifiable.GetEventID() == "" {
+			return handler(ctx, event)
+		}
+
+		return store.Process(ctx, consumer, identifiable.GetEventID(), func(ctx context.Context) error {
+			return handler(ctx, event)
+		})
+	}
+}
@@ -0,0 +1,209 @@
+package core
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	_ "modernc.org/sqlite"
+)
+
+type orderPlaced struct {
+	ID      string `json:"id"`
+	OrderID string `json:"orderId"`
+}
+
+func (e *orderPlaced) GetName() string         { return "order.placed" }
+func (e *orderPlaced) GetTimestamp() time.Time { return time.Time{} }
+func (e *orderPlaced) GetEventID() string      { return e.ID }
+
+// openTestDB opens a SQLite database in a temporary directory
+func openTestDB(t *testing.T) *sql.DB {
+	t.Helper()
+	db, err := sql.Open("sqlite", "file:"+filepath.Join(t.TempDir(), "test.db")+"?_pragma=busy_timeout(5000)")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+	return db
+}
+
+func countRows(t *testing.T, db *sql.DB, table string) int {
+	t.Helper()
+	var n int
+	if err := db.QueryRow("SELECT COUNT(*) FROM " + table).Scan(&n); err != nil {
+		t.Fatal(err)
+	}
+	return n
+}
+
+type publisherFunc func(Event) error
+
+func (f publisherFunc) Publish(event Event) error { return f(event) }
+
+func TestSQLOutboxRelaysCommittedEvents(t *testing.T) {
+	ctx := context.Background()
+	db := openTestDB(t)
+	outbox := NewSQLOutbox(db, SQLDialectSQLite, "outbox")
+	if err := outbox.CreateTable(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.Exec("CREATE TABLE orders (id TEXT PRIMARY KEY)"); err != nil {
+		t.Fatal(err)
+	}
+
+	place := func(id string, fail bool) error {
+		return RunInTransaction(ctx, db, func(tx *sql.Tx) error {
+			if _, err := tx.ExecContext(ctx, "INSERT INTO orders (id) VALUES (?)", id); err != nil {
+				return err
+			}
+			if err := outbox.Add(ctx, tx, &orderPlaced{ID: "evt-" + id, OrderID: id}); err != nil {
+				return err
+			}
+			if fail {
+				return errTestHandler
+			}
+			return nil
+		})
+	}
+	if err := place("1", false); err != nil {
+		t.Fatal(err)
+	}
+	if err := place("2", true); err == nil {
+		t.Fatal("expected the transaction to fail")
+	}
+
+	registry := NewEventRegistry()
+	registry.Register(&orderPlaced{})
+
+	failing := true
+	var received []*orderPlaced
+	relay := NewOutboxRelay(outbox, publisherFunc(func(event Event) error {
+		if failing {
+			return errTestHandler
+		}
+		received = append(received, event.(*orderPlaced))
+		return nil
+	}), OutboxRelayOptions{Registry: registry})
+
+	// A failed publish keeps the message for the next run
+	if n, err := relay.RunOnce(ctx); err != nil || n != 0 {
+		t.Fatalf("expected nothing published, got %d, %v", n, err)
+	}
+	failing = false
+	if n, err := relay.RunOnce(ctx); err != nil || n != 1 {
+		t.Fatalf("expected one message published, got %d, %v", n, err)
+	}
+	if n, err := relay.RunOnce(ctx); err != nil || n != 0 {
+		t.Fatalf("expected the outbox to be drained, got %d, %v", n, err)
+	}
+
+	if len(received) != 1 || received[0].OrderID != "1" || received[0].ID != "evt-1" {
+		t.Fatalf("unexpected events %+v", received)
+	}
+}
+
+func TestSQLIdempotentHandlerCommitsWithHandler(t *testing.T) {
+	db := openTestDB(t)
+	store := NewSQLProcessedEventStore(db, SQLDialectSQLite, "")
+	if err := store.CreateTable(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.Exec("CREATE TABLE charges (order_id TEXT)"); err != nil {
+		t.Fatal(err)
+	}
+
+	fail := true
+	calls := 0
+	handler := IdempotentHandler(store, "billing", func(ctx context.Context, event Event) error {
+		calls++
+		tx, ok := TxFromContext(ctx)
+		if !ok {
+			t.Fatal("expected a transaction in the context")
+		}
+		if _, err := tx.ExecContext(ctx, "INSERT INTO charges (order_id) VALUES (?)", event.(*orderPlaced).OrderID); err != nil {
+			return err
+		}
+		if fail {
+			return errTestHandler
+		}
+		return nil
+	})
+
+	event := &orderPlaced{ID: "evt-1", OrderID: "1"}
+
+	// The failed attempt rolls back its write and is not recorded
+	if err := handler(event); !errors.Is(err, errTestHandler) {
+		t.Fatalf("expected the handler error, got %v", err)
+	}
+	if n := countRows(t, db, "charges"); n != 0 {
+		t.Fatalf("expected the charge to be rolled back, got %d", n)
+	}
+
+	fail = false
+	for i := 0; i < 2; i++ {
+		if err := handler(event); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if calls != 2 {
+		t.Fatalf("expected the redelivery to be skipped, got %d calls", calls)
+	}
+	if n := countRows(t, db, "charges"); n != 1 {
+		t.Fatalf("expected one charge, got %d", n)
+	}
+	if n := countRows(t, db, "processed_events"); n != 1 {
+		t.Fatalf("expected one processed marker, got %d", n)
+	}
+}
+
+func TestMemoryIdempotentHandlerRetriesAfterFailure(t *testing.T) {
+	store := NewMemoryProcessedEventStore()
+
+	started := make(chan struct{})
+	release := make(chan struct{})
+	var calls, succeeded int32
+	handler := IdempotentHandler(store, "billing", func(ctx context.Context, event Event) error {
+		// The first delivery fails after a concurrent redelivery arrived
+		if atomic.AddInt32(&calls, 1) == 1 {
+			close(started)
+			<-release
+			return errTestHandler
+		}
+		atomic.AddInt32(&succeeded, 1)
+		return nil
+	})
+
+	event := &orderPlaced{ID: "evt-1"}
+	errs := make([]error, 2)
+	var wg sync.WaitGroup
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		errs[0] = handler(event)
+	}()
+	<-started
+	go func() {
+		defer wg.Done()
+		errs[1] = handler(event)
+	}()
+	time.Sleep(10 * time.Millisecond)
+	close(release)
+	wg.Wait()
+
+	if !errors.Is(errs[0], errTestHandler) || errs[1] != nil {
+		t.Fatalf("unexpected results %v", errs)
+	}
+	if calls != 2 || succeeded != 1 {
+		t.Fatalf("expected the redelivery to run after the failure, got calls=%d succeeded=%d", calls, succeeded)
+	}
+
+	if err := handler(event); err != nil || calls != 2 {
+		t.Fatalf("expected a processed event to be skipped, got %v after %d calls", err, calls)
+	}
+}
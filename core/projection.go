@@ -0,0 +1,357 @@
+package core
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"sync"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// Projection builds a read model from stored events
+type Projection interface {
+	Handle(ctx context.Context, event StoredEvent) error
+}
+
+// ProjectionFunc adapts a function to a Projection
+type ProjectionFunc func(ctx context.Context, event StoredEvent) error
+
+// Handle implements Projection
+func (f ProjectionFunc) Handle(ctx context.Context, event StoredEvent) error {
+	return f(ctx, event)
+}
+
+// ResettableProjection is implemented by projections that can clear their
+// read model before a rebuild
+type ResettableProjection interface {
+	Projection
+	Reset(ctx context.Context) error
+}
+
+// CheckpointStore remembers the last global position handled by each projection
+type CheckpointStore interface {
+	// LoadCheckpoint returns 0 when the projection has no checkpoint
+	LoadCheckpoint(ctx context.Context, name string) (int64, error)
+	SaveCheckpoint(ctx context.Context, name string, position int64) error
+}
+
+// MemoryCheckpointStore is an in-memory CheckpointStore
+type MemoryCheckpointStore struct {
+	checkpoints map[string]int64
+	mu          sync.RWMutex
+}
+
+// NewMemoryCheckpointStore creates a new in-memory checkpoint store
+func NewMemoryCheckpointStore() *MemoryCheckpointStore {
+	return &MemoryCheckpointStore{
+		checkpoints: make(map[string]int64),
+	}
+}
+
+// LoadCheckpoint implements CheckpointStore
+func (s *MemoryCheckpointStore) LoadCheckpoint(ctx context.Context, name string) (int64, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.checkpoints[name], nil
+}
+
+// SaveCheckpoint implements CheckpointStore
+func (s *MemoryCheckpointStore) SaveCheckpoint(ctx context.Context, name string, position int64) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.checkpoints[name] = position
+	return nil
+}
+
+// SQLCheckpointStore is a CheckpointStore stored in a SQL table
+type SQLCheckpointStore struct {
+	db      *sql.DB
+	dialect SQLDialect
+	table   string
+}
+
+// NewSQLCheckpointStore creates a new SQL checkpoint store
+func NewSQLCheckpointStore(db *sql.DB, dialect SQLDialect, table string) *SQLCheckpointStore {
+	if table == "" {
+		table = "projection_checkpoints"
+	}
+	return &SQLCheckpointStore{db: db, dialect: dialect, table: table}
+}
+
+// CreateTable creates the checkpoints table if it does not exist
+func (s *SQLCheckpointStore) CreateTable() error {
+	_, err := s.db.Exec(fmt.Sprintf(`CREATE TABLE IF NOT EXISTS %s (
+		name VARCHAR(255) PRIMARY KEY,
+		position BIGINT NOT NULL,
+		updated_at TIMESTAMP NOT NULL
+	)`, s.table))
+	return err
+}
+
+// LoadCheckpoint implements CheckpointStore
+func (s *SQLCheckpointStore) LoadCheckpoint(ctx context.Context, name string) (int64, error) {
+	var position int64
+	err := s.db.QueryRowContext(ctx, s.dialect.Rebind(fmt.Sprintf(
+		"SELECT position FROM %s WHERE name = ?", s.table)), name).Scan(&position)
+	if err == sql.ErrNoRows {
+		return 0, nil
+	}
+	return position, err
+}
+
+// SaveCheckpoint implements CheckpointStore
+func (s *SQLCheckpointStore) SaveCheckpoint(ctx context.Context, name string, position int64) error {
+	return RunInTransaction(ctx, s.db, func(tx *sql.Tx) error {
+		result, err := tx.ExecContext(ctx, s.dialect.Rebind(fmt.Sprintf(
+			"UPDATE %s SET position = ?, updated_at = ? WHERE name = ?", s.table)), position, time.Now().UTC(), name)
+		if err != nil {
+			return err
+		}
+		if affected, err := result.RowsAffected(); err != nil || affected > 0 {
+			return err
+		}
+		_, err = tx.ExecContext(ctx, s.dialect.Rebind(fmt.Sprintf(
+			"INSERT INTO %s (name, position, updated_at) VALUES (?, ?, ?)", s.table)), name, position, time.Now().UTC())
+		return err
+	})
+}
+
+// MongoCheckpointStore is a CheckpointStore stored in a MongoDB collection
+type MongoCheckpointStore struct {
+	collection *mongo.Collection
+}
+
+// NewMongoCheckpointStore creates a new MongoDB checkpoint store
+func NewMongoCheckpointStore(collection *mongo.Collection) *MongoCheckpointStore {
+	return &MongoCheckpointStore{collection: collection}
+}
+
+// LoadCheckpoint implements CheckpointStore
+func (s *MongoCheckpointStore) LoadCheckpoint(ctx context.Context, name string) (int64, error) {
+	var checkpoint struct {
+		Position int64 `bson:"position"`
+	}
+	err := s.collection.FindOne(ctx, bson.D{{Key: "_id", Value: name}}).Decode(&checkpoint)
+	if err == mongo.ErrNoDocuments {
+		return 0, nil
+	}
+	return checkpoint.Position, err
+}
+
+// SaveCheckpoint implements CheckpointStore
+func (s *MongoCheckpointStore) SaveCheckpoint(ctx context.Context, name string, position int64) error {
+	_, err := s.collection.UpdateOne(ctx,
+		bson.D{{Key: "_id", Value: name}},
+		bson.D{{Key: "$set", Value: bson.D{
+			{Key: "position", Value: position},
+			{Key: "updatedAt", Value: time.Now().UTC()},
+		}}},
+		options.Update().SetUpsert(true))
+	return err
+}
+
+// ProjectionRunnerOptions defines projection runner configuration
+type ProjectionRunnerOptions struct {
+	Interval  time.Duration
+	BatchSize int
+	// GapTimeout is how long the runner waits for a missing global position
+	// before skipping it, defaults to 5 seconds. Positions are allocated
+	// before commit, so a concurrent append can become visible after higher
+	// positions. Negative disables waiting.
+	GapTimeout time.Duration
+	// OnError is called when the projection fails, the runner retries the
+	// same event on the next poll
+	OnError func(event StoredEvent, err error)
+}
+
+// ProjectionRunner feeds events from an EventStore to a projection and
+// tracks its checkpoint, so a restarted runner continues where it stopped
+type ProjectionRunner struct {
+	name        string
+	store       EventStore
+	projection  Projection
+	checkpoints CheckpointStore
+	options     ProjectionRunnerOptions
+	cancel      context.CancelFunc
+	done        chan struct{}
+	mu          sync.Mutex
+	runMu       sync.Mutex
+
+	// gapAfter and gapSince track the position gap the runner waits on
+	gapAfter int64
+	gapSince time.Time
+	now      func() time.Time
+}
+
+// NewProjectionRunner creates a new projection runner
+func NewProjectionRunner(name string, store EventStore, projection Projection, checkpoints CheckpointStore, options ProjectionRunnerOptions) *ProjectionRunner {
+	if options.Interval == 0 {
+		options.Interval = time.Second
+	}
+	if options.BatchSize == 0 {
+		options.BatchSize = 100
+	}
+	if options.GapTimeout == 0 {
+		options.GapTimeout = 5 * time.Second
+	}
+
+	return &ProjectionRunner{
+		name:        name,
+		store:       store,
+		projection:  projection,
+		checkpoints: checkpoints,
+		options:     options,
+		now:         time.Now,
+	}
+}
+
+// RunOnce projects one batch of events and returns how many were handled
+func (r *ProjectionRunner) RunOnce(ctx context.Context) (int, error) {
+	r.runMu.Lock()
+	defer r.runMu.Unlock()
+
+	return r.runOnce(ctx)
+}
+
+func (r *ProjectionRunner) runOnce(ctx context.Context) (int, error) {
+	position, err := r.checkpoints.LoadCheckpoint(ctx, r.name)
+	if err != nil {
+		return 0, err
+	}
+
+	events, err := r.store.ReadAll(ctx, position, r.options.BatchSize)
+	if err != nil {
+		return 0, err
+	}
+
+	for i, event := range events {
+		if r.waitForGap(position, event) {
+			return i, nil
+		}
+
+		if err := r.projection.Handle(ctx, event); err != nil {
+			if r.options.OnError != nil {
+				r.options.OnError(event, err)
+			}
+			return i, fmt.Errorf("projection %s failed at position %d: %v", r.name, event.Position, err)
+		}
+		if err := r.checkpoints.SaveCheckpoint(ctx, r.name, event.Position); err != nil {
+			return i, err
+		}
+		position = event.Position
+	}
+	return len(events), nil
+}
+
+// waitForGap reports whether the runner has to stop before event because
+// the positions between the checkpoint and event are not visible yet. A gap
+// is skipped once it has been seen for GapTimeout, or right away when event
+// is older than that, since the missing append then never committed.
+func (r *ProjectionRunner) waitForGap(position int64, event StoredEvent) bool {
+	if event.Position == position+1 || r.options.GapTimeout < 0 {
+		r.gapSince = time.Time{}
+		return false
+	}
+
+	now := r.now()
+	if event.Timestamp.Before(now.Add(-r.options.GapTimeout)) {
+		r.gapSince = time.Time{}
+		return false
+	}
+
+	if r.gapSince.IsZero() || r.gapAfter != position {
+		r.gapAfter, r.gapSince = position, now
+		return true
+	}
+	if now.Sub(r.gapSince) < r.options.GapTimeout {
+		return true
+	}
+
+	r.gapSince = time.Time{}
+	return false
+}
+
+// CatchUp projects events until the projection reached the end of the store
+func (r *ProjectionRunner) CatchUp(ctx context.Context) error {
+	r.runMu.Lock()
+	defer r.runMu.Unlock()
+
+	return r.catchUp(ctx)
+}
+
+func (r *ProjectionRunner) catchUp(ctx context.Context) error {
+	for {
+		handled, err := r.runOnce(ctx)
+		if err != nil {
+			return err
+		}
+		if handled < r.options.BatchSize {
+			return nil
+		}
+	}
+}
+
+// Rebuild resets the read model and checkpoint, then replays every event
+func (r *ProjectionRunner) Rebuild(ctx context.Context) error {
+	r.runMu.Lock()
+	defer r.runMu.Unlock()
+
+	if resettable, ok := r.projection.(ResettableProjection); ok {
+		if err := resettable.Reset(ctx); err != nil {
+			return err
+		}
+	}
+	if err := r.checkpoints.SaveCheckpoint(ctx, r.name, 0); err != nil {
+		return err
+	}
+	return r.catchUp(ctx)
+}
+
+// Start polls the event store in the background until Stop is called
+func (r *ProjectionRunner) Start() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.cancel != nil {
+		return
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	r.cancel = cancel
+	r.done = make(chan struct{})
+
+	go func() {
+		defer close(r.done)
+
+		ticker := time.NewTicker(r.options.Interval)
+		defer ticker.Stop()
+
+		for {
+			// Failed events are retried on the next tick
+			r.CatchUp(ctx)
+
+			select {
+			case <-ticker.C:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+}
+
+// Stop stops the background runner and waits for the current batch
+func (r *ProjectionRunner) Stop() {
+	r.mu.Lock()
+	cancel, done := r.cancel, r.done
+	r.cancel = nil
+	r.mu.Unlock()
+
+	if cancel != nil {
+		cancel()
+		<-done
+	}
+}
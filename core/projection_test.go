@@ -0,0 +1,189 @@
+package core
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"sort"
+	"testing"
+	"time"
+)
+
+// gapEventStore serves events with arbitrary global positions
+type gapEventStore struct {
+	events []StoredEvent
+}
+
+func (s *gapEventStore) add(position int64, timestamp time.Time) {
+	s.events = append(s.events, StoredEvent{Position: position, Name: "job", Timestamp: timestamp})
+	sort.Slice(s.events, func(i, j int) bool { return s.events[i].Position < s.events[j].Position })
+}
+
+func (s *gapEventStore) Append(ctx context.Context, streamID string, expectedVersion int64, events ...Event) (int64, error) {
+	return 0, errors.New("not supported")
+}
+
+func (s *gapEventStore) Load(ctx context.Context, streamID string, afterVersion int64) ([]StoredEvent, error) {
+	return nil, nil
+}
+
+func (s *gapEventStore) ReadAll(ctx context.Context, afterPosition int64, limit int) ([]StoredEvent, error) {
+	var events []StoredEvent
+	for _, event := range s.events {
+		if event.Position > afterPosition && (limit <= 0 || len(events) < limit) {
+			events = append(events, event)
+		}
+	}
+	return events, nil
+}
+
+func newGapRunner(store EventStore, handled *[]int64, now *time.Time) *ProjectionRunner {
+	runner := NewProjectionRunner("test", store, ProjectionFunc(func(ctx context.Context, event StoredEvent) error {
+		*handled = append(*handled, event.Position)
+		return nil
+	}), NewMemoryCheckpointStore(), ProjectionRunnerOptions{})
+	runner.now = func() time.Time { return *now }
+	return runner
+}
+
+func TestProjectionRunnerWaitsForPositionGap(t *testing.T) {
+	ctx := context.Background()
+	now := time.Now()
+	store := &gapEventStore{}
+	store.add(1, now)
+	store.add(2, now)
+	store.add(4, now)
+
+	var handled []int64
+	runner := newGapRunner(store, &handled, &now)
+
+	if err := runner.CatchUp(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if len(handled) != 2 {
+		t.Fatalf("expected the runner to stop before the gap, got %v", handled)
+	}
+
+	// The concurrent append commits and fills the gap
+	store.add(3, now)
+	now = now.Add(time.Second)
+	if err := runner.CatchUp(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if len(handled) != 4 || handled[2] != 3 || handled[3] != 4 {
+		t.Fatalf("expected positions in order, got %v", handled)
+	}
+}
+
+func TestProjectionRunnerSkipsGapAfterTimeout(t *testing.T) {
+	ctx := context.Background()
+	now := time.Now()
+	store := &gapEventStore{}
+	store.add(1, now)
+	store.add(3, now)
+
+	var handled []int64
+	runner := newGapRunner(store, &handled, &now)
+
+	runner.CatchUp(ctx)
+	now = now.Add(4 * time.Second)
+	runner.CatchUp(ctx)
+	if len(handled) != 1 {
+		t.Fatalf("expected the runner to wait within the timeout, got %v", handled)
+	}
+
+	// The append never committed, e.g. it was rolled back
+	now = now.Add(2 * time.Second)
+	runner.CatchUp(ctx)
+	if len(handled) != 2 || handled[1] != 3 {
+		t.Fatalf("expected the gap to be skipped, got %v", handled)
+	}
+}
+
+func TestProjectionRunnerSkipsOldGaps(t *testing.T) {
+	now := time.Now()
+	store := &gapEventStore{}
+	store.add(2, now.Add(-time.Minute))
+	store.add(5, now.Add(-time.Minute))
+
+	var handled []int64
+	runner := newGapRunner(store, &handled, &now)
+
+	if err := runner.CatchUp(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if len(handled) != 2 {
+		t.Fatalf("expected gaps between old events to be skipped, got %v", handled)
+	}
+}
+
+// balanceProjection sums the N field of every event
+type balanceProjection struct {
+	total int
+}
+
+func (p *balanceProjection) Handle(ctx context.Context, event StoredEvent) error {
+	var e testEvent
+	if err := json.Unmarshal(event.Data, &e); err != nil {
+		return err
+	}
+	p.total += e.N
+	return nil
+}
+
+func (p *balanceProjection) Reset(ctx context.Context) error {
+	p.total = 0
+	return nil
+}
+
+func TestSQLProjectionCheckpoints(t *testing.T) {
+	ctx := context.Background()
+	db := openTestDB(t)
+	store := NewSQLEventStore(db, SQLDialectSQLite, "")
+	checkpoints := NewSQLCheckpointStore(db, SQLDialectSQLite, "")
+	if err := store.CreateTable(); err != nil {
+		t.Fatal(err)
+	}
+	if err := checkpoints.CreateTable(); err != nil {
+		t.Fatal(err)
+	}
+
+	version, err := store.Append(ctx, "acc-1", NoStream, &testEvent{Name: "deposited", N: 10}, &testEvent{Name: "deposited", N: 5})
+	if err != nil || version != 2 {
+		t.Fatalf("expected version 2, got %d, %v", version, err)
+	}
+	if _, err := store.Append(ctx, "acc-1", NoStream, &testEvent{Name: "deposited", N: 1}); err != ErrConcurrencyConflict {
+		t.Fatalf("expected a concurrency conflict, got %v", err)
+	}
+
+	projection := &balanceProjection{}
+	runner := NewProjectionRunner("balances", store, projection, checkpoints, ProjectionRunnerOptions{})
+	if err := runner.CatchUp(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if projection.total != 15 {
+		t.Fatalf("expected 15, got %d", projection.total)
+	}
+
+	// A restarted runner continues after the saved checkpoint
+	if _, err := store.Append(ctx, "acc-2", NoStream, &testEvent{Name: "deposited", N: 7}); err != nil {
+		t.Fatal(err)
+	}
+	restarted := NewProjectionRunner("balances", store, projection, checkpoints, ProjectionRunnerOptions{})
+	if err := restarted.CatchUp(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if projection.total != 22 {
+		t.Fatalf("expected 22, got %d", projection.total)
+	}
+	if position, _ := checkpoints.LoadCheckpoint(ctx, "balances"); position != 3 {
+		t.Fatalf("expected checkpoint 3, got %d", position)
+	}
+
+	if err := restarted.Rebuild(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if projection.total != 22 {
+		t.Fatalf("expected the rebuild to replay every event once, got %d", projection.total)
+	}
+}
@@ -0,0 +1,290 @@
+package core
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"net/http"
+	"strings"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/gofiber/fiber/v2/utils"
+)
+
+// HeaderRequestID carries the request ID in HTTP requests, responses and
+// broker messages
+const HeaderRequestID = "X-Request-ID"
+
+// HeaderTraceParent carries the W3C trace context of HTTP requests and
+// broker messages
+const HeaderTraceParent = "traceparent"
+
+// Request ID locals keys
+const (
+	LocalsRequestID     = "requestID"
+	LocalsTraceID       = "traceID"
+	LocalsRequestLogger = "requestLogger"
+)
+
+// RequestIDOptions defines request ID middleware configuration
+type RequestIDOptions struct {
+	// Header defaults to X-Request-ID
+	Header string
+	// Generator creates IDs for requests without a valid one, defaults to 16 random hex bytes
+	Generator func() string
+	// Logger is the parent of the request loggers, defaults to an info text logger
+	Logger *Logger
+}
+
+// RequestIDMiddleware accepts the request ID sent by the client or a proxy,
+// or generates one, and returns it in the response. The ID is stored in the
+// locals and the user context together with a child logger carrying it;
+// use RequestLogger to log with the ID, principal and route of the request.
+// A valid W3C traceparent header is kept in the user context, so it is
+// forwarded unchanged, and its trace ID is added to the locals and logger.
+// The middleware does not start traces or create spans.
+func RequestIDMiddleware(options ...RequestIDOptions) fiber.Handler {
+	var opts RequestIDOptions
+	if len(options) > 0 {
+		opts = options[0]
+	}
+	if opts.Header == "" {
+		opts.Header = HeaderRequestID
+	}
+	if opts.Generator == nil {
+		opts.Generator = generateRequestID
+	}
+	if opts.Logger == nil {
+		opts.Logger = NewLogger(Info)
+	}
+
+	return func(ctx *fiber.Ctx) error {
+		// Header values point into buffers fasthttp reuses after the
+		// handler, while the IDs outlive it in events and loggers
+		id := utils.CopyString(ctx.Get(opts.Header))
+		if !validRequestID(id) {
+			id = opts.Generator()
+		}
+		ctx.Set(opts.Header, id)
+
+		logger := opts.Logger.With("request_id", id)
+		userCtx := ContextWithRequestID(ctx.UserContext(), id)
+		ctx.Locals(LocalsRequestID, id)
+
+		if traceParent, traceID, ok := parseTraceParent(utils.CopyString(ctx.Get(HeaderTraceParent))); ok {
+			logger = logger.With("trace_id", traceID)
+			userCtx = ContextWithTraceParent(userCtx, traceParent)
+			ctx.Locals(LocalsTraceID, traceID)
+		}
+
+		ctx.Locals(LocalsRequestLogger, logger)
+		ctx.SetUserContext(ContextWithLogger(userCtx, logger))
+
+		return ctx.Next()
+	}
+}
+
+// RequestID returns the ID of a request, empty without RequestIDMiddleware
+func RequestID(ctx *fiber.Ctx) string {
+	id, _ := ctx.Locals(LocalsRequestID).(string)
+	return id
+}
+
+// TraceID returns the trace ID of a request's traceparent header, empty
+// without one or without RequestIDMiddleware
+func TraceID(ctx *fiber.Ctx) string {
+	id, _ := ctx.Locals(LocalsTraceID).(string)
+	return id
+}
+
+// RequestLogger returns a logger for the request with its ID, the route
+// pattern and the authenticated principal, or fallback without
+// RequestIDMiddleware
+func RequestLogger(ctx *fiber.Ctx, fallback *Logger) *Logger {
+	logger, ok := ctx.Locals(LocalsRequestLogger).(*Logger)
+	if !ok {
+		return fallback
+	}
+
+	args := []interface{}{"method", ctx.Method()}
+	if route := ctx.Route(); route != nil && route.Path != "" {
+		args = append(args, "route", route.Path)
+	}
+	if principal, _ := defaultMFASubject(ctx); principal != "" {
+		args = append(args, "principal", principal)
+	}
+	return logger.With(args...)
+}
+
+type requestIDKey struct{}
+type traceParentKey struct{}
+type loggerKey struct{}
+
+// ContextWithRequestID returns a context carrying a request ID
+func ContextWithRequestID(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, requestIDKey{}, id)
+}
+
+// RequestIDFromContext returns the request ID of a context, if any
+func RequestIDFromContext(ctx context.Context) (string, bool) {
+	id, ok := ctx.Value(requestIDKey{}).(string)
+	return id, ok && id != ""
+}
+
+// ContextWithTraceParent returns a context carrying a W3C traceparent header
+func ContextWithTraceParent(ctx context.Context, traceParent string) context.Context {
+	return context.WithValue(ctx, traceParentKey{}, traceParent)
+}
+
+// TraceParentFromContext returns the traceparent header of a context, if any
+func TraceParentFromContext(ctx context.Context) (string, bool) {
+	traceParent, ok := ctx.Value(traceParentKey{}).(string)
+	return traceParent, ok && traceParent != ""
+}
+
+// TraceIDFromContext returns the trace ID of the context's traceparent, if any
+func TraceIDFromContext(ctx context.Context) (string, bool) {
+	traceParent, _ := TraceParentFromContext(ctx)
+	_, traceID, ok := parseTraceParent(traceParent)
+	return traceID, ok
+}
+
+// ContextWithLogger returns a context carrying a logger
+func ContextWithLogger(ctx context.Context, logger *Logger) context.Context {
+	return context.WithValue(ctx, loggerKey{}, logger)
+}
+
+// LoggerFromContext returns the logger of a context, or fallback
+func LoggerFromContext(ctx context.Context, fallback *Logger) *Logger {
+	if logger, ok := ctx.Value(loggerKey{}).(*Logger); ok {
+		return logger
+	}
+	return fallback
+}
+
+// RequestIDTransport adds the request ID and traceparent of the request
+// context to outgoing HTTP requests that do not set them:
+//
+//	client := &http.Client{Transport: core.RequestIDTransport{}}
+//	req, _ := http.NewRequestWithContext(ctx.UserContext(), "GET", url, nil)
+type RequestIDTransport struct {
+	// Base defaults to http.DefaultTransport
+	Base http.RoundTripper
+}
+
+// RoundTrip implements http.RoundTripper
+func (t RequestIDTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	base := t.Base
+	if base == nil {
+		base = http.DefaultTransport
+	}
+
+	headers := make(map[string]string)
+	if id, ok := RequestIDFromContext(req.Context()); ok && req.Header.Get(HeaderRequestID) == "" {
+		headers[HeaderRequestID] = id
+	}
+	if traceParent, ok := TraceParentFromContext(req.Context()); ok && req.Header.Get(HeaderTraceParent) == "" {
+		headers[HeaderTraceParent] = traceParent
+	}
+
+	if len(headers) > 0 {
+		req = req.Clone(req.Context())
+		for key, value := range headers {
+			req.Header.Set(key, value)
+		}
+	}
+	return base.RoundTrip(req)
+}
+
+// RequestScopedEvent is implemented by events that carry the ID of the
+// request that caused them. EventBus.PublishContext and Broker set it from
+// the context, and brokers carry it in the HeaderRequestID header.
+type RequestScopedEvent interface {
+	Event
+	GetRequestID() string
+	SetRequestID(id string)
+}
+
+// RequestMetadata implements the request ID methods of RequestScopedEvent
+// for events that embed it
+type RequestMetadata struct {
+	RequestID string `json:"requestId,omitempty" bson:"requestId,omitempty"`
+}
+
+// GetRequestID returns the request ID
+func (m *RequestMetadata) GetRequestID() string {
+	return m.RequestID
+}
+
+// SetRequestID sets the request ID
+func (m *RequestMetadata) SetRequestID(id string) {
+	m.RequestID = id
+}
+
+// stampRequestID sets the request ID of the context on an event without one
+// and returns the event's request ID
+func stampRequestID(ctx context.Context, event Event) string {
+	scoped, ok := event.(RequestScopedEvent)
+	if !ok {
+		id, _ := RequestIDFromContext(ctx)
+		return id
+	}
+	if scoped.GetRequestID() == "" {
+		if id, ok := RequestIDFromContext(ctx); ok {
+			scoped.SetRequestID(id)
+		}
+	}
+	return scoped.GetRequestID()
+}
+
+func generateRequestID() string {
+	buf := make([]byte, 16)
+	if _, err := rand.Read(buf); err != nil {
+		return ""
+	}
+	return hex.EncodeToString(buf)
+}
+
+// validRequestID accepts short printable IDs so clients cannot inject log lines
+func validRequestID(id string) bool {
+	if id == "" || len(id) > 128 {
+		return false
+	}
+	for i := 0; i < len(id); i++ {
+		if id[i] < 0x21 || id[i] > 0x7e {
+			return false
+		}
+	}
+	return true
+}
+
+// parseTraceParent validates a W3C traceparent header,
+// version-traceid-parentid-flags in lowercase hex, and returns it with its
+// trace ID. Fields that later versions append are dropped.
+func parseTraceParent(header string) (string, string, bool) {
+	if len(header) < 55 || len(header) > 55 && (strings.HasPrefix(header, "00") || header[55] != '-') {
+		return "", "", false
+	}
+	header = header[:55]
+	if header[2] != '-' || header[35] != '-' || header[52] != '-' {
+		return "", "", false
+	}
+
+	version, traceID, parentID, flags := header[:2], header[3:35], header[36:52], header[53:]
+	if version == "ff" || !isLowerHex(version) || !isLowerHex(traceID) || !isLowerHex(parentID) || !isLowerHex(flags) {
+		return "", "", false
+	}
+	if strings.Trim(traceID, "0") == "" || strings.Trim(parentID, "0") == "" {
+		return "", "", false
+	}
+	return header, traceID, true
+}
+
+func isLowerHex(value string) bool {
+	for i := 0; i < len(value); i++ {
+		if (value[i] < '0' || value[i] > '9') && (value[i] < 'a' || value[i] > 'f') {
+			return false
+		}
+	}
+	return true
+}
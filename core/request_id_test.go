@@ -0,0 +1,132 @@
+package core
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+const testTraceParent = "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01"
+
+func TestRequestIDOutlivesRequest(t *testing.T) {
+	var logs bytes.Buffer
+	logger := NewLoggerWithOptions(LoggerOptions{Level: Info, Format: LogFormatLogfmt, Output: &logs})
+
+	var contexts []context.Context
+	app := fiber.New()
+	app.Use(RequestIDMiddleware(RequestIDOptions{Logger: logger}))
+	app.Get("/", func(ctx *fiber.Ctx) error {
+		contexts = append(contexts, ctx.UserContext())
+		return nil
+	})
+
+	ids := []string{strings.Repeat("a", 32), strings.Repeat("b", 32), strings.Repeat("c", 32)}
+	for _, id := range ids {
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		req.Header.Set(HeaderRequestID, id)
+		req.Header.Set(HeaderTraceParent, strings.Replace(testTraceParent, "4bf9", id[:4], 1))
+		resp, err := app.Test(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if resp.Header.Get(HeaderRequestID) != id {
+			t.Fatalf("unexpected response ID %q", resp.Header.Get(HeaderRequestID))
+		}
+	}
+
+	// Contexts are used after the request, e.g. by events handled asynchronously
+	for i, ctx := range contexts {
+		if id, _ := RequestIDFromContext(ctx); id != ids[i] {
+			t.Errorf("request %d: ID changed to %q", i, id)
+		}
+		if traceID, _ := TraceIDFromContext(ctx); !strings.HasPrefix(traceID, ids[i][:4]) {
+			t.Errorf("request %d: trace ID changed to %q", i, traceID)
+		}
+		LoggerFromContext(ctx, nil).Info("done")
+	}
+	for _, id := range ids {
+		if !strings.Contains(logs.String(), "request_id="+id) {
+			t.Errorf("expected request_id=%s in %s", id, logs.String())
+		}
+	}
+}
+
+func TestRequestIDTraceParent(t *testing.T) {
+	var logs bytes.Buffer
+	logger := NewLoggerWithOptions(LoggerOptions{Level: Info, Format: LogFormatLogfmt, Output: &logs})
+
+	received := make(chan http.Header, 1)
+	downstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		received <- r.Header.Clone()
+	}))
+	defer downstream.Close()
+
+	app := fiber.New()
+	app.Use(RequestIDMiddleware(RequestIDOptions{Logger: logger}))
+	app.Get("/", func(ctx *fiber.Ctx) error {
+		RequestLogger(ctx, logger).Info("handling")
+
+		client := &http.Client{Transport: RequestIDTransport{}}
+		req, _ := http.NewRequestWithContext(ctx.UserContext(), http.MethodGet, downstream.URL, nil)
+		resp, err := client.Do(req)
+		if err != nil {
+			return err
+		}
+		resp.Body.Close()
+		return ctx.SendString(TraceID(ctx))
+	})
+
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	req.Header.Set(HeaderRequestID, "req-1")
+	req.Header.Set(HeaderTraceParent, testTraceParent)
+	resp, err := app.Test(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var body bytes.Buffer
+	body.ReadFrom(resp.Body)
+	if body.String() != "4bf92f3577b34da6a3ce929d0e0e4736" {
+		t.Errorf("unexpected trace ID %q", body.String())
+	}
+	if !strings.Contains(logs.String(), "trace_id=4bf92f3577b34da6a3ce929d0e0e4736") {
+		t.Errorf("expected the trace ID in %s", logs.String())
+	}
+
+	headers := <-received
+	if headers.Get(HeaderRequestID) != "req-1" || headers.Get(HeaderTraceParent) != testTraceParent {
+		t.Errorf("unexpected downstream headers %v", headers)
+	}
+}
+
+func TestParseTraceParent(t *testing.T) {
+	valid := map[string]string{
+		testTraceParent: testTraceParent,
+		"01-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01-future": "01-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01",
+	}
+	for header, want := range valid {
+		if traceParent, traceID, ok := parseTraceParent(header); !ok || traceParent != want || traceID != "4bf92f3577b34da6a3ce929d0e0e4736" {
+			t.Errorf("parseTraceParent(%q) = %q, %q, %v", header, traceParent, traceID, ok)
+		}
+	}
+
+	for _, header := range []string{
+		"",
+		strings.ToUpper(testTraceParent),
+		"ff-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01",
+		"00-00000000000000000000000000000000-00f067aa0ba902b7-01",
+		"00-4bf92f3577b34da6a3ce929d0e0e4736-0000000000000000-01",
+		"00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01-extra",
+		"00_4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01",
+		"00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-0\n",
+	} {
+		if _, _, ok := parseTraceParent(header); ok {
+			t.Errorf("expected %q to be rejected", header)
+		}
+	}
+}
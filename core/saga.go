@@ -0,0 +1,976 @@
+package core
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// Saga errors
+var (
+	ErrSagaNotFound = fiber.NewError(fiber.StatusNotFound, "Saga not found")
+	ErrSagaExists   = fiber.NewError(fiber.StatusConflict, "Saga already exists")
+)
+
+// SagaStatus is the lifecycle status of a saga instance
+type SagaStatus string
+
+const (
+	SagaRunning      SagaStatus = "running"
+	SagaCompleted    SagaStatus = "completed"
+	SagaCompensating SagaStatus = "compensating"
+	SagaCompensated  SagaStatus = "compensated"
+	// SagaFailed means a compensation failed, see Saga.Retry
+	SagaFailed SagaStatus = "failed"
+)
+
+// SagaState is the persisted state of a saga instance
+type SagaState struct {
+	ID     string     `bson:"id"`
+	Type   string     `bson:"type"`
+	Status SagaStatus `bson:"status"`
+	// Step is the index of the current step, which equals the number of
+	// completed steps that are not compensated yet. A step that timed out
+	// counts as completed, so it is compensated too.
+	Step int    `bson:"step"`
+	Data []byte `bson:"data"`
+	// Error describes why the saga is compensating or failed
+	Error string `bson:"error"`
+	// Deadline is when the current step times out
+	Deadline  *time.Time `bson:"deadline"`
+	Version   int64      `bson:"version"`
+	CreatedAt time.Time  `bson:"createdAt"`
+	UpdatedAt time.Time  `bson:"updatedAt"`
+}
+
+// SagaEvent is an event received for a saga instance, stored until the saga
+// has handled it
+type SagaEvent struct {
+	ID         string    `bson:"_id"`
+	SagaType   string    `bson:"type"`
+	SagaID     string    `bson:"sagaId"`
+	Name       string    `bson:"name"`
+	Payload    []byte    `bson:"payload"`
+	ReceivedAt time.Time `bson:"receivedAt"`
+	// Sequence orders the events of a saga type in stores without an
+	// insertion order, such as MongoSagaStore
+	Sequence int64 `bson:"sequence"`
+	// Event is the decoded event, kept by the memory store
+	Event Event `bson:"-"`
+}
+
+// SagaStore persists saga state and the events waiting to be handled
+type SagaStore interface {
+	// Save inserts the state when its Version is 0 and otherwise updates it
+	// if the stored version matches, failing with ErrConcurrencyConflict.
+	// The Version is incremented on success.
+	Save(ctx context.Context, state *SagaState) error
+	// Load returns nil when the saga does not exist
+	Load(ctx context.Context, sagaType, id string) (*SagaState, error)
+	// Expired returns running sagas of a type whose deadline passed
+	Expired(ctx context.Context, sagaType string, now time.Time) ([]SagaState, error)
+	// Enqueue stores a received event
+	Enqueue(ctx context.Context, event SagaEvent) error
+	// Inbox returns stored events of a saga type, oldest first
+	Inbox(ctx context.Context, sagaType string, limit int) ([]SagaEvent, error)
+	// Dequeue removes a handled event
+	Dequeue(ctx context.Context, sagaType, id string) error
+}
+
+// SagaStep is one step of a saga
+type SagaStep[D any] struct {
+	Name string
+	// Action performs the step, usually by dispatching a command
+	Action func(ctx context.Context, data *D) error
+	// Compensate undoes the step after a later step failed
+	Compensate func(ctx context.Context, data *D) error
+	// CompletedOn lists events completing the step. Without events the step
+	// completes when Action returns.
+	CompletedOn []string
+	// FailedOn lists events failing the step
+	FailedOn []string
+	// OnEvent updates the saga data from completion and failure events
+	OnEvent func(data *D, event Event)
+	// Timeout fails the step when no completion event arrived in time
+	Timeout time.Duration
+}
+
+// SagaDefinition defines a saga with data of type D
+type SagaDefinition[D any] struct {
+	Name  string
+	Steps []SagaStep[D]
+	// Correlate returns the ID of the saga instance an event belongs to,
+	// or an empty string when the event is unrelated
+	Correlate func(event Event) string
+}
+
+// SagaOptions defines saga configuration
+type SagaOptions struct {
+	// Store persists saga state, defaults to a MemorySagaStore
+	Store SagaStore
+	// Bus delivers the events steps wait for
+	Bus *EventBus
+	// Interval is how often timeouts are checked, defaults to one second
+	Interval time.Duration
+	// Registry decodes stored events, defaults to DefaultEventRegistry.
+	// Register the events steps wait for so they survive a restart.
+	Registry *EventRegistry
+	// OnError is called when handling an event or timeout failed
+	OnError func(sagaID string, err error)
+}
+
+// Saga runs instances of a saga definition. Steps run in order, and when a
+// step fails or times out the completed steps are compensated in reverse.
+// Received events are stored with the saga state and handled by the
+// background loop, so they are not lost when the process stops.
+//
+// Stores do not claim inbox events. When several processes run the same
+// saga on a shared store, each handles every event, and a step Action can
+// run more than once before the losing process fails the version check
+// with ErrConcurrencyConflict. Make actions idempotent or run the
+// background loop of a saga type in a single process.
+type Saga[D any] struct {
+	definition SagaDefinition[D]
+	options    SagaOptions
+	subs       []*Subscription
+	notify     chan struct{}
+	cancel     context.CancelFunc
+	done       chan struct{}
+	runMu      sync.Mutex
+	mu         sync.Mutex
+}
+
+// NewSaga creates a saga and subscribes to the events of its steps. Call
+// Start to process events and timeouts.
+func NewSaga[D any](definition SagaDefinition[D], options SagaOptions) (*Saga[D], error) {
+	if definition.Name == "" {
+		return nil, fmt.Errorf("saga has no name")
+	}
+	if len(definition.Steps) == 0 {
+		return nil, fmt.Errorf("saga %s has no steps", definition.Name)
+	}
+	if options.Store == nil {
+		options.Store = NewMemorySagaStore()
+	}
+	if options.Interval == 0 {
+		options.Interval = time.Second
+	}
+	if options.Registry == nil {
+		options.Registry = DefaultEventRegistry
+	}
+
+	s := &Saga[D]{
+		definition: definition,
+		options:    options,
+		notify:     make(chan struct{}, 1),
+	}
+
+	names := make(map[string]bool)
+	for _, step := range definition.Steps {
+		for _, name := range append(append([]string(nil), step.CompletedOn...), step.FailedOn...) {
+			names[name] = true
+		}
+	}
+	if len(names) > 0 && (options.Bus == nil || definition.Correlate == nil) {
+		return nil, fmt.Errorf("saga %s waits for events but has no bus or Correlate function", definition.Name)
+	}
+
+	for name := range names {
+		sub, err := options.Bus.Subscribe(name, s.enqueue, SubscribeOptions{Name: "saga:" + definition.Name})
+		if err != nil {
+			s.unsubscribe()
+			return nil, err
+		}
+		s.subs = append(s.subs, sub)
+	}
+
+	return s, nil
+}
+
+// Begin starts a new saga instance and runs its first steps
+func (s *Saga[D]) Begin(ctx context.Context, id string, data D) error {
+	s.runMu.Lock()
+	defer s.runMu.Unlock()
+
+	existing, err := s.options.Store.Load(ctx, s.definition.Name, id)
+	if err != nil {
+		return err
+	}
+	if existing != nil {
+		return ErrSagaExists
+	}
+
+	now := time.Now().UTC()
+	state := &SagaState{
+		ID:        id,
+		Type:      s.definition.Name,
+		Status:    SagaRunning,
+		CreatedAt: now,
+	}
+	if err := s.save(ctx, state, &data); err != nil {
+		return err
+	}
+	return s.advance(ctx, state, &data)
+}
+
+// Status returns the state of a saga instance
+func (s *Saga[D]) Status(ctx context.Context, id string) (*SagaState, error) {
+	state, err := s.options.Store.Load(ctx, s.definition.Name, id)
+	if err != nil {
+		return nil, err
+	}
+	if state == nil {
+		return nil, ErrSagaNotFound
+	}
+	return state, nil
+}
+
+// Data returns the data of a saga instance
+func (s *Saga[D]) Data(ctx context.Context, id string) (D, error) {
+	var data D
+
+	state, err := s.Status(ctx, id)
+	if err != nil {
+		return data, err
+	}
+	if err := json.Unmarshal(state.Data, &data); err != nil {
+		return data, err
+	}
+	return data, nil
+}
+
+// Retry continues the compensation of a failed saga
+func (s *Saga[D]) Retry(ctx context.Context, id string) error {
+	s.runMu.Lock()
+	defer s.runMu.Unlock()
+
+	state, data, err := s.load(ctx, id)
+	if err != nil {
+		return err
+	}
+	if state == nil {
+		return ErrSagaNotFound
+	}
+	if state.Status != SagaFailed {
+		return fmt.Errorf("saga %s is %s, not failed", id, state.Status)
+	}
+	return s.compensate(ctx, state, data, nil)
+}
+
+// Start processes events and timeouts in the background until Stop is called
+func (s *Saga[D]) Start() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.cancel != nil {
+		return
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	s.cancel = cancel
+	s.done = make(chan struct{})
+
+	go func() {
+		defer close(s.done)
+
+		ticker := time.NewTicker(s.options.Interval)
+		defer ticker.Stop()
+
+		for {
+			s.processEvents(ctx)
+
+			select {
+			case <-s.notify:
+			case <-ticker.C:
+				s.CheckTimeouts(ctx)
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+}
+
+// Stop stops background processing and waits for the current event
+func (s *Saga[D]) Stop() {
+	s.mu.Lock()
+	cancel, done := s.cancel, s.done
+	s.cancel = nil
+	s.mu.Unlock()
+
+	if cancel != nil {
+		cancel()
+		<-done
+	}
+}
+
+// Close stops background processing and unsubscribes from the event bus
+func (s *Saga[D]) Close() {
+	s.Stop()
+	s.unsubscribe()
+}
+
+// CheckTimeouts compensates running sagas whose current step timed out
+func (s *Saga[D]) CheckTimeouts(ctx context.Context) {
+	expired, err := s.options.Store.Expired(ctx, s.definition.Name, time.Now().UTC())
+	if err != nil {
+		s.report("", err)
+		return
+	}
+
+	for _, candidate := range expired {
+		s.runMu.Lock()
+		err := s.timeout(ctx, candidate.ID)
+		s.runMu.Unlock()
+		if err != nil {
+			s.report(candidate.ID, err)
+		}
+	}
+}
+
+func (s *Saga[D]) timeout(ctx context.Context, id string) error {
+	state, data, err := s.load(ctx, id)
+	if err != nil || state == nil {
+		return err
+	}
+	if state.Status != SagaRunning || state.Deadline == nil || state.Deadline.After(time.Now().UTC()) {
+		return nil
+	}
+
+	// The step's action already ran, so it is compensated as well
+	step := s.definition.Steps[state.Step]
+	state.Step++
+	return s.compensate(ctx, state, data, fmt.Errorf("step %s timed out", step.Name))
+}
+
+// enqueue stores events received from the bus. Events are handled by the
+// background loop, so step actions may publish events synchronously.
+func (s *Saga[D]) enqueue(event Event) error {
+	id := s.definition.Correlate(event)
+	if id == "" {
+		return nil
+	}
+
+	payload, err := s.options.Registry.Encode(event)
+	if err != nil {
+		return err
+	}
+
+	err = s.options.Store.Enqueue(context.Background(), SagaEvent{
+		ID:         generateID(),
+		SagaType:   s.definition.Name,
+		SagaID:     id,
+		Name:       event.GetName(),
+		Payload:    payload,
+		ReceivedAt: time.Now().UTC(),
+		Event:      event,
+	})
+	if err != nil {
+		return err
+	}
+
+	select {
+	case s.notify <- struct{}{}:
+	default:
+	}
+	return nil
+}
+
+// processEvents handles stored events in the order they were received.
+// Events are removed after handling, so an event may be handled again after
+// a crash, where it no longer matches the saga's current step. When handling
+// fails, e.g. on a store error or a concurrent update, the event is kept and
+// processing resumes with it on the next pass. Events that cannot be decoded
+// are reported and removed.
+func (s *Saga[D]) processEvents(ctx context.Context) {
+	for ctx.Err() == nil {
+		inbox, err := s.options.Store.Inbox(ctx, s.definition.Name, 100)
+		if err != nil {
+			s.report("", err)
+			return
+		}
+		if len(inbox) == 0 {
+			return
+		}
+
+		for _, received := range inbox {
+			if ctx.Err() != nil {
+				return
+			}
+
+			event, err := s.decode(received)
+			if err != nil {
+				s.report(received.SagaID, err)
+			} else {
+				s.runMu.Lock()
+				err = s.handle(ctx, received.SagaID, event)
+				s.runMu.Unlock()
+				if err != nil {
+					s.report(received.SagaID, err)
+					return
+				}
+			}
+
+			if err := s.options.Store.Dequeue(ctx, s.definition.Name, received.ID); err != nil {
+				s.report(received.SagaID, err)
+				return
+			}
+		}
+	}
+}
+
+func (s *Saga[D]) decode(received SagaEvent) (Event, error) {
+	if received.Event != nil {
+		return received.Event, nil
+	}
+	event, err := s.options.Registry.Decode(received.Name, received.Payload)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode event %s: %v", received.Name, err)
+	}
+	return event, nil
+}
+
+func (s *Saga[D]) handle(ctx context.Context, id string, event Event) error {
+	state, data, err := s.load(ctx, id)
+	if err != nil || state == nil || state.Status != SagaRunning {
+		return err
+	}
+
+	step := s.definition.Steps[state.Step]
+	switch {
+	case containsString(step.CompletedOn, event.GetName()):
+		if step.OnEvent != nil {
+			step.OnEvent(data, event)
+		}
+		state.Step++
+		state.Deadline = nil
+		return s.advance(ctx, state, data)
+	case containsString(step.FailedOn, event.GetName()):
+		if step.OnEvent != nil {
+			step.OnEvent(data, event)
+		}
+		return s.compensate(ctx, state, data, fmt.Errorf("step %s failed on %s", step.Name, event.GetName()))
+	}
+	return nil
+}
+
+// advance runs steps from the current one until a step waits for an event
+func (s *Saga[D]) advance(ctx context.Context, state *SagaState, data *D) error {
+	for state.Step < len(s.definition.Steps) {
+		step := s.definition.Steps[state.Step]
+
+		if step.Action != nil {
+			if err := step.Action(ctx, data); err != nil {
+				return s.compensate(ctx, state, data, fmt.Errorf("step %s failed: %v", step.Name, err))
+			}
+		}
+
+		if len(step.CompletedOn) > 0 {
+			if step.Timeout > 0 {
+				deadline := time.Now().UTC().Add(step.Timeout)
+				state.Deadline = &deadline
+			}
+			return s.save(ctx, state, data)
+		}
+		state.Step++
+	}
+
+	state.Status = SagaCompleted
+	state.Deadline = nil
+	return s.save(ctx, state, data)
+}
+
+// compensate undoes completed steps in reverse. Progress is saved after every
+// step, so Retry continues where a failed compensation stopped.
+func (s *Saga[D]) compensate(ctx context.Context, state *SagaState, data *D, cause error) error {
+	state.Status = SagaCompensating
+	state.Deadline = nil
+	if cause != nil {
+		state.Error = cause.Error()
+	}
+	if err := s.save(ctx, state, data); err != nil {
+		return err
+	}
+
+	for state.Step > 0 {
+		step := s.definition.Steps[state.Step-1]
+		if step.Compensate != nil {
+			if err := step.Compensate(ctx, data); err != nil {
+				state.Status = SagaFailed
+				state.Error = fmt.Sprintf("%s; compensation of step %s failed: %v", state.Error, step.Name, err)
+				if saveErr := s.save(ctx, state, data); saveErr != nil {
+					return saveErr
+				}
+				return fmt.Errorf("saga %s: compensation of step %s failed: %v", state.ID, step.Name, err)
+			}
+		}
+
+		state.Step--
+		if err := s.save(ctx, state, data); err != nil {
+			return err
+		}
+	}
+
+	state.Status = SagaCompensated
+	return s.save(ctx, state, data)
+}
+
+func (s *Saga[D]) load(ctx context.Context, id string) (*SagaState, *D, error) {
+	state, err := s.options.Store.Load(ctx, s.definition.Name, id)
+	if err != nil || state == nil {
+		return nil, nil, err
+	}
+
+	data := new(D)
+	if err := json.Unmarshal(state.Data, data); err != nil {
+		return nil, nil, fmt.Errorf("failed to decode saga %s: %v", id, err)
+	}
+	return state, data, nil
+}
+
+func (s *Saga[D]) save(ctx context.Context, state *SagaState, data *D) error {
+	encoded, err := json.Marshal(data)
+	if err != nil {
+		return err
+	}
+
+	state.Data = encoded
+	state.UpdatedAt = time.Now().UTC()
+	return s.options.Store.Save(ctx, state)
+}
+
+func (s *Saga[D]) unsubscribe() {
+	for _, sub := range s.subs {
+		sub.Unsubscribe()
+	}
+	s.subs = nil
+}
+
+func (s *Saga[D]) report(id string, err error) {
+	if s.options.OnError != nil {
+		s.options.OnError(id, err)
+	}
+}
+
+func containsString(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
+// MemorySagaStore is an in-memory SagaStore
+type MemorySagaStore struct {
+	states map[string]SagaState
+	inbox  []SagaEvent
+	mu     sync.RWMutex
+}
+
+// NewMemorySagaStore creates a new in-memory saga store
+func NewMemorySagaStore() *MemorySagaStore {
+	return &MemorySagaStore{
+		states: make(map[string]SagaState),
+	}
+}
+
+// Save implements SagaStore
+func (s *MemorySagaStore) Save(ctx context.Context, state *SagaState) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	key := state.Type + "/" + state.ID
+	if state.Version != s.states[key].Version {
+		return ErrConcurrencyConflict
+	}
+
+	state.Version++
+	stored := *state
+	stored.Data = append([]byte(nil), state.Data...)
+	s.states[key] = stored
+	return nil
+}
+
+// Load implements SagaStore
+func (s *MemorySagaStore) Load(ctx context.Context, sagaType, id string) (*SagaState, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	state, exists := s.states[sagaType+"/"+id]
+	if !exists {
+		return nil, nil
+	}
+	return &state, nil
+}
+
+// Expired implements SagaStore
+func (s *MemorySagaStore) Expired(ctx context.Context, sagaType string, now time.Time) ([]SagaState, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	var expired []SagaState
+	for _, state := range s.states {
+		if state.Type == sagaType && state.Status == SagaRunning && state.Deadline != nil && state.Deadline.Before(now) {
+			expired = append(expired, state)
+		}
+	}
+	return expired, nil
+}
+
+// Enqueue implements SagaStore
+func (s *MemorySagaStore) Enqueue(ctx context.Context, event SagaEvent) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.inbox = append(s.inbox, event)
+	return nil
+}
+
+// Inbox implements SagaStore
+func (s *MemorySagaStore) Inbox(ctx context.Context, sagaType string, limit int) ([]SagaEvent, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	var events []SagaEvent
+	for _, event := range s.inbox {
+		if event.SagaType != sagaType {
+			continue
+		}
+		if limit > 0 && len(events) == limit {
+			break
+		}
+		events = append(events, event)
+	}
+	return events, nil
+}
+
+// Dequeue implements SagaStore
+func (s *MemorySagaStore) Dequeue(ctx context.Context, sagaType, id string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for i, event := range s.inbox {
+		if event.SagaType == sagaType && event.ID == id {
+			s.inbox = append(s.inbox[:i], s.inbox[i+1:]...)
+			break
+		}
+	}
+	return nil
+}
+
+// SQLSagaStore is a SagaStore stored in a SQL table. Received events are
+// kept in a "<table>_events" table. Inbox rows are not claimed, so processes
+// sharing the table all handle every event, see Saga.
+type SQLSagaStore struct {
+	db      *sql.DB
+	dialect SQLDialect
+	table   string
+}
+
+// NewSQLSagaStore creates a new SQL saga store
+func NewSQLSagaStore(db *sql.DB, dialect SQLDialect, table string) *SQLSagaStore {
+	if table == "" {
+		table = "sagas"
+	}
+	return &SQLSagaStore{db: db, dialect: dialect, table: table}
+}
+
+// CreateTable creates the sagas and events tables if they do not exist
+func (s *SQLSagaStore) CreateTable() error {
+	_, err := s.db.Exec(fmt.Sprintf(`CREATE TABLE IF NOT EXISTS %s (
+		saga_type VARCHAR(255) NOT NULL,
+		id VARCHAR(255) NOT NULL,
+		status VARCHAR(32) NOT NULL,
+		step INT NOT NULL,
+		data %s NOT NULL,
+		error TEXT,
+		deadline TIMESTAMP NULL,
+		version BIGINT NOT NULL,
+		created_at TIMESTAMP NOT NULL,
+		updated_at TIMESTAMP NOT NULL,
+		PRIMARY KEY (saga_type, id)
+	)`, s.table, s.dialect.BlobType()))
+	if err != nil {
+		return err
+	}
+
+	_, err = s.db.Exec(fmt.Sprintf(`CREATE TABLE IF NOT EXISTS %s_events (
+		position %s,
+		saga_type VARCHAR(255) NOT NULL,
+		id VARCHAR(64) NOT NULL,
+		saga_id VARCHAR(255) NOT NULL,
+		event_name VARCHAR(255) NOT NULL,
+		payload %s NOT NULL,
+		received_at TIMESTAMP NOT NULL
+	)`, s.table, s.dialect.SerialPrimaryKey(), s.dialect.BlobType()))
+	return err
+}
+
+// Save implements SagaStore
+func (s *SQLSagaStore) Save(ctx context.Context, state *SagaState) error {
+	if state.Version == 0 {
+		_, err := s.db.ExecContext(ctx, s.dialect.Rebind(fmt.Sprintf(`INSERT INTO %s
+			(saga_type, id, status, step, data, error, deadline, version, created_at, updated_at)
+			VALUES (?, ?, ?, ?, ?, ?, ?, 1, ?, ?)`, s.table)),
+			state.Type, state.ID, string(state.Status), state.Step, state.Data, state.Error, state.Deadline, state.CreatedAt, state.UpdatedAt)
+		if err != nil {
+			if existing, loadErr := s.Load(ctx, state.Type, state.ID); loadErr == nil && existing != nil {
+				return ErrConcurrencyConflict
+			}
+			return err
+		}
+		state.Version = 1
+		return nil
+	}
+
+	result, err := s.db.ExecContext(ctx, s.dialect.Rebind(fmt.Sprintf(`UPDATE %s
+		SET status = ?, step = ?, data = ?, error = ?, deadline = ?, version = version + 1, updated_at = ?
+		WHERE saga_type = ? AND id = ? AND version = ?`, s.table)),
+		string(state.Status), state.Step, state.Data, state.Error, state.Deadline, state.UpdatedAt, state.Type, state.ID, state.Version)
+	if err != nil {
+		return err
+	}
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if affected == 0 {
+		return ErrConcurrencyConflict
+	}
+	state.Version++
+	return nil
+}
+
+// Load implements SagaStore
+func (s *SQLSagaStore) Load(ctx context.Context, sagaType, id string) (*SagaState, error) {
+	states, err := s.query(ctx, "saga_type = ? AND id = ?", sagaType, id)
+	if err != nil || len(states) == 0 {
+		return nil, err
+	}
+	return &states[0], nil
+}
+
+// Expired implements SagaStore
+func (s *SQLSagaStore) Expired(ctx context.Context, sagaType string, now time.Time) ([]SagaState, error) {
+	return s.query(ctx, "saga_type = ? AND status = ? AND deadline < ?", sagaType, string(SagaRunning), now)
+}
+
+// Enqueue implements SagaStore
+func (s *SQLSagaStore) Enqueue(ctx context.Context, event SagaEvent) error {
+	_, err := s.db.ExecContext(ctx, s.dialect.Rebind(fmt.Sprintf(`INSERT INTO %s_events
+		(saga_type, id, saga_id, event_name, payload, received_at) VALUES (?, ?, ?, ?, ?, ?)`, s.table)),
+		event.SagaType, event.ID, event.SagaID, event.Name, event.Payload, event.ReceivedAt)
+	return err
+}
+
+// Inbox implements SagaStore
+func (s *SQLSagaStore) Inbox(ctx context.Context, sagaType string, limit int) ([]SagaEvent, error) {
+	query := fmt.Sprintf(`SELECT saga_type, id, saga_id, event_name, payload, received_at
+		FROM %s_events WHERE saga_type = ? ORDER BY position`, s.table)
+	if limit > 0 {
+		query += fmt.Sprintf(" LIMIT %d", limit)
+	}
+
+	rows, err := s.db.QueryContext(ctx, s.dialect.Rebind(query), sagaType)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var events []SagaEvent
+	for rows.Next() {
+		var event SagaEvent
+		if err := rows.Scan(&event.SagaType, &event.ID, &event.SagaID, &event.Name, &event.Payload, &event.ReceivedAt); err != nil {
+			return nil, err
+		}
+		events = append(events, event)
+	}
+	return events, rows.Err()
+}
+
+// Dequeue implements SagaStore
+func (s *SQLSagaStore) Dequeue(ctx context.Context, sagaType, id string) error {
+	_, err := s.db.ExecContext(ctx, s.dialect.Rebind(fmt.Sprintf(
+		"DELETE FROM %s_events WHERE saga_type = ? AND id = ?", s.table)), sagaType, id)
+	return err
+}
+
+func (s *SQLSagaStore) query(ctx context.Context, where string, args ...interface{}) ([]SagaState, error) {
+	rows, err := s.db.QueryContext(ctx, s.dialect.Rebind(fmt.Sprintf(`SELECT
+		saga_type, id, status, step, data, error, deadline, version, created_at, updated_at
+		FROM %s WHERE %s`, s.table, where)), args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var states []SagaState
+	for rows.Next() {
+		var state SagaState
+		var status string
+		var message sql.NullString
+		var deadline sql.NullTime
+		if err := rows.Scan(&state.Type, &state.ID, &status, &state.Step, &state.Data, &message,
+			&deadline, &state.Version, &state.CreatedAt, &state.UpdatedAt); err != nil {
+			return nil, err
+		}
+		state.Status = SagaStatus(status)
+		state.Error = message.String
+		if deadline.Valid {
+			state.Deadline = &deadline.Time
+		}
+		states = append(states, state)
+	}
+	return states, rows.Err()
+}
+
+// MongoSagaStore is a SagaStore stored in a MongoDB collection. Received
+// events are kept in a "<collection>_events" collection and numbered by a
+// counter per saga type in a "<collection>_sequences" collection.
+type MongoSagaStore struct {
+	collection *mongo.Collection
+	events     *mongo.Collection
+	sequences  *mongo.Collection
+}
+
+// NewMongoSagaStore creates a new MongoDB saga store
+func NewMongoSagaStore(collection *mongo.Collection) *MongoSagaStore {
+	return &MongoSagaStore{
+		collection: collection,
+		events:     collection.Database().Collection(collection.Name() + "_events"),
+		sequences:  collection.Database().Collection(collection.Name() + "_sequences"),
+	}
+}
+
+// CreateIndexes creates the index enforcing one document per saga and the
+// index ordering received events
+func (s *MongoSagaStore) CreateIndexes(ctx context.Context) error {
+	_, err := s.collection.Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys:    bson.D{{Key: "type", Value: 1}, {Key: "id", Value: 1}},
+		Options: options.Index().SetUnique(true),
+	})
+	if err != nil {
+		return err
+	}
+
+	_, err = s.events.Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys: bson.D{{Key: "type", Value: 1}, {Key: "sequence", Value: 1}},
+	})
+	return err
+}
+
+// Save implements SagaStore
+func (s *MongoSagaStore) Save(ctx context.Context, state *SagaState) error {
+	next := *state
+	next.Version++
+
+	if state.Version == 0 {
+		if _, err := s.collection.InsertOne(ctx, next); err != nil {
+			if mongo.IsDuplicateKeyError(err) {
+				return ErrConcurrencyConflict
+			}
+			return err
+		}
+		state.Version = next.Version
+		return nil
+	}
+
+	result, err := s.collection.ReplaceOne(ctx, bson.D{
+		{Key: "type", Value: state.Type},
+		{Key: "id", Value: state.ID},
+		{Key: "version", Value: state.Version},
+	}, next)
+	if err != nil {
+		return err
+	}
+	if result.MatchedCount == 0 {
+		return ErrConcurrencyConflict
+	}
+	state.Version = next.Version
+	return nil
+}
+
+// Load implements SagaStore
+func (s *MongoSagaStore) Load(ctx context.Context, sagaType, id string) (*SagaState, error) {
+	var state SagaState
+	err := s.collection.FindOne(ctx, bson.D{{Key: "type", Value: sagaType}, {Key: "id", Value: id}}).Decode(&state)
+	if err == mongo.ErrNoDocuments {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &state, nil
+}
+
+// Expired implements SagaStore
+func (s *MongoSagaStore) Expired(ctx context.Context, sagaType string, now time.Time) ([]SagaState, error) {
+	cursor, err := s.collection.Find(ctx, bson.D{
+		{Key: "type", Value: sagaType},
+		{Key: "status", Value: string(SagaRunning)},
+		{Key: "deadline", Value: bson.D{{Key: "$lt", Value: now}}},
+	})
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	var states []SagaState
+	if err := cursor.All(ctx, &states); err != nil {
+		return nil, err
+	}
+	return states, nil
+}
+
+// Enqueue implements SagaStore. The event's Sequence is taken from the
+// counter of its saga type, so events received in the same millisecond
+// keep their order.
+func (s *MongoSagaStore) Enqueue(ctx context.Context, event SagaEvent) error {
+	var counter struct {
+		Sequence int64 `bson:"sequence"`
+	}
+	err := s.sequences.FindOneAndUpdate(ctx,
+		bson.D{{Key: "_id", Value: event.SagaType}},
+		bson.D{{Key: "$inc", Value: bson.D{{Key: "sequence", Value: int64(1)}}}},
+		options.FindOneAndUpdate().SetUpsert(true).SetReturnDocument(options.After)).Decode(&counter)
+	if err != nil {
+		return err
+	}
+
+	event.Sequence = counter.Sequence
+	_, err = s.events.InsertOne(ctx, event)
+	return err
+}
+
+// Inbox implements SagaStore
+func (s *MongoSagaStore) Inbox(ctx context.Context, sagaType string, limit int) ([]SagaEvent, error) {
+	opts := options.Find().SetSort(bson.D{{Key: "sequence", Value: 1}})
+	if limit > 0 {
+		opts.SetLimit(int64(limit))
+	}
+
+	cursor, err := s.events.Find(ctx, bson.D{{Key: "type", Value: sagaType}}, opts)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	var events []SagaEvent
+	if err := cursor.All(ctx, &events); err != nil {
+		return nil, err
+	}
+	return events, nil
+}
+
+// Dequeue implements SagaStore
+func (s *MongoSagaStore) Dequeue(ctx context.Context, sagaType, id string) error {
+	_, err := s.events.DeleteOne(ctx, bson.D{{Key: "_id", Value: id}, {Key: "type", Value: sagaType}})
+	return err
+}
@@ -0,0 +1,215 @@
+package core
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+)
+
+type paymentSucceeded struct {
+	OrderID string `json:"orderId"`
+	Amount  int    `json:"amount"`
+}
+
+func (e *paymentSucceeded) GetName() string         { return "payment.succeeded" }
+func (e *paymentSucceeded) GetTimestamp() time.Time { return time.Time{} }
+
+type checkoutData struct {
+	OrderID string
+	Paid    int
+}
+
+func checkoutDefinition(compensated *[]string, timeout time.Duration) SagaDefinition[checkoutData] {
+	return SagaDefinition[checkoutData]{
+		Name: "checkout",
+		Correlate: func(event Event) string {
+			if e, ok := event.(*paymentSucceeded); ok {
+				return e.OrderID
+			}
+			return ""
+		},
+		Steps: []SagaStep[checkoutData]{
+			{
+				Name:   "reserve",
+				Action: func(ctx context.Context, d *checkoutData) error { return nil },
+				Compensate: func(ctx context.Context, d *checkoutData) error {
+					*compensated = append(*compensated, "release")
+					return nil
+				},
+			},
+			{
+				Name:   "charge",
+				Action: func(ctx context.Context, d *checkoutData) error { return nil },
+				Compensate: func(ctx context.Context, d *checkoutData) error {
+					*compensated = append(*compensated, "refund")
+					return nil
+				},
+				CompletedOn: []string{"payment.succeeded"},
+				OnEvent:     func(d *checkoutData, event Event) { d.Paid = event.(*paymentSucceeded).Amount },
+				Timeout:     timeout,
+			},
+		},
+	}
+}
+
+func TestSagaTimeoutCompensatesStepInProgress(t *testing.T) {
+	ctx := context.Background()
+	var compensated []string
+	saga, err := NewSaga(checkoutDefinition(&compensated, time.Millisecond), SagaOptions{Bus: NewEventBus()})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer saga.Close()
+
+	if err := saga.Begin(ctx, "order-1", checkoutData{OrderID: "order-1"}); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(5 * time.Millisecond)
+	saga.CheckTimeouts(ctx)
+
+	state, err := saga.Status(ctx, "order-1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if state.Status != SagaCompensated || state.Step != 0 {
+		t.Fatalf("expected the saga to be compensated, got %+v", state)
+	}
+	if !reflect.DeepEqual(compensated, []string{"refund", "release"}) {
+		t.Fatalf("expected the charge to be compensated too, got %v", compensated)
+	}
+}
+
+func TestSagaEventsSurviveRestart(t *testing.T) {
+	ctx := context.Background()
+	db := openTestDB(t)
+	store := NewSQLSagaStore(db, SQLDialectSQLite, "")
+	if err := store.CreateTable(); err != nil {
+		t.Fatal(err)
+	}
+	registry := NewEventRegistry()
+	registry.Register(&paymentSucceeded{})
+	bus := NewEventBus()
+
+	var compensated []string
+	options := SagaOptions{Store: store, Bus: bus, Registry: registry}
+	first, err := NewSaga(checkoutDefinition(&compensated, time.Hour), options)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := first.Begin(ctx, "order-1", checkoutData{OrderID: "order-1"}); err != nil {
+		t.Fatal(err)
+	}
+
+	// The event is stored, but the process stops before handling it
+	if err := bus.Publish(&paymentSucceeded{OrderID: "order-1", Amount: 42}); err != nil {
+		t.Fatal(err)
+	}
+	first.Close()
+
+	second, err := NewSaga(checkoutDefinition(&compensated, time.Hour), options)
+	if err != nil {
+		t.Fatal(err)
+	}
+	second.Start()
+	defer second.Close()
+
+	deadline := time.Now().Add(time.Second)
+	for {
+		state, err := second.Status(ctx, "order-1")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if state.Status == SagaCompleted {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("expected the stored event to complete the saga, got %+v", state)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+
+	data, err := second.Data(ctx, "order-1")
+	if err != nil || data.Paid != 42 {
+		t.Fatalf("expected the decoded event to update the data, got %+v, %v", data, err)
+	}
+	if inbox, _ := store.Inbox(ctx, "checkout", 0); len(inbox) != 0 {
+		t.Fatalf("expected handled events to be removed, got %d", len(inbox))
+	}
+}
+
+// flakySagaStore fails the next Save calls
+type flakySagaStore struct {
+	*MemorySagaStore
+	failSaves int
+}
+
+func (s *flakySagaStore) Save(ctx context.Context, state *SagaState) error {
+	if s.failSaves > 0 {
+		s.failSaves--
+		return errTestHandler
+	}
+	return s.MemorySagaStore.Save(ctx, state)
+}
+
+func TestSagaKeepsEventsThatFailToBeHandled(t *testing.T) {
+	ctx := context.Background()
+	store := &flakySagaStore{MemorySagaStore: NewMemorySagaStore()}
+	registry := NewEventRegistry()
+	registry.Register(&paymentSucceeded{})
+	bus := NewEventBus()
+
+	var compensated []string
+	var reported []error
+	saga, err := NewSaga(checkoutDefinition(&compensated, time.Hour), SagaOptions{
+		Store:    store,
+		Bus:      bus,
+		Registry: registry,
+		OnError:  func(sagaID string, err error) { reported = append(reported, err) },
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer saga.Close()
+
+	if err := saga.Begin(ctx, "order-1", checkoutData{OrderID: "order-1"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := bus.Publish(&paymentSucceeded{OrderID: "order-1", Amount: 42}); err != nil {
+		t.Fatal(err)
+	}
+
+	// A transient store error keeps the event for the next pass
+	store.failSaves = 1
+	saga.processEvents(ctx)
+	if len(reported) != 1 || !errors.Is(reported[0], errTestHandler) {
+		t.Fatalf("expected the store error to be reported, got %v", reported)
+	}
+	if inbox, _ := store.Inbox(ctx, "checkout", 0); len(inbox) != 1 {
+		t.Fatalf("expected the event to be kept, got %d", len(inbox))
+	}
+
+	saga.processEvents(ctx)
+	state, err := saga.Status(ctx, "order-1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if state.Status != SagaCompleted {
+		t.Fatalf("expected the retried event to complete the saga, got %+v", state)
+	}
+	if inbox, _ := store.Inbox(ctx, "checkout", 0); len(inbox) != 0 {
+		t.Fatalf("expected the handled event to be removed, got %d", len(inbox))
+	}
+
+	// An event that cannot be decoded never will be
+	reported = nil
+	store.Enqueue(ctx, SagaEvent{ID: "bad", SagaType: "checkout", SagaID: "order-2", Name: "payment.succeeded", Payload: []byte("{")})
+	saga.processEvents(ctx)
+	if len(reported) != 1 {
+		t.Fatalf("expected the decode error to be reported, got %v", reported)
+	}
+	if inbox, _ := store.Inbox(ctx, "checkout", 0); len(inbox) != 0 {
+		t.Fatalf("expected the undecodable event to be removed, got %d", len(inbox))
+	}
+}
@@ -0,0 +1,532 @@
+package core
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"google.golang.org/protobuf/proto"
+)
+
+// Transport errors
+var (
+	ErrTransportClosed = fiber.NewError(fiber.StatusServiceUnavailable, "Transport is closed")
+)
+
+// Transport message headers
+const (
+	HeaderEventName   = "Event-Name"
+	HeaderEventID     = "Event-Id"
+	HeaderContentType = "Content-Type"
+)
+
+// TransportMessage is a message exchanged with a broker
+type TransportMessage struct {
+	Subject string
+	Headers map[string]string
+	Data    []byte
+	// Deliveries counts deliveries of the message when the broker reports it
+	Deliveries int
+
+	ack     func() error
+	nak     func() error
+	settled int32
+}
+
+// Ack acknowledges the message. Only the first Ack or Nak has an effect.
+func (m *TransportMessage) Ack() error {
+	if m.ack == nil || !atomic.CompareAndSwapInt32(&m.settled, 0, 1) {
+		return nil
+	}
+	return m.ack()
+}
+
+// Nak asks the broker to redeliver the message. Only the first Ack or Nak has an effect.
+func (m *TransportMessage) Nak() error {
+	if m.nak == nil || !atomic.CompareAndSwapInt32(&m.settled, 0, 1) {
+		return nil
+	}
+	return m.nak()
+}
+
+// TransportHandler handles messages received from a transport
+type TransportHandler func(msg *TransportMessage)
+
+// TransportSubscription is an active subscription on a transport
+type TransportSubscription interface {
+	Unsubscribe() error
+}
+
+// Transport publishes and consumes messages through an external broker
+type Transport interface {
+	Publish(ctx context.Context, msg *TransportMessage) error
+	// Subscribe receives messages published on subject. Subscribers sharing a
+	// non-empty group form a consumer group and each message goes to one of them.
+	Subscribe(subject, group string, handler TransportHandler) (TransportSubscription, error)
+	Close() error
+}
+
+// EventCodec serializes events for transports
+type EventCodec interface {
+	ContentType() string
+	Encode(event Event) ([]byte, error)
+	Decode(name string, data []byte) (Event, error)
+}
+
+// JSONEventCodec encodes events as JSON
+type JSONEventCodec struct {
+	// Registry resolves event types, defaults to DefaultEventRegistry
+	Registry *EventRegistry
+}
+
+// ContentType implements EventCodec
+func (c JSONEventCodec) ContentType() string {
+	return "application/json"
+}
+
+// Encode implements EventCodec
+func (c JSONEventCodec) Encode(event Event) ([]byte, error) {
+	return eventRegistry(c.Registry).Encode(event)
+}
+
+// Decode implements EventCodec
+func (c JSONEventCodec) Decode(name string, data []byte) (Event, error) {
+	return eventRegistry(c.Registry).Decode(name, data)
+}
+
+// ProtobufEventCodec encodes events implementing proto.Message
+type ProtobufEventCodec struct {
+	// Registry resolves event types, defaults to DefaultEventRegistry
+	Registry *EventRegistry
+}
+
+// ContentType implements EventCodec
+func (c ProtobufEventCodec) ContentType() string {
+	return "application/protobuf"
+}
+
+// Encode implements EventCodec
+func (c ProtobufEventCodec) Encode(event Event) ([]byte, error) {
+	if raw, ok := event.(*RawEvent); ok {
+		return raw.Payload, nil
+	}
+
+	message, ok := event.(proto.Message)
+	if !ok {
+		return nil, fmt.Errorf("event %s does not implement proto.Message", event.GetName())
+	}
+	return proto.Marshal(message)
+}
+
+// Decode implements EventCodec
+func (c ProtobufEventCodec) Decode(name string, data []byte) (Event, error) {
+	return eventRegistry(c.Registry).DecodeWith(name, data, func(data []byte, v interface{}) error {
+		message, ok := v.(proto.Message)
+		if !ok {
+			return fmt.Errorf("event %s does not implement proto.Message", name)
+		}
+		return proto.Unmarshal(data, message)
+	})
+}
+
+func eventRegistry(registry *EventRegistry) *EventRegistry {
+	if registry == nil {
+		return DefaultEventRegistry
+	}
+	return registry
+}
+
+// BrokerOptions defines broker configuration
+type BrokerOptions struct {
+	// Codec encodes published events, defaults to JSONEventCodec
+	Codec EventCodec
+	// Codecs decode received messages by content type, Codec is always included
+	Codecs []EventCodec
+	// SubjectPrefix is prepended to event names, e.g. "billing."
+	SubjectPrefix string
+	// OnError is called when a received message cannot be decoded or handled
+	OnError func(msg *TransportMessage, err error)
+}
+
+// Broker connects events to an external Transport
+type Broker struct {
+	transport Transport
+	bus       *EventBus
+	options   BrokerOptions
+	codecs    map[string]EventCodec
+}
+
+// NewBroker creates a new broker. The bus receives consumed events and may be nil
+// when events are only published or handled with Subscribe.
+func NewBroker(transport Transport, bus *EventBus, options ...BrokerOptions) *Broker {
+	var opts BrokerOptions
+	if len(options) > 0 {
+		opts = options[0]
+	}
+	if opts.Codec == nil {
+		opts.Codec = JSONEventCodec{}
+	}
+
+	codecs := make(map[string]EventCodec)
+	for _, codec := range opts.Codecs {
+		codecs[codec.ContentType()] = codec
+	}
+	codecs[opts.Codec.ContentType()] = opts.Codec
+
+	return &Broker{
+		transport: transport,
+		bus:       bus,
+		options:   opts,
+		codecs:    codecs,
+	}
+}
+
+// Transport returns the underlying transport
+func (b *Broker) Transport() Transport {
+	return b.transport
+}
+
+// Publish sends an event to the transport. Broker implements EventPublisher,
+// so it can be used by an OutboxRelay.
+func (b *Broker) Publish(event Event) error {
+	return b.PublishContext(context.Background(), event)
+}
+
+// PublishContext sends an event to the transport
+func (b *Broker) PublishContext(ctx context.Context, event Event) error {
+	data, err := b.options.Codec.Encode(event)
+	if err != nil {
+		return fmt.Errorf("failed to encode event %s: %v", event.GetName(), err)
+	}
+
+	headers := map[string]string{
+		HeaderEventName:   event.GetName(),
+		HeaderContentType: b.options.Codec.ContentType(),
+	}
+	if identifiable, ok := event.(IdentifiableEvent); ok {
+		headers[HeaderEventID] = identifiable.GetEventID()
+	}
+	if requestID := stampRequestID(ctx, event); requestID != "" {
+		headers[HeaderRequestID] = requestID
+	}
+	if traceParent, ok := TraceParentFromContext(ctx); ok {
+		headers[HeaderTraceParent] = traceParent
+	}
+
+	return b.transport.Publish(ctx, &TransportMessage{
+		Subject: b.options.SubjectPrefix + event.GetName(),
+		Headers: headers,
+		Data:    data,
+	})
+}
+
+// Subscribe handles events received on subject, which may use the "*" and "#"
+// wildcards. Messages are acknowledged when the handler succeeds and negatively
+// acknowledged for redelivery when it fails, if the transport supports it.
+// Messages that cannot be decoded are acknowledged and reported to OnError.
+func (b *Broker) Subscribe(subject, group string, handler EventHandler) (TransportSubscription, error) {
+	return b.transport.Subscribe(b.options.SubjectPrefix+subject, group, func(msg *TransportMessage) {
+		event, err := b.decode(msg)
+		if err != nil {
+			b.report(msg, err)
+			b.settle(msg, msg.Ack())
+			return
+		}
+
+		if err := invokeHandler(handler, event); err != nil {
+			b.report(msg, err)
+			b.settle(msg, msg.Nak())
+			return
+		}
+
+		b.settle(msg, msg.Ack())
+	})
+}
+
+// Consume publishes events received on subject to the broker's EventBus.
+// Do not Forward the same events from that bus, or they are sent back and forth.
+func (b *Broker) Consume(subject, group string) (TransportSubscription, error) {
+	if b.bus == nil {
+		return nil, fmt.Errorf("broker has no event bus")
+	}
+	return b.Subscribe(subject, group, b.bus.Publish)
+}
+
+// Forward sends events published on the broker's EventBus under eventName to the transport
+func (b *Broker) Forward(eventName string, options ...SubscribeOptions) (*Subscription, error) {
+	if b.bus == nil {
+		return nil, fmt.Errorf("broker has no event bus")
+	}
+	return b.bus.Subscribe(eventName, b.Publish, options...)
+}
+
+func (b *Broker) decode(msg *TransportMessage) (Event, error) {
+	name := msg.Headers[HeaderEventName]
+	if name == "" {
+		name = strings.TrimPrefix(msg.Subject, b.options.SubjectPrefix)
+	}
+
+	codec := b.options.Codec
+	if contentType := msg.Headers[HeaderContentType]; contentType != "" {
+		found, exists := b.codecs[contentType]
+		if !exists {
+			return nil, fmt.Errorf("no codec for content type %s", contentType)
+		}
+		codec = found
+	}
+
+	event, err := codec.Decode(name, msg.Data)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode event %s: %v", name, err)
+	}
+	if requestID := msg.Headers[HeaderRequestID]; requestID != "" {
+		stampRequestID(ContextWithRequestID(context.Background(), requestID), event)
+	}
+	return event, nil
+}
+
+func (b *Broker) settle(msg *TransportMessage, err error) {
+	if err != nil {
+		b.report(msg, fmt.Errorf("failed to acknowledge message: %v", err))
+	}
+}
+
+func (b *Broker) report(msg *TransportMessage, err error) {
+	if b.options.OnError != nil {
+		b.options.OnError(msg, err)
+	}
+}
+
+// MemoryTransportOptions defines in-memory transport configuration
+type MemoryTransportOptions struct {
+	// BufferSize is the queue size of every subscription, defaults to 256
+	BufferSize int
+	// RedeliveryDelay is the wait before a negatively acknowledged message is redelivered
+	RedeliveryDelay time.Duration
+	// MaxDeliveries limits deliveries of a message, 0 means unlimited
+	MaxDeliveries int
+}
+
+// MemoryTransport is an in-process Transport for tests and single-process deployments
+type MemoryTransport struct {
+	options MemoryTransportOptions
+	subs    []*memorySubscription
+	cursors map[string]int
+	closed  bool
+	mu      sync.Mutex
+	wg      sync.WaitGroup
+}
+
+type memorySubscription struct {
+	transport *MemoryTransport
+	subject   string
+	group     string
+	handler   TransportHandler
+	queue     chan *TransportMessage
+	done      chan struct{}
+	once      sync.Once
+}
+
+// NewMemoryTransport creates a new in-memory transport
+func NewMemoryTransport(options ...MemoryTransportOptions) *MemoryTransport {
+	var opts MemoryTransportOptions
+	if len(options) > 0 {
+		opts = options[0]
+	}
+	if opts.BufferSize <= 0 {
+		opts.BufferSize = 256
+	}
+
+	return &MemoryTransport{
+		options: opts,
+		cursors: make(map[string]int),
+	}
+}
+
+// Publish implements Transport
+func (t *MemoryTransport) Publish(ctx context.Context, msg *TransportMessage) error {
+	t.mu.Lock()
+	if t.closed {
+		t.mu.Unlock()
+		return ErrTransportClosed
+	}
+
+	var targets []*memorySubscription
+	groups := make(map[string][]*memorySubscription)
+	var order []string
+	for _, sub := range t.subs {
+		if !sub.matches(msg.Subject) {
+			continue
+		}
+		if sub.group == "" {
+			targets = append(targets, sub)
+			continue
+		}
+		key := sub.subject + "|" + sub.group
+		if _, exists := groups[key]; !exists {
+			order = append(order, key)
+		}
+		groups[key] = append(groups[key], sub)
+	}
+	for _, key := range order {
+		members := groups[key]
+		targets = append(targets, members[t.cursors[key]%len(members)])
+		t.cursors[key]++
+	}
+	t.mu.Unlock()
+
+	for _, sub := range targets {
+		if err := sub.enqueue(ctx, msg.Subject, msg.Headers, msg.Data, 1); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Subscribe implements Transport
+func (t *MemoryTransport) Subscribe(subject, group string, handler TransportHandler) (TransportSubscription, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if t.closed {
+		return nil, ErrTransportClosed
+	}
+
+	sub := &memorySubscription{
+		transport: t,
+		subject:   subject,
+		group:     group,
+		handler:   handler,
+		queue:     make(chan *TransportMessage, t.options.BufferSize),
+		done:      make(chan struct{}),
+	}
+	t.subs = append(t.subs, sub)
+
+	t.wg.Add(1)
+	go sub.run()
+
+	return sub, nil
+}
+
+// Close stops all subscriptions and waits for running handlers
+func (t *MemoryTransport) Close() error {
+	t.mu.Lock()
+	if t.closed {
+		t.mu.Unlock()
+		return nil
+	}
+	t.closed = true
+	subs := t.subs
+	t.subs = nil
+	t.mu.Unlock()
+
+	for _, sub := range subs {
+		sub.stop()
+	}
+	t.wg.Wait()
+	return nil
+}
+
+// redeliver sends a message back to the subscription's group after the configured delay
+func (t *MemoryTransport) redeliver(from *memorySubscription, msg *TransportMessage) {
+	if t.options.MaxDeliveries > 0 && msg.Deliveries >= t.options.MaxDeliveries {
+		return
+	}
+
+	time.AfterFunc(t.options.RedeliveryDelay, func() {
+		t.mu.Lock()
+		if t.closed {
+			t.mu.Unlock()
+			return
+		}
+
+		target := from
+		if from.group != "" {
+			var members []*memorySubscription
+			for _, sub := range t.subs {
+				if sub.subject == from.subject && sub.group == from.group {
+					members = append(members, sub)
+				}
+			}
+			if len(members) == 0 {
+				t.mu.Unlock()
+				return
+			}
+			key := from.subject + "|" + from.group
+			target = members[t.cursors[key]%len(members)]
+			t.cursors[key]++
+		}
+		t.mu.Unlock()
+
+		target.enqueue(context.Background(), msg.Subject, msg.Headers, msg.Data, msg.Deliveries+1)
+	})
+}
+
+// Unsubscribe implements TransportSubscription
+func (s *memorySubscription) Unsubscribe() error {
+	t := s.transport
+	t.mu.Lock()
+	for i, sub := range t.subs {
+		if sub == s {
+			t.subs = append(t.subs[:i:i], t.subs[i+1:]...)
+			break
+		}
+	}
+	t.mu.Unlock()
+
+	s.stop()
+	return nil
+}
+
+func (s *memorySubscription) matches(subject string) bool {
+	if isTopicPattern(s.subject) {
+		return matchTopic(s.subject, subject)
+	}
+	return s.subject == subject
+}
+
+func (s *memorySubscription) enqueue(ctx context.Context, subject string, headers map[string]string, data []byte, deliveries int) error {
+	msg := &TransportMessage{
+		Subject:    subject,
+		Headers:    headers,
+		Data:       data,
+		Deliveries: deliveries,
+	}
+	msg.ack = func() error { return nil }
+	msg.nak = func() error {
+		s.transport.redeliver(s, msg)
+		return nil
+	}
+
+	select {
+	case s.queue <- msg:
+		return nil
+	case <-s.done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (s *memorySubscription) run() {
+	defer s.transport.wg.Done()
+
+	for {
+		select {
+		case msg := <-s.queue:
+			s.handler(msg)
+		case <-s.done:
+			return
+		}
+	}
+}
+
+func (s *memorySubscription) stop() {
+	s.once.Do(func() {
+		close(s.done)
+	})
+}
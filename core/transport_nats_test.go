@@ -0,0 +1,272 @@
+package core
+
+import (
+	"context"
+	"errors"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/nats-io/nats-server/v2/server"
+	natstest "github.com/nats-io/nats-server/v2/test"
+	"github.com/nats-io/nats.go"
+)
+
+func runNATSServer(t *testing.T, port int, jetStream bool) *server.Server {
+	t.Helper()
+	opts := natstest.DefaultTestOptions
+	opts.Port = port
+	if jetStream {
+		opts.JetStream = true
+		opts.StoreDir = t.TempDir()
+	}
+	s := natstest.RunServer(&opts)
+	t.Cleanup(s.Shutdown)
+	return s
+}
+
+func newTestNATSTransport(t *testing.T, s *server.Server, options NATSOptions) *NATSTransport {
+	t.Helper()
+	options.URL = s.ClientURL()
+	transport, err := NewNATSTransport(options)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { transport.Close() })
+	return transport
+}
+
+func flushNATS(t *testing.T, transport *NATSTransport) {
+	t.Helper()
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	if err := transport.Flush(ctx); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestNATSTransportPublishSubscribe(t *testing.T) {
+	s := runNATSServer(t, -1, false)
+	transport := newTestNATSTransport(t, s, NATSOptions{})
+
+	received := make(chan *TransportMessage, 1)
+	if _, err := transport.Subscribe("orders.#", "", func(msg *TransportMessage) { received <- msg }); err != nil {
+		t.Fatal(err)
+	}
+	flushNATS(t, transport)
+
+	err := transport.Publish(context.Background(), &TransportMessage{
+		Subject: "orders.eu.placed",
+		Headers: map[string]string{HeaderEventName: "order.placed", HeaderRequestID: "req-1"},
+		Data:    []byte(`{"id":"1"}`),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	msg := waitFor(t, received, "the message")
+	if msg.Subject != "orders.eu.placed" || string(msg.Data) != `{"id":"1"}` || msg.Deliveries != 1 {
+		t.Fatalf("unexpected message %+v", msg)
+	}
+	if msg.Headers[HeaderEventName] != "order.placed" || msg.Headers[HeaderRequestID] != "req-1" {
+		t.Fatalf("unexpected headers %v", msg.Headers)
+	}
+}
+
+func TestNATSTransportConsumerGroup(t *testing.T) {
+	s := runNATSServer(t, -1, false)
+	transport := newTestNATSTransport(t, s, NATSOptions{})
+
+	received := make(chan string, 20)
+	for _, name := range []string{"a", "b"} {
+		name := name
+		if _, err := transport.Subscribe("jobs", "workers", func(*TransportMessage) { received <- name }); err != nil {
+			t.Fatal(err)
+		}
+	}
+	flushNATS(t, transport)
+
+	for i := 0; i < 10; i++ {
+		transport.Publish(context.Background(), &TransportMessage{Subject: "jobs", Data: []byte("job")})
+	}
+	for i := 0; i < 10; i++ {
+		waitFor(t, received, "a job")
+	}
+	select {
+	case <-received:
+		t.Fatal("expected each message to go to one group member")
+	case <-time.After(50 * time.Millisecond):
+	}
+}
+
+func TestNATSTransportReconnects(t *testing.T) {
+	s := runNATSServer(t, -1, false)
+	port := s.Addr().(*net.TCPAddr).Port
+
+	errs := make(chan error, 10)
+	transport := newTestNATSTransport(t, s, NATSOptions{
+		ReconnectWait: 10 * time.Millisecond,
+		OnError: func(err error) {
+			select {
+			case errs <- err:
+			default:
+			}
+		},
+	})
+
+	received := make(chan *TransportMessage, 10)
+	if _, err := transport.Subscribe("ping", "", func(msg *TransportMessage) { received <- msg }); err != nil {
+		t.Fatal(err)
+	}
+
+	s.Shutdown()
+	waitFor(t, errs, "the disconnect")
+	runNATSServer(t, port, false)
+
+	// Subscriptions are restored once the transport is connected again
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+		err := transport.Publish(ctx, &TransportMessage{Subject: "ping", Data: []byte("ping")})
+		if err == nil {
+			err = transport.Flush(ctx)
+		}
+		cancel()
+		if err == nil {
+			select {
+			case <-received:
+				return
+			case <-time.After(50 * time.Millisecond):
+			}
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("no message received after reconnecting: %v", err)
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+}
+
+func TestNATSTransportDoesNotAckRequests(t *testing.T) {
+	s := runNATSServer(t, -1, false)
+	transport := newTestNATSTransport(t, s, NATSOptions{})
+
+	if _, err := transport.Subscribe("service", "", func(msg *TransportMessage) {
+		msg.Nak()
+		msg.Ack()
+	}); err != nil {
+		t.Fatal(err)
+	}
+	flushNATS(t, transport)
+
+	nc, err := nats.Connect(s.ClientURL())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer nc.Close()
+
+	// The requester must not receive +ACK or -NAK as a response
+	if reply, err := nc.Request("service", []byte("ping"), 100*time.Millisecond); !errors.Is(err, nats.ErrTimeout) {
+		t.Fatalf("expected the request to time out, got %v %v", reply, err)
+	}
+}
+
+func TestNATSTransportJetStreamAck(t *testing.T) {
+	s := runNATSServer(t, -1, true)
+	transport := newTestNATSTransport(t, s, NATSOptions{})
+
+	nc, err := nats.Connect(s.ClientURL())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer nc.Close()
+	js, err := nc.JetStream()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := js.AddStream(&nats.StreamConfig{Name: "ORDERS", Subjects: []string{"orders.>"}}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := js.AddConsumer("ORDERS", &nats.ConsumerConfig{
+		Durable:        "billing",
+		DeliverSubject: "deliver.billing",
+		AckPolicy:      nats.AckExplicitPolicy,
+		AckWait:        30 * time.Second,
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	var mu sync.Mutex
+	var deliveries []int
+	acked := make(chan struct{}, 1)
+	if _, err := transport.Subscribe("deliver.billing", "", func(msg *TransportMessage) {
+		mu.Lock()
+		deliveries = append(deliveries, msg.Deliveries)
+		first := len(deliveries) == 1
+		mu.Unlock()
+
+		// The first delivery fails and is redelivered right away
+		if first {
+			msg.Nak()
+			return
+		}
+		msg.Ack()
+		acked <- struct{}{}
+	}); err != nil {
+		t.Fatal(err)
+	}
+	flushNATS(t, transport)
+
+	if err := transport.Publish(context.Background(), &TransportMessage{Subject: "orders.placed", Data: []byte("1")}); err != nil {
+		t.Fatal(err)
+	}
+	waitFor(t, acked, "the redelivery")
+	flushNATS(t, transport)
+
+	info, err := js.ConsumerInfo("ORDERS", "billing")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.NumAckPending != 0 || info.Delivered.Consumer != 2 {
+		t.Fatalf("expected the message to be acknowledged after two deliveries, got %+v", info)
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	if len(deliveries) != 2 || deliveries[0] != 1 || deliveries[1] != 2 {
+		t.Fatalf("unexpected delivery counts %v", deliveries)
+	}
+}
+
+func TestNATSTransportSubscribeFailureAndClose(t *testing.T) {
+	s := runNATSServer(t, -1, false)
+	transport := newTestNATSTransport(t, s, NATSOptions{})
+
+	if _, err := transport.Subscribe("orders..placed", "", func(*TransportMessage) {}); err == nil {
+		t.Fatal("expected an invalid subject to be rejected")
+	}
+	if _, err := transport.Subscribe("orders.#.placed", "", func(*TransportMessage) {}); err == nil {
+		t.Fatal("expected '#' before the last token to be rejected")
+	}
+	if n := transport.Conn().NumSubscriptions(); n != 0 {
+		t.Fatalf("failed subscriptions left %d subscription(s) behind", n)
+	}
+
+	sub, err := transport.Subscribe("orders.placed", "", func(*TransportMessage) {})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := transport.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := transport.Publish(context.Background(), &TransportMessage{Subject: "orders.placed"}); err != ErrTransportClosed {
+		t.Fatalf("Publish after Close = %v, want ErrTransportClosed", err)
+	}
+	if _, err := transport.Subscribe("orders.placed", "", func(*TransportMessage) {}); err != ErrTransportClosed {
+		t.Fatalf("Subscribe after Close = %v, want ErrTransportClosed", err)
+	}
+	if err := sub.Unsubscribe(); err != nil {
+		t.Fatalf("Unsubscribe after Close = %v", err)
+	}
+}